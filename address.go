@@ -5,6 +5,8 @@
 package btcscript
 
 import (
+	"bytes"
+
 	"github.com/hlandauf/btcnet"
 	"github.com/hlandauf/btcutil"
 )
@@ -13,10 +15,14 @@ import (
 // signatures associated with the passed PkScript.  Note that it only works for
 // 'standard' transaction script types.  Any data such as public keys which are
 // invalid are omitted from the results.
+//
+// The number of required signatures is reported the same way for every class,
+// so callers need not check the class before using it: it is one for
+// pay-to-pubkey, pay-to-pubkey-hash and pay-to-script-hash scripts, the number
+// of signatures the script requires for multisig scripts and zero for null data
+// and non-standard scripts.  Name scripts report the class, addresses and
+// required signatures of the address script which follows the name prefix.
 func ExtractPkScriptAddrs(pkScript []byte, net *btcnet.Params) (ScriptClass, []btcutil.Address, int, error) {
-	var addrs []btcutil.Address
-	var requiredSigs int
-
 	// No valid addresses or required signatures if the script doesn't
 	// parse.
 	pops, err := parseScript(pkScript)
@@ -24,9 +30,27 @@ func ExtractPkScriptAddrs(pkScript []byte, net *btcnet.Params) (ScriptClass, []b
 		return NonStandardTy, nil, 0, err
 	}
 
-  pops = skipComment(pops) // namecoin
+	// Name scripts pay to the address script which follows the name
+	// prefix.
+	var scriptClass ScriptClass
+	if base, ok := stripNamePrefix(pops); ok {
+		pops = base
+		scriptClass = typeOfNameBase(pops)
+	} else {
+		pops = skipComment(pops) // namecoin
+		scriptClass = typeOfScript(pops)
+	}
+
+	addrs, requiredSigs := extractAddrs(pops, scriptClass, net)
+	return scriptClass, addrs, requiredSigs, nil
+}
+
+// extractAddrs returns the addresses and required signatures associated with
+// the passed opcodes, which must be of the passed script class.
+func extractAddrs(pops []parsedOpcode, scriptClass ScriptClass, net *btcnet.Params) ([]btcutil.Address, int) {
+	var addrs []btcutil.Address
+	var requiredSigs int
 
-	scriptClass := typeOfScript(pops)
 	switch scriptClass {
 	case PubKeyHashTy:
 		// A pay-to-pubkey-hash script is of the form:
@@ -83,10 +107,94 @@ func ExtractPkScriptAddrs(pkScript []byte, net *btcnet.Params) (ScriptClass, []b
 		// Null data transactions have no addresses or required
 		// signatures.
 
+	case NameScriptTy:
+		// Don't attempt to extract addresses or required signatures for
+		// name scripts with a nonstandard address script.
+
 	case NonStandardTy:
 		// Don't attempt to extract addresses or required signatures for
 		// nonstandard transactions.
 	}
 
-	return scriptClass, addrs, requiredSigs, nil
+	return addrs, requiredSigs
+}
+
+// MatchesAddress returns whether the passed address is one of the addresses
+// which ExtractPkScriptAddrs extracts from pkScript, so that a wallet watching
+// the address can recognise outputs paying to it without holding any private
+// key.  Pay-to-pubkey-hash, pay-to-script-hash, pay-to-pubkey and multisig
+// scripts are supported, including when they follow a name prefix.  The public
+// keys of pay-to-pubkey and multisig scripts are matched by
+// btcutil.AddressPubKey addresses in the same serialized form.
+//
+// Scripts of classes which have no addresses, such as null data and
+// nonstandard scripts, match no address and are not an error.  Only a pkScript
+// which fails to parse is.  The network of the address is not checked, since
+// pkScripts are the same on every network.
+func MatchesAddress(pkScript []byte, addr btcutil.Address) (bool, error) {
+	_, addrs, _, err := ExtractPkScriptAddrs(pkScript,
+		&btcnet.MainNetParams)
+	if err != nil {
+		return false, err
+	}
+
+	for _, a := range addrs {
+		if sameAddress(a, addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sameAddress returns whether the two addresses are of the same type and pay
+// to the same script address.
+func sameAddress(a, b btcutil.Address) bool {
+	var ok bool
+	switch b.(type) {
+	case *btcutil.AddressPubKeyHash:
+		_, ok = a.(*btcutil.AddressPubKeyHash)
+	case *btcutil.AddressScriptHash:
+		_, ok = a.(*btcutil.AddressScriptHash)
+	case *btcutil.AddressPubKey:
+		_, ok = a.(*btcutil.AddressPubKey)
+	}
+	return ok && bytes.Equal(a.ScriptAddress(), b.ScriptAddress())
+}
+
+// NameScriptInfo houses information about a name script that is determined by
+// GetNameScriptInfo.
+type NameScriptInfo struct {
+	// NameOp is the name operation type, equivalent to calling NameOp on
+	// the parsed NameScript.
+	NameOp byte
+
+	// BaseClass is the class of the address script following the name
+	// prefix.
+	BaseClass ScriptClass
+
+	// Addresses are the addresses controlling the name, extracted from
+	// the address script.
+	Addresses []btcutil.Address
+
+	// RequiredSigs is the number of signatures required to spend the
+	// name output.
+	RequiredSigs int
+}
+
+// GetNameScriptInfo returns a structure summarising the name operation and
+// address script of the pkScript loaded into s.  Addresses are encoded for the
+// passed network.  It will error if the pkScript is not a name script.
+func GetNameScriptInfo(s *Script, net *btcnet.Params) (*NameScriptInfo, error) {
+	ns, err := NewNameScript(s, NameAllowNonStandardBase)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &NameScriptInfo{
+		NameOp:    ns.NameOp(),
+		BaseClass: typeOfScript(ns.base),
+	}
+	info.Addresses, info.RequiredSigs = extractAddrs(ns.base,
+		info.BaseClass, net)
+	return info, nil
 }
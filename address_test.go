@@ -4,13 +4,16 @@
 package btcscript_test
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/hlandauf/btcnet"
 	"github.com/hlandauf/btcscript"
 	"github.com/hlandauf/btcutil"
+	"github.com/hlandauf/btcwire"
 )
 
 // decodeHex decodes the passed hex string and returns the resulting bytes.  It
@@ -260,6 +263,14 @@ func TestExtractPkScriptAddrs(t *testing.T) {
 			reqSigs: 0,
 			class:   btcscript.NonStandardTy,
 		},
+		{
+			name: "p2pk with 34 byte pubkey",
+			script: decodeHex("2202192d74d0cb94344c9569c2e7790157" +
+				"3d8d7903c3ebec3a957724895dca52c6b400ac"),
+			addrs:   nil,
+			reqSigs: 0,
+			class:   btcscript.NonStandardTy,
+		},
 		{
 			name: "valid signature from a sigscript - no addresses",
 			script: decodeHex("47304402204e45e16932b8af514961a1d3" +
@@ -321,6 +332,133 @@ func TestExtractPkScriptAddrs(t *testing.T) {
 			reqSigs: 1,
 			class:   btcscript.MultiSigTy,
 		},
+		{
+			name: "name_update p2pkh",
+			script: decodeHex("5309642f6578616d706c650576616c7565" +
+				"6d7576a914ad06dd6ddee55cbca9a9e3713bd7587509" +
+				"a3056488ac"),
+			addrs: []btcutil.Address{
+				newAddressPubKeyHash(decodeHex("ad06dd6ddee55" +
+					"cbca9a9e3713bd7587509a30564")),
+			},
+			reqSigs: 1,
+			class:   btcscript.PubKeyHashTy,
+		},
+		{
+			name: "name_update 1 of 2 multisig",
+			script: decodeHex("5309642f6578616d706c650576616c7565" +
+				"6d75514104cc71eb30d653c0c3163990c47b976f3fb3" +
+				"f37cccdcbedb169a1dfef58bbfbfaff7d8a473e7e2e6" +
+				"d317b87bafe8bde97e3cf8f065dec022b51d11fcdd0d" +
+				"348ac4410461cbdcc5409fb4b4d42b51d33381354d80" +
+				"e550078cb532a34bfa2fcfdeb7d76519aecc62770f5b" +
+				"0e4ef8551946d8a540911abe3e7854a26f39f58b25c1" +
+				"5342af52ae"),
+			addrs: []btcutil.Address{
+				newAddressPubKey(decodeHex("04cc71eb30d653c0c" +
+					"3163990c47b976f3fb3f37cccdcbedb169a1" +
+					"dfef58bbfbfaff7d8a473e7e2e6d317b87ba" +
+					"fe8bde97e3cf8f065dec022b51d11fcdd0d3" +
+					"48ac4")),
+				newAddressPubKey(decodeHex("0461cbdcc5409fb4b" +
+					"4d42b51d33381354d80e550078cb532a34bf" +
+					"a2fcfdeb7d76519aecc62770f5b0e4ef8551" +
+					"946d8a540911abe3e7854a26f39f58b25c15" +
+					"342af")),
+			},
+			reqSigs: 1,
+			class:   btcscript.MultiSigTy,
+		},
+		{
+			name: "name_update 2 of 3 multisig",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				decodeHex("524104cb9c3c222c5f7a7d3b9bd152f363"+
+					"a0b6d54c9eb312c4d4f9af1e8551b6c421a6"+
+					"a4ab0e29105f24de20ff463c1c91fcf3bf66"+
+					"2cdde4783d4799f787cb7c08869b4104ccc5"+
+					"88420deeebea22a7e900cc8b68620d2212c3"+
+					"74604e3487ca08f1ff3ae12bdc639514d0ec"+
+					"8612a2d3c519f084d9a00cbbe3b53d071e9b"+
+					"09e71e610b036aa24104ab47ad1939edcb3d"+
+					"b65f7fedea62bbf781c5410d3f22a7a3a56f"+
+					"fefb2238af8627363bdf2ed97c1f89784a1a"+
+					"ecdb43384f11d2acc64443c7fc299cef0400"+
+					"421a53ae")),
+			addrs: []btcutil.Address{
+				newAddressPubKey(decodeHex("04cb9c3c222c5f7a7" +
+					"d3b9bd152f363a0b6d54c9eb312c4d4f9af1" +
+					"e8551b6c421a6a4ab0e29105f24de20ff463" +
+					"c1c91fcf3bf662cdde4783d4799f787cb7c0" +
+					"8869b")),
+				newAddressPubKey(decodeHex("04ccc588420deeebe" +
+					"a22a7e900cc8b68620d2212c374604e3487c" +
+					"a08f1ff3ae12bdc639514d0ec8612a2d3c51" +
+					"9f084d9a00cbbe3b53d071e9b09e71e610b0" +
+					"36aa2")),
+				newAddressPubKey(decodeHex("04ab47ad1939edcb3" +
+					"db65f7fedea62bbf781c5410d3f22a7a3a56" +
+					"ffefb2238af8627363bdf2ed97c1f89784a1" +
+					"aecdb43384f11d2acc64443c7fc299cef040" +
+					"0421a")),
+			},
+			reqSigs: 2,
+			class:   btcscript.MultiSigTy,
+		},
+		{
+			name: "name_update with nonstandard address",
+			script: decodeHex("5309642f6578616d706c650576616c7565" +
+				"6d7551"),
+			addrs:   nil,
+			reqSigs: 0,
+			class:   btcscript.NameScriptTy,
+		},
+		{
+			name:    "null data",
+			script:  decodeHex("6a0b68656c6c6f20776f726c64"),
+			addrs:   nil,
+			reqSigs: 0,
+			class:   btcscript.NullDataTy,
+		},
+		{
+			name: "name_new p2pk",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP},
+				decodeHex("2102192d74d0cb94344c9569c2e77901573d8d"+
+					"7903c3ebec3a957724895dca52c6b4ac")),
+			addrs: []btcutil.Address{
+				newAddressPubKey(decodeHex("02192d74d0cb94344" +
+					"c9569c2e77901573d8d7903c3ebec3a95772" +
+					"4895dca52c6b4")),
+			},
+			reqSigs: 1,
+			class:   btcscript.PubKeyTy,
+		},
+		{
+			name: "name_firstupdate p2sh",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), []byte("rand"),
+					[]byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				nameTestP2SH),
+			addrs: []btcutil.Address{
+				newAddressScriptHash(nameTestP2SH[2:22]),
+			},
+			reqSigs: 1,
+			class:   btcscript.ScriptHashTy,
+		},
+		{
+			name: "name_update null data",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				decodeHex("6a0b68656c6c6f20776f726c64")),
+			addrs:   nil,
+			reqSigs: 0,
+			class:   btcscript.NullDataTy,
+		},
 		{
 			name:    "empty script",
 			script:  []byte{},
@@ -364,5 +502,312 @@ func TestExtractPkScriptAddrs(t *testing.T) {
 				class, test.class)
 			continue
 		}
+
+		// The class must agree with the one given by GetScriptClass.
+		if class := btcscript.GetScriptClass(test.script); class != test.class {
+			t.Errorf("GetScriptClass #%d (%s) unexpected script "+
+				"type - got %s, want %s", i, test.name, class,
+				test.class)
+			continue
+		}
+	}
+}
+
+// namecoinParams are the address versions of the Namecoin main network, which
+// do not need any other parameters to extract and encode addresses.
+var namecoinParams = btcnet.Params{
+	Name:             "namecoin",
+	PubKeyHashAddrID: 0x34,
+	ScriptHashAddrID: 0x0d,
+}
+
+// TestExtractNamecoinAddrs ensures addresses extracted with the Namecoin
+// network parameters, including those of name scripts, are encoded with the
+// Namecoin address versions and give back their address scripts.
+func TestExtractNamecoinAddrs(t *testing.T) {
+	nameUpdate := nameScript(btcscript.OP_NAME_UPDATE,
+		[][]byte{[]byte("d/example"), []byte("value")},
+		[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, nameTestP2PKH)
+	nameP2SH := nameScript(btcscript.OP_NAME_UPDATE,
+		[][]byte{[]byte("d/example"), []byte("value")},
+		[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, nameTestP2SH)
+
+	tests := []struct {
+		name   string
+		script []byte
+		base   []byte
+		addr   string
+	}{
+		{
+			name:   "pay to pubkey hash",
+			script: nameTestP2PKH,
+			base:   nameTestP2PKH,
+			addr:   "N2hvfAv17EwEq5g9UykB33VtJNzNf67f2Z",
+		},
+		{
+			name:   "name_update pay to pubkey hash",
+			script: nameUpdate,
+			base:   nameTestP2PKH,
+			addr:   "N2hvfAv17EwEq5g9UykB33VtJNzNf67f2Z",
+		},
+		{
+			name:   "pay to script hash",
+			script: nameTestP2SH,
+			base:   nameTestP2SH,
+			addr:   "6PVCqSyqxFAy6BZvmHunff2mqgK51D1AGu",
+		},
+		{
+			name:   "name_update pay to script hash",
+			script: nameP2SH,
+			base:   nameTestP2SH,
+			addr:   "6PVCqSyqxFAy6BZvmHunff2mqgK51D1AGu",
+		},
+	}
+
+	t.Logf("Running %d tests.", len(tests))
+	for i, test := range tests {
+		_, addrs, _, err := btcscript.ExtractPkScriptAddrs(test.script,
+			&namecoinParams)
+		if err != nil {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if len(addrs) != 1 {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) got %d "+
+				"addresses, want 1", i, test.name, len(addrs))
+			continue
+		}
+
+		addr := addrs[0]
+		if got := addr.EncodeAddress(); got != test.addr {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) wrong address - "+
+				"got %s, want %s", i, test.name, got, test.addr)
+			continue
+		}
+		if !addr.IsForNet(&namecoinParams) ||
+			addr.IsForNet(&btcnet.MainNetParams) {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) address is not "+
+				"only for the Namecoin network", i, test.name)
+			continue
+		}
+
+		script, err := btcscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Errorf("PayToAddrScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(script, test.base) {
+			t.Errorf("PayToAddrScript #%d (%s) wrong script - got "+
+				"%x, want %x", i, test.name, script, test.base)
+		}
+	}
+}
+
+// TestMatchesAddress ensures pkScripts of each class with addresses match the
+// addresses they pay to and no others, and that classes without addresses
+// match nothing.
+func TestMatchesAddress(t *testing.T) {
+	const pubKey = "02192d74d0cb94344c9569c2e77901573d8d7903c3ebec3a95" +
+		"7724895dca52c6b4"
+	var pubKeys []*btcutil.AddressPubKey
+	for _, pubKey := range []string{
+		"03cb9c3c222c5f7a7d3b9bd152f363a0b6d54c9eb312c4d4f9af1e8551b" +
+			"6c421a6",
+		"02ccc588420deeebea22a7e900cc8b68620d2212c374604e3487ca08f1f" +
+			"f3ae12b",
+	} {
+		addr := newAddressPubKey(decodeHex(pubKey))
+		pubKeys = append(pubKeys, addr.(*btcutil.AddressPubKey))
+	}
+	multiSig, err := btcscript.MultiSigScript(pubKeys, 1)
+	if err != nil {
+		t.Fatalf("MultiSigScript: unexpected error: %v", err)
+	}
+	payToPubKey := decodeHex("21" + pubKey + "ac")
+	pubKeyAddr := newAddressPubKey(decodeHex(pubKey))
+	pubKeyHash := newAddressPubKeyHash(nameTestP2PKH[3:23])
+	scriptHash := newAddressScriptHash(nameTestP2SH[2:22])
+	nameUpdate := nameScript(btcscript.OP_NAME_UPDATE,
+		[][]byte{[]byte("d/example"), []byte("value")},
+		[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, nameTestP2PKH)
+	nameNew := nameScript(btcscript.OP_NAME_NEW, [][]byte{nameTestHash},
+		[]byte{btcscript.OP_2DROP}, nameTestP2SH)
+
+	namecoinAddr, err := btcutil.NewAddressPubKeyHash(nameTestP2PKH[3:23],
+		&namecoinParams)
+	if err != nil {
+		t.Fatalf("NewAddressPubKeyHash: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		script []byte
+		addr   btcutil.Address
+		want   bool
+		err    error
+	}{
+		{"p2pkh", nameTestP2PKH, pubKeyHash, true, nil},
+		{"p2pkh other hash", nameTestP2PKH,
+			newAddressPubKeyHash(nameTestHash), false, nil},
+		{"p2pkh script hash of same hash", nameTestP2PKH,
+			newAddressScriptHash(nameTestP2PKH[3:23]), false, nil},
+		{"p2pkh other network", nameTestP2PKH, namecoinAddr, true, nil},
+		{"p2sh", nameTestP2SH, scriptHash, true, nil},
+		{"p2sh other hash", nameTestP2SH,
+			newAddressScriptHash(nameTestHash), false, nil},
+		{"p2sh pubkey hash of same hash", nameTestP2SH,
+			newAddressPubKeyHash(nameTestP2SH[2:22]), false, nil},
+		{"p2pk", payToPubKey, pubKeyAddr, true, nil},
+		{"p2pk other key", payToPubKey, pubKeys[0], false, nil},
+		{"p2pk pubkey hash of key", payToPubKey,
+			pubKeyAddr.(*btcutil.AddressPubKey).AddressPubKeyHash(),
+			false, nil},
+		{"multisig first key", multiSig, pubKeys[0], true, nil},
+		{"multisig second key", multiSig, pubKeys[1], true, nil},
+		{"multisig other key", multiSig, pubKeyAddr, false, nil},
+		{"name_update p2pkh", nameUpdate, pubKeyHash, true, nil},
+		{"name_update p2pkh other hash", nameUpdate,
+			newAddressPubKeyHash(nameTestHash), false, nil},
+		{"name_new p2sh", nameNew, scriptHash, true, nil},
+		{"name_new p2sh pubkey hash", nameNew, pubKeyHash, false, nil},
+		{"null data", decodeHex("6a0b68656c6c6f20776f726c64"),
+			pubKeyHash, false, nil},
+		{"nonstandard", []byte{btcscript.OP_1}, pubKeyHash, false, nil},
+		{"unsupported address", nameTestP2PKH, &bogusAddress{},
+			false, nil},
+		{"unparsable", []byte{btcscript.OP_DATA_2, 0x01}, pubKeyHash,
+			false, btcscript.ErrStackShortScript},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got, err := btcscript.MatchesAddress(test.script, test.addr)
+		if err != test.err {
+			t.Errorf("MatchesAddress #%d (%s) unexpected error - "+
+				"got %v, want %v", i, test.name, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("MatchesAddress #%d (%s) got %v, want %v", i,
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestGetNameScriptInfo ensures the summary of a name script reports the name
+// operation along with the class, addresses and required signatures of its
+// address script.
+func TestGetNameScriptInfo(t *testing.T) {
+	var pubKeys []*btcutil.AddressPubKey
+	for _, pubKey := range []string{
+		"03cb9c3c222c5f7a7d3b9bd152f363a0b6d54c9eb312c4d4f9af1e8551b" +
+			"6c421a6",
+		"02ccc588420deeebea22a7e900cc8b68620d2212c374604e3487ca08f1f" +
+			"f3ae12b",
+		"02ab47ad1939edcb3db65f7fedea62bbf781c5410d3f22a7a3a56ffefb2" +
+			"238af86",
+	} {
+		addr := newAddressPubKey(decodeHex(pubKey))
+		pubKeys = append(pubKeys, addr.(*btcutil.AddressPubKey))
+	}
+	multiSig, err := btcscript.MultiSigScript(pubKeys, 2)
+	if err != nil {
+		t.Fatalf("MultiSigScript: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		script  []byte
+		op      byte
+		class   btcscript.ScriptClass
+		addrs   []btcutil.Address
+		reqSigs int
+		err     error
+	}{
+		{
+			name: "name_update pay to pubkey hash",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			op:    btcscript.OP_NAME_UPDATE,
+			class: btcscript.PubKeyHashTy,
+			addrs: []btcutil.Address{
+				newAddressPubKeyHash(nameTestP2PKH[3:23]),
+			},
+			reqSigs: 1,
+		},
+		{
+			name: "name_firstupdate 2 of 3 multisig",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), []byte("rand"),
+					[]byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				multiSig),
+			op:    btcscript.OP_NAME_FIRSTUPDATE,
+			class: btcscript.MultiSigTy,
+			addrs: []btcutil.Address{
+				pubKeys[0], pubKeys[1], pubKeys[2],
+			},
+			reqSigs: 2,
+		},
+		{
+			name:   "not a name script",
+			script: nameTestP2PKH,
+			err:    btcscript.ErrNotNameScript,
+		},
+	}
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	t.Logf("Running %d tests.", len(tests))
+	for i, test := range tests {
+		s, err := btcscript.NewScript(nil, test.script, 0, tx, 0)
+		if err != nil {
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+
+		info, err := btcscript.GetNameScriptInfo(s,
+			&btcnet.MainNetParams)
+		if !errors.Is(err, test.err) {
+			t.Errorf("GetNameScriptInfo #%d (%s) unexpected "+
+				"error: got %v, want %v", i, test.name, err,
+				test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if info.NameOp != test.op {
+			t.Errorf("GetNameScriptInfo #%d (%s) unexpected op - "+
+				"got %d, want %d", i, test.name, info.NameOp,
+				test.op)
+			continue
+		}
+		if info.BaseClass != test.class {
+			t.Errorf("GetNameScriptInfo #%d (%s) unexpected "+
+				"script type - got %s, want %s", i, test.name,
+				info.BaseClass, test.class)
+			continue
+		}
+		if !reflect.DeepEqual(info.Addresses, test.addrs) {
+			t.Errorf("GetNameScriptInfo #%d (%s) unexpected "+
+				"addresses\ngot  %v\nwant %v", i, test.name,
+				info.Addresses, test.addrs)
+			continue
+		}
+		if info.RequiredSigs != test.reqSigs {
+			t.Errorf("GetNameScriptInfo #%d (%s) unexpected "+
+				"number of required signatures - got %d, "+
+				"want %d", i, test.name, info.RequiredSigs,
+				test.reqSigs)
+			continue
+		}
 	}
 }
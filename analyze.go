@@ -0,0 +1,98 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript
+
+// ScriptStats holds statistics about the opcodes of one or more scripts as
+// gathered by AnalyzeScript and combined by Merge.
+type ScriptStats struct {
+	// Scripts is the number of scripts the statistics cover.
+	Scripts int
+
+	// OpcodeCounts is the number of times each opcode occurs, indexed by
+	// opcode value.
+	OpcodeCounts [256]int
+
+	// PushBytes is the total number of bytes of data pushed by data push
+	// opcodes.  The bytes of the opcodes and lengths are not included.
+	PushBytes int
+
+	// MaxStackDepth is the largest estimated stack depth reached by any of
+	// the scripts.  See AnalyzeScript for how it is estimated.
+	MaxStackDepth int
+
+	// PushOnlyScripts is the number of scripts which only push data.
+	PushOnlyScripts int
+}
+
+// AnalyzeScript returns statistics about the opcodes in the passed script.
+// The script is only parsed and never executed, so any script may be analyzed
+// safely.  An error is returned if the script does not parse.
+//
+// The stack depth is estimated by assuming that every push opcode, including
+// OP_1NEGATE and OP_1 through OP_16, adds an item, that OP_DUP adds an item,
+// that OP_DROP, OP_NIP and OP_2DROP remove one, one and two items respectively,
+// and that every other opcode leaves the depth unchanged.  Conditional branches
+// are not taken into account.
+func AnalyzeScript(script []byte) (*ScriptStats, error) {
+	stats := &ScriptStats{Scripts: 1}
+	pushOnly := true
+	depth := 0
+
+	tokenizer := NewScriptTokenizer(script)
+	for tokenizer.Next() {
+		op := tokenizer.Opcode()
+		stats.OpcodeCounts[op]++
+		stats.PushBytes += len(tokenizer.Data())
+
+		switch {
+		case op <= OP_16 && op != OP_RESERVED:
+			depth++
+		case op == OP_DUP:
+			depth++
+		case op == OP_DROP || op == OP_NIP:
+			depth--
+		case op == OP_2DROP:
+			depth -= 2
+		}
+		if depth < 0 {
+			depth = 0
+		}
+		if depth > stats.MaxStackDepth {
+			stats.MaxStackDepth = depth
+		}
+
+		if op > OP_16 {
+			pushOnly = false
+		}
+	}
+	if err := tokenizer.Err(); err != nil {
+		return nil, err
+	}
+
+	if pushOnly {
+		stats.PushOnlyScripts = 1
+	}
+	return stats, nil
+}
+
+// Merge adds the statistics in other to those in stats, so that stats covers
+// the scripts of both.
+func (stats *ScriptStats) Merge(other *ScriptStats) {
+	stats.Scripts += other.Scripts
+	for i := range stats.OpcodeCounts {
+		stats.OpcodeCounts[i] += other.OpcodeCounts[i]
+	}
+	stats.PushBytes += other.PushBytes
+	if other.MaxStackDepth > stats.MaxStackDepth {
+		stats.MaxStackDepth = other.MaxStackDepth
+	}
+	stats.PushOnlyScripts += other.PushOnlyScripts
+}
+
+// PushOnly returns whether or not every script covered by the statistics only
+// pushes data.
+func (stats *ScriptStats) PushOnly() bool {
+	return stats.PushOnlyScripts == stats.Scripts
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hlandauf/btcscript"
+)
+
+// TestAnalyzeScript ensures the statistics gathered for scripts are correct
+// and are summed by Merge.
+func TestAnalyzeScript(t *testing.T) {
+	sigScript := builderScript(btcscript.NewScriptBuilder().
+		AddData(bytes.Repeat([]byte{0x30}, 71)).
+		AddData(bytes.Repeat([]byte{0x02}, 33)))
+	nameUpdate := nameScript(btcscript.OP_NAME_UPDATE,
+		[][]byte{[]byte("d/example"), []byte("value")},
+		[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, nameTestP2PKH)
+
+	tests := []struct {
+		name      string
+		script    []byte
+		counts    map[byte]int
+		pushBytes int
+		maxDepth  int
+		pushOnly  bool
+	}{
+		{
+			name:   "pay to pubkey hash",
+			script: nameTestP2PKH,
+			counts: map[byte]int{
+				btcscript.OP_DUP:         1,
+				btcscript.OP_HASH160:     1,
+				btcscript.OP_DATA_20:     1,
+				btcscript.OP_EQUALVERIFY: 1,
+				btcscript.OP_CHECKSIG:    1,
+			},
+			pushBytes: 20,
+			maxDepth:  2,
+		},
+		{
+			name:   "signature script",
+			script: sigScript,
+			counts: map[byte]int{
+				btcscript.OP_DATA_1 + 70: 1,
+				btcscript.OP_DATA_33:     1,
+			},
+			pushBytes: 104,
+			maxDepth:  2,
+			pushOnly:  true,
+		},
+		{
+			name:   "name_update",
+			script: nameUpdate,
+			counts: map[byte]int{
+				btcscript.OP_NAME_UPDATE: 1,
+				btcscript.OP_DATA_9:      1,
+				btcscript.OP_DATA_5:      1,
+				btcscript.OP_2DROP:       1,
+				btcscript.OP_DROP:        1,
+				btcscript.OP_DUP:         1,
+				btcscript.OP_HASH160:     1,
+				btcscript.OP_DATA_20:     1,
+				btcscript.OP_EQUALVERIFY: 1,
+				btcscript.OP_CHECKSIG:    1,
+			},
+			pushBytes: 34,
+			maxDepth:  3,
+		},
+		{
+			name:     "empty",
+			script:   nil,
+			counts:   map[byte]int{},
+			pushOnly: true,
+		},
+	}
+
+	total := &btcscript.ScriptStats{}
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		stats, err := btcscript.AnalyzeScript(test.script)
+		if err != nil {
+			t.Errorf("AnalyzeScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		for op, count := range stats.OpcodeCounts {
+			if count != test.counts[byte(op)] {
+				t.Errorf("AnalyzeScript #%d (%s) wrong count for "+
+					"opcode %02x: got %d, want %d", i,
+					test.name, op, count, test.counts[byte(op)])
+			}
+		}
+		if stats.Scripts != 1 || stats.PushBytes != test.pushBytes ||
+			stats.MaxStackDepth != test.maxDepth ||
+			stats.PushOnly() != test.pushOnly {
+			t.Errorf("AnalyzeScript #%d (%s) got %d scripts, %d "+
+				"push bytes, depth %d, push only %v", i,
+				test.name, stats.Scripts, stats.PushBytes,
+				stats.MaxStackDepth, stats.PushOnly())
+		}
+		total.Merge(stats)
+	}
+
+	if total.Scripts != len(tests) {
+		t.Errorf("Merge wrong number of scripts: got %d, want %d",
+			total.Scripts, len(tests))
+	}
+	if total.OpcodeCounts[btcscript.OP_CHECKSIG] != 2 ||
+		total.OpcodeCounts[btcscript.OP_DATA_20] != 2 ||
+		total.OpcodeCounts[btcscript.OP_NAME_UPDATE] != 1 {
+		t.Errorf("Merge wrong opcode counts")
+	}
+	if total.PushBytes != 20+104+34 {
+		t.Errorf("Merge wrong push bytes: got %d", total.PushBytes)
+	}
+	if total.MaxStackDepth != 3 {
+		t.Errorf("Merge wrong max stack depth: got %d",
+			total.MaxStackDepth)
+	}
+	if total.PushOnlyScripts != 2 || total.PushOnly() {
+		t.Errorf("Merge wrong push only scripts: got %d",
+			total.PushOnlyScripts)
+	}
+
+	if _, err := btcscript.AnalyzeScript([]byte{btcscript.OP_DATA_2}); err !=
+		btcscript.ErrStackShortScript {
+		t.Errorf("AnalyzeScript unparsable script unexpected error: "+
+			"got %v, want %v", err, btcscript.ErrStackShortScript)
+	}
+}
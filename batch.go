@@ -0,0 +1,158 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/hlandauf/btcwire"
+)
+
+// VerifyJob describes the validation of a single transaction input for
+// BatchVerify.  The fields are passed to the script engine as for
+// NewScriptWithWitness, with Witness and Amount only being used when Flags
+// include ScriptVerifyWitness.
+type VerifyJob struct {
+	SigScript []byte
+	PkScript  []byte
+	Witness   [][]byte
+	Amount    int64
+	Tx        *btcwire.MsgTx
+	TxIdx     int
+	Flags     ScriptFlags
+}
+
+// verify creates a script engine for the job and executes it, returning nil if
+// the input is valid.
+func (job *VerifyJob) verify() error {
+	engine, err := newScript(job.SigScript, job.PkScript, job.Witness,
+		job.Amount, job.TxIdx, job.Tx, job.Flags, ScriptLimits{})
+	if err != nil {
+		return err
+	}
+	err = engine.Execute()
+	engine.Release()
+	return err
+}
+
+// BatchVerify validates each of the passed jobs, spreading the work across a
+// goroutine per CPU, and returns the result of each in the same order as the
+// jobs.  A nil result means the input is valid, otherwise it is the error that
+// creating or executing the script engine for the job returned.  The
+// transactions of the jobs are only read, so jobs may share them.
+func BatchVerify(jobs []VerifyJob) []error {
+	results := make([]error, len(jobs))
+
+	workers := runtime.NumCPU()
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				results[idx] = jobs[idx].verify()
+			}
+		}()
+	}
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// PrevOutFetcher is the type of function which VerifyTransaction calls to look
+// up the public key script of the output spent by each input.
+type PrevOutFetcher func(outPoint *btcwire.OutPoint) ([]byte, error)
+
+// InputError describes the failure to verify an input of a transaction.  Err
+// is the error returned by the PrevOutFetcher or the script engine.
+type InputError struct {
+	Index int
+	Err   error
+}
+
+// Error satisfies the error interface and prints the index of the input along
+// with the error.
+func (e *InputError) Error() string {
+	return fmt.Sprintf("input %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error which caused the input to fail.
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// InputErrors is returned by VerifyTransaction when allInputs is true and
+// holds the failure of every input which failed, in input order.
+type InputErrors []*InputError
+
+// Error satisfies the error interface and prints each failure.
+func (errs InputErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the failure of each input, so that errors.Is and errors.As
+// look through all of them.
+func (errs InputErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+	return unwrapped
+}
+
+// VerifyTransaction verifies the signature script of every input of tx against
+// the public key script of the output it spends, as returned by fetchPrevOut,
+// with the passed flags.  It returns nil if every input is valid.  Otherwise
+// the first failure is returned as an *InputError giving the index of the
+// input, and no further inputs are verified unless allInputs is true, in which
+// case every failure is returned as InputErrors.
+//
+// The amounts of the outputs spent are not known, so inputs spending witness
+// programs must be verified with NewScriptWithWitness or BatchVerify instead.
+func VerifyTransaction(tx *btcwire.MsgTx, fetchPrevOut PrevOutFetcher,
+	flags ScriptFlags, allInputs bool) error {
+	var errs InputErrors
+	for i, txIn := range tx.TxIn {
+		pkScript, err := fetchPrevOut(&txIn.PreviousOutPoint)
+		if err == nil {
+			job := VerifyJob{
+				SigScript: txIn.SignatureScript,
+				PkScript:  pkScript,
+				Tx:        tx,
+				TxIdx:     i,
+				Flags:     flags,
+			}
+			err = job.verify()
+		}
+		if err == nil {
+			continue
+		}
+
+		inputErr := &InputError{Index: i, Err: err}
+		if !allInputs {
+			return inputErr
+		}
+		errs = append(errs, inputErr)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
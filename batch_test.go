@@ -0,0 +1,222 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/conformal/btcec"
+	"github.com/hlandauf/btcscript"
+	"github.com/hlandauf/btcutil"
+	"github.com/hlandauf/btcwire"
+)
+
+// batchTestJobs returns a transaction with n inputs each spending a
+// pay-to-pubkey-hash output and a job for each input with a valid signature
+// script.
+func batchTestJobs(n int) ([]btcscript.VerifyJob, error) {
+	tx := btcwire.NewMsgTx()
+	for i := 0; i < n; i++ {
+		prevOut := btcwire.NewOutPoint(&btcwire.ShaHash{}, uint32(i))
+		tx.AddTxIn(btcwire.NewTxIn(prevOut, nil))
+	}
+	tx.AddTxOut(btcwire.NewTxOut(1, []byte{btcscript.OP_TRUE}))
+
+	jobs := make([]btcscript.VerifyJob, n)
+	for i := range jobs {
+		key, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			return nil, err
+		}
+		pk := (*btcec.PublicKey)(&key.PublicKey).SerializeCompressed()
+		pkScript := builderScript(btcscript.NewScriptBuilder().
+			AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_HASH160).
+			AddData(btcutil.Hash160(pk)).
+			AddOp(btcscript.OP_EQUALVERIFY).
+			AddOp(btcscript.OP_CHECKSIG))
+		sigScript, err := btcscript.SignatureScript(tx, i, pkScript,
+			btcscript.SigHashAll, key, true)
+		if err != nil {
+			return nil, err
+		}
+		jobs[i] = btcscript.VerifyJob{
+			SigScript: sigScript,
+			PkScript:  pkScript,
+			Tx:        tx,
+			TxIdx:     i,
+			Flags:     btcscript.ScriptBip16,
+		}
+	}
+	return jobs, nil
+}
+
+// TestBatchVerify ensures BatchVerify reports the result of each job in the
+// order the jobs were given.
+func TestBatchVerify(t *testing.T) {
+	jobs, err := batchTestJobs(16)
+	if err != nil {
+		t.Fatalf("failed to make jobs: %v", err)
+	}
+
+	want := make([]error, len(jobs))
+	// The signature script of a different input, with a key not matching
+	// the public key hash.
+	jobs[3].SigScript = jobs[4].SigScript
+	want[3] = btcscript.ErrStackVerifyFailed
+	// A signature for a different input with the right key.
+	jobs[5].TxIdx = 6
+	want[5] = btcscript.ErrStackScriptFailed
+	// No signature script at all.
+	jobs[7].SigScript = nil
+	want[7] = btcscript.ErrStackUnderflow
+	// A public key script which fails to parse.
+	jobs[8].PkScript = []byte{btcscript.OP_DATA_2}
+	want[8] = btcscript.ScriptParseError{
+		ErrorCode: btcscript.ErrCodeMalformedPkScript,
+		Err:       btcscript.ErrStackShortScript,
+	}
+	// A signature script which is not push only for a script hash.
+	jobs[15].PkScript = []byte{btcscript.OP_HASH160, btcscript.OP_DATA_20,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		btcscript.OP_EQUAL}
+	jobs[15].SigScript = []byte{btcscript.OP_DUP}
+	want[15] = btcscript.ErrStackP2SHNonPushOnly
+
+	results := btcscript.BatchVerify(jobs)
+	if len(results) != len(jobs) {
+		t.Fatalf("BatchVerify wrong number of results: got %d, want %d",
+			len(results), len(jobs))
+	}
+	for i, err := range results {
+		if err != want[i] {
+			t.Errorf("BatchVerify job #%d unexpected error - got %v, "+
+				"want %v", i, err, want[i])
+		}
+	}
+
+	if results := btcscript.BatchVerify(nil); len(results) != 0 {
+		t.Errorf("BatchVerify with no jobs got %d results",
+			len(results))
+	}
+}
+
+// TestVerifyTransaction ensures every input of a transaction is verified
+// against the output it spends and that failures report the input index.
+func TestVerifyTransaction(t *testing.T) {
+	jobs, err := batchTestJobs(4)
+	if err != nil {
+		t.Fatalf("failed to make jobs: %v", err)
+	}
+	tx := jobs[0].Tx
+	prevOuts := make(map[btcwire.OutPoint][]byte)
+	for i, job := range jobs {
+		tx.TxIn[i].SignatureScript = job.SigScript
+		prevOuts[tx.TxIn[i].PreviousOutPoint] = job.PkScript
+	}
+	errNoPrevOut := errors.New("no such output")
+	fetch := func(outPoint *btcwire.OutPoint) ([]byte, error) {
+		pkScript, ok := prevOuts[*outPoint]
+		if !ok {
+			return nil, errNoPrevOut
+		}
+		return pkScript, nil
+	}
+
+	if err := btcscript.VerifyTransaction(tx, fetch,
+		btcscript.ScriptBip16, false); err != nil {
+		t.Fatalf("VerifyTransaction unexpected error: %v", err)
+	}
+
+	// Break input 2 with the signature script of input 3 and input 3 by
+	// forgetting the output it spends.
+	tx.TxIn[2].SignatureScript = jobs[3].SigScript
+	delete(prevOuts, tx.TxIn[3].PreviousOutPoint)
+
+	err = btcscript.VerifyTransaction(tx, fetch, btcscript.ScriptBip16,
+		false)
+	inputErr, ok := err.(*btcscript.InputError)
+	if !ok || inputErr.Index != 2 ||
+		inputErr.Err != btcscript.ErrStackVerifyFailed {
+		t.Fatalf("VerifyTransaction wrong error - got %v, want input 2: "+
+			"%v", err, btcscript.ErrStackVerifyFailed)
+	}
+	if !errors.Is(err, btcscript.ErrStackVerifyFailed) {
+		t.Errorf("VerifyTransaction error %v does not wrap %v", err,
+			btcscript.ErrStackVerifyFailed)
+	}
+	if !btcscript.IsErrorCode(err, btcscript.ErrCodeVerifyFailed) {
+		t.Errorf("VerifyTransaction error %v does not match code %v",
+			err, btcscript.ErrCodeVerifyFailed)
+	}
+
+	err = btcscript.VerifyTransaction(tx, fetch, btcscript.ScriptBip16,
+		true)
+	inputErrs, ok := err.(btcscript.InputErrors)
+	if !ok || len(inputErrs) != 2 {
+		t.Fatalf("VerifyTransaction of all inputs wrong error - "+
+			"got %v", err)
+	}
+	want := []btcscript.InputError{
+		{Index: 2, Err: btcscript.ErrStackVerifyFailed},
+		{Index: 3, Err: errNoPrevOut},
+	}
+	for i, inputErr := range inputErrs {
+		if *inputErr != want[i] {
+			t.Errorf("VerifyTransaction of all inputs error #%d - "+
+				"got %v, want %v", i, inputErr, &want[i])
+		}
+	}
+	if !btcscript.IsErrorCode(err, btcscript.ErrCodeVerifyFailed) ||
+		!errors.Is(err, errNoPrevOut) {
+		t.Errorf("VerifyTransaction of all inputs error %v does "+
+			"not wrap the failures of the inputs", err)
+	}
+	if btcscript.IsErrorCode(err, btcscript.ErrCodeInternal) {
+		t.Errorf("VerifyTransaction of all inputs error %v "+
+			"matched code %v", err, btcscript.ErrCodeInternal)
+	}
+}
+
+// BenchmarkVerifySerial benchmarks validating the inputs of a transaction one
+// after another with a script engine each.
+func BenchmarkVerifySerial(b *testing.B) {
+	jobs, err := batchTestJobs(64)
+	if err != nil {
+		b.Fatalf("failed to make jobs: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, job := range jobs {
+			engine, err := btcscript.NewScript(job.SigScript,
+				job.PkScript, job.TxIdx, job.Tx, job.Flags)
+			if err != nil {
+				b.Fatalf("NewScript unexpected error: %v", err)
+			}
+			if err := engine.Execute(); err != nil {
+				b.Fatalf("Execute unexpected error: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkBatchVerify benchmarks validating the inputs of a transaction with
+// BatchVerify.
+func BenchmarkBatchVerify(b *testing.B) {
+	jobs, err := batchTestJobs(64)
+	if err != nil {
+		b.Fatalf("failed to make jobs: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, err := range btcscript.BatchVerify(jobs) {
+			if err != nil {
+				b.Fatalf("BatchVerify unexpected error: %v", err)
+			}
+		}
+	}
+}
@@ -35,5 +35,11 @@ Errors
 Errors returned by this package are of the form btcscript.ErrStackX where X
 indicates the specific error.  See Variables in the package documentation for a
 full list.
+
+Errors returned by the script engine when a script fails to execute are of type
+ScriptError, whose ErrorCode gives the reason for the failure.  Several errors
+may share a code, and errors whose descriptions include details of the failure
+are only available as a code, so callers which need to classify failures should
+use IsErrorCode rather than comparing against the variables.
 */
 package btcscript
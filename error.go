@@ -0,0 +1,288 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode identifies a kind of failure of the script engine.  Several of the
+// errors returned by the engine may share a code when they fail for the same
+// reason, such as a conditional which is not terminated and an OP_ENDIF with no
+// matching OP_IF.
+type ErrorCode int
+
+// These constants are the codes of the errors returned by the script engine.
+const (
+	// ErrCodeInternal identifies an internal error which should never be
+	// returned.
+	ErrCodeInternal ErrorCode = iota
+
+	// ErrCodeInvalidIndex identifies an input index which is not an input
+	// of the transaction.
+	ErrCodeInvalidIndex
+
+	// ErrCodeInvalidProgramCounter identifies an attempt to execute past
+	// the end of the scripts.
+	ErrCodeInvalidProgramCounter
+
+	// ErrCodeShortScript identifies a data push which is longer than the
+	// rest of the script.
+	ErrCodeShortScript
+
+	// ErrCodeScriptTooBig identifies a script which is longer than the
+	// maximum script size.
+	ErrCodeScriptTooBig
+
+	// ErrCodeElementTooBig identifies a push of data which is larger than
+	// allowed on the stack.
+	ErrCodeElementTooBig
+
+	// ErrCodeTooManyOperations identifies a script with more non-push
+	// operations than allowed.
+	ErrCodeTooManyOperations
+
+	// ErrCodeStackOverflow identifies stacks which hold more items than
+	// allowed.
+	ErrCodeStackOverflow
+
+	// ErrCodeStackUnderflow identifies an opcode which needs more items
+	// than are on the stack.
+	ErrCodeStackUnderflow
+
+	// ErrCodeInvalidArgs identifies an opcode given an invalid argument,
+	// such as a negative stack index.
+	ErrCodeInvalidArgs
+
+	// ErrCodeNumberTooBig identifies a numeric argument which is longer
+	// than allowed.
+	ErrCodeNumberTooBig
+
+	// ErrCodeDisabledOpcode identifies the execution of a disabled opcode.
+	ErrCodeDisabledOpcode
+
+	// ErrCodeReservedOpcode identifies the execution of a reserved opcode.
+	ErrCodeReservedOpcode
+
+	// ErrCodeInvalidOpcode identifies the execution of an undefined opcode.
+	ErrCodeInvalidOpcode
+
+	// ErrCodeEarlyReturn identifies the execution of OP_RETURN.
+	ErrCodeEarlyReturn
+
+	// ErrCodeUnbalancedConditional identifies conditionals which are not
+	// properly nested within a script.
+	ErrCodeUnbalancedConditional
+
+	// ErrCodeVerifyFailed identifies a verify opcode, such as OP_VERIFY or
+	// OP_EQUALVERIFY, finding a false value.
+	ErrCodeVerifyFailed
+
+	// ErrCodeEvalFalse identifies scripts which finish with a false value
+	// on top of the stack.
+	ErrCodeEvalFalse
+
+	// ErrCodeEmptyStack identifies scripts which finish with an empty
+	// stack.
+	ErrCodeEmptyStack
+
+	// ErrCodeScriptUnfinished identifies a check of the result of a script
+	// which has not finished executing.
+	ErrCodeScriptUnfinished
+
+	// ErrCodeNotPushOnly identifies a signature script which must only
+	// push data but does not.
+	ErrCodeNotPushOnly
+
+	// ErrCodeInvalidPubKeyCount identifies a multisig operation with a
+	// negative number of public keys or more than allowed.
+	ErrCodeInvalidPubKeyCount
+
+	// ErrCodeInvalidSignatureCount identifies a multisig operation with a
+	// negative number of signatures or more signatures than public keys.
+	ErrCodeInvalidSignatureCount
+
+	// ErrCodeSigNullDummy identifies a multisig operation whose extra
+	// stack item is not empty when ScriptStrictMultiSig is set.
+	ErrCodeSigNullDummy
+
+	// ErrCodeSigDER identifies a signature which is not strictly DER
+	// encoded when ScriptVerifyDERSignatures is set.
+	ErrCodeSigDER
+
+	// ErrCodeSigHighS identifies a signature with a high S value when
+	// ScriptVerifyLowS is set.
+	ErrCodeSigHighS
+
+	// ErrCodeMinimalData identifies a data push which does not use the
+	// smallest opcode possible, or a lock time operand which is not
+	// minimally encoded, when ScriptVerifyMinimalData is set.
+	ErrCodeMinimalData
+
+	// ErrCodeNegativeLockTime identifies a negative lock time or sequence
+	// number checked by OP_CHECKLOCKTIMEVERIFY or OP_CHECKSEQUENCEVERIFY.
+	ErrCodeNegativeLockTime
+
+	// ErrCodeUnsatisfiedLockTime identifies a lock time or sequence number
+	// requirement which the transaction does not meet.
+	ErrCodeUnsatisfiedLockTime
+
+	// ErrCodeWitnessUnexpected identifies a witness for an input which
+	// does not spend a witness program.
+	ErrCodeWitnessUnexpected
+
+	// ErrCodeWitnessMalleated identifies a witness program spent with a
+	// signature script which does more than is required.
+	ErrCodeWitnessMalleated
+
+	// ErrCodeWitnessProgramWrongLength identifies a version 0 witness
+	// program of the wrong length.
+	ErrCodeWitnessProgramWrongLength
+
+	// ErrCodeWitnessProgramEmpty identifies a pay-to-witness-script-hash
+	// program spent with an empty witness.
+	ErrCodeWitnessProgramEmpty
+
+	// ErrCodeWitnessProgramMismatch identifies a witness which does not
+	// match the witness program it spends.
+	ErrCodeWitnessProgramMismatch
+
+	// ErrCodeWitnessCleanStack identifies a witness script which does not
+	// leave exactly one item on the stack.
+	ErrCodeWitnessCleanStack
+
+	// ErrCodeBudgetExceeded identifies scripts whose opcodes cost more in
+	// total than the budget given in the ScriptLimits of the engine.
+	ErrCodeBudgetExceeded
+
+	// ErrCodeWitnessUnsupportedVersion identifies the spend of a witness
+	// program of a version reserved for future soft forks when
+	// ScriptVerifyDiscourageUpgradableWitnessProgram is set.
+	ErrCodeWitnessUnsupportedVersion
+
+	// ErrCodeMalformedSigScript identifies a signature script given to
+	// the script engine which fails to parse.
+	ErrCodeMalformedSigScript
+
+	// ErrCodeMalformedPkScript identifies a public key script given to the
+	// script engine which fails to parse.
+	ErrCodeMalformedPkScript
+)
+
+// errorCodeStrings maps error codes to the names of their constants.
+var errorCodeStrings = map[ErrorCode]string{
+	ErrCodeInternal:                  "ErrCodeInternal",
+	ErrCodeInvalidIndex:              "ErrCodeInvalidIndex",
+	ErrCodeInvalidProgramCounter:     "ErrCodeInvalidProgramCounter",
+	ErrCodeShortScript:               "ErrCodeShortScript",
+	ErrCodeScriptTooBig:              "ErrCodeScriptTooBig",
+	ErrCodeElementTooBig:             "ErrCodeElementTooBig",
+	ErrCodeTooManyOperations:         "ErrCodeTooManyOperations",
+	ErrCodeStackOverflow:             "ErrCodeStackOverflow",
+	ErrCodeStackUnderflow:            "ErrCodeStackUnderflow",
+	ErrCodeInvalidArgs:               "ErrCodeInvalidArgs",
+	ErrCodeNumberTooBig:              "ErrCodeNumberTooBig",
+	ErrCodeDisabledOpcode:            "ErrCodeDisabledOpcode",
+	ErrCodeReservedOpcode:            "ErrCodeReservedOpcode",
+	ErrCodeInvalidOpcode:             "ErrCodeInvalidOpcode",
+	ErrCodeEarlyReturn:               "ErrCodeEarlyReturn",
+	ErrCodeUnbalancedConditional:     "ErrCodeUnbalancedConditional",
+	ErrCodeVerifyFailed:              "ErrCodeVerifyFailed",
+	ErrCodeEvalFalse:                 "ErrCodeEvalFalse",
+	ErrCodeEmptyStack:                "ErrCodeEmptyStack",
+	ErrCodeScriptUnfinished:          "ErrCodeScriptUnfinished",
+	ErrCodeNotPushOnly:               "ErrCodeNotPushOnly",
+	ErrCodeInvalidPubKeyCount:        "ErrCodeInvalidPubKeyCount",
+	ErrCodeInvalidSignatureCount:     "ErrCodeInvalidSignatureCount",
+	ErrCodeSigNullDummy:              "ErrCodeSigNullDummy",
+	ErrCodeSigDER:                    "ErrCodeSigDER",
+	ErrCodeSigHighS:                  "ErrCodeSigHighS",
+	ErrCodeMinimalData:               "ErrCodeMinimalData",
+	ErrCodeNegativeLockTime:          "ErrCodeNegativeLockTime",
+	ErrCodeUnsatisfiedLockTime:       "ErrCodeUnsatisfiedLockTime",
+	ErrCodeWitnessUnexpected:         "ErrCodeWitnessUnexpected",
+	ErrCodeWitnessMalleated:          "ErrCodeWitnessMalleated",
+	ErrCodeWitnessProgramWrongLength: "ErrCodeWitnessProgramWrongLength",
+	ErrCodeWitnessProgramEmpty:       "ErrCodeWitnessProgramEmpty",
+	ErrCodeWitnessProgramMismatch:    "ErrCodeWitnessProgramMismatch",
+	ErrCodeWitnessCleanStack:         "ErrCodeWitnessCleanStack",
+	ErrCodeBudgetExceeded:            "ErrCodeBudgetExceeded",
+	ErrCodeWitnessUnsupportedVersion: "ErrCodeWitnessUnsupportedVersion",
+	ErrCodeMalformedSigScript:        "ErrCodeMalformedSigScript",
+	ErrCodeMalformedPkScript:         "ErrCodeMalformedPkScript",
+}
+
+// String returns the name of the error code.
+func (e ErrorCode) String() string {
+	if s := errorCodeStrings[e]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// ScriptError is the type of the errors returned by the script engine when a
+// script fails to execute.  The ErrorCode allows callers to tell the reasons
+// for failures apart, while the description is meant for humans.
+//
+// The script engine returns the error variables of this package, such as
+// ErrStackUnderflow, wherever it did before, so they may still be compared
+// against directly.  Errors with descriptions giving the details of a failure
+// can only be identified by their code.  Scripts which fail to parse when the
+// engine is created are reported by a ScriptParseError wrapping the error
+// variable, which errors.Is still finds.
+type ScriptError struct {
+	ErrorCode   ErrorCode
+	Description string
+}
+
+// Error satisfies the error interface and returns the description.
+func (e ScriptError) Error() string {
+	return e.Description
+}
+
+// scriptError returns a ScriptError with the passed code and description.
+func scriptError(c ErrorCode, desc string) error {
+	return ScriptError{ErrorCode: c, Description: desc}
+}
+
+// ScriptParseError is the error returned by NewScript and the other
+// constructors of the script engine when the signature script or the public
+// key script fails to parse.  The ErrorCode, ErrCodeMalformedSigScript or
+// ErrCodeMalformedPkScript, identifies which of the two it was, while Err is
+// the ScriptError giving the reason, such as ErrStackShortScript.
+type ScriptParseError struct {
+	ErrorCode ErrorCode
+	Err       error
+}
+
+// Error satisfies the error interface and returns the reason for the failure
+// preceded by the script which failed to parse.
+func (e ScriptParseError) Error() string {
+	script := "public key script"
+	if e.ErrorCode == ErrCodeMalformedSigScript {
+		script = "signature script"
+	}
+	return script + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error giving the reason for the failure, so that
+// errors.Is may compare it against the error variables of this package.
+func (e ScriptParseError) Unwrap() error {
+	return e.Err
+}
+
+// IsErrorCode returns whether err is, or wraps, a ScriptError with the passed
+// code.  A ScriptParseError matches both its own code and that of the error it
+// wraps.  Wrapped errors are found as errors.As finds them, so the errors
+// returned by VerifyTransaction match the code of the failure of an input.
+func IsErrorCode(err error, c ErrorCode) bool {
+	var perr ScriptParseError
+	if errors.As(err, &perr) && perr.ErrorCode == c {
+		return true
+	}
+	var serr ScriptError
+	return errors.As(err, &serr) && serr.ErrorCode == c
+}
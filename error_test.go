@@ -0,0 +1,348 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hlandauf/btcscript"
+	"github.com/hlandauf/btcwire"
+)
+
+// TestErrorCodeStringer tests the stringized output for the ErrorCode type.
+func TestErrorCodeStringer(t *testing.T) {
+	tests := []struct {
+		in   btcscript.ErrorCode
+		want string
+	}{
+		{btcscript.ErrCodeInternal, "ErrCodeInternal"},
+		{btcscript.ErrCodeStackUnderflow, "ErrCodeStackUnderflow"},
+		{btcscript.ErrCodeDisabledOpcode, "ErrCodeDisabledOpcode"},
+		{btcscript.ErrCodeVerifyFailed, "ErrCodeVerifyFailed"},
+		{btcscript.ErrCodeWitnessCleanStack, "ErrCodeWitnessCleanStack"},
+		{btcscript.ErrCodeBudgetExceeded, "ErrCodeBudgetExceeded"},
+		{btcscript.ErrCodeWitnessUnsupportedVersion,
+			"ErrCodeWitnessUnsupportedVersion"},
+		{btcscript.ErrCodeMalformedSigScript, "ErrCodeMalformedSigScript"},
+		{btcscript.ErrCodeMalformedPkScript, "ErrCodeMalformedPkScript"},
+		{0xffff, "Unknown ErrorCode (65535)"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if got := test.in.String(); got != test.want {
+			t.Errorf("String #%d got: %s want: %s", i, got, test.want)
+		}
+	}
+}
+
+// TestScriptErrors ensures the errors returned by the script engine for failing
+// scripts carry the code for the reason of the failure, while still being the
+// error variables of the package where those were returned before.
+func TestScriptErrors(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+	tx.AddTxOut(btcwire.NewTxOut(0, []byte{btcscript.OP_TRUE}))
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		witness   [][]byte
+		flags     btcscript.ScriptFlags
+		code      btcscript.ErrorCode
+		err       error
+	}{
+		{
+			name:     "stack underflow",
+			pkScript: []byte{btcscript.OP_DROP},
+			code:     btcscript.ErrCodeStackUnderflow,
+			err:      btcscript.ErrStackUnderflow,
+		},
+		{
+			name: "disabled opcode",
+			pkScript: []byte{btcscript.OP_1, btcscript.OP_1,
+				btcscript.OP_CAT},
+			code: btcscript.ErrCodeDisabledOpcode,
+			err:  btcscript.ErrStackOpDisabled,
+		},
+		{
+			name:     "reserved opcode",
+			pkScript: []byte{btcscript.OP_RESERVED},
+			code:     btcscript.ErrCodeReservedOpcode,
+			err:      btcscript.ErrStackReservedOpcode,
+		},
+		{
+			name:     "invalid opcode",
+			pkScript: []byte{btcscript.OP_UNKNOWN186},
+			code:     btcscript.ErrCodeInvalidOpcode,
+			err:      btcscript.ErrStackInvalidOpcode,
+		},
+		{
+			name:     "verify failed",
+			pkScript: []byte{btcscript.OP_0, btcscript.OP_VERIFY},
+			code:     btcscript.ErrCodeVerifyFailed,
+			err:      btcscript.ErrStackVerifyFailed,
+		},
+		{
+			name:     "false on stack",
+			pkScript: []byte{btcscript.OP_0},
+			code:     btcscript.ErrCodeEvalFalse,
+			err:      btcscript.ErrStackScriptFailed,
+		},
+		{
+			name:     "empty stack",
+			pkScript: []byte{btcscript.OP_1, btcscript.OP_DROP},
+			code:     btcscript.ErrCodeEmptyStack,
+			err:      btcscript.ErrStackEmptyStack,
+		},
+		{
+			name:     "early return",
+			pkScript: []byte{btcscript.OP_RETURN},
+			code:     btcscript.ErrCodeEarlyReturn,
+			err:      btcscript.ErrStackEarlyReturn,
+		},
+		{
+			name:     "OP_ENDIF without OP_IF",
+			pkScript: []byte{btcscript.OP_ENDIF},
+			code:     btcscript.ErrCodeUnbalancedConditional,
+			err:      btcscript.ErrStackNoIf,
+		},
+		{
+			name:     "OP_IF without OP_ENDIF",
+			pkScript: []byte{btcscript.OP_1, btcscript.OP_IF},
+			code:     btcscript.ErrCodeUnbalancedConditional,
+			err:      btcscript.ErrStackMissingEndif,
+		},
+		{
+			name: "number too big",
+			pkScript: []byte{btcscript.OP_DATA_5, 0x01, 0x02, 0x03,
+				0x04, 0x05, btcscript.OP_1ADD},
+			code: btcscript.ErrCodeNumberTooBig,
+			err:  btcscript.ErrStackNumberTooBig,
+		},
+		{
+			name: "too many pubkeys",
+			pkScript: []byte{btcscript.OP_DATA_1, 21,
+				btcscript.OP_CHECKMULTISIG},
+			code: btcscript.ErrCodeInvalidPubKeyCount,
+			err:  btcscript.ErrStackTooManyPubkeys,
+		},
+		{
+			name: "more signatures than pubkeys",
+			pkScript: []byte{btcscript.OP_2, btcscript.OP_0,
+				btcscript.OP_CHECKMULTISIG},
+			code: btcscript.ErrCodeInvalidSignatureCount,
+		},
+		{
+			name: "multisig dummy not empty",
+			pkScript: []byte{btcscript.OP_1, btcscript.OP_0,
+				btcscript.OP_0, btcscript.OP_CHECKMULTISIG},
+			flags: btcscript.ScriptStrictMultiSig,
+			code:  btcscript.ErrCodeSigNullDummy,
+		},
+		{
+			name: "non-minimal push",
+			pkScript: []byte{btcscript.OP_DATA_1, 0x05,
+				btcscript.OP_DROP, btcscript.OP_1},
+			flags: btcscript.ScriptVerifyMinimalData,
+			code:  btcscript.ErrCodeMinimalData,
+			err:   btcscript.ErrStackMinimalData,
+		},
+		{
+			name:     "unparsable script",
+			pkScript: []byte{btcscript.OP_DATA_2, 0x01},
+			code:     btcscript.ErrCodeShortScript,
+			err:      btcscript.ErrStackShortScript,
+		},
+		{
+			name:      "pay to script hash non push only",
+			sigScript: []byte{btcscript.OP_1, btcscript.OP_DUP},
+			pkScript:  nameTestP2SH,
+			flags:     btcscript.ScriptBip16,
+			code:      btcscript.ErrCodeNotPushOnly,
+			err:       btcscript.ErrStackP2SHNonPushOnly,
+		},
+		{
+			name:     "unexpected witness",
+			pkScript: []byte{btcscript.OP_1},
+			witness:  [][]byte{{0x01}},
+			flags:    btcscript.ScriptVerifyWitness,
+			code:     btcscript.ErrCodeWitnessUnexpected,
+			err:      btcscript.ErrWitnessUnexpected,
+		},
+		{
+			name: "unsupported witness version",
+			pkScript: append([]byte{btcscript.OP_2,
+				btcscript.OP_DATA_20}, nameTestHash...),
+			flags: btcscript.ScriptVerifyWitness |
+				btcscript.ScriptVerifyDiscourageUpgradableWitnessProgram,
+			code: btcscript.ErrCodeWitnessUnsupportedVersion,
+			err:  btcscript.ErrWitnessUnsupportedVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		engine, err := btcscript.NewScriptWithWitness(test.sigScript,
+			test.pkScript, test.witness, 0, 0, tx, test.flags)
+		if err == nil {
+			err = engine.Execute()
+		}
+		if !btcscript.IsErrorCode(err, test.code) {
+			t.Errorf("Execute #%d (%s) wrong error - got %v, want "+
+				"code %v", i, test.name, err, test.code)
+			continue
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("Execute #%d (%s) wrong error - got %v, want %v",
+				i, test.name, err, test.err)
+			continue
+		}
+		var serr btcscript.ScriptError
+		if !errors.As(err, &serr) {
+			t.Errorf("Execute #%d (%s) error %v is not a "+
+				"ScriptError", i, test.name, err)
+			continue
+		}
+		if serr.Error() != serr.Description {
+			t.Errorf("Execute #%d (%s) error %q does not match "+
+				"description %q", i, test.name, serr.Error(),
+				serr.Description)
+		}
+	}
+
+	// Stepping past the end of the scripts is reported too.
+	engine, err := btcscript.NewScript(nil, []byte{btcscript.OP_1}, 0, tx,
+		0)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	if err := engine.Execute(); err != nil {
+		t.Fatalf("Execute unexpected error: %v", err)
+	}
+	_, err = engine.Step()
+	if !btcscript.IsErrorCode(err, btcscript.ErrCodeInvalidProgramCounter) {
+		t.Errorf("Step past end wrong error - got %v, want code %v", err,
+			btcscript.ErrCodeInvalidProgramCounter)
+	}
+
+	// Errors wrapping a ScriptError match its code.
+	wrapped := fmt.Errorf("input 0: %w", btcscript.ErrStackVerifyFailed)
+	if !btcscript.IsErrorCode(wrapped, btcscript.ErrCodeVerifyFailed) {
+		t.Errorf("IsErrorCode did not match wrapped error %v", wrapped)
+	}
+
+	if btcscript.IsErrorCode(nil, btcscript.ErrCodeInternal) ||
+		btcscript.IsErrorCode(btcscript.ErrNotNameScript,
+			btcscript.ErrCodeInternal) {
+		t.Errorf("IsErrorCode matched an error which is not a " +
+			"ScriptError")
+	}
+}
+
+// TestScriptParseErrors ensures the errors returned by the script engine for
+// scripts which fail to parse identify whether it was the signature script or
+// the public key script, along with the reason.
+func TestScriptParseErrors(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+	tx.AddTxOut(btcwire.NewTxOut(0, []byte{btcscript.OP_TRUE}))
+
+	malformed := []byte{btcscript.OP_DATA_2, 0x01}
+	// Scripts may be at most 10000 bytes long.
+	long := make([]byte, 10001)
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		code      btcscript.ErrorCode
+		err       error
+		desc      string
+	}{
+		{
+			name:      "malformed signature script",
+			sigScript: malformed,
+			pkScript:  []byte{btcscript.OP_TRUE},
+			code:      btcscript.ErrCodeMalformedSigScript,
+			err:       btcscript.ErrStackShortScript,
+			desc: "signature script: " +
+				btcscript.ErrStackShortScript.Error(),
+		},
+		{
+			name:      "malformed public key script",
+			sigScript: []byte{btcscript.OP_TRUE},
+			pkScript:  malformed,
+			code:      btcscript.ErrCodeMalformedPkScript,
+			err:       btcscript.ErrStackShortScript,
+			desc: "public key script: " +
+				btcscript.ErrStackShortScript.Error(),
+		},
+		{
+			name:      "both malformed",
+			sigScript: malformed,
+			pkScript:  malformed,
+			code:      btcscript.ErrCodeMalformedSigScript,
+			err:       btcscript.ErrStackShortScript,
+			desc: "signature script: " +
+				btcscript.ErrStackShortScript.Error(),
+		},
+		{
+			name:      "long signature script",
+			sigScript: long,
+			pkScript:  []byte{btcscript.OP_TRUE},
+			code:      btcscript.ErrCodeMalformedSigScript,
+			err:       btcscript.ErrStackLongScript,
+			desc: "signature script: " +
+				btcscript.ErrStackLongScript.Error(),
+		},
+		{
+			name:     "long public key script",
+			pkScript: long,
+			code:     btcscript.ErrCodeMalformedPkScript,
+			err:      btcscript.ErrStackLongScript,
+			desc: "public key script: " +
+				btcscript.ErrStackLongScript.Error(),
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		_, err := btcscript.NewScript(test.sigScript, test.pkScript, 0,
+			tx, 0)
+		perr, ok := err.(btcscript.ScriptParseError)
+		if !ok {
+			t.Errorf("NewScript #%d (%s) wrong error - got %v (%T), "+
+				"want a ScriptParseError", i, test.name, err, err)
+			continue
+		}
+		if perr.ErrorCode != test.code {
+			t.Errorf("NewScript #%d (%s) wrong code - got %v, want "+
+				"%v", i, test.name, perr.ErrorCode, test.code)
+		}
+		if perr.Err != test.err || !errors.Is(err, test.err) {
+			t.Errorf("NewScript #%d (%s) wrong reason - got %v, "+
+				"want %v", i, test.name, perr.Err, test.err)
+		}
+		if err.Error() != test.desc {
+			t.Errorf("NewScript #%d (%s) wrong description - got "+
+				"%q, want %q", i, test.name, err.Error(), test.desc)
+		}
+
+		// Both the code of the script and that of the reason match.
+		serr := test.err.(btcscript.ScriptError)
+		if !btcscript.IsErrorCode(err, test.code) ||
+			!btcscript.IsErrorCode(err, serr.ErrorCode) {
+			t.Errorf("IsErrorCode #%d (%s) did not match codes %v "+
+				"and %v", i, test.name, test.code, serr.ErrorCode)
+		}
+		if btcscript.IsErrorCode(err, btcscript.ErrCodeInternal) {
+			t.Errorf("IsErrorCode #%d (%s) matched a code of "+
+				"neither", i, test.name)
+		}
+	}
+}
@@ -39,6 +39,12 @@ func TstRemoveOpcodeByData(pkscript []byte, data []byte) ([]byte, error) {
 	return unparseScript(pops)
 }
 
+// TstParsedScriptClass returns the class of the script without the fast path
+// GetScriptClass takes for the most common templates.
+func TstParsedScriptClass(script []byte) ScriptClass {
+	return parsedScriptClass(script)
+}
+
 // TestSetPC allows the test modules to set the program counter to whatever they
 // want.
 func (s *Script) TstSetPC(script, off int) {
@@ -46,6 +52,12 @@ func (s *Script) TstSetPC(script, off int) {
 	s.scriptoff = off
 }
 
+// TstDisableSigHashCache makes the script engine calculate every signature hash
+// it needs rather than reusing those calculated before.
+func (s *Script) TstDisableSigHashCache() {
+	s.sigHashes = nil
+}
+
 // Internal tests for opcodde parsing with bad data templates.
 func TestParseOpcode(t *testing.T) {
 	fakemap := make(map[byte]*opcode)
@@ -3781,7 +3793,7 @@ func ParseShortForm(script string) ([]byte, error) {
 			builder.script = append(builder.script, bts...)
 		} else if len(tok) >= 2 &&
 			tok[0] == '\'' && tok[len(tok)-1] == '\'' {
-			builder.AddData([]byte(tok[1 : len(tok)-1]))
+			builder.AddFullData([]byte(tok[1 : len(tok)-1]))
 		} else if opcode, ok := ops[tok]; ok {
 			builder.AddOp(opcode.value)
 		} else {
@@ -3789,7 +3801,7 @@ func ParseShortForm(script string) ([]byte, error) {
 		}
 
 	}
-	return builder.Script(), nil
+	return builder.Script()
 }
 
 func TestBitcoindInvalidTests(t *testing.T) {
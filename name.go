@@ -1,45 +1,120 @@
 package btcscript
 
-import "errors"
-import "fmt"
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/conformal/fastsha256"
+)
 
 // NameScript provides information parsed from a Script. It includes the name
 // operation type, the destination address and any operation arguments.
+//
+// The script engine needs no special handling to execute name scripts.  The
+// name opcodes share their values with OP_1, OP_2 and OP_3 and push those small
+// integers, which the delimiters drop along with the arguments, so the address
+// script is evaluated on the stack it would see on its own.
 type NameScript struct {
 	op      byte
 	address *Script
-	args    []string
+	args    [][]byte
+	delims  []byte
+	base    []parsedOpcode
 }
 
-var ErrNameEmptyScript = errors.New("pk script contains no opcodes and thus cannot be a valid name script")
-var ErrNameOpcodeOutOfRange = errors.New("pk script is not a valid name script because it contains an out-of-range opcode")
-var ErrNameNoDropDelimiter = errors.New("pk script is not a valid name script because it does not contain a DROP/2DROP/NOP delimiter")
-var ErrNameWrongArgCount = errors.New("pk script is not a valid name script because it does not have the correct number of arguments for the given op type")
-var ErrNameUnknownOp = errors.New("pk script is not a valid name script because it has an unknown name op type")
+// ErrNotNameScript is wrapped by every error returned when a script is not a
+// valid name script, so errors.Is(err, ErrNotNameScript) may be used to detect
+// a name parsing failure of any kind.
+var ErrNotNameScript = errors.New("pk script is not a valid name script")
+
+var ErrNameEmptyScript = fmt.Errorf("%w because it contains no opcodes", ErrNotNameScript)
+var ErrNameOpcodeOutOfRange = fmt.Errorf("%w because it contains an out-of-range opcode", ErrNotNameScript)
+var ErrNameNoDropDelimiter = fmt.Errorf("%w because it does not contain a DROP/2DROP/NOP delimiter", ErrNotNameScript)
+var ErrNameWrongArgCount = fmt.Errorf("%w because it does not have the correct number of arguments for the given op type", ErrNotNameScript)
+var ErrNameUnknownOp = fmt.Errorf("%w because it has an unknown name op type", ErrNotNameScript)
+var ErrNameTooLong = fmt.Errorf("%w because the name is longer than MaxNameLength", ErrNotNameScript)
+var ErrNameEmptyName = fmt.Errorf("%w because the name is empty", ErrNotNameScript)
+var ErrNameValueTooLong = fmt.Errorf("%w because the value is longer than MaxNameValueLength", ErrNotNameScript)
+var ErrNameHashWrongSize = fmt.Errorf("%w because the name_new hash is not NameHashSize bytes", ErrNotNameScript)
+var ErrNameBadBaseScript = fmt.Errorf("%w because the address script is not a standard script", ErrNotNameScript)
+
+// These are the Namecoin consensus limits on the arguments of name
+// operations.
+const (
+	MaxNameLength      = 255 // Max bytes in a name.
+	MaxNameValueLength = 520 // Max bytes in a name value.
+	NameHashSize       = 20  // Size of the name_new commitment.
+)
+
+// NameFlags is a bitmask defining additional checks that will be done when
+// parsing a name script.
+type NameFlags uint32
+
+const (
+	// NameCheckLimits defines whether the lengths of the name operation
+	// arguments are checked against the Namecoin consensus limits, which
+	// include the name of an update not being empty.  Scripts which
+	// predate a rule can be parsed by omitting this flag.
+	NameCheckLimits NameFlags = 1 << iota
+
+	// NameAllowNonStandardBase defines whether the address script
+	// following the name prefix may be of a non-standard type.  Without
+	// this flag, scripts with an empty address script or one carrying
+	// extra opcodes are rejected.
+	NameAllowNonStandardBase
+)
+
+// isNameDelimiter returns whether the passed opcode may separate the arguments
+// of a name operation from its address script.
+func isNameDelimiter(op byte) bool {
+	return op == OP_DROP || op == OP_2DROP || op == OP_NOP
+}
 
-func NewNameScriptFromPk(pkScript []byte) (*NameScript, error) {
-  pk, err := parseScript(pkScript)
-  if err != nil {
-    return nil, err
-  }
+// Attempt to parse a raw pk script in order to find name information.  If the
+// script is not a syntactically valid name script, returns an error.
+//
+// The arguments of the name operation are the data pushes following its
+// opcode, and end at the first OP_DROP, OP_2DROP or OP_NOP.  Every delimiter
+// opcode from there on is skipped and the rest of the script is the address
+// script.  Any sequence of delimiters is accepted, as by Namecoin itself, since
+// none of them change the arguments found.  This covers the layouts seen on
+// mainnet: the OP_2DROP and OP_DROP sequences which remove the arguments from
+// the stack, such as "OP_2DROP OP_DROP" after a name_update, the equivalent
+// runs of single OP_DROPs, and the lone OP_NOP used by some early clients.
+//
+// The result is the same as that of NewNameScript for a Script holding
+// pkScript as its public key script, so callers with only the bytes of an
+// output need no Script.  Address returns such a Script, which is intended for
+// inspection and must not be executed.
+func NewNameScriptFromPk(pkScript []byte, flags NameFlags) (*NameScript, error) {
+	pk, err := parseScript(pkScript)
+	if err != nil {
+		return nil, err
+	}
 
-  return newNameScript(pk)
+	return NewNameScript(inspectionScript(pk), flags)
 }
 
 // Attempt to parse a Script in order to find name information.  If the script
 // is not a syntactically valid name script, returns an error.
-func NewNameScript(s *Script) (*NameScript, error) {
-  ns, err := newNameScript(s.scripts[1])
-  if err != nil {
-    return nil, err
-  }
+func NewNameScript(s *Script, flags NameFlags) (*NameScript, error) {
+	ns, err := newNameScript(s.scripts[1], flags)
+	if err != nil {
+		return nil, err
+	}
 
-  ns.address = s
+	ns.address = s
 
-  return ns, nil
+	return ns, nil
 }
 
-func newNameScript(pkOpcodes []parsedOpcode) (*NameScript, error) {
+func newNameScript(pkOpcodes []parsedOpcode, flags NameFlags) (*NameScript, error) {
 	ns := &NameScript{}
 
 	// Build arguments.
@@ -54,31 +129,34 @@ func newNameScript(pkOpcodes []parsedOpcode) (*NameScript, error) {
 	for i = 1; i < len(pkOpcodes); i++ {
 		opNum := pkOpcodes[i].opcode.value
 
-		if opNum == OP_DROP || opNum == OP_2DROP || opNum == OP_NOP {
+		if isNameDelimiter(opNum) {
 			break
 		}
 
 		if opNum < 0 || opNum > OP_PUSHDATA4 {
-      return nil, fmt.Errorf("%v: %v", ErrNameOpcodeOutOfRange, pkOpcodes[i].opcode)
+			return nil, fmt.Errorf("%w: %v", ErrNameOpcodeOutOfRange, pkOpcodes[i].opcode)
 		}
 
-		ns.args = append(ns.args, string(pkOpcodes[i].data))
+		ns.args = append(ns.args, pkOpcodes[i].data)
 	}
 
 	// No DROP/NOP opcodes were encountered before the end of the script, this is
 	// invalid.
 	if i >= len(pkOpcodes) {
-    return nil, fmt.Errorf("%v: %v", ErrNameNoDropDelimiter, dc(pkOpcodes))
+		return nil, fmt.Errorf("%w: %v", ErrNameNoDropDelimiter, dc(pkOpcodes))
 	}
 
-	// Move to after any DROP/NOP opcodes.
-	for i = i; i < len(pkOpcodes); i++ {
-		opNum := pkOpcodes[i].opcode.value
-		if opNum != OP_DROP && opNum != OP_2DROP && opNum != OP_NOP {
-			break
-		}
+	// Move to after any DROP/NOP opcodes.  As in Namecoin, the whole run
+	// of delimiters is skipped whatever it consists of, so the arguments
+	// are always the pushes before the first delimiter.
+	for i < len(pkOpcodes) && isNameDelimiter(pkOpcodes[i].opcode.value) {
+		ns.delims = append(ns.delims, pkOpcodes[i].opcode.value)
+		i++
 	}
 
+	// Everything which remains is the address script.
+	ns.base = pkOpcodes[i:]
+
 	// Check that the name operation type is known and that the right number of
 	// arguments are present.
 	switch nameOp {
@@ -95,19 +173,55 @@ func newNameScript(pkOpcodes []parsedOpcode) (*NameScript, error) {
 			return nil, ErrNameWrongArgCount
 		}
 	default:
-    return nil, fmt.Errorf("%v: %d: %v", ErrNameUnknownOp, nameOp, dc(pkOpcodes))
+		return nil, fmt.Errorf("%w: %d: %v", ErrNameUnknownOp, nameOp, dc(pkOpcodes))
 	}
 
 	ns.op = nameOp
+
+	if flags&NameAllowNonStandardBase != NameAllowNonStandardBase &&
+		typeOfScript(ns.base) == NonStandardTy {
+		return nil, fmt.Errorf("%w: %v", ErrNameBadBaseScript, dc(ns.base))
+	}
+
+	if flags&NameCheckLimits == NameCheckLimits {
+		err := ns.checkLimits()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return ns, nil
 }
 
+// checkLimits returns an error if any of the arguments of the name operation
+// exceed the Namecoin consensus limits for the operation type, or if the name
+// of an update is empty.  Empty values are allowed.
+func (ns *NameScript) checkLimits() error {
+	switch ns.op {
+	case OP_NAME_NEW:
+		if len(ns.args[0]) != NameHashSize {
+			return ErrNameHashWrongSize
+		}
+	case OP_NAME_FIRSTUPDATE, OP_NAME_UPDATE:
+		if len(ns.OpNameBytes()) == 0 {
+			return ErrNameEmptyName
+		}
+		if len(ns.OpNameBytes()) > MaxNameLength {
+			return ErrNameTooLong
+		}
+		if len(ns.OpValueBytes()) > MaxNameValueLength {
+			return ErrNameValueTooLong
+		}
+	}
+	return nil
+}
+
 func dc(pc []parsedOpcode) string {
-  s := ""
-  for _, c := range pc {
-    s += c.print(true) + " "
-  }
-  return s
+	s := ""
+	for _, c := range pc {
+		s += c.print(true) + " "
+	}
+	return s
 }
 
 // Returns the destination address for the script.
@@ -115,71 +229,378 @@ func (ns *NameScript) Address() *Script {
 	return ns.address
 }
 
+// Returns a Script containing only the address script which follows the name
+// operation, its arguments and the DROP/2DROP/NOP delimiters.  The address
+// script is held as the public key script of the returned Script in the same
+// way as for the Script passed to NewNameScript.  The returned Script is
+// intended for inspection and is not associated with a transaction, so it
+// must not be executed.
+func (ns *NameScript) BaseScript() *Script {
+	return inspectionScript(ns.base)
+}
+
+// inspectionScript returns a Script holding the passed opcodes as its public
+// key script, which is not associated with a transaction.
+func inspectionScript(pkOpcodes []parsedOpcode) *Script {
+	return &Script{
+		scripts:        [][]parsedOpcode{nil, pkOpcodes},
+		scriptidx:      1,
+		condStack:      []int{OpCondTrue},
+		maxElementSize: MaxScriptElementSize,
+		maxOps:         MaxOpsPerScript,
+	}
+}
+
+// Returns the serialized address script which follows the name prefix.  Unlike
+// the full name script, this may be passed to GetScriptClass or
+// ExtractPkScriptAddrs to determine which keys control the name.
+func (ns *NameScript) BasePkScript() []byte {
+	// unparseScript cannot fail here since the opcodes came from
+	// parseScript.
+	script, _ := unparseScript(ns.base)
+	return script
+}
+
+// Returns the DROP/2DROP/NOP opcodes which separate the arguments of the name
+// operation from the address script, in the order they appear.  They are not
+// kept by Rebuild, so comparing them shows whether a rebuilt script uses the
+// same delimiters as the script which was parsed.  A NameScript restored by
+// UnmarshalJSON has no delimiters.
+func (ns *NameScript) DelimiterOpcodes() []byte {
+	return copyBytes(ns.delims)
+}
+
 // Returns the name operation type found in the script.
 func (ns *NameScript) NameOp() byte {
 	return ns.op
 }
 
+// Returns true iff the name operation type is New.
+func (ns *NameScript) IsNameNew() bool {
+	return ns.op == OP_NAME_NEW
+}
+
+// Returns true iff the name operation type is FirstUpdate.
+func (ns *NameScript) IsNameFirstUpdate() bool {
+	return ns.op == OP_NAME_FIRSTUPDATE
+}
+
+// Returns true iff the name operation type is Update.
+func (ns *NameScript) IsNameUpdate() bool {
+	return ns.op == OP_NAME_UPDATE
+}
+
 // Returns true iff the name operation type is FirstUpdate or Update.
 func (ns *NameScript) IsAnyUpdate() bool {
-	switch ns.op {
-	case OP_NAME_NEW:
-		return false
-	case OP_NAME_FIRSTUPDATE, OP_NAME_UPDATE:
-		return true
-	default:
-		panic("unexpected value in internal field")
-	}
+	return ns.IsNameFirstUpdate() || ns.IsNameUpdate()
 }
 
 // Obtains the name name for scripts where IsAnyUpdate() is true.
 // Panics otherwise.
+//
+// The name is returned as a Go string holding the raw bytes of the push,
+// which need not be valid UTF-8.  Use OpNameBytes for binary-safe access.
 func (ns *NameScript) OpName() string {
+	return string(ns.OpNameBytes())
+}
+
+// Obtains the name name for scripts where IsAnyUpdate() is true as a copy of
+// the underlying push data.  Panics otherwise.
+func (ns *NameScript) OpNameBytes() []byte {
 	switch ns.op {
 	case OP_NAME_FIRSTUPDATE, OP_NAME_UPDATE:
-		return ns.args[0]
+		return copyBytes(ns.args[0])
 	default:
 		panic("called OpName() on non-update name script")
 	}
 }
 
+// Obtains the namespace of the name for scripts where IsAnyUpdate() is true,
+// e.g. "d" for "d/example".  Panics otherwise.
+//
+// The name is split on the first slash only, so the namespace of "d/a/b" is
+// "d".  Returns the empty string if the name contains no slash.
+func (ns *NameScript) Namespace() string {
+	namespace, _, ok := splitName(ns.OpName())
+	if !ok {
+		return ""
+	}
+	return namespace
+}
+
+// Obtains the part of the name following the namespace for scripts where
+// IsAnyUpdate() is true, e.g. "example" for "d/example".  Panics otherwise.
+//
+// The name is split on the first slash only, so the identifier of "d/a/b" is
+// "a/b".  Returns the empty string if the name contains no slash.
+func (ns *NameScript) Identifier() string {
+	_, identifier, ok := splitName(ns.OpName())
+	if !ok {
+		return ""
+	}
+	return identifier
+}
+
+// splitName splits a name into its namespace and identifier on the first
+// slash.  The returned bool is false if the name contains no slash.
+func splitName(name string) (string, string, bool) {
+	i := strings.IndexByte(name, '/')
+	if i < 0 {
+		return "", "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// Obtains an identifier of the name for scripts where IsAnyUpdate() is true.
+// Panics otherwise.
+//
+// The identifier is the SHA-256 hash of the raw bytes of the name, namespace
+// and identifier included, and does not depend on the value, so every
+// name_firstupdate and name_update of a name gives the same one.  It is an
+// array so that it may be used as a map key by indexers.
+func (ns *NameScript) NameID() [fastsha256.Size]byte {
+	return fastsha256.Sum256(ns.OpNameBytes())
+}
+
 // Obtains the name value for scripts where IsAnyUpdate() is true.
 // Panics otherwise.
+//
+// Values are frequently JSON but may contain arbitrary bytes, in which case
+// the returned string will not be valid UTF-8.  Use OpValueBytes for
+// binary-safe access.
 func (ns *NameScript) OpValue() string {
+	return string(ns.OpValueBytes())
+}
+
+// Obtains the name value for scripts where IsAnyUpdate() is true as a copy of
+// the underlying push data.  Panics otherwise.
+func (ns *NameScript) OpValueBytes() []byte {
 	switch ns.op {
 	case OP_NAME_FIRSTUPDATE:
-		return ns.args[2]
+		return copyBytes(ns.args[2])
 	case OP_NAME_UPDATE:
-		return ns.args[1]
+		return copyBytes(ns.args[1])
 	default:
 		panic("called OpValue() on non-update name script")
 	}
 }
 
+// Sets the name value for scripts where IsAnyUpdate() is true to a copy of the
+// passed value.  Panics otherwise.  Use Rebuild to serialize the modified
+// script.
+func (ns *NameScript) SetOpValue(value []byte) {
+	switch ns.op {
+	case OP_NAME_FIRSTUPDATE:
+		ns.args[2] = copyBytes(value)
+	case OP_NAME_UPDATE:
+		ns.args[1] = copyBytes(value)
+	default:
+		panic("called SetOpValue() on non-update name script")
+	}
+}
+
 // Returns the random value for FirstUpdate name operations.
 // Panics otherwise.
+//
+// The random value is binary data, so the returned string is generally not
+// valid UTF-8.  Use OpRandBytes for binary-safe access.
 func (ns *NameScript) OpRand() string {
+	return string(ns.OpRandBytes())
+}
+
+// Returns the random value for FirstUpdate name operations as a copy of the
+// underlying push data.  Panics otherwise.
+func (ns *NameScript) OpRandBytes() []byte {
 	switch ns.op {
 	case OP_NAME_FIRSTUPDATE:
-		return ns.args[1]
+		return copyBytes(ns.args[1])
 	default:
 		panic("called OpRand() on non-FirstUpdate name script")
 	}
 }
 
+// Returns the hash which the name_new preceding this FirstUpdate name
+// operation must commit to.  Panics if the operation is not FirstUpdate.
+func (ns *NameScript) ExpectedNewHash() []byte {
+	return NameNewHash(ns.OpNameBytes(), ns.OpRandBytes())
+}
+
 // Returns the name hash for New name operations.
 // Panics otherwise.
+//
+// The hash is binary data, so the returned string is generally not valid
+// UTF-8.  Use OpHashBytes for binary-safe access.
 func (ns *NameScript) OpHash() string {
+	return string(ns.OpHashBytes())
+}
+
+// Returns the name hash for New name operations as a copy of the underlying
+// push data.  Panics otherwise.
+func (ns *NameScript) OpHashBytes() []byte {
 	switch ns.op {
 	case OP_NAME_NEW:
-		return ns.args[0]
+		return copyBytes(ns.args[0])
 	default:
 		panic("called OpHash() on non-New name script")
 	}
 }
 
+// VerifyNameNewHash returns whether the commitment of a name_new operation
+// matches the name and random salt later revealed by name_firstupdate.
+func VerifyNameNewHash(name, rand []byte, commitment []byte) bool {
+	return bytes.Equal(NameNewHash(name, rand), commitment)
+}
+
+// NameNewHash returns the 20 byte commitment to a name and random salt which a
+// name_new operation pushes, and which the name_firstupdate registering the
+// name must match by revealing the same name and salt.  It is the hash160 of
+// the salt followed by the name.
+func NameNewHash(name, rand []byte) []byte {
+	buf := make([]byte, 0, len(rand)+len(name))
+	buf = append(buf, rand...)
+	buf = append(buf, name...)
+	return calcHash160(buf)
+}
+
+// copyBytes returns a copy of the passed byte slice so that callers can not
+// modify the data backing a parsed script.
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// Equal returns whether two name scripts carry the same name operation, the
+// same arguments and the same address script.  The DROP/2DROP/NOP delimiters
+// separating the arguments from the address script are not compared, so
+// scripts which differ only in their choice of delimiters are equal.
+func (ns *NameScript) Equal(other *NameScript) bool {
+	if ns == nil || other == nil {
+		return ns == other
+	}
+	if ns.op != other.op || len(ns.args) != len(other.args) {
+		return false
+	}
+	for i := range ns.args {
+		if !bytes.Equal(ns.args[i], other.args[i]) {
+			return false
+		}
+	}
+	return bytes.Equal(ns.BasePkScript(), other.BasePkScript())
+}
+
+// Rebuild returns the name script serialized in canonical form, which is the
+// form built by NameScriptBuilder: the name opcode, each argument pushed
+// verbatim with the smallest data push opcode, as many OP_2DROP and OP_DROP
+// opcodes as are needed to clear the operation from the stack, and then the
+// address script.  The delimiters and push opcodes of a parsed script are not
+// kept, so the result may differ from the script which was parsed, but parsing
+// it again gives a NameScript which is Equal to ns.  An error is returned if
+// an argument is too long to be pushed.
+func (ns *NameScript) Rebuild() ([]byte, error) {
+	return NewNameScriptBuilder().setOp(ns.op, ns.args...).
+		Script(ns.BasePkScript())
+}
+
+// maxNameStringArg is the maximum number of bytes of a name operation argument
+// which are shown by NameScript.String before the argument is truncated.
+const maxNameStringArg = 64
+
+// String returns a human-readable description of the name operation followed
+// by the disassembly of the address script, for example:
+//
+//	name_update name="d/example" value="{...}" -> OP_DUP OP_HASH160 ...
+//
+// Names and values are quoted when they are printable UTF-8 and hex-encoded
+// otherwise.  Arguments longer than maxNameStringArg bytes are truncated.
+func (ns *NameScript) String() string {
+	var desc string
+	switch ns.op {
+	case OP_NAME_NEW:
+		desc = "name_new hash=" + formatNameHex(ns.args[0])
+	case OP_NAME_FIRSTUPDATE:
+		desc = "name_firstupdate name=" + formatNameArg(ns.args[0]) +
+			" rand=" + formatNameHex(ns.args[1]) +
+			" value=" + formatNameArg(ns.args[2])
+	case OP_NAME_UPDATE:
+		desc = "name_update name=" + formatNameArg(ns.args[0]) +
+			" value=" + formatNameArg(ns.args[1])
+	default:
+		desc = fmt.Sprintf("name_unknown(%d)", ns.op)
+	}
+
+	// The address script came from parseScript, so disassembling it can
+	// not fail.
+	dis, _ := DisasmString(ns.BasePkScript())
+	return desc + " -> " + dis
+}
+
+// formatNameArg returns the passed name or value quoted if it is printable
+// UTF-8, or hex-encoded otherwise.
+func formatNameArg(b []byte) string {
+	if !utf8.Valid(b) {
+		return formatNameHex(b)
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return formatNameHex(b)
+		}
+	}
+
+	if len(b) <= maxNameStringArg {
+		return strconv.Quote(string(b))
+	}
+
+	// Don't split a multi-byte character when truncating.
+	n := maxNameStringArg
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return strconv.Quote(string(b[:n])) + "..."
+}
+
+// formatNameHex returns the passed argument hex-encoded, truncating it if it
+// is longer than maxNameStringArg bytes.
+func formatNameHex(b []byte) string {
+	if len(b) <= maxNameStringArg {
+		return hex.EncodeToString(b)
+	}
+	return hex.EncodeToString(b[:maxNameStringArg]) + "..."
+}
+
+// nameOpcodeNames maps the name operations to the mnemonics used by
+// DisasmString.  The name operations share their values with OP_1 through
+// OP_3, so these are only used for the first opcode of a name script.
+var nameOpcodeNames = map[byte]string{
+	OP_NAME_NEW:         "OP_NAME_NEW",
+	OP_NAME_FIRSTUPDATE: "OP_NAME_FIRSTUPDATE",
+	OP_NAME_UPDATE:      "OP_NAME_UPDATE",
+}
+
+// stripNamePrefix returns the opcodes of the address script which follows the
+// name prefix and true if pops is a syntactically valid name script.
+// Otherwise pops is returned unchanged along with false.
+func stripNamePrefix(pops []parsedOpcode) ([]parsedOpcode, bool) {
+	ns, err := newNameScript(pops, NameAllowNonStandardBase)
+	if err != nil {
+		return pops, false
+	}
+	return ns.base, true
+}
+
+// typeOfNameBase returns the class of the address script of a name script.
+// Since the name prefix itself is valid, an address script which is not of a
+// standard type is reported as NameScriptTy rather than NonStandardTy.
+func typeOfNameBase(base []parsedOpcode) ScriptClass {
+	class := typeOfScript(base)
+	if class == NonStandardTy {
+		return NameScriptTy
+	}
+	return class
+}
+
 // Determines whether a script contains a syntatically valid name script.
 func IsNameScript(s *Script) bool {
-	_, err := NewNameScript(s)
+	_, err := NewNameScript(s, 0)
 	return err == nil
 }
@@ -0,0 +1,1588 @@
+package btcscript_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/conformal/btcec"
+	"github.com/hlandauf/btcnet"
+	"github.com/hlandauf/btcscript"
+	"github.com/hlandauf/btcutil"
+	"github.com/hlandauf/btcwire"
+)
+
+// nameTestP2PKH is an ordinary pay-to-pubkey-hash script used as the address
+// portion of the name scripts in the tests.
+var nameTestP2PKH = []byte{
+	btcscript.OP_DUP, btcscript.OP_HASH160, btcscript.OP_DATA_20,
+	0x43, 0x3e, 0xc2, 0xac, 0x1f, 0xfa, 0x1b, 0x7b, 0x7d, 0x02,
+	0x7f, 0x56, 0x45, 0x29, 0xc5, 0x71, 0x97, 0xf9, 0xae, 0x88,
+	btcscript.OP_EQUALVERIFY, btcscript.OP_CHECKSIG,
+}
+
+// nameTestP2SH is an ordinary pay-to-script-hash script used as the address
+// portion of the name scripts in the tests.
+var nameTestP2SH = []byte{
+	btcscript.OP_HASH160, btcscript.OP_DATA_20,
+	0x63, 0xbc, 0xc5, 0x65, 0xf9, 0xe6, 0x8e, 0xe0, 0x18, 0x9d,
+	0xd5, 0xcc, 0x67, 0xf1, 0xb0, 0xe5, 0xf0, 0x2f, 0x45, 0xcb,
+	btcscript.OP_EQUAL,
+}
+
+// nameTestHash is a 20 byte commitment used for name_new scripts in the
+// tests.
+var nameTestHash = []byte{
+	0x05, 0x42, 0x8e, 0x47, 0x4f, 0x5b, 0x1b, 0x1c, 0x5e, 0x3b,
+	0x3d, 0x10, 0x44, 0x36, 0xb3, 0xc5, 0x17, 0x0e, 0x8e, 0x42,
+}
+
+// nameScript builds a name script from the passed name operation, arguments
+// and delimiter opcodes followed by the address script.
+func nameScript(op byte, args [][]byte, delims []byte, addr []byte) []byte {
+	builder := btcscript.NewScriptBuilder().AddOp(op)
+	for _, arg := range args {
+		builder.AddFullData(arg)
+	}
+	for _, delim := range delims {
+		builder.AddOp(delim)
+	}
+	script, err := builder.Script()
+	if err != nil {
+		panic("invalid name script in test source: " + err.Error())
+	}
+	return append(script, addr...)
+}
+
+// TestNewNameScript tests that name scripts of each operation type are parsed
+// and classified correctly and that malformed scripts are rejected.
+func TestNewNameScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []byte
+		err    error
+		op     byte
+		args   []string
+	}{
+		{
+			name: "name_new",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			op:   btcscript.OP_NAME_NEW,
+			args: []string{string(nameTestHash)},
+		},
+		{
+			name: "name_firstupdate",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), []byte("rand"),
+					[]byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				nameTestP2PKH),
+			op:   btcscript.OP_NAME_FIRSTUPDATE,
+			args: []string{"d/example", "rand", "value"},
+		},
+		{
+			name: "name_update",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			op:   btcscript.OP_NAME_UPDATE,
+			args: []string{"d/example", "value"},
+		},
+		{
+			name: "name_new with too many args",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash, nameTestHash},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			err: btcscript.ErrNameWrongArgCount,
+		},
+		{
+			name: "name_firstupdate with too few args",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			err: btcscript.ErrNameWrongArgCount,
+		},
+		{
+			name: "name_update with too few args",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example")},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			err: btcscript.ErrNameWrongArgCount,
+		},
+		{
+			name:   "empty script",
+			script: []byte{},
+			err:    btcscript.ErrNameEmptyScript,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ns, err := btcscript.NewNameScriptFromPk(test.script, 0)
+		if !errors.Is(err, test.err) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: got %v, want %v", i, test.name, err,
+				test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if ns.NameOp() != test.op {
+			t.Errorf("NewNameScriptFromPk #%d (%s) wrong op: "+
+				"got %d, want %d", i, test.name, ns.NameOp(),
+				test.op)
+			continue
+		}
+
+		var args []string
+		switch ns.NameOp() {
+		case btcscript.OP_NAME_NEW:
+			args = []string{ns.OpHash()}
+		case btcscript.OP_NAME_FIRSTUPDATE:
+			args = []string{ns.OpName(), ns.OpRand(), ns.OpValue()}
+		case btcscript.OP_NAME_UPDATE:
+			args = []string{ns.OpName(), ns.OpValue()}
+		}
+		if len(args) != len(test.args) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) wrong number "+
+				"of args: got %d, want %d", i, test.name,
+				len(args), len(test.args))
+			continue
+		}
+		for j := range args {
+			if args[j] != test.args[j] {
+				t.Errorf("NewNameScriptFromPk #%d (%s) wrong "+
+					"arg %d: got %q, want %q", i, test.name,
+					j, args[j], test.args[j])
+			}
+		}
+	}
+}
+
+// TestNewNameScriptFromPk ensures parsing the raw bytes of name outputs gives
+// the same name scripts as parsing a Script holding them, including the
+// Script returned by Address.
+func TestNewNameScriptFromPk(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []byte
+		op     byte
+	}{
+		{
+			name: "name_new",
+			script: decodeHex("511405428e474f5b1b1c5e3b3d104436b3c5170e" +
+				"8e426d76a914433ec2ac1ffa1b7b7d027f564529c57197" +
+				"f9ae8888ac"),
+			op: btcscript.OP_NAME_NEW,
+		},
+		{
+			name: "name_firstupdate",
+			script: decodeHex("5209642f6578616d706c650472616e640576616c" +
+				"75656d6d76a914433ec2ac1ffa1b7b7d027f564529c571" +
+				"97f9ae8888ac"),
+			op: btcscript.OP_NAME_FIRSTUPDATE,
+		},
+		{
+			name: "name_update",
+			script: decodeHex("5309642f6578616d706c650576616c75656d7576" +
+				"a914433ec2ac1ffa1b7b7d027f564529c57197f9ae8888" +
+				"ac"),
+			op: btcscript.OP_NAME_UPDATE,
+		},
+	}
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ns, err := btcscript.NewNameScriptFromPk(test.script,
+			btcscript.NameCheckLimits)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if ns.NameOp() != test.op {
+			t.Errorf("NewNameScriptFromPk #%d (%s) wrong op: got "+
+				"%d, want %d", i, test.name, ns.NameOp(), test.op)
+		}
+		if !bytes.Equal(ns.BasePkScript(), nameTestP2PKH) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) wrong address "+
+				"script: got %x, want %x", i, test.name,
+				ns.BasePkScript(), nameTestP2PKH)
+		}
+
+		s, err := btcscript.NewScript(nil, test.script, 0, tx, 0)
+		if err != nil {
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		want, err := btcscript.NewNameScript(s,
+			btcscript.NameCheckLimits)
+		if err != nil {
+			t.Errorf("NewNameScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		if !ns.Equal(want) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) differs from "+
+				"NewNameScript", i, test.name)
+		}
+
+		// The Script returned by Address holds the whole pkScript.
+		if ns.Address() == nil {
+			t.Errorf("Address #%d (%s) no script", i, test.name)
+			continue
+		}
+		dis, err := ns.Address().DisasmScript(1)
+		if err != nil {
+			t.Errorf("DisasmScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		wantDis, err := want.Address().DisasmScript(1)
+		if err != nil {
+			t.Errorf("DisasmScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		if dis != wantDis {
+			t.Errorf("Address #%d (%s) wrong script: got %q, want "+
+				"%q", i, test.name, dis, wantDis)
+		}
+	}
+
+	// Scripts which fail to parse are reported as such.
+	_, err := btcscript.NewNameScriptFromPk([]byte{btcscript.OP_NAME_UPDATE,
+		btcscript.OP_DATA_2, 0x01}, 0)
+	if err != btcscript.ErrStackShortScript {
+		t.Errorf("NewNameScriptFromPk wrong error for unparsable "+
+			"script - got %v, want %v", err,
+			btcscript.ErrStackShortScript)
+	}
+}
+
+// TestNameScriptDelimiters ensures each of the delimiter layouts found on
+// mainnet separates the arguments from the address script at the same place.
+func TestNameScriptDelimiters(t *testing.T) {
+	const (
+		drop  = btcscript.OP_DROP
+		drop2 = btcscript.OP_2DROP
+		nop   = btcscript.OP_NOP
+	)
+	nameArgs := [][]byte{[]byte("d/example"), []byte("value")}
+	tests := []struct {
+		name   string
+		delims []byte
+		base   []byte
+	}{
+		{"OP_2DROP OP_DROP", []byte{drop2, drop}, nameTestP2PKH},
+		{"OP_DROP OP_2DROP", []byte{drop, drop2}, nameTestP2PKH},
+		{"three OP_DROPs", []byte{drop, drop, drop}, nameTestP2PKH},
+		{"two OP_DROPs", []byte{drop, drop}, nameTestP2PKH},
+		{"single OP_2DROP", []byte{drop2}, nameTestP2PKH},
+		{"single OP_DROP", []byte{drop}, nameTestP2PKH},
+		{"single OP_NOP", []byte{nop}, nameTestP2PKH},
+		{"OP_NOP after drops", []byte{drop2, drop, nop}, nameTestP2SH},
+		{"drops after OP_NOP", []byte{nop, drop2, drop}, nameTestP2SH},
+		{"single OP_NOP ending the script", []byte{nop}, []byte{}},
+		{"OP_2DROP ending the script", []byte{drop2}, []byte{}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		script := nameScript(btcscript.OP_NAME_UPDATE, nameArgs,
+			test.delims, test.base)
+		ns, err := btcscript.NewNameScriptFromPk(script,
+			btcscript.NameAllowNonStandardBase)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if ns.OpName() != "d/example" || ns.OpValue() != "value" {
+			t.Errorf("NewNameScriptFromPk #%d (%s) wrong args: "+
+				"got %q %q", i, test.name, ns.OpName(),
+				ns.OpValue())
+		}
+		if !bytes.Equal(ns.BasePkScript(), test.base) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) wrong address "+
+				"script: got %x, want %x", i, test.name,
+				ns.BasePkScript(), test.base)
+		}
+		if !bytes.Equal(ns.DelimiterOpcodes(), test.delims) {
+			t.Errorf("DelimiterOpcodes #%d (%s) got %x, want %x", i,
+				test.name, ns.DelimiterOpcodes(), test.delims)
+		}
+	}
+
+	// A rebuilt script uses OP_2DROP where it can, so it only keeps the
+	// delimiters of a script which does the same.
+	for i, test := range []struct {
+		delims []byte
+		same   bool
+	}{
+		{[]byte{drop2, drop}, true},
+		{[]byte{drop, drop, drop}, false},
+	} {
+		ns, err := btcscript.NewNameScriptFromPk(nameScript(
+			btcscript.OP_NAME_UPDATE, nameArgs, test.delims,
+			nameTestP2PKH), 0)
+		if err != nil {
+			t.Fatalf("NewNameScriptFromPk #%d unexpected error: %v",
+				i, err)
+		}
+		script, err := ns.Rebuild()
+		if err != nil {
+			t.Fatalf("Rebuild #%d unexpected error: %v", i, err)
+		}
+		rebuilt, err := btcscript.NewNameScriptFromPk(script, 0)
+		if err != nil {
+			t.Fatalf("NewNameScriptFromPk #%d unexpected error: %v",
+				i, err)
+		}
+		same := bytes.Equal(rebuilt.DelimiterOpcodes(),
+			ns.DelimiterOpcodes())
+		if same != test.same {
+			t.Errorf("DelimiterOpcodes #%d rebuilt got %x, parsed "+
+				"%x", i, rebuilt.DelimiterOpcodes(),
+				ns.DelimiterOpcodes())
+		}
+
+		// The returned opcodes are a copy.
+		ns.DelimiterOpcodes()[0] = nop
+		if !bytes.Equal(ns.DelimiterOpcodes(), test.delims) {
+			t.Errorf("DelimiterOpcodes #%d modified through the "+
+				"returned slice", i)
+		}
+	}
+
+	// An address script is still required without
+	// NameAllowNonStandardBase.
+	script := nameScript(btcscript.OP_NAME_UPDATE, nameArgs, []byte{nop},
+		[]byte{})
+	if _, err := btcscript.NewNameScriptFromPk(script, 0); !errors.Is(err,
+		btcscript.ErrNameBadBaseScript) {
+		t.Errorf("NewNameScriptFromPk with no address script unexpected "+
+			"error: got %v, want %v", err,
+			btcscript.ErrNameBadBaseScript)
+	}
+}
+
+// TestIsNameScript ensures IsNameScript recognises name scripts when they are
+// loaded into a script engine and rejects ordinary scripts.
+func TestIsNameScript(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []byte
+		isName bool
+	}{
+		{
+			name: "name_new",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			isName: true,
+		},
+		{
+			name: "name_firstupdate",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), []byte("rand"),
+					[]byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				nameTestP2PKH),
+			isName: true,
+		},
+		{
+			name: "name_update",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			isName: true,
+		},
+		{
+			name:   "pay to pubkey hash",
+			script: nameTestP2PKH,
+			isName: false,
+		},
+	}
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		s, err := btcscript.NewScript(nil, test.script, 0, tx, 0)
+		if err != nil {
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if btcscript.IsNameScript(s) != test.isName {
+			t.Errorf("IsNameScript #%d (%s) wrong result: got %v, "+
+				"want %v", i, test.name, !test.isName,
+				test.isName)
+		}
+	}
+}
+
+// TestNameScriptBytes ensures the byte slice accessors return binary data
+// unmodified and that modifying the returned slices does not alter the
+// parsed script.
+func TestNameScriptBytes(t *testing.T) {
+	name := []byte("d/\x00binary\xff")
+	rand := []byte{0x00, 0xff, 0x00, 0xff}
+	value := []byte{0x7b, 0x00, 0xff, 0xfe, 0x00, 0x7d}
+
+	script := nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+		[][]byte{name, rand, value},
+		[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP}, nameTestP2PKH)
+	ns, err := btcscript.NewNameScriptFromPk(script, 0)
+	if err != nil {
+		t.Fatalf("NewNameScriptFromPk: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  func() []byte
+		str  func() string
+		want []byte
+	}{
+		{name: "name", got: ns.OpNameBytes, str: ns.OpName, want: name},
+		{name: "rand", got: ns.OpRandBytes, str: ns.OpRand, want: rand},
+		{name: "value", got: ns.OpValueBytes, str: ns.OpValue, want: value},
+	}
+
+	for i, test := range tests {
+		got := test.got()
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("#%d (%s) wrong bytes: got %x, want %x", i,
+				test.name, got, test.want)
+			continue
+		}
+		if test.str() != string(test.want) {
+			t.Errorf("#%d (%s) wrong string: got %q, want %q", i,
+				test.name, test.str(), test.want)
+			continue
+		}
+
+		// Modifying the returned slice must not affect the script.
+		got[0] ^= 0xff
+		if !bytes.Equal(test.got(), test.want) {
+			t.Errorf("#%d (%s) returned slice aliases script data",
+				i, test.name)
+		}
+	}
+
+	script = nameScript(btcscript.OP_NAME_NEW, [][]byte{nameTestHash},
+		[]byte{btcscript.OP_2DROP}, nameTestP2PKH)
+	ns, err = btcscript.NewNameScriptFromPk(script, 0)
+	if err != nil {
+		t.Fatalf("NewNameScriptFromPk: unexpected error: %v", err)
+	}
+	if !bytes.Equal(ns.OpHashBytes(), nameTestHash) {
+		t.Errorf("OpHashBytes: got %x, want %x", ns.OpHashBytes(),
+			nameTestHash)
+	}
+}
+
+// TestNameScriptLimits ensures the consensus limits on name operation
+// arguments are enforced when requested and ignored otherwise.
+func TestNameScriptLimits(t *testing.T) {
+	update := func(name, value []byte) []byte {
+		return nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{name, value},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+			nameTestP2PKH)
+	}
+	firstUpdate := func(name, value []byte) []byte {
+		return nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+			[][]byte{name, []byte("rand"), value},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+			nameTestP2PKH)
+	}
+	nameNew := func(hash []byte) []byte {
+		return nameScript(btcscript.OP_NAME_NEW, [][]byte{hash},
+			[]byte{btcscript.OP_2DROP}, nameTestP2PKH)
+	}
+	n := func(l int) []byte {
+		return bytes.Repeat([]byte{'a'}, l)
+	}
+
+	tests := []struct {
+		name   string
+		script []byte
+		err    error
+	}{
+		{
+			name:   "name at limit",
+			script: update(n(btcscript.MaxNameLength), n(1)),
+		},
+		{
+			name:   "name over limit",
+			script: update(n(btcscript.MaxNameLength+1), n(1)),
+			err:    btcscript.ErrNameTooLong,
+		},
+		{
+			name: "firstupdate name over limit",
+			script: firstUpdate(n(btcscript.MaxNameLength+1),
+				n(1)),
+			err: btcscript.ErrNameTooLong,
+		},
+		{
+			name:   "empty name",
+			script: update(nil, n(1)),
+			err:    btcscript.ErrNameEmptyName,
+		},
+		{
+			name:   "firstupdate empty name",
+			script: firstUpdate(nil, n(1)),
+			err:    btcscript.ErrNameEmptyName,
+		},
+		{
+			name:   "empty value",
+			script: update(n(2), nil),
+		},
+		{
+			name:   "firstupdate empty value",
+			script: firstUpdate(n(2), nil),
+		},
+		{
+			name:   "name and value not empty",
+			script: update(n(1), n(1)),
+		},
+		{
+			name:   "value at limit",
+			script: update(n(2), n(btcscript.MaxNameValueLength)),
+		},
+		{
+			name:   "value over limit",
+			script: update(n(2), n(btcscript.MaxNameValueLength+1)),
+			err:    btcscript.ErrNameValueTooLong,
+		},
+		{
+			name: "firstupdate value over limit",
+			script: firstUpdate(n(2),
+				n(btcscript.MaxNameValueLength+1)),
+			err: btcscript.ErrNameValueTooLong,
+		},
+		{
+			name:   "hash at size",
+			script: nameNew(n(btcscript.NameHashSize)),
+		},
+		{
+			name:   "hash one over size",
+			script: nameNew(n(btcscript.NameHashSize + 1)),
+			err:    btcscript.ErrNameHashWrongSize,
+		},
+		{
+			name:   "hash one under size",
+			script: nameNew(n(btcscript.NameHashSize - 1)),
+			err:    btcscript.ErrNameHashWrongSize,
+		},
+		{
+			name:   "empty hash",
+			script: nameNew(nil),
+			err:    btcscript.ErrNameHashWrongSize,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		_, err := btcscript.NewNameScriptFromPk(test.script,
+			btcscript.NameCheckLimits)
+		if !errors.Is(err, test.err) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: got %v, want %v", i, test.name, err,
+				test.err)
+			continue
+		}
+
+		// Without the flag every script must be accepted.
+		_, err = btcscript.NewNameScriptFromPk(test.script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error without limits: %v", i, test.name, err)
+		}
+	}
+}
+
+// TestNameScriptBaseScript ensures the address script following a name prefix
+// is extracted intact and can be classified normally.
+func TestNameScriptBaseScript(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  []byte
+		class btcscript.ScriptClass
+	}{
+		{
+			name:  "pay to pubkey hash",
+			base:  nameTestP2PKH,
+			class: btcscript.PubKeyHashTy,
+		},
+		{
+			name:  "pay to script hash",
+			base:  nameTestP2SH,
+			class: btcscript.ScriptHashTy,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		script := nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, test.base)
+		ns, err := btcscript.NewNameScriptFromPk(script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+
+		base := ns.BasePkScript()
+		if !bytes.Equal(base, test.base) {
+			t.Errorf("BasePkScript #%d (%s) wrong result\n"+
+				"got: %x\nwant: %x", i, test.name, base,
+				test.base)
+			continue
+		}
+		if class := btcscript.GetScriptClass(base); class != test.class {
+			t.Errorf("GetScriptClass #%d (%s) wrong class: got %v, "+
+				"want %v", i, test.name, class, test.class)
+			continue
+		}
+
+		_, addrs, _, err := btcscript.ExtractPkScriptAddrs(base,
+			&btcnet.MainNetParams)
+		if err != nil || len(addrs) != 1 {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) unexpected "+
+				"result: %v, %v", i, test.name, addrs, err)
+			continue
+		}
+
+		dis, err := ns.BaseScript().DisasmScript(1)
+		if err != nil {
+			t.Errorf("DisasmScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		want, _ := btcscript.DisasmString(test.base)
+		if got := disasmOneline(dis); got != want {
+			t.Errorf("BaseScript #%d (%s) wrong disassembly: got "+
+				"%q, want %q", i, test.name, got, want)
+		}
+	}
+}
+
+// disasmOneline converts the multi-line disassembly produced by DisasmScript
+// to the single line format produced by DisasmString.
+func disasmOneline(dis string) string {
+	var ops []string
+	for _, line := range strings.Split(strings.TrimSpace(dis), "\n") {
+		fields := strings.Fields(line)
+		op := fields[1]
+		if len(fields) > 2 {
+			op = strings.Join(fields[2:], "")
+		}
+		ops = append(ops, op)
+	}
+	return strings.Join(ops, " ")
+}
+
+// TestNameScriptDisasm ensures the operation of a name script is disassembled
+// by its mnemonic while the same opcodes elsewhere are not.
+func TestNameScriptDisasm(t *testing.T) {
+	p2pkhDis, _ := btcscript.DisasmString(nameTestP2PKH)
+
+	tests := []struct {
+		name     string
+		script   []byte
+		expected string
+	}{
+		{
+			name: "name_new",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			expected: "OP_NAME_NEW 05428e474f5b1b1c5e3b3d104436b3c5" +
+				"170e8e42 OP_2DROP " + p2pkhDis,
+		},
+		{
+			name: "name_firstupdate",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), {0x01, 0x02},
+					[]byte("{}")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				nameTestP2PKH),
+			expected: "OP_NAME_FIRSTUPDATE 642f6578616d706c65 0102 " +
+				"7b7d OP_2DROP OP_2DROP " + p2pkhDis,
+		},
+		{
+			name: "name_update",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("{}")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2SH),
+			expected: "OP_NAME_UPDATE 642f6578616d706c65 7b7d " +
+				"OP_2DROP OP_DROP OP_HASH160 63bcc565f9e68ee0189d" +
+				"d5cc67f1b0e5f02f45cb OP_EQUAL",
+		},
+		{
+			name: "small integers outside a name script",
+			script: []byte{btcscript.OP_1, btcscript.OP_2,
+				btcscript.OP_3, btcscript.OP_DROP},
+			expected: "1 2 3 OP_DROP",
+		},
+		{
+			name: "name prefix without delimiters",
+			script: append([]byte{btcscript.OP_NAME_UPDATE,
+				btcscript.OP_DATA_1, 0x61, btcscript.OP_DATA_1, 0x62},
+				nameTestP2PKH...),
+			expected: "3 61 62 " + p2pkhDis,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		dis, err := btcscript.DisasmString(test.script)
+		if err != nil {
+			t.Errorf("DisasmString #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if dis != test.expected {
+			t.Errorf("DisasmString #%d (%s) wrong disassembly: got "+
+				"%q, want %q", i, test.name, dis, test.expected)
+		}
+	}
+}
+
+// TestNameScriptString ensures name scripts of each operation type are
+// described correctly, including binary and over-long arguments.
+func TestNameScriptString(t *testing.T) {
+	p2pkhDis, _ := btcscript.DisasmString(nameTestP2PKH)
+	long := bytes.Repeat([]byte{'a'}, 100)
+
+	tests := []struct {
+		name     string
+		script   []byte
+		expected string
+	}{
+		{
+			name: "name_new",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			expected: "name_new hash=05428e474f5b1b1c5e3b3d104436" +
+				"b3c5170e8e42 -> " + p2pkhDis,
+		},
+		{
+			name: "name_firstupdate",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), {0xab, 0xcd},
+					[]byte(`{"ip":"192.0.2.1"}`)},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				nameTestP2PKH),
+			expected: `name_firstupdate name="d/example" ` +
+				`rand=abcd value="{\"ip\":\"192.0.2.1\"}" -> ` +
+				p2pkhDis,
+		},
+		{
+			name: "name_update with binary value",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), {0x00, 0xff}},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			expected: `name_update name="d/example" value=00ff -> ` +
+				p2pkhDis,
+		},
+		{
+			name: "name_update with long value",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), long},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			expected: `name_update name="d/example" value="` +
+				string(long[:64]) + `"... -> ` + p2pkhDis,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ns, err := btcscript.NewNameScriptFromPk(test.script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if got := ns.String(); got != test.expected {
+			t.Errorf("String #%d (%s) wrong result\ngot:  %s\n"+
+				"want: %s", i, test.name, got, test.expected)
+		}
+	}
+}
+
+// TestNameScriptPredicates ensures exactly one of the operation type
+// predicates is true for each name operation and that IsAnyUpdate agrees.
+func TestNameScriptPredicates(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      []byte
+		new         bool
+		firstUpdate bool
+		update      bool
+	}{
+		{
+			name: "name_new",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			new: true,
+		},
+		{
+			name: "name_firstupdate",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), []byte("rand"),
+					[]byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				nameTestP2PKH),
+			firstUpdate: true,
+		},
+		{
+			name: "name_update",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			update: true,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ns, err := btcscript.NewNameScriptFromPk(test.script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if ns.IsNameNew() != test.new {
+			t.Errorf("IsNameNew #%d (%s): got %v, want %v", i,
+				test.name, ns.IsNameNew(), test.new)
+		}
+		if ns.IsNameFirstUpdate() != test.firstUpdate {
+			t.Errorf("IsNameFirstUpdate #%d (%s): got %v, want %v",
+				i, test.name, ns.IsNameFirstUpdate(),
+				test.firstUpdate)
+		}
+		if ns.IsNameUpdate() != test.update {
+			t.Errorf("IsNameUpdate #%d (%s): got %v, want %v", i,
+				test.name, ns.IsNameUpdate(), test.update)
+		}
+		anyUpdate := test.firstUpdate || test.update
+		if ns.IsAnyUpdate() != anyUpdate {
+			t.Errorf("IsAnyUpdate #%d (%s): got %v, want %v", i,
+				test.name, ns.IsAnyUpdate(), anyUpdate)
+		}
+	}
+}
+
+// TestNameScriptNamespace ensures names are split into their namespace and
+// identifier on the first slash.
+func TestNameScriptNamespace(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespace  string
+		identifier string
+	}{
+		{name: "d/example", namespace: "d", identifier: "example"},
+		{name: "id/bob", namespace: "id", identifier: "bob"},
+		{name: "d/a/b", namespace: "d", identifier: "a/b"},
+		{name: "d/", namespace: "d", identifier: ""},
+		{name: "noslash", namespace: "", identifier: ""},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		script := nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte(test.name), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+			nameTestP2PKH)
+		ns, err := btcscript.NewNameScriptFromPk(script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if ns.Namespace() != test.namespace {
+			t.Errorf("Namespace #%d (%s): got %q, want %q", i,
+				test.name, ns.Namespace(), test.namespace)
+		}
+		if ns.Identifier() != test.identifier {
+			t.Errorf("Identifier #%d (%s): got %q, want %q", i,
+				test.name, ns.Identifier(), test.identifier)
+		}
+	}
+}
+
+// TestNameScriptNameID ensures every update of a name gives the same name
+// identifier whatever its value, and that different names give different
+// identifiers.
+func TestNameScriptNameID(t *testing.T) {
+	firstUpdate := func(name, value string) []byte {
+		return nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+			[][]byte{[]byte(name), []byte("rand"), []byte(value)},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+			nameTestP2PKH)
+	}
+	update := func(name, value string) []byte {
+		return nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte(name), []byte(value)},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+			nameTestP2SH)
+	}
+
+	tests := []struct {
+		name    string
+		scripts [][]byte
+	}{
+		{
+			name: "d/example",
+			scripts: [][]byte{
+				firstUpdate("d/example", "first"),
+				update("d/example", "second"),
+				update("d/example", ""),
+			},
+		},
+		{
+			name: "id/example",
+			scripts: [][]byte{
+				firstUpdate("id/example", "first"),
+				update("id/example", "first"),
+			},
+		},
+		{
+			name:    "d/examplf",
+			scripts: [][]byte{update("d/examplf", "first")},
+		},
+		{
+			name:    "noslash",
+			scripts: [][]byte{update("noslash", "first")},
+		},
+	}
+
+	want := sha256.Sum256([]byte("d/example"))
+	ids := make(map[[sha256.Size]byte]string)
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var id [sha256.Size]byte
+		for j, script := range test.scripts {
+			ns, err := btcscript.NewNameScriptFromPk(script, 0)
+			if err != nil {
+				t.Fatalf("NewNameScriptFromPk #%d.%d (%s) "+
+					"unexpected error: %v", i, j, test.name,
+					err)
+			}
+			got := ns.NameID()
+			if j == 0 {
+				id = got
+				continue
+			}
+			if got != id {
+				t.Errorf("NameID #%d.%d (%s) got %x, want %x",
+					i, j, test.name, got, id)
+			}
+		}
+		if other, ok := ids[id]; ok {
+			t.Errorf("NameID #%d (%s) same as the id of %s", i,
+				test.name, other)
+		}
+		ids[id] = test.name
+	}
+	if _, ok := ids[want]; !ok {
+		t.Errorf("NameID of d/example is not the SHA-256 hash of the " +
+			"name")
+	}
+}
+
+// TestNameScriptErrors ensures every name parsing error can be matched both by
+// its specific sentinel and by ErrNotNameScript, including errors which carry
+// additional context.
+func TestNameScriptErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []byte
+		flags  btcscript.NameFlags
+		err    error
+	}{
+		{
+			name:   "empty script",
+			script: []byte{},
+			err:    btcscript.ErrNameEmptyScript,
+		},
+		{
+			name: "out of range opcode",
+			script: []byte{btcscript.OP_NAME_UPDATE,
+				btcscript.OP_CHECKSIG, btcscript.OP_2DROP},
+			err: btcscript.ErrNameOpcodeOutOfRange,
+		},
+		{
+			name: "no delimiter",
+			script: []byte{btcscript.OP_NAME_UPDATE,
+				btcscript.OP_DATA_1, 'a'},
+			err: btcscript.ErrNameNoDropDelimiter,
+		},
+		{
+			name: "wrong arg count",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example")},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			err: btcscript.ErrNameWrongArgCount,
+		},
+		{
+			name: "unknown op",
+			script: nameScript(btcscript.OP_4,
+				[][]byte{[]byte("d/example")},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			err: btcscript.ErrNameUnknownOp,
+		},
+		{
+			name: "name too long",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{bytes.Repeat([]byte{'a'},
+					btcscript.MaxNameLength+1), []byte("v")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			flags: btcscript.NameCheckLimits,
+			err:   btcscript.ErrNameTooLong,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		_, err := btcscript.NewNameScriptFromPk(test.script, test.flags)
+		if !errors.Is(err, test.err) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: got %v, want %v", i, test.name, err,
+				test.err)
+			continue
+		}
+		if !errors.Is(err, btcscript.ErrNotNameScript) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) error %v does "+
+				"not match ErrNotNameScript", i, test.name, err)
+		}
+	}
+}
+
+// TestVerifyNameNewHash ensures name_new commitments are calculated as the
+// hash160 of the salt followed by the name.
+func TestVerifyNameNewHash(t *testing.T) {
+	tests := []struct {
+		name       string
+		rand       string
+		commitment string
+	}{
+		{
+			name:       "d/example",
+			rand:       "c16a2d0fbae46cd7",
+			commitment: "0e877ac226d4ce3138197495f011b6087b3d3166",
+		},
+		{
+			name:       "id/alice",
+			rand:       "0102030405060708",
+			commitment: "b13aca8fabca535faffe19d8605d576cf5332b08",
+		},
+		{
+			name:       "d/",
+			rand:       "",
+			commitment: "ebb833498545718a6db73311f791f7cdc6b696c3",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		name := []byte(test.name)
+		rand := decodeHex(test.rand)
+		commitment := decodeHex(test.commitment)
+
+		if !btcscript.VerifyNameNewHash(name, rand, commitment) {
+			t.Errorf("VerifyNameNewHash #%d (%s) rejected valid "+
+				"commitment", i, test.name)
+		}
+
+		// Any change to the salt must break the commitment.
+		bad := append(append([]byte{}, rand...), 0x01)
+		if btcscript.VerifyNameNewHash(name, bad, commitment) {
+			t.Errorf("VerifyNameNewHash #%d (%s) accepted wrong "+
+				"salt", i, test.name)
+		}
+
+		script := nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+			[][]byte{name, rand, []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+			nameTestP2PKH)
+		ns, err := btcscript.NewNameScriptFromPk(script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if got := ns.ExpectedNewHash(); !bytes.Equal(got, commitment) {
+			t.Errorf("ExpectedNewHash #%d (%s): got %x, want %x",
+				i, test.name, got, commitment)
+		}
+	}
+}
+
+// TestNameNewHash ensures the name_new commitment is the hash160 of the salt
+// followed by the name, and that a name_new built with it is matched by the
+// name_firstupdate revealing the same name and salt.
+func TestNameNewHash(t *testing.T) {
+	tests := []struct {
+		name string
+		rand string
+		want string
+	}{
+		{"d/example", "c16a2d0fbae46cd7",
+			"0e877ac226d4ce3138197495f011b6087b3d3166"},
+		{"d/bitcoin", "0000000000000000000000000000000000000000",
+			"402897c49bc03db677d3a550449f3e3bf778580b"},
+		{"d/" + strings.Repeat("x", 253), "ffeeddccbbaa9988",
+			"ef86d424a355fe33a7b85b6cbd79f8450a75a32f"},
+		{"id/\x00\xff", "5a", "3157f375c89f090dcf30ac7ba03b32c896857380"},
+		{"", "", "b472a266d0bd89c13706a4132ccfb16f7c3b9fcb"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		name := []byte(test.name)
+		rand := decodeHex(test.rand)
+		want := decodeHex(test.want)
+
+		hash := btcscript.NameNewHash(name, rand)
+		if !bytes.Equal(hash, want) {
+			t.Errorf("NameNewHash #%d (%q) got %x, want %x", i,
+				test.name, hash, want)
+			continue
+		}
+
+		newScript, err := btcscript.NewNameScriptBuilder().
+			NameNew(hash).Script(nameTestP2PKH)
+		if err != nil {
+			t.Errorf("NameNew #%d (%q) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		firstUpdate, err := btcscript.NewNameScriptBuilder().
+			NameFirstUpdate(name, rand, []byte("value")).
+			Script(nameTestP2PKH)
+		if err != nil {
+			t.Errorf("NameFirstUpdate #%d (%q) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		newNs, err := btcscript.NewNameScriptFromPk(newScript, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%q) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		firstNs, err := btcscript.NewNameScriptFromPk(firstUpdate, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%q) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(firstNs.ExpectedNewHash(), newNs.OpHashBytes()) {
+			t.Errorf("ExpectedNewHash #%d (%q) does not match "+
+				"name_new hash", i, test.name)
+		}
+	}
+}
+
+// TestNameScriptEqual ensures name scripts compare equal when they carry the
+// same operation, arguments and address script regardless of the delimiters
+// used between the arguments and the address script.
+func TestNameScriptEqual(t *testing.T) {
+	update := func(name, value string, delims []byte, addr []byte) []byte {
+		return nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte(name), []byte(value)}, delims, addr)
+	}
+	canonical := []byte{btcscript.OP_2DROP, btcscript.OP_DROP}
+
+	tests := []struct {
+		name  string
+		a     []byte
+		b     []byte
+		equal bool
+	}{
+		{
+			name:  "identical",
+			a:     update("d/example", "value", canonical, nameTestP2PKH),
+			b:     update("d/example", "value", canonical, nameTestP2PKH),
+			equal: true,
+		},
+		{
+			name: "DROP DROP DROP delimiters",
+			a:    update("d/example", "value", canonical, nameTestP2PKH),
+			b: update("d/example", "value",
+				[]byte{btcscript.OP_DROP, btcscript.OP_DROP,
+					btcscript.OP_DROP}, nameTestP2PKH),
+			equal: true,
+		},
+		{
+			name: "NOP padded delimiters",
+			a:    update("d/example", "value", canonical, nameTestP2PKH),
+			b: update("d/example", "value",
+				[]byte{btcscript.OP_2DROP, btcscript.OP_NOP,
+					btcscript.OP_DROP}, nameTestP2PKH),
+			equal: true,
+		},
+		{
+			name:  "different name",
+			a:     update("d/example", "value", canonical, nameTestP2PKH),
+			b:     update("d/other", "value", canonical, nameTestP2PKH),
+			equal: false,
+		},
+		{
+			name:  "different value",
+			a:     update("d/example", "value", canonical, nameTestP2PKH),
+			b:     update("d/example", "other", canonical, nameTestP2PKH),
+			equal: false,
+		},
+		{
+			name:  "different address",
+			a:     update("d/example", "value", canonical, nameTestP2PKH),
+			b:     update("d/example", "value", canonical, nameTestP2SH),
+			equal: false,
+		},
+		{
+			name: "different op",
+			a: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), []byte("value"),
+					[]byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				nameTestP2PKH),
+			b:     update("d/example", "value", canonical, nameTestP2PKH),
+			equal: false,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		a, err := btcscript.NewNameScriptFromPk(test.a, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		b, err := btcscript.NewNameScriptFromPk(test.b, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if a.Equal(b) != test.equal || b.Equal(a) != test.equal {
+			t.Errorf("Equal #%d (%s): got %v, want %v", i,
+				test.name, a.Equal(b), test.equal)
+		}
+	}
+}
+
+// TestNameScriptRebuild ensures name scripts are serialized again in the form
+// built by NameScriptBuilder, that the result parses to an equal name script
+// and that a changed value is carried over.
+func TestNameScriptRebuild(t *testing.T) {
+	name, rand := []byte("d/example"), []byte{0x01, 0x02, 0x03, 0x04}
+	mustBuild := func(b *btcscript.NameScriptBuilder) []byte {
+		script, err := b.Script(nameTestP2PKH)
+		if err != nil {
+			panic("invalid name script in test source: " + err.Error())
+		}
+		return script
+	}
+
+	// A name_update pushing its value with OP_PUSHDATA1 rather than
+	// OP_DATA_5.
+	pushData1 := []byte{btcscript.OP_NAME_UPDATE, btcscript.OP_DATA_9}
+	pushData1 = append(pushData1, name...)
+	pushData1 = append(pushData1, btcscript.OP_PUSHDATA1, 5)
+	pushData1 = append(pushData1, []byte("value")...)
+	pushData1 = append(pushData1, btcscript.OP_DROP, btcscript.OP_DROP,
+		btcscript.OP_DROP)
+	pushData1 = append(pushData1, nameTestP2PKH...)
+
+	tests := []struct {
+		name     string
+		script   []byte
+		expected []byte
+		newValue []byte
+		edited   []byte
+	}{
+		{
+			name: "name_new",
+			script: mustBuild(btcscript.NewNameScriptBuilder().
+				NameNew(nameTestHash)),
+			expected: mustBuild(btcscript.NewNameScriptBuilder().
+				NameNew(nameTestHash)),
+		},
+		{
+			name: "name_new NOP delimiter",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash}, []byte{btcscript.OP_NOP},
+				nameTestP2PKH),
+			expected: mustBuild(btcscript.NewNameScriptBuilder().
+				NameNew(nameTestHash)),
+		},
+		{
+			name: "name_firstupdate DROP delimiters",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{name, rand, []byte("value")},
+				[]byte{btcscript.OP_DROP, btcscript.OP_DROP,
+					btcscript.OP_DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			expected: mustBuild(btcscript.NewNameScriptBuilder().
+				NameFirstUpdate(name, rand, []byte("value"))),
+			newValue: []byte("new value"),
+			edited: mustBuild(btcscript.NewNameScriptBuilder().
+				NameFirstUpdate(name, rand, []byte("new value"))),
+		},
+		{
+			name: "name_update canonical",
+			script: mustBuild(btcscript.NewNameScriptBuilder().
+				NameUpdate(name, []byte("value"))),
+			expected: mustBuild(btcscript.NewNameScriptBuilder().
+				NameUpdate(name, []byte("value"))),
+			newValue: []byte{},
+			edited: mustBuild(btcscript.NewNameScriptBuilder().
+				NameUpdate(name, []byte{})),
+		},
+		{
+			name:   "name_update OP_PUSHDATA1 value",
+			script: pushData1,
+			expected: mustBuild(btcscript.NewNameScriptBuilder().
+				NameUpdate(name, []byte("value"))),
+			newValue: bytes.Repeat([]byte{0x07}, 300),
+			edited: mustBuild(btcscript.NewNameScriptBuilder().
+				NameUpdate(name, bytes.Repeat([]byte{0x07}, 300))),
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ns, err := btcscript.NewNameScriptFromPk(test.script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		script, err := ns.Rebuild()
+		if err != nil {
+			t.Errorf("Rebuild #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if !bytes.Equal(script, test.expected) {
+			t.Errorf("Rebuild #%d (%s) got: %x\nwant: %x", i,
+				test.name, script, test.expected)
+			continue
+		}
+		rebuilt, err := btcscript.NewNameScriptFromPk(script, 0)
+		if err != nil || !rebuilt.Equal(ns) {
+			t.Errorf("Rebuild #%d (%s) does not parse to an equal "+
+				"name script: %v", i, test.name, err)
+			continue
+		}
+
+		if test.newValue == nil {
+			continue
+		}
+		ns.SetOpValue(test.newValue)
+		script, err = ns.Rebuild()
+		if err != nil || !bytes.Equal(script, test.edited) {
+			t.Errorf("Rebuild #%d (%s) of edited script got: %x, "+
+				"%v\nwant: %x", i, test.name, script, err,
+				test.edited)
+			continue
+		}
+		edited, err := btcscript.NewNameScriptFromPk(script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) of edited script "+
+				"unexpected error: %v", i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(edited.OpValueBytes(), test.newValue) ||
+			!edited.Equal(ns) {
+			t.Errorf("Rebuild #%d (%s) edited script has value %x, "+
+				"want %x", i, test.name, edited.OpValueBytes(),
+				test.newValue)
+		}
+	}
+
+	// A value too large to push can not be rebuilt.
+	ns, err := btcscript.NewNameScriptFromPk(tests[3].script, 0)
+	if err != nil {
+		t.Fatalf("NewNameScriptFromPk unexpected error: %v", err)
+	}
+	ns.SetOpValue(make([]byte, btcscript.MaxScriptElementSize+1))
+	if _, err := ns.Rebuild(); err != btcscript.ErrStackElementTooBig {
+		t.Errorf("Rebuild of oversized value got error %v, want %v",
+			err, btcscript.ErrStackElementTooBig)
+	}
+}
+
+// TestNameScriptBadBase ensures name scripts whose address script is not
+// standard are rejected unless NameAllowNonStandardBase is given.
+func TestNameScriptBadBase(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  []byte
+		valid bool
+	}{
+		{
+			name:  "pay to pubkey hash",
+			base:  nameTestP2PKH,
+			valid: true,
+		},
+		{
+			name:  "empty",
+			base:  []byte{},
+			valid: false,
+		},
+		{
+			name: "pay to pubkey hash with extra opcodes",
+			base: append(append([]byte{}, nameTestP2PKH...),
+				btcscript.OP_DATA_1, 0x01, btcscript.OP_DROP),
+			valid: false,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		script := nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, test.base)
+
+		_, err := btcscript.NewNameScriptFromPk(script, 0)
+		if test.valid && err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if !test.valid && !errors.Is(err, btcscript.ErrNameBadBaseScript) {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: got %v, want %v", i, test.name, err,
+				btcscript.ErrNameBadBaseScript)
+			continue
+		}
+
+		// Every base must be accepted when explicitly allowed.
+		ns, err := btcscript.NewNameScriptFromPk(script,
+			btcscript.NameAllowNonStandardBase)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error with nonstandard base allowed: %v", i,
+				test.name, err)
+			continue
+		}
+		if !bytes.Equal(ns.BasePkScript(), test.base) {
+			t.Errorf("BasePkScript #%d (%s) wrong result\n"+
+				"got: %x\nwant: %x", i, test.name,
+				ns.BasePkScript(), test.base)
+		}
+	}
+}
+
+// TestNameScriptExecute ensures spends of name outputs paying to a public key
+// hash validate with and without the standard verification flags, the name
+// opcodes executing as the small integers they share their values with.
+func TestNameScriptExecute(t *testing.T) {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("NewPrivateKey unexpected error: %v", err)
+	}
+	pk := (*btcec.PublicKey)(&key.PublicKey).SerializeCompressed()
+	p2pkh := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_HASH160).
+		AddData(btcutil.Hash160(pk)).AddOp(btcscript.OP_EQUALVERIFY).
+		AddOp(btcscript.OP_CHECKSIG))
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+	}{
+		{"name_new", nameScript(btcscript.OP_NAME_NEW,
+			[][]byte{nameTestHash}, []byte{btcscript.OP_2DROP},
+			p2pkh)},
+		{"name_firstupdate", nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+			[][]byte{[]byte("d/example"), []byte("rand"),
+				[]byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP}, p2pkh)},
+		{"name_update", nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, p2pkh)},
+		{"name_update single drops", nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_DROP, btcscript.OP_DROP,
+				btcscript.OP_DROP}, p2pkh)},
+	}
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+	tx.AddTxOut(btcwire.NewTxOut(0, p2pkh))
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		sigScript, err := btcscript.SignatureScript(tx, 0,
+			test.pkScript, btcscript.SigHashAll, key, true)
+		if err != nil {
+			t.Errorf("SignatureScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		// A signature of the address script alone does not commit to
+		// the name prefix and is rejected.
+		baseSigScript, err := btcscript.SignatureScript(tx, 0, p2pkh,
+			btcscript.SigHashAll, key, true)
+		if err != nil {
+			t.Errorf("SignatureScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+
+		for _, flags := range []btcscript.ScriptFlags{0,
+			btcscript.StandardVerifyFlags} {
+			for _, spend := range []struct {
+				sigScript []byte
+				err       error
+			}{
+				{sigScript, nil},
+				{baseSigScript, btcscript.ErrStackScriptFailed},
+			} {
+				engine, err := btcscript.NewScript(spend.sigScript,
+					test.pkScript, 0, tx, flags)
+				if err != nil {
+					t.Errorf("NewScript #%d (%s) unexpected "+
+						"error: %v", i, test.name, err)
+					continue
+				}
+				if err := engine.Execute(); err != spend.err {
+					t.Errorf("Execute #%d (%s) flags %x wrong "+
+						"error - got %v, want %v", i,
+						test.name, flags, err, spend.err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package btcscript
+
+// NameScriptBuilder provides a facility for building name pkScripts.  A name
+// operation is selected by calling one of NameNew, NameFirstUpdate or
+// NameUpdate, after which Script is called with the address script which
+// controls the name to produce the finished pkScript.
+//
+// For example, the following would build a name_update script for the name
+// "d/example" paying to an existing pay-to-pubkey-hash script:
+// 	pkScript, err := btcscript.NewNameScriptBuilder().
+// 		NameUpdate([]byte("d/example"), []byte("value")).
+// 		Script(p2pkhScript)
+type NameScriptBuilder struct {
+	prefix *ScriptBuilder
+	err    error
+}
+
+// NewNameScriptBuilder returns a new instance of a name script builder.  See
+// NameScriptBuilder for details.
+func NewNameScriptBuilder() *NameScriptBuilder {
+	return &NameScriptBuilder{
+		prefix: NewScriptBuilder(),
+	}
+}
+
+// setOp replaces any previously built name prefix with the passed name
+// operation and arguments followed by enough OP_2DROP/OP_DROP opcodes to clear
+// the operation and its arguments from the stack.
+func (b *NameScriptBuilder) setOp(op byte, args ...[]byte) *NameScriptBuilder {
+	b.prefix.Reset()
+	b.err = nil
+
+	b.prefix.AddOp(op)
+	for _, arg := range args {
+		if len(arg) > MaxScriptElementSize {
+			b.err = ErrStackElementTooBig
+			return b
+		}
+		b.prefix.addData(arg)
+	}
+
+	// The name opcode itself pushes a value to the stack, so one more item
+	// than the number of arguments must be dropped.
+	for n := len(args) + 1; n > 0; n -= 2 {
+		if n == 1 {
+			b.prefix.AddOp(OP_DROP)
+		} else {
+			b.prefix.AddOp(OP_2DROP)
+		}
+	}
+
+	return b
+}
+
+// NameNew sets the name operation to a name_new committing to the passed
+// hash, which is usually calculated by NameNewHash.
+func (b *NameScriptBuilder) NameNew(hash []byte) *NameScriptBuilder {
+	return b.setOp(OP_NAME_NEW, hash)
+}
+
+// NameFirstUpdate sets the name operation to a name_firstupdate registering
+// the passed name with the passed value.  rand is the salt which was used to
+// compute the hash in the preceding name_new.
+func (b *NameScriptBuilder) NameFirstUpdate(name, rand, value []byte) *NameScriptBuilder {
+	return b.setOp(OP_NAME_FIRSTUPDATE, name, rand, value)
+}
+
+// NameUpdate sets the name operation to a name_update setting the passed name
+// to the passed value.
+func (b *NameScriptBuilder) NameUpdate(name, value []byte) *NameScriptBuilder {
+	return b.setOp(OP_NAME_UPDATE, name, value)
+}
+
+// Script returns the name pkScript built so far with the passed address
+// script appended.  An error is returned if no name operation has been set or
+// if any of the arguments were too long to be pushed.
+func (b *NameScriptBuilder) Script(addrScript []byte) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	prefix, err := b.prefix.Script()
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix) == 0 {
+		return nil, ErrNameEmptyScript
+	}
+
+	script := make([]byte, 0, len(prefix)+len(addrScript))
+	script = append(script, prefix...)
+	script = append(script, addrScript...)
+	return script, nil
+}
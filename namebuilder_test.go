@@ -0,0 +1,140 @@
+package btcscript_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hlandauf/btcscript"
+)
+
+// TestNameScriptBuilder ensures the scripts produced by the NameScriptBuilder
+// parse back into name scripts carrying the same data.
+func TestNameScriptBuilder(t *testing.T) {
+	name := []byte("d/example")
+	rand := []byte{0x01}
+	value := []byte(`{"ip":"192.0.2.1"}`)
+
+	tests := []struct {
+		name     string
+		build    func(*btcscript.NameScriptBuilder) *btcscript.NameScriptBuilder
+		op       byte
+		args     [][]byte
+		expected []byte
+	}{
+		{
+			name: "name_new",
+			build: func(b *btcscript.NameScriptBuilder) *btcscript.NameScriptBuilder {
+				return b.NameNew(nameTestHash)
+			},
+			op:   btcscript.OP_NAME_NEW,
+			args: [][]byte{nameTestHash},
+			expected: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+		},
+		{
+			name: "name_firstupdate",
+			build: func(b *btcscript.NameScriptBuilder) *btcscript.NameScriptBuilder {
+				return b.NameFirstUpdate(name, rand, value)
+			},
+			op:   btcscript.OP_NAME_FIRSTUPDATE,
+			args: [][]byte{name, rand, value},
+		},
+		{
+			name: "name_update",
+			build: func(b *btcscript.NameScriptBuilder) *btcscript.NameScriptBuilder {
+				return b.NameUpdate(name, value)
+			},
+			op:   btcscript.OP_NAME_UPDATE,
+			args: [][]byte{name, value},
+			expected: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{name, value},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+		},
+		{
+			name: "name_update with empty value",
+			build: func(b *btcscript.NameScriptBuilder) *btcscript.NameScriptBuilder {
+				return b.NameUpdate(name, nil)
+			},
+			op:   btcscript.OP_NAME_UPDATE,
+			args: [][]byte{name, {}},
+		},
+	}
+
+	builder := btcscript.NewNameScriptBuilder()
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		script, err := test.build(builder).Script(nameTestP2PKH)
+		if err != nil {
+			t.Errorf("NameScriptBuilder #%d (%s) unexpected error: "+
+				"%v", i, test.name, err)
+			continue
+		}
+		if test.expected != nil && !bytes.Equal(script, test.expected) {
+			t.Errorf("NameScriptBuilder #%d (%s) wrong result\n"+
+				"got: %x\nwant: %x", i, test.name, script,
+				test.expected)
+			continue
+		}
+
+		ns, err := btcscript.NewNameScriptFromPk(script,
+			btcscript.NameCheckLimits)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if ns.NameOp() != test.op {
+			t.Errorf("NewNameScriptFromPk #%d (%s) wrong op: "+
+				"got %d, want %d", i, test.name, ns.NameOp(),
+				test.op)
+			continue
+		}
+
+		var args [][]byte
+		switch test.op {
+		case btcscript.OP_NAME_NEW:
+			args = [][]byte{ns.OpHashBytes()}
+		case btcscript.OP_NAME_FIRSTUPDATE:
+			args = [][]byte{ns.OpNameBytes(), ns.OpRandBytes(),
+				ns.OpValueBytes()}
+		case btcscript.OP_NAME_UPDATE:
+			args = [][]byte{ns.OpNameBytes(), ns.OpValueBytes()}
+		}
+		for j := range test.args {
+			if !bytes.Equal(args[j], test.args[j]) {
+				t.Errorf("NewNameScriptFromPk #%d (%s) wrong "+
+					"arg %d: got %x, want %x", i, test.name,
+					j, args[j], test.args[j])
+			}
+		}
+	}
+}
+
+// TestNameScriptBuilderErrors ensures the NameScriptBuilder rejects arguments
+// which are too long to push and scripts with no name operation.
+func TestNameScriptBuilderErrors(t *testing.T) {
+	long := make([]byte, btcscript.MaxScriptElementSize+1)
+
+	_, err := btcscript.NewNameScriptBuilder().
+		NameUpdate([]byte("d/example"), long).Script(nameTestP2PKH)
+	if err != btcscript.ErrStackElementTooBig {
+		t.Errorf("over-length value: got %v, want %v", err,
+			btcscript.ErrStackElementTooBig)
+	}
+
+	_, err = btcscript.NewNameScriptBuilder().Script(nameTestP2PKH)
+	if err != btcscript.ErrNameEmptyScript {
+		t.Errorf("no name operation: got %v, want %v", err,
+			btcscript.ErrNameEmptyScript)
+	}
+
+	// A failed operation must not poison a subsequent one.
+	builder := btcscript.NewNameScriptBuilder()
+	builder.NameNew(long)
+	_, err = builder.NameNew(nameTestHash).Script(nameTestP2PKH)
+	if err != nil {
+		t.Errorf("reused builder: unexpected error: %v", err)
+	}
+}
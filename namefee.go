@@ -0,0 +1,70 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript
+
+// These are the amounts used by the Namecoin name fee schedule, in the smallest
+// units of the currency.
+const (
+	// NameLockedAmount is the amount which the output holding a name
+	// operation must carry.  It stays locked in the name for as long as the
+	// name is kept, being passed on by each update.
+	NameLockedAmount = 1000000 // 0.01 NMC
+
+	// nameFeeStart is the network fee for registering a name at the genesis
+	// block.
+	nameFeeStart = 50 * 100000000 // 50 NMC
+
+	// nameFeeRounding is the unit which network fees are rounded up to.
+	nameFeeRounding = 1000000 // 0.01 NMC
+
+	// nameFeeSpeedupHeight is the height from which the network fee falls
+	// four times as fast.
+	nameFeeSpeedupHeight = 24000
+)
+
+// NameOperationFee returns the amount which a transaction performing the name
+// operation op in a block at the passed height must commit to the name, apart
+// from the transaction fee paid to miners.  Every name operation must lock
+// NameLockedAmount in its output.  OP_NAME_FIRSTUPDATE, which registers the
+// name, must also pay the network fee in force at the height, which is burned.
+// Zero is returned for opcodes which are not name operations.
+//
+// The network fee follows the schedule of GetNetworkFee in the original
+// Namecoin client (src/namecoin.cpp) for the main network.  It starts at 50 NMC
+// and halves every 8192 blocks, falling linearly within each period, and is
+// rounded up to a multiple of 0.01 NMC.  From height 24000 the blocks after it
+// count four times, so the fee falls four times as fast, and it reaches zero at
+// height 85584.  Current versions of Namecoin no longer charge a network fee,
+// which is consistent with the schedule having reached zero long before.
+func NameOperationFee(op byte, height int32) int64 {
+	switch op {
+	case OP_NAME_NEW, OP_NAME_UPDATE:
+		return NameLockedAmount
+	case OP_NAME_FIRSTUPDATE:
+		return NameLockedAmount + nameNetworkFee(height)
+	}
+	return 0
+}
+
+// nameNetworkFee returns the network fee burned when registering a name in a
+// block at the passed height, as described by NameOperationFee.
+func nameNetworkFee(height int32) int64 {
+	h := int64(height)
+	if h < 0 {
+		h = 0
+	}
+	if h >= nameFeeSpeedupHeight {
+		h += (h - nameFeeSpeedupHeight) * 3
+	}
+	halvings := h >> 13
+	if halvings >= 60 {
+		return 0
+	}
+
+	fee := int64(nameFeeStart) >> uint(halvings)
+	fee -= (fee >> 14) * (h % 8192)
+	fee += nameFeeRounding - 1
+	return fee / nameFeeRounding * nameFeeRounding
+}
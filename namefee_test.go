@@ -0,0 +1,71 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript_test
+
+import (
+	"testing"
+
+	"github.com/hlandauf/btcscript"
+)
+
+// TestNameOperationFee ensures the amounts required of name operations follow
+// the Namecoin fee schedule around the heights where it changes.
+func TestNameOperationFee(t *testing.T) {
+	const locked = btcscript.NameLockedAmount
+
+	tests := []struct {
+		name   string
+		op     byte
+		height int32
+		want   int64
+	}{
+		{"name_new genesis", btcscript.OP_NAME_NEW, 0, locked},
+		{"name_new late", btcscript.OP_NAME_NEW, 500000, locked},
+		{"name_update genesis", btcscript.OP_NAME_UPDATE, 0, locked},
+		{"name_update late", btcscript.OP_NAME_UPDATE, 500000, locked},
+		{"name_firstupdate genesis", btcscript.OP_NAME_FIRSTUPDATE, 0,
+			locked + 5000000000},
+		{"name_firstupdate negative height",
+			btcscript.OP_NAME_FIRSTUPDATE, -1, locked + 5000000000},
+		{"name_firstupdate end of first period",
+			btcscript.OP_NAME_FIRSTUPDATE, 8191, locked + 2501000000},
+		{"name_firstupdate first halving",
+			btcscript.OP_NAME_FIRSTUPDATE, 8192, locked + 2500000000},
+		{"name_firstupdate before speedup",
+			btcscript.OP_NAME_FIRSTUPDATE, 23999, locked + 670000000},
+		{"name_firstupdate speedup", btcscript.OP_NAME_FIRSTUPDATE,
+			24000, locked + 669000000},
+		{"name_firstupdate after speedup",
+			btcscript.OP_NAME_FIRSTUPDATE, 30000, locked + 90000000},
+		{"name_firstupdate last fee", btcscript.OP_NAME_FIRSTUPDATE,
+			85583, locked + 1000000},
+		{"name_firstupdate no fee", btcscript.OP_NAME_FIRSTUPDATE,
+			85584, locked},
+		{"name_firstupdate highest height",
+			btcscript.OP_NAME_FIRSTUPDATE, 1<<31 - 1, locked},
+		{"not a name operation", btcscript.OP_CHECKSIG, 0, 0},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got := btcscript.NameOperationFee(test.op, test.height)
+		if got != test.want {
+			t.Errorf("NameOperationFee #%d (%s) got %d, want %d", i,
+				test.name, got, test.want)
+		}
+	}
+
+	// The fee never rises with the height.
+	last := btcscript.NameOperationFee(btcscript.OP_NAME_FIRSTUPDATE, 0)
+	for height := int32(1); height < 90000; height++ {
+		fee := btcscript.NameOperationFee(btcscript.OP_NAME_FIRSTUPDATE,
+			height)
+		if fee > last {
+			t.Fatalf("NameOperationFee rose from %d to %d at height "+
+				"%d", last, fee, height)
+		}
+		last = fee
+	}
+}
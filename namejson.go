@@ -0,0 +1,132 @@
+package btcscript
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// These are the values of the encoding field in the JSON representation of a
+// NameScript.
+const (
+	nameEncodingUTF8 = "utf8"
+	nameEncodingHex  = "hex"
+)
+
+// nameScriptJSON is the JSON representation of a NameScript.  The name and
+// value are given as UTF-8 text when both are valid UTF-8 and hex-encoded
+// otherwise, as indicated by Encoding.  All other binary fields, including the
+// address script, are always hex-encoded.
+type nameScriptJSON struct {
+	Op       string `json:"op"`
+	Name     string `json:"name,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Rand     string `json:"rand,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Address  string `json:"address"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.  The name operation is
+// encoded as an object such as:
+//
+//	{"op":"name_update","name":"d/example","value":"...","encoding":"utf8",
+//	 "address":"76a914...88ac"}
+func (ns *NameScript) MarshalJSON() ([]byte, error) {
+	j := nameScriptJSON{
+		Address: hex.EncodeToString(ns.BasePkScript()),
+	}
+
+	switch ns.op {
+	case OP_NAME_NEW:
+		j.Op = "name_new"
+		j.Hash = hex.EncodeToString(ns.args[0])
+	case OP_NAME_FIRSTUPDATE, OP_NAME_UPDATE:
+		if ns.op == OP_NAME_FIRSTUPDATE {
+			j.Op = "name_firstupdate"
+			j.Rand = hex.EncodeToString(ns.args[1])
+		} else {
+			j.Op = "name_update"
+		}
+
+		name, value := ns.OpNameBytes(), ns.OpValueBytes()
+		if utf8.Valid(name) && utf8.Valid(value) {
+			j.Encoding = nameEncodingUTF8
+			j.Name, j.Value = string(name), string(value)
+		} else {
+			j.Encoding = nameEncodingHex
+			j.Name = hex.EncodeToString(name)
+			j.Value = hex.EncodeToString(value)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrNameUnknownOp, ns.op)
+	}
+
+	return json.Marshal(&j)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for the encoding
+// produced by MarshalJSON.  The name operation and address script are
+// restored, but the original script bytes, including the choice of
+// delimiters, are not.
+func (ns *NameScript) UnmarshalJSON(data []byte) error {
+	var j nameScriptJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	addr, err := hex.DecodeString(j.Address)
+	if err != nil {
+		return err
+	}
+	base, err := parseScript(addr)
+	if err != nil {
+		return err
+	}
+
+	var op byte
+	var args [][]byte
+	switch j.Op {
+	case "name_new":
+		hash, err := hex.DecodeString(j.Hash)
+		if err != nil {
+			return err
+		}
+		op, args = OP_NAME_NEW, [][]byte{hash}
+	case "name_firstupdate", "name_update":
+		var name, value []byte
+		switch j.Encoding {
+		case nameEncodingUTF8:
+			name, value = []byte(j.Name), []byte(j.Value)
+		case nameEncodingHex:
+			if name, err = hex.DecodeString(j.Name); err != nil {
+				return err
+			}
+			if value, err = hex.DecodeString(j.Value); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown name encoding %q", j.Encoding)
+		}
+
+		if j.Op == "name_firstupdate" {
+			rand, err := hex.DecodeString(j.Rand)
+			if err != nil {
+				return err
+			}
+			op, args = OP_NAME_FIRSTUPDATE, [][]byte{name, rand, value}
+		} else {
+			op, args = OP_NAME_UPDATE, [][]byte{name, value}
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrNameUnknownOp, j.Op)
+	}
+
+	*ns = NameScript{
+		op:   op,
+		args: args,
+		base: base,
+	}
+	return nil
+}
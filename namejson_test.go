@@ -0,0 +1,132 @@
+package btcscript_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hlandauf/btcscript"
+)
+
+// TestNameScriptJSON ensures name scripts of each operation type survive a
+// round trip through their JSON encoding.
+func TestNameScriptJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   []byte
+		op       string
+		encoding string
+	}{
+		{
+			name: "name_new",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+			op: "name_new",
+		},
+		{
+			name: "name_firstupdate",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), {0x01, 0x02},
+					[]byte(`{"ip":"192.0.2.1"}`)},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				nameTestP2PKH),
+			op:       "name_firstupdate",
+			encoding: "utf8",
+		},
+		{
+			name: "name_update",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("id/alice"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2SH),
+			op:       "name_update",
+			encoding: "utf8",
+		},
+		{
+			name: "name_update with invalid utf8 value",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), {0xff, 0xfe, 0x00}},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			op:       "name_update",
+			encoding: "hex",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ns, err := btcscript.NewNameScriptFromPk(test.script, 0)
+		if err != nil {
+			t.Errorf("NewNameScriptFromPk #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+
+		data, err := json.Marshal(ns)
+		if err != nil {
+			t.Errorf("MarshalJSON #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+
+		var fields map[string]string
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Errorf("MarshalJSON #%d (%s) produced invalid "+
+				"object %s: %v", i, test.name, data, err)
+			continue
+		}
+		if fields["op"] != test.op ||
+			fields["encoding"] != test.encoding {
+			t.Errorf("MarshalJSON #%d (%s) wrong fields: %s", i,
+				test.name, data)
+			continue
+		}
+
+		var decoded btcscript.NameScript
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Errorf("UnmarshalJSON #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		if !decoded.Equal(ns) {
+			t.Errorf("UnmarshalJSON #%d (%s) round trip mismatch\n"+
+				"got: %v\nwant: %v", i, test.name, &decoded, ns)
+		}
+	}
+}
+
+// TestNameScriptJSONErrors ensures malformed JSON encodings are rejected.
+func TestNameScriptJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "unknown op",
+			data: `{"op":"name_delete","address":""}`,
+		},
+		{
+			name: "unknown encoding",
+			data: `{"op":"name_update","name":"d/a","value":"v",` +
+				`"encoding":"base64","address":""}`,
+		},
+		{
+			name: "bad hex value",
+			data: `{"op":"name_update","name":"00","value":"zz",` +
+				`"encoding":"hex","address":""}`,
+		},
+		{
+			name: "bad address",
+			data: `{"op":"name_new","hash":"00","address":"4c"}`,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var ns btcscript.NameScript
+		if err := json.Unmarshal([]byte(test.data), &ns); err == nil {
+			t.Errorf("UnmarshalJSON #%d (%s) unexpectedly "+
+				"succeeded", i, test.name)
+		}
+	}
+}
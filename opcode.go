@@ -218,8 +218,10 @@ const (
 	OP_CHECKMULTISIG       = 174
 	OP_CHECKMULTISIGVERIFY = 175
 	OP_NOP1                = 176
-	OP_NOP2                = 177
-	OP_NOP3                = 178
+	OP_NOP2                = 177 // AKA OP_CHECKLOCKTIMEVERIFY
+	OP_CHECKLOCKTIMEVERIFY = 177
+	OP_NOP3                = 178 // AKA OP_CHECKSEQUENCEVERIFY
+	OP_CHECKSEQUENCEVERIFY = 178
 	OP_NOP4                = 179
 	OP_NOP5                = 180
 	OP_NOP6                = 181
@@ -676,9 +678,9 @@ var opcodemapPreinit = map[byte]*opcode{
 	OP_NOP1: {value: OP_NOP1, name: "OP_NOP1", length: 1,
 		opfunc: opcodeNop},
 	OP_NOP2: {value: OP_NOP2, name: "OP_NOP2", length: 1,
-		opfunc: opcodeNop},
+		opfunc: opcodeCheckLockTimeVerify},
 	OP_NOP3: {value: OP_NOP3, name: "OP_NOP3", length: 1,
-		opfunc: opcodeNop},
+		opfunc: opcodeCheckSequenceVerify},
 	OP_NOP4: {value: OP_NOP4, name: "OP_NOP4", length: 1,
 		opfunc: opcodeNop},
 	OP_NOP5: {value: OP_NOP5, name: "OP_NOP5", length: 1,
@@ -905,6 +907,48 @@ func (pop *parsedOpcode) disabled() bool {
 	}
 }
 
+// OpcodeDetail describes an opcode for the benefit of tools such as script
+// editors.  Length is the number of bytes taken by the opcode including any
+// data it pushes when positive, or the negated number of bytes in the
+// little endian length prefix which follows the opcode for the OP_PUSHDATA
+// opcodes.
+type OpcodeDetail struct {
+	Value    byte
+	Name     string
+	Length   int
+	Disabled bool
+}
+
+// OpcodeInfo returns the name and length of opcode op, in the form used by
+// OpcodeDetail, and whether it is disabled.  ok is false if op is not a known
+// opcode.
+func OpcodeInfo(op byte) (name string, length int, disabled bool, ok bool) {
+	opc, ok := opcodemap[op]
+	if !ok {
+		return "", 0, false, false
+	}
+	pop := parsedOpcode{opcode: opc}
+	return opc.name, opc.length, pop.disabled(), true
+}
+
+// AllOpcodes returns the details of every known opcode, ordered by value.
+func AllOpcodes() []OpcodeDetail {
+	details := make([]OpcodeDetail, 0, len(opcodemap))
+	for i := 0; i < 256; i++ {
+		name, length, disabled, ok := OpcodeInfo(byte(i))
+		if !ok {
+			continue
+		}
+		details = append(details, OpcodeDetail{
+			Value:    byte(i),
+			Name:     name,
+			Length:   length,
+			Disabled: disabled,
+		})
+	}
+	return details
+}
+
 // The following opcodes are always illegal when passed over by the program
 // counter even if in a non-executed branch. (it isn't a coincidence that they
 // are conditionals).
@@ -953,11 +997,11 @@ func (pop *parsedOpcode) exec(s *Script) error {
 	// Note that this includes OP_RESERVED which counts as a push operation.
 	if pop.opcode.value > OP_16 {
 		s.numOps++
-		if s.numOps > MaxOpsPerScript {
+		if s.numOps > s.maxOps {
 			return ErrStackTooManyOperations
 		}
 
-	} else if len(pop.data) > MaxScriptElementSize {
+	} else if len(pop.data) > s.maxElementSize {
 		return ErrStackElementTooBig
 	}
 
@@ -966,9 +1010,40 @@ func (pop *parsedOpcode) exec(s *Script) error {
 	if s.condStack[0] != OpCondTrue && !pop.conditional() {
 		return nil
 	}
+
+	if s.minimalData && pop.opcode.value <= OP_PUSHDATA4 &&
+		!isMinimalDataPush(pop) {
+		return ErrStackMinimalData
+	}
 	return pop.opcode.opfunc(pop, s)
 }
 
+// isMinimalDataPush returns whether or not the passed data push uses the
+// smallest possible opcode for the data it pushes, as defined by rule 3 of
+// BIP0062.  Empty data and single bytes from 1 to 16 or of 0x81 must be pushed
+// with OP_0, OP_1 through OP_16 and OP_1NEGATE respectively, other data of up
+// to 75 bytes with a direct push, and longer data with the smallest of the
+// OP_PUSHDATA opcodes able to encode its length.
+func isMinimalDataPush(pop *parsedOpcode) bool {
+	data := pop.data
+	dataLen := len(data)
+	switch {
+	case dataLen == 0:
+		return pop.opcode.value == OP_0
+	case dataLen == 1 && data[0] >= 1 && data[0] <= 16:
+		return false
+	case dataLen == 1 && data[0] == 0x81:
+		return false
+	case dataLen <= 75:
+		return int(pop.opcode.value) == dataLen
+	case dataLen <= 0xff:
+		return pop.opcode.value == OP_PUSHDATA1
+	case dataLen <= 0xffff:
+		return pop.opcode.value == OP_PUSHDATA2
+	}
+	return true
+}
+
 func (pop *parsedOpcode) print(oneline bool) string {
 	// The reference implementation one-line disassembly replaces opcodes
 	// which represent values (e.g. OP_0 through OP_16 and OP_1NEGATE)
@@ -1092,6 +1167,143 @@ func opcodeNop(op *parsedOpcode, s *Script) error {
 	return nil
 }
 
+// lockTimeThreshold is the value below which a lock time is interpreted as a
+// block height rather than a unix time.
+const lockTimeThreshold = 500000000
+
+// opcodeCheckLockTimeVerify implements OP_CHECKLOCKTIMEVERIFY as defined by
+// BIP0065 when the ScriptVerifyCheckLockTimeVerify flag is set, and is a no-op
+// otherwise.  The lock time on top of the stack is compared against the lock
+// time of the transaction, and execution fails if the transaction does not
+// satisfy it.  The stack is left unchanged.
+func opcodeCheckLockTimeVerify(op *parsedOpcode, s *Script) error {
+	if !s.verifyCLTV {
+		return nil
+	}
+
+	// The operand may be up to 5 bytes so that lock times with bit 31 set
+	// can be expressed without the number becoming negative.
+	so, err := s.dstack.PeekByteArray(0)
+	if err != nil {
+		return err
+	}
+	num, err := asIntN(so, 5, s.minimalData)
+	if err != nil {
+		return err
+	}
+	if num.Sign() < 0 {
+		return ErrStackNegativeLockTime
+	}
+	lockTime := num.Int64()
+
+	// Both lock times must be of the same type, either block heights or
+	// unix times, and the transaction's lock time must be at least that
+	// required by the script.
+	txLockTime := int64(s.tx.LockTime)
+	if (txLockTime < lockTimeThreshold) != (lockTime < lockTimeThreshold) {
+		return ErrStackUnsatisfiedLockTime
+	}
+	if lockTime > txLockTime {
+		return ErrStackUnsatisfiedLockTime
+	}
+
+	// The transaction's lock time is ignored if the input is final, so
+	// the input must not be for the lock time to be enforced.
+	if s.tx.TxIn[s.txidx].Sequence == btcwire.MaxTxInSequenceNum {
+		return ErrStackUnsatisfiedLockTime
+	}
+
+	return nil
+}
+
+// These are the fields of a transaction input sequence number which are
+// interpreted as a relative lock time by BIP0068 and BIP0112.
+const (
+	// sequenceLockTimeDisabled is set in a sequence number when it does
+	// not encode a relative lock time.
+	sequenceLockTimeDisabled = 1 << 31
+
+	// sequenceLockTimeIsSeconds is set in a sequence number when the
+	// relative lock time is in units of 512 seconds rather than blocks.
+	sequenceLockTimeIsSeconds = 1 << 22
+
+	// sequenceLockTimeMask extracts the relative lock time from a
+	// sequence number.
+	sequenceLockTimeMask = 0x0000ffff
+)
+
+// SequenceEnablesRBF returns whether an input with the passed sequence number
+// signals that its transaction may be replaced by one paying a higher fee, as
+// defined by BIP0125, which is the case for any sequence number below
+// 0xfffffffe.  While replacement is decided for the whole transaction, this
+// lets the input be explained alongside OP_CHECKSEQUENCEVERIFY.  Every sequence
+// number which enables a relative lock time, with bit 31 clear, signals
+// replacement, so an input which satisfies OP_CHECKSEQUENCEVERIFY always does.
+// Sequence numbers from 0x80000000 to 0xfffffffd signal replacement but fail
+// the check, since their relative lock time is disabled.
+func SequenceEnablesRBF(sequence uint32) bool {
+	return sequence < btcwire.MaxTxInSequenceNum-1
+}
+
+// opcodeCheckSequenceVerify implements OP_CHECKSEQUENCEVERIFY as defined by
+// BIP0112 when the ScriptVerifyCheckSequenceVerify flag is set, and is a no-op
+// otherwise.  The relative lock time on top of the stack is compared against
+// the sequence number of the input being verified, and execution fails if the
+// input does not satisfy it.  The stack is left unchanged.
+func opcodeCheckSequenceVerify(op *parsedOpcode, s *Script) error {
+	if !s.verifyCSV {
+		return nil
+	}
+
+	// The operand may be up to 5 bytes so that the disable flag in bit 31
+	// can be set without the number becoming negative.
+	so, err := s.dstack.PeekByteArray(0)
+	if err != nil {
+		return err
+	}
+	num, err := asIntN(so, 5, s.minimalData)
+	if err != nil {
+		return err
+	}
+	if num.Sign() < 0 {
+		return ErrStackNegativeLockTime
+	}
+	sequence := num.Int64()
+
+	// Operands with the disable flag set do not encode a relative lock
+	// time, so behave as a no-op.
+	if sequence&sequenceLockTimeDisabled != 0 {
+		return nil
+	}
+
+	// Relative lock times are only enforced for version 2 transactions
+	// and for inputs which themselves have relative lock times enabled.
+	// The version is compared as unsigned, as in the reference
+	// implementation, so negative versions count as high ones.
+	if uint32(s.tx.Version) < 2 {
+		return ErrStackUnsatisfiedLockTime
+	}
+	txSequence := int64(s.tx.TxIn[s.txidx].Sequence)
+	if txSequence&sequenceLockTimeDisabled != 0 {
+		return ErrStackUnsatisfiedLockTime
+	}
+
+	// Both lock times must be of the same type, either blocks or time, and
+	// the input's lock time must be at least that required by the script.
+	mask := int64(sequenceLockTimeIsSeconds | sequenceLockTimeMask)
+	sequence &= mask
+	txSequence &= mask
+	if sequence&sequenceLockTimeIsSeconds !=
+		txSequence&sequenceLockTimeIsSeconds {
+		return ErrStackUnsatisfiedLockTime
+	}
+	if sequence > txSequence {
+		return ErrStackUnsatisfiedLockTime
+	}
+
+	return nil
+}
+
 // opcodeIf computes true/false based on the value on the stack and pushes
 // the condition on the condStack (conditional execution stack)
 func opcodeIf(op *parsedOpcode, s *Script) error {
@@ -1753,6 +1965,141 @@ func opcodeCodeSeparator(op *parsedOpcode, s *Script) error {
 	return nil
 }
 
+// checkSignatureEncoding returns ErrStackInvalidSignatureEncoding unless the
+// passed signature, including its trailing hash type byte, is strictly DER
+// encoded as defined by BIP0066:
+//  0x30 <total length> 0x02 <length of R> <R> 0x02 <length of S> <S> <hashtype>
+// R and S must be positive and minimally encoded, and the lengths must
+// exactly account for the size of the signature.
+func checkSignatureEncoding(sig []byte) error {
+	// The shortest possible signature has one byte R and S values and the
+	// longest has 33 byte values.
+	if len(sig) < 9 || len(sig) > 73 {
+		return ErrStackInvalidSignatureEncoding
+	}
+
+	// The total length covers everything but the header and hash type.
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return ErrStackInvalidSignatureEncoding
+	}
+
+	// The length of S must leave space for the R and S lengths and the
+	// hash type.
+	rLen := int(sig[3])
+	if 5+rLen >= len(sig) {
+		return ErrStackInvalidSignatureEncoding
+	}
+	sLen := int(sig[5+rLen])
+	if rLen+sLen+7 != len(sig) {
+		return ErrStackInvalidSignatureEncoding
+	}
+
+	if !isCanonicalDERInt(sig[2], sig[4:4+rLen]) ||
+		!isCanonicalDERInt(sig[4+rLen], sig[6+rLen:6+rLen+sLen]) {
+		return ErrStackInvalidSignatureEncoding
+	}
+
+	return nil
+}
+
+// isCanonicalDERInt returns whether the passed DER type and integer bytes form
+// a non-empty, positive and minimally encoded DER integer.
+func isCanonicalDERInt(typ byte, b []byte) bool {
+	if typ != 0x02 || len(b) == 0 {
+		return false
+	}
+	// Negative numbers are not allowed.
+	if b[0]&0x80 != 0 {
+		return false
+	}
+	// A leading zero byte is only allowed to stop the next byte being
+	// interpreted as a sign bit.
+	if len(b) > 1 && b[0] == 0 && b[1]&0x80 == 0 {
+		return false
+	}
+	return true
+}
+
+// halfOrder is half the order of the secp256k1 curve, which is the highest S
+// value a low S signature may have.
+var halfOrder = new(big.Int).Rsh(btcec.S256().N, 1)
+
+// IsLowSSignature returns whether the passed DER encoded signature, without a
+// trailing hash type byte, has an S value of at most half the curve order.
+// For any valid signature, negating S modulo the curve order produces another
+// valid signature, so requiring the low value removes this source of
+// transaction malleability.  Signatures which do not parse are not low S.
+func IsLowSSignature(sig []byte) bool {
+	signature, err := btcec.ParseSignature(sig, btcec.S256())
+	if err != nil {
+		return false
+	}
+	return signature.S.Cmp(halfOrder) <= 0
+}
+
+// VerifyMessageSignature returns whether signature is a valid signature by
+// pubKey of the SHA256 hash of message, as checked by OP_CHECKDATASIG in other
+// implementations.  The public key and signature are parsed in the same way as
+// for OP_CHECKSIG, except that the signature has no trailing hash type byte
+// and must be strictly DER encoded as defined by BIP0066.  An error is returned
+// if either of them can not be parsed.
+func VerifyMessageSignature(pubKey, signature, message []byte) (bool, error) {
+	pk, err := btcec.ParsePubKey(pubKey, btcec.S256())
+	if err != nil {
+		return false, err
+	}
+
+	// The encoding check expects a hash type byte, so append one to a copy
+	// of the signature.
+	sigWithHashType := append(signature[:len(signature):len(signature)],
+		byte(SigHashAll))
+	if err := checkSignatureEncoding(sigWithHashType); err != nil {
+		return false, err
+	}
+	sig, err := btcec.ParseDERSignature(signature, btcec.S256())
+	if err != nil {
+		return false, err
+	}
+	return sig.Verify(calcHash(message, fastsha256.New()), pk), nil
+}
+
+// SignatureHashType returns the hash type of the passed script signature, which
+// is its trailing byte.  ErrStackInvalidSignatureEncoding is returned unless
+// the rest of the signature is strictly DER encoded as defined by BIP0066, so
+// the byte found is known to follow a signature.
+func SignatureHashType(sig []byte) (SigHashType, error) {
+	if err := checkSignatureEncoding(sig); err != nil {
+		return 0, err
+	}
+	return SigHashType(sig[len(sig)-1]), nil
+}
+
+// StripSignatureHashType returns the DER encoded part of the passed script
+// signature without its trailing hash type byte, or nil if sig is empty.  The
+// returned slice shares the memory of sig.  The encoding is not checked, so
+// SignatureHashType should be used first for signatures which may be invalid.
+func StripSignatureHashType(sig []byte) []byte {
+	if len(sig) == 0 {
+		return nil
+	}
+	return sig[:len(sig)-1]
+}
+
+// checkSignature returns an error if the passed signature, including its
+// trailing hash type byte, violates any of the encoding rules enforced by the
+// script flags.
+func (s *Script) checkSignature(sig []byte) error {
+	if s.verifyDER || s.verifyLowS {
+		if err := checkSignatureEncoding(sig); err != nil {
+			return err
+		}
+	}
+	if s.verifyLowS && !IsLowSSignature(sig[:len(sig)-1]) {
+		return ErrStackHighS
+	}
+	return nil
+}
+
 func opcodeCheckSig(op *parsedOpcode, s *Script) error {
 
 	pkStr, err := s.dstack.PopByteArray()
@@ -1772,6 +2119,9 @@ func opcodeCheckSig(op *parsedOpcode, s *Script) error {
 		s.dstack.PushBool(false)
 		return nil
 	}
+	if err := s.checkSignature(sigStr); err != nil {
+		return err
+	}
 
 	// Trim off hashtype from the signature string.
 	hashType := SigHashType(sigStr[len(sigStr)-1])
@@ -1782,10 +2132,13 @@ func opcodeCheckSig(op *parsedOpcode, s *Script) error {
 	subScript := s.subScript()
 
 	// Unlikely to hit any cases here, but remove the signature from
-	// the script if present.
-	subScript = removeOpcodeByData(subScript, sigStr)
+	// the script if present.  Signatures in witness scripts commit to the
+	// script as it is.
+	if !s.witnessExec {
+		subScript = removeOpcodeByData(subScript, sigStr)
+	}
 
-	hash := calcScriptHash(subScript, hashType, &s.tx, s.txidx)
+	hash := s.calcSignatureHash(subScript, hashType)
 
 	pubKey, err := btcec.ParsePubKey(pkStr, btcec.S256())
 	if err != nil {
@@ -1815,11 +2168,27 @@ func opcodeCheckSig(op *parsedOpcode, s *Script) error {
 			hex.Dump(pkStr), pubKey.X, pubKey.Y,
 			signature.R, signature.S, hex.Dump(hash))
 	}))
-	ok := signature.Verify(hash, pubKey)
+	ok := s.verifySignature(hash, signature, sigStr, pubKey, pkStr)
 	s.dstack.PushBool(ok)
 	return nil
 }
 
+// verifySignature returns whether signature, serialized as sigStr without the
+// hash type, is a valid signature of hash by pubKey, serialized as pkStr.  The
+// signature cache of the engine, if any, is consulted before verifying and
+// valid signatures are added to it.
+func (s *Script) verifySignature(hash []byte, signature *btcec.Signature,
+	sigStr []byte, pubKey *btcec.PublicKey, pkStr []byte) bool {
+	if s.sigCache != nil && s.sigCache.Exists(hash, sigStr, pkStr) {
+		return true
+	}
+	ok := signature.Verify(hash, pubKey)
+	if ok && s.sigCache != nil {
+		s.sigCache.Add(hash, sigStr, pkStr)
+	}
+	return ok
+}
+
 func opcodeCheckSigVerify(op *parsedOpcode, s *Script) error {
 	err := opcodeCheckSig(op, s)
 	if err == nil {
@@ -1829,8 +2198,9 @@ func opcodeCheckSigVerify(op *parsedOpcode, s *Script) error {
 }
 
 type sig struct {
-	s  *btcec.Signature
-	ht byte
+	s   *btcec.Signature
+	raw []byte
+	ht  byte
 }
 
 // stack; sigs <numsigs> pubkeys <numpubkeys>
@@ -1850,7 +2220,7 @@ func opcodeCheckMultiSig(op *parsedOpcode, s *Script) error {
 		return ErrStackTooManyPubkeys
 	}
 	s.numOps += npk
-	if s.numOps > MaxOpsPerScript {
+	if s.numOps > s.maxOps {
 		return ErrStackTooManyOperations
 	}
 	pubKeyStrings := make([][]byte, npk)
@@ -1869,10 +2239,13 @@ func opcodeCheckMultiSig(op *parsedOpcode, s *Script) error {
 	// PopInt promises that the int returned is 32 bit.
 	nsig := int(numSignatures.Int64())
 	if nsig < 0 {
-		return fmt.Errorf("number of signatures %d is less than 0", nsig)
+		return scriptError(ErrCodeInvalidSignatureCount,
+			fmt.Sprintf("number of signatures %d is less than 0", nsig))
 	}
 	if nsig > npk {
-		return fmt.Errorf("more signatures than pubkeys: %d > %d", nsig, npk)
+		return scriptError(ErrCodeInvalidSignatureCount,
+			fmt.Sprintf("more signatures than pubkeys: %d > %d", nsig,
+				npk))
 	}
 
 	sigStrings := make([][]byte, nsig)
@@ -1885,8 +2258,12 @@ func opcodeCheckMultiSig(op *parsedOpcode, s *Script) error {
 		if len(sigStrings[i]) == 0 {
 			continue
 		}
+		if err := s.checkSignature(sigStrings[i]); err != nil {
+			return err
+		}
 		sig := sig{}
 		sig.ht = sigStrings[i][len(sigStrings[i])-1]
+		sig.raw = sigStrings[i][:len(sigStrings[i])-1]
 		// skip off the last byte for hashtype
 		if s.der {
 			sig.s, err =
@@ -1911,8 +2288,9 @@ func opcodeCheckMultiSig(op *parsedOpcode, s *Script) error {
 	}
 
 	if s.strictMultiSig && len(dummy) != 0 {
-		return fmt.Errorf("multisig dummy argument is not zero length: %d",
-			len(dummy))
+		return scriptError(ErrCodeSigNullDummy,
+			fmt.Sprintf("multisig dummy argument is not zero "+
+				"length: %d", len(dummy)))
 	}
 
 	if len(signatures) == 0 {
@@ -1925,9 +2303,11 @@ func opcodeCheckMultiSig(op *parsedOpcode, s *Script) error {
 
 	// Remove any of the signatures that happen to be in the script.
 	// can't sign somthing containing the signature you're making, after
-	// all
-	for i := range sigStrings {
-		script = removeOpcodeByData(script, sigStrings[i])
+	// all.  Signatures in witness scripts commit to the script as it is.
+	if !s.witnessExec {
+		for i := range sigStrings {
+			script = removeOpcodeByData(script, sigStrings[i])
+		}
 	}
 
 	curPk := 0
@@ -1935,8 +2315,8 @@ func opcodeCheckMultiSig(op *parsedOpcode, s *Script) error {
 		// check signatures.
 		success := false
 
-		hash := calcScriptHash(script, SigHashType(signatures[i].ht),
-			&s.tx, s.txidx)
+		hash := s.calcSignatureHash(script,
+			SigHashType(signatures[i].ht))
 	inner:
 		// Find first pubkey that successfully validates signature.
 		// we start off the search from the key that was successful
@@ -1950,7 +2330,9 @@ func opcodeCheckMultiSig(op *parsedOpcode, s *Script) error {
 					continue
 				}
 			}
-			success = signatures[i].s.Verify(hash, pubKeys[curPk])
+			success = s.verifySignature(hash, signatures[i].s,
+				signatures[i].raw, pubKeys[curPk],
+				pubKeyStrings[curPk])
 			if success {
 				break inner
 			}
@@ -6,9 +6,14 @@ package btcscript_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
+	"reflect"
 	"testing"
 
+	"github.com/conformal/btcec"
 	"github.com/hlandauf/btcscript"
+	"github.com/hlandauf/btcutil"
 	"github.com/hlandauf/btcwire"
 )
 
@@ -4289,7 +4294,7 @@ func testOpcode(t *testing.T, test *detailedTest) {
 	engine, err := btcscript.NewScript(tx.TxIn[0].SignatureScript,
 		tx.TxOut[0].PkScript, 0, tx, 0)
 	if err != nil {
-		if err != test.expectedReturn {
+		if !errors.Is(err, test.expectedReturn) {
 			t.Errorf("Error return not expected %s: %v %v",
 				test.name, test.expectedReturn, err)
 			return
@@ -4379,6 +4384,316 @@ func TestOpcodes(t *testing.T) {
 	}
 }
 
+// TestOpcodeInfo ensures the exported opcode metadata matches the opcode
+// definitions.
+func TestOpcodeInfo(t *testing.T) {
+	tests := []struct {
+		op       byte
+		name     string
+		length   int
+		disabled bool
+	}{
+		{btcscript.OP_FALSE, "OP_0", 1, false},
+		{btcscript.OP_DATA_20, "OP_DATA_20", 21, false},
+		{btcscript.OP_PUSHDATA1, "OP_PUSHDATA1", -1, false},
+		{btcscript.OP_PUSHDATA4, "OP_PUSHDATA4", -4, false},
+		{btcscript.OP_DUP, "OP_DUP", 1, false},
+		{btcscript.OP_CHECKSIG, "OP_CHECKSIG", 1, false},
+		{btcscript.OP_CAT, "OP_CAT", 1, true},
+		{btcscript.OP_MUL, "OP_MUL", 1, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		name, length, disabled, ok := btcscript.OpcodeInfo(test.op)
+		if !ok {
+			t.Errorf("OpcodeInfo #%d (%s) unknown opcode", i,
+				test.name)
+			continue
+		}
+		if name != test.name || length != test.length ||
+			disabled != test.disabled {
+			t.Errorf("OpcodeInfo #%d (%s) wrong result - got "+
+				"(%s, %d, %v), want (%s, %d, %v)", i, test.name,
+				name, length, disabled, test.name, test.length,
+				test.disabled)
+		}
+	}
+
+	all := btcscript.AllOpcodes()
+	if len(all) != 256 {
+		t.Errorf("AllOpcodes wrong number of opcodes: got %d, want 256",
+			len(all))
+	}
+	for i, detail := range all {
+		if int(detail.Value) != i {
+			t.Errorf("AllOpcodes #%d out of order: got value %d", i,
+				detail.Value)
+			break
+		}
+		name, length, disabled, _ := btcscript.OpcodeInfo(detail.Value)
+		if detail.Name != name || detail.Length != length ||
+			detail.Disabled != disabled {
+			t.Errorf("AllOpcodes #%d does not match OpcodeInfo", i)
+		}
+	}
+}
+
+// executePkScript runs the passed public key script with an empty signature
+// script and returns the result.
+func executePkScript(pkScript []byte) error {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+	engine, err := btcscript.NewScript(nil, pkScript, 0, tx, 0)
+	if err != nil {
+		return err
+	}
+	return engine.Execute()
+}
+
+// TestNumericOperandLimits ensures every numeric opcode rejects operands which
+// are longer than 4 bytes, whichever operand they are, while accepting the
+// largest 4 byte numbers.
+func TestNumericOperandLimits(t *testing.T) {
+	// The largest 4 byte number, 2^31-1, and a 5 byte number.
+	maxNum := []byte{0xff, 0xff, 0xff, 0x7f}
+	bigNum := []byte{0x00, 0x00, 0x00, 0x80, 0x00}
+
+	tests := []struct {
+		opcode   byte
+		operands int
+	}{
+		{btcscript.OP_1ADD, 1},
+		{btcscript.OP_1SUB, 1},
+		{btcscript.OP_NEGATE, 1},
+		{btcscript.OP_ABS, 1},
+		{btcscript.OP_NOT, 1},
+		{btcscript.OP_0NOTEQUAL, 1},
+		{btcscript.OP_ADD, 2},
+		{btcscript.OP_SUB, 2},
+		{btcscript.OP_BOOLAND, 2},
+		{btcscript.OP_BOOLOR, 2},
+		{btcscript.OP_NUMEQUAL, 2},
+		{btcscript.OP_NUMNOTEQUAL, 2},
+		{btcscript.OP_LESSTHAN, 2},
+		{btcscript.OP_GREATERTHAN, 2},
+		{btcscript.OP_LESSTHANOREQUAL, 2},
+		{btcscript.OP_GREATERTHANOREQUAL, 2},
+		{btcscript.OP_MIN, 2},
+		{btcscript.OP_MAX, 2},
+		{btcscript.OP_WITHIN, 3},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		name, _, _, _ := btcscript.OpcodeInfo(test.opcode)
+
+		// script pushes the operands, with the one at index big being
+		// oversized unless it is negative, and then runs the opcode.
+		// Whatever the result, the script then succeeds.
+		script := func(big int) []byte {
+			builder := btcscript.NewScriptBuilder()
+			for j := 0; j < test.operands; j++ {
+				if j == big {
+					builder.AddData(bigNum)
+				} else {
+					builder.AddData(maxNum)
+				}
+			}
+			return builderScript(builder.AddOp(test.opcode).
+				AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE))
+		}
+
+		if err := executePkScript(script(-1)); err != nil {
+			t.Errorf("%s #%d with 4 byte operands unexpected error: "+
+				"%v", name, i, err)
+		}
+		for j := 0; j < test.operands; j++ {
+			err := executePkScript(script(j))
+			if err != btcscript.ErrStackNumberTooBig {
+				t.Errorf("%s #%d with 5 byte operand %d wrong "+
+					"error - got %v, want %v", name, i, j, err,
+					btcscript.ErrStackNumberTooBig)
+			}
+		}
+	}
+
+	// The sum of two 4 byte numbers may need 5 bytes.  It is pushed, but
+	// may not be used as an operand.
+	sum := builderScript(btcscript.NewScriptBuilder().AddData(maxNum).
+		AddData(maxNum).AddOp(btcscript.OP_ADD).
+		AddData([]byte{0xfe, 0xff, 0xff, 0xff, 0x00}).
+		AddOp(btcscript.OP_EQUAL))
+	if err := executePkScript(sum); err != nil {
+		t.Errorf("OP_ADD with 5 byte result unexpected error: %v", err)
+	}
+	sum = builderScript(btcscript.NewScriptBuilder().AddData(maxNum).
+		AddData(maxNum).AddOp(btcscript.OP_ADD).AddOp(btcscript.OP_1ADD))
+	if err := executePkScript(sum); err != btcscript.ErrStackNumberTooBig {
+		t.Errorf("OP_1ADD of 5 byte result wrong error - got %v, want "+
+			"%v", err, btcscript.ErrStackNumberTooBig)
+	}
+}
+
+// TestOpcodeWithin ensures OP_WITHIN includes the minimum of the range and
+// excludes the maximum.
+func TestOpcodeWithin(t *testing.T) {
+	tests := []struct {
+		x, min, max int64
+		within      bool
+	}{
+		{x: 5, min: 5, max: 10, within: true},
+		{x: 9, min: 5, max: 10, within: true},
+		{x: 10, min: 5, max: 10, within: false},
+		{x: 4, min: 5, max: 10, within: false},
+		{x: -1, min: -1, max: 0, within: true},
+		{x: 0, min: -1, max: 0, within: false},
+		{x: 5, min: 5, max: 5, within: false},
+		{x: 7, min: 10, max: 5, within: false},
+		{x: -2147483647, min: -2147483647, max: 2147483647,
+			within: true},
+		{x: 2147483647, min: -2147483647, max: 2147483647,
+			within: false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		want := int64(0)
+		if test.within {
+			want = 1
+		}
+		script := builderScript(btcscript.NewScriptBuilder().
+			AddInt64(test.x).AddInt64(test.min).AddInt64(test.max).
+			AddOp(btcscript.OP_WITHIN).AddInt64(want).
+			AddOp(btcscript.OP_NUMEQUAL))
+		if err := executePkScript(script); err != nil {
+			t.Errorf("OP_WITHIN #%d (%d in [%d, %d)) got wrong "+
+				"result, want %v: %v", i, test.x, test.min,
+				test.max, test.within, err)
+		}
+	}
+}
+
+// TestVerifyMessageSignature ensures signatures over arbitrary messages are
+// verified against the SHA256 hash of the message.
+func TestVerifyMessageSignature(t *testing.T) {
+	privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), decodeHex(
+		"22a47fa09a223f2aa079edf85a7c2d4f8720ee63e502ee2869afab7de234b80c"))
+	message := []byte("namecoin message")
+	hash := sha256.Sum256(message)
+	sig, err := privKey.Sign(hash[:])
+	if err != nil {
+		t.Fatalf("failed to sign message: %v", err)
+	}
+	sigBytes := sig.Serialize()
+
+	tests := []struct {
+		name    string
+		pubKey  []byte
+		sig     []byte
+		message []byte
+		valid   bool
+		isErr   bool
+	}{
+		{"valid compressed", pubKey.SerializeCompressed(), sigBytes,
+			message, true, false},
+		{"valid uncompressed", pubKey.SerializeUncompressed(), sigBytes,
+			message, true, false},
+		{"tampered message", pubKey.SerializeCompressed(), sigBytes,
+			[]byte("namecoin messagf"), false, false},
+		{"empty message", pubKey.SerializeCompressed(), sigBytes,
+			nil, false, false},
+		{"other key", decodeHex("02192d74d0cb94344c9569c2e77901573d8" +
+			"d7903c3ebec3a957724895dca52c6b4"), sigBytes, message,
+			false, false},
+		{"bad pubkey", []byte{0x02, 0x01}, sigBytes, message, false,
+			true},
+		{"trailing hash type", pubKey.SerializeCompressed(),
+			append(append([]byte{}, sigBytes...), 0x01), message,
+			false, true},
+		{"empty signature", pubKey.SerializeCompressed(), nil, message,
+			false, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		valid, err := btcscript.VerifyMessageSignature(test.pubKey,
+			test.sig, test.message)
+		if (err != nil) != test.isErr {
+			t.Errorf("VerifyMessageSignature #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if valid != test.valid {
+			t.Errorf("VerifyMessageSignature #%d (%s) wrong result "+
+				"- got %v, want %v", i, test.name, valid,
+				test.valid)
+		}
+	}
+}
+
+// TestSignatureHashType ensures the hash type of script signatures is read from
+// their trailing byte and that stripping it leaves the DER signature.
+func TestSignatureHashType(t *testing.T) {
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), decodeHex(
+		"22a47fa09a223f2aa079edf85a7c2d4f8720ee63e502ee2869afab7de234b80c"))
+	hash := sha256.Sum256([]byte("namecoin message"))
+	sig, err := privKey.Sign(hash[:])
+	if err != nil {
+		t.Fatalf("failed to sign message: %v", err)
+	}
+	der := sig.Serialize()
+	withHashType := func(hashType btcscript.SigHashType) []byte {
+		return append(append([]byte{}, der...), byte(hashType))
+	}
+
+	tests := []struct {
+		name     string
+		sig      []byte
+		hashType btcscript.SigHashType
+		err      error
+	}{
+		{"SIGHASH_ALL", withHashType(btcscript.SigHashAll),
+			btcscript.SigHashAll, nil},
+		{"SIGHASH_SINGLE|ANYONECANPAY", withHashType(
+			btcscript.SigHashSingle | btcscript.SigHashAnyOneCanPay),
+			btcscript.SigHashSingle | btcscript.SigHashAnyOneCanPay,
+			nil},
+		{"missing hash type", der, 0,
+			btcscript.ErrStackInvalidSignatureEncoding},
+		{"too short", []byte{0x30, 0x01}, 0,
+			btcscript.ErrStackInvalidSignatureEncoding},
+		{"empty", nil, 0, btcscript.ErrStackInvalidSignatureEncoding},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		hashType, err := btcscript.SignatureHashType(test.sig)
+		if err != test.err {
+			t.Errorf("SignatureHashType #%d (%s) wrong error - got "+
+				"%v, want %v", i, test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if hashType != test.hashType {
+			t.Errorf("SignatureHashType #%d (%s) got %x, want %x", i,
+				test.name, hashType, test.hashType)
+		}
+		stripped := btcscript.StripSignatureHashType(test.sig)
+		if !bytes.Equal(stripped, der) {
+			t.Errorf("StripSignatureHashType #%d (%s) got %x, want "+
+				"%x", i, test.name, stripped, der)
+		}
+	}
+
+	if stripped := btcscript.StripSignatureHashType(nil); stripped != nil {
+		t.Errorf("StripSignatureHashType of empty signature got %x, "+
+			"want nil", stripped)
+	}
+}
+
 func testDisasmString(t *testing.T, test *detailedTest) {
 	// mock up fake tx.
 	dis, err := btcscript.DisasmString(test.script)
@@ -4401,12 +4716,265 @@ func testDisasmString(t *testing.T, test *detailedTest) {
 	}
 }
 
+// TestCheckSigVerifyOpcodes ensures OP_CHECKSIGVERIFY and
+// OP_CHECKMULTISIGVERIFY leave no result on the stack when the signatures are
+// valid, and abort the script with the items they used removed when they are
+// not.
+func TestCheckSigVerifyOpcodes(t *testing.T) {
+	tx := sigHashTestTx()
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("failed to make key: %v", err)
+	}
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("failed to make key: %v", err)
+	}
+	pk := (*btcec.PublicKey)(&key.PublicKey).SerializeCompressed()
+
+	// Each script is followed by a check that the item pushed by the
+	// signature script below the arguments is on top of the stack, which
+	// fails if the opcode left its result behind.
+	checkSig := builderScript(btcscript.NewScriptBuilder().AddData(pk).
+		AddOp(btcscript.OP_CHECKSIGVERIFY).AddOp(btcscript.OP_9).
+		AddOp(btcscript.OP_EQUAL))
+	multiSig := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_1).AddData(pk).AddOp(btcscript.OP_1).
+		AddOp(btcscript.OP_CHECKMULTISIGVERIFY).AddOp(btcscript.OP_9).
+		AddOp(btcscript.OP_EQUAL))
+
+	sign := func(script []byte, key *btcec.PrivateKey) []byte {
+		sig, err := sigHashTestSign(tx, script, btcscript.SigHashAll,
+			key)
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return sig
+	}
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		err       error
+		stack     [][]byte
+	}{
+		{
+			name: "OP_CHECKSIGVERIFY valid",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_9).
+				AddData(sign(checkSig, key))),
+			pkScript: checkSig,
+			stack:    [][]byte{{0x01}},
+		},
+		{
+			name: "OP_CHECKSIGVERIFY wrong key",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_9).
+				AddData(sign(checkSig, otherKey))),
+			pkScript: checkSig,
+			err:      btcscript.ErrStackVerifyFailed,
+			stack:    [][]byte{{0x09}},
+		},
+		{
+			name: "OP_CHECKSIGVERIFY empty signature",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_9).AddOp(btcscript.OP_0)),
+			pkScript: checkSig,
+			err:      btcscript.ErrStackVerifyFailed,
+			stack:    [][]byte{{0x09}},
+		},
+		{
+			name: "OP_CHECKMULTISIGVERIFY valid",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_9).AddOp(btcscript.OP_0).
+				AddData(sign(multiSig, key))),
+			pkScript: multiSig,
+			stack:    [][]byte{{0x01}},
+		},
+		{
+			name: "OP_CHECKMULTISIGVERIFY wrong key",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_9).AddOp(btcscript.OP_0).
+				AddData(sign(multiSig, otherKey))),
+			pkScript: multiSig,
+			err:      btcscript.ErrStackVerifyFailed,
+			stack:    [][]byte{{0x09}},
+		},
+		{
+			name: "OP_CHECKMULTISIGVERIFY missing dummy",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddData(sign(multiSig, key))),
+			pkScript: multiSig,
+			err:      btcscript.ErrStackUnderflow,
+			stack:    [][]byte{},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		engine, err := btcscript.NewScript(test.sigScript,
+			test.pkScript, 0, tx, btcscript.ScriptStrictMultiSig)
+		if err != nil {
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if err := engine.Execute(); err != test.err {
+			t.Errorf("Execute #%d (%s) wrong error - got %v, want %v",
+				i, test.name, err, test.err)
+			continue
+		}
+		if stack := engine.FinalStack(); !reflect.DeepEqual(stack,
+			test.stack) {
+			t.Errorf("FinalStack #%d (%s) got %x, want %x", i,
+				test.name, stack, test.stack)
+		}
+	}
+}
+
 func TestDisasmStrings(t *testing.T) {
 	for i := range detailedTests {
 		testDisasmString(t, &detailedTests[i])
 	}
 }
 
+// TestDisasmStringVerbose ensures the verbose disassembly shows the names of
+// data pushes and the numbers encoded by short pushes.
+func TestDisasmStringVerbose(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []byte
+		want   string
+	}{
+		{
+			name: "lock time",
+			script: []byte{btcscript.OP_DATA_3, 0x80, 0xbb, 0x01,
+				btcscript.OP_NOP2, btcscript.OP_DROP,
+				btcscript.OP_1},
+			want: "OP_DATA_3 80bb01 (113536) OP_NOP2 OP_DROP 1",
+		},
+		{
+			name:   "negative number",
+			script: []byte{btcscript.OP_DATA_2, 0xe8, 0x83},
+			want:   "OP_DATA_2 e883 (-1000)",
+		},
+		{
+			name: "8 byte push",
+			script: []byte{btcscript.OP_DATA_8, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0x7f},
+			want: "OP_DATA_8 ffffffffffffff7f (9223372036854775807)",
+		},
+		{
+			name: "9 byte push",
+			script: []byte{btcscript.OP_DATA_9, 0x01, 0x02, 0x03,
+				0x04, 0x05, 0x06, 0x07, 0x08, 0x09},
+			want: "OP_DATA_9 010203040506070809",
+		},
+		{
+			name:   "empty OP_PUSHDATA1",
+			script: []byte{btcscript.OP_PUSHDATA1, 0x00, btcscript.OP_0},
+			want:   "OP_PUSHDATA1 0",
+		},
+		{
+			name:   "OP_PUSHDATA2",
+			script: []byte{btcscript.OP_PUSHDATA2, 0x01, 0x00, 0x10},
+			want:   "OP_PUSHDATA2 10 (16)",
+		},
+		{
+			name:   "unparsable",
+			script: []byte{btcscript.OP_DROP, btcscript.OP_DATA_2},
+			want:   "OP_DROP[error]",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		dis, _ := btcscript.DisasmStringVerbose(test.script)
+		if dis != test.want {
+			t.Errorf("DisasmStringVerbose #%d (%s) got %q, want %q",
+				i, test.name, dis, test.want)
+		}
+	}
+}
+
+// TestDisasmInput ensures the disassembly of a spend shows the signature
+// script, the public key script and, for pay-to-script-hash, the redeem script.
+func TestDisasmInput(t *testing.T) {
+	redeemScript := []byte{btcscript.OP_2, btcscript.OP_EQUAL}
+	p2sh := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_HASH160).AddData(btcutil.Hash160(redeemScript)).
+		AddOp(btcscript.OP_EQUAL))
+	p2shDis, _ := btcscript.DisasmString(p2sh)
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		want      string
+	}{
+		{
+			name: "pay to script hash",
+			sigScript: append([]byte{btcscript.OP_2, 0x02},
+				redeemScript...),
+			pkScript: p2sh,
+			want: "sigScript: 2 5287\n" +
+				"pkScript: " + p2shDis + "\n" +
+				"redeemScript: 2 OP_EQUAL\n",
+		},
+		{
+			name:      "pay to pubkey hash",
+			sigScript: []byte{btcscript.OP_DATA_1, 0x01},
+			pkScript:  nameTestP2PKH,
+			want: "sigScript: 01\n" +
+				"pkScript: OP_DUP OP_HASH160 " +
+				"433ec2ac1ffa1b7b7d027f564529c57197f9ae88 " +
+				"OP_EQUALVERIFY OP_CHECKSIG\n",
+		},
+		{
+			name: "redeem script does not parse",
+			sigScript: []byte{btcscript.OP_DATA_2, btcscript.OP_DUP,
+				0x05},
+			pkScript: p2sh,
+			want: "sigScript: 7605\n" +
+				"pkScript: " + p2shDis + "\n" +
+				"redeemScript: OP_DUP[error]\n",
+		},
+		{
+			name:      "signature script not push only",
+			sigScript: []byte{btcscript.OP_1, btcscript.OP_DUP},
+			pkScript:  p2sh,
+			want: "sigScript: 1 OP_DUP\n" +
+				"pkScript: " + p2shDis + "\n" +
+				"redeemScript: [missing]\n",
+		},
+		{
+			name:     "empty signature script",
+			pkScript: p2sh,
+			want: "sigScript: \n" +
+				"pkScript: " + p2shDis + "\n" +
+				"redeemScript: [missing]\n",
+		},
+		{
+			name:      "signature script does not parse",
+			sigScript: []byte{btcscript.OP_DATA_2, 0x01},
+			pkScript:  p2sh,
+			want: "sigScript: [error]\n" +
+				"pkScript: " + p2shDis + "\n" +
+				"redeemScript: [missing]\n",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got := btcscript.DisasmInput(test.sigScript, test.pkScript)
+		if got != test.want {
+			t.Errorf("DisasmInput #%d (%s) got:\n%s\nwant:\n%s", i,
+				test.name, got, test.want)
+		}
+	}
+}
+
 // A basic test of GetSigOpCount for most opcodes, we do this by
 // running the same test for every one of the detailed tests. Since
 // disassembly errors are always parse errors, and so are
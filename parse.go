@@ -0,0 +1,227 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// ParsedScript is a script which has been parsed by SafeParse.  It provides
+// read only access to the opcodes of the script along with the results of
+// the checks which are usually made by parsing the script again, such as the
+// class of the script.
+type ParsedScript struct {
+	script   []byte
+	pops     []parsedOpcode
+	class    ScriptClass
+	pushOnly bool
+}
+
+// SafeParse parses the passed script and classifies it, returning an error
+// rather than panicking for any input.  Parsing is not expected to panic, but
+// any panic which does occur is recovered and returned as an error so that a
+// bug can not be used to crash a process handling the script.  Callers which
+// deal with scripts from untrusted sources, such as the network, should prefer
+// it to the other functions taking raw scripts.
+func SafeParse(script []byte) (ps *ParsedScript, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ps = nil
+			err = fmt.Errorf("internal error parsing script: %v", r)
+		}
+	}()
+
+	pops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+
+	class := typeOfScript(pops)
+	if base, ok := stripNamePrefix(pops); ok {
+		class = typeOfNameBase(base)
+	}
+	return &ParsedScript{
+		script:   script,
+		pops:     pops,
+		class:    class,
+		pushOnly: isPushOnly(pops),
+	}, nil
+}
+
+// parseScriptsParallelMin is the number of scripts from which ParseScripts
+// spreads the work across goroutines.  Parsing a single script is cheap, so
+// fewer scripts are parsed faster without the goroutines.
+const parseScriptsParallelMin = 512
+
+// ParseScripts parses each of the passed scripts as SafeParse does, such as all
+// the output scripts of a block scanned by an indexer.  The results and errors
+// are in the same order as the scripts, so for each index either the parsed
+// script or the error is nil.  When there are many scripts the work is spread
+// across a goroutine per CPU.  The scripts are only read, and the parsed
+// scripts share their memory as with SafeParse.
+func ParseScripts(scripts [][]byte) ([]*ParsedScript, []error) {
+	results := make([]*ParsedScript, len(scripts))
+	errs := make([]error, len(scripts))
+
+	workers := runtime.NumCPU()
+	if len(scripts) < parseScriptsParallelMin || workers < 2 {
+		for i, script := range scripts {
+			results[i], errs[i] = SafeParse(script)
+		}
+		return results, errs
+	}
+
+	// Each worker parses an interleaved share of the scripts, so no
+	// coordination is needed beyond waiting for them to finish.
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(scripts); i += workers {
+				results[i], errs[i] = SafeParse(scripts[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	return results, errs
+}
+
+// Script returns the raw script which was parsed.
+func (ps *ParsedScript) Script() []byte {
+	return ps.script
+}
+
+// Len returns the number of opcodes in the script.
+func (ps *ParsedScript) Len() int {
+	return len(ps.pops)
+}
+
+// Opcode returns the value of opcode i of the script, where i must be less
+// than Len.
+func (ps *ParsedScript) Opcode(i int) byte {
+	return ps.pops[i].opcode.value
+}
+
+// Data returns the data pushed by opcode i of the script, where i must be less
+// than Len, or nil if the opcode does not push data.  The returned slice shares
+// the memory of the script and must not be modified.
+func (ps *ParsedScript) Data(i int) []byte {
+	return ps.pops[i].data
+}
+
+// Class returns the class of the script as GetScriptClass would.
+func (ps *ParsedScript) Class() ScriptClass {
+	return ps.class
+}
+
+// IsPushOnly returns whether or not the script only pushes data.
+func (ps *ParsedScript) IsPushOnly() bool {
+	return ps.pushOnly
+}
+
+// Bytes returns the serialization of the parsed script with every data push
+// encoded in canonical form, which is the smallest opcode able to push exactly
+// the same data.  Single bytes from 1 to 16 are pushed with OP_1 through
+// OP_16 and empty data with OP_0.  For any script for which
+// HasCanonicalPushes returns true the result is the script that was parsed.
+// Other scripts are normalized, so the result may be shorter, but it pushes
+// the same data when executed.
+func (ps *ParsedScript) Bytes() []byte {
+	return reencodePushes(ps.pops, len(ps.script), false)
+}
+
+// CanonicalizeScript returns the passed script with every data push encoded
+// with the smallest opcode able to push the same data, as required of executed
+// pushes by ScriptVerifyMinimalData.  This is the same as the Bytes method of
+// ParsedScript, except that a single byte of 0x81 is also pushed with
+// OP_1NEGATE.  Other opcodes are left unchanged, so the result pushes the same
+// data when executed, and wallets may use it to make sure the signature
+// scripts they build can not be malleated by changing their data pushes.  An
+// error is returned if the script does not parse.
+func CanonicalizeScript(script []byte) ([]byte, error) {
+	pops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+	return reencodePushes(pops, len(script), true), nil
+}
+
+// reencodePushes returns the serialization of the passed opcodes with every
+// data push encoded with the smallest opcode able to push the same data.  A
+// single byte of 0x81 is only pushed with OP_1NEGATE when negOne is true.
+// size is the expected length of the result.
+func reencodePushes(pops []parsedOpcode, size int, negOne bool) []byte {
+	b := &ScriptBuilder{script: make([]byte, 0, size)}
+	for _, pop := range pops {
+		data := pop.data
+		switch {
+		case pop.opcode.value > OP_PUSHDATA4:
+			b.AddOp(pop.opcode.value)
+		case len(data) == 1 && data[0] >= 1 && data[0] <= 16:
+			b.AddOp(OP_1 - 1 + data[0])
+		case negOne && len(data) == 1 && data[0] == 0x81:
+			b.AddOp(OP_1NEGATE)
+		default:
+			b.addData(data)
+		}
+	}
+	return b.script
+}
+
+// ScriptsEqualCanonical returns whether scripts a and b are the same apart from
+// the opcodes used to push data.  Each data push, including those of OP_0,
+// OP_1NEGATE and OP_1 through OP_16, is compared by the data it pushes, so for
+// example OP_PUSHDATA1 of five bytes is equal to OP_DATA_5 of the same bytes
+// and OP_DATA_1 of 0x07 is equal to OP_7.  Every other opcode must be the same.
+// Scripts which do not parse are never equal.
+func ScriptsEqualCanonical(a, b []byte) bool {
+	aPops, err := parseScript(a)
+	if err != nil {
+		return false
+	}
+	bPops, err := parseScript(b)
+	if err != nil {
+		return false
+	}
+	if len(aPops) != len(bPops) {
+		return false
+	}
+
+	for i := range aPops {
+		aData, aPush := pushedValue(aPops[i])
+		bData, bPush := pushedValue(bPops[i])
+		if aPush != bPush {
+			return false
+		}
+		if aPush {
+			if !bytes.Equal(aData, bData) {
+				return false
+			}
+		} else if aPops[i].opcode.value != bPops[i].opcode.value {
+			return false
+		}
+	}
+	return true
+}
+
+// pushedValue returns the data the opcode pushes to the stack and true, or nil
+// and false if it is not a data push.  OP_RESERVED is not a data push since it
+// fails the script rather than pushing anything.
+func pushedValue(pop parsedOpcode) ([]byte, bool) {
+	switch op := pop.opcode.value; {
+	case op == OP_1NEGATE:
+		return []byte{0x81}, true
+	case op >= OP_1 && op <= OP_16:
+		return []byte{op - (OP_1 - 1)}, true
+	case op <= OP_PUSHDATA4:
+		return pop.data, true
+	}
+	return nil, false
+}
@@ -0,0 +1,651 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/hlandauf/btcscript"
+	"github.com/hlandauf/btcwire"
+)
+
+// TestSafeParse ensures SafeParse parses and classifies well formed scripts
+// the same way as the functions taking raw scripts.
+func TestSafeParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   []byte
+		len      int
+		class    btcscript.ScriptClass
+		pushOnly bool
+	}{
+		{
+			name:     "empty",
+			script:   nil,
+			len:      0,
+			class:    btcscript.NonStandardTy,
+			pushOnly: true,
+		},
+		{
+			name:     "pay to pubkey hash",
+			script:   nameTestP2PKH,
+			len:      5,
+			class:    btcscript.PubKeyHashTy,
+			pushOnly: false,
+		},
+		{
+			name: "name_update to pay to script hash",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2SH),
+			len:      8,
+			class:    btcscript.ScriptHashTy,
+			pushOnly: false,
+		},
+		{
+			name:     "pushes",
+			script:   []byte{btcscript.OP_0, btcscript.OP_DATA_1, 0x11},
+			len:      2,
+			class:    btcscript.NonStandardTy,
+			pushOnly: true,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ps, err := btcscript.SafeParse(test.script)
+		if err != nil {
+			t.Errorf("SafeParse #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if !bytes.Equal(ps.Script(), test.script) {
+			t.Errorf("SafeParse #%d (%s) wrong script: got %x", i,
+				test.name, ps.Script())
+		}
+		if ps.Len() != test.len {
+			t.Errorf("SafeParse #%d (%s) wrong length: got %d, "+
+				"want %d", i, test.name, ps.Len(), test.len)
+			continue
+		}
+		if class := btcscript.GetScriptClass(test.script); ps.Class() != test.class ||
+			class != test.class {
+			t.Errorf("SafeParse #%d (%s) wrong class: got %v "+
+				"(GetScriptClass %v), want %v", i, test.name,
+				ps.Class(), class, test.class)
+		}
+		if ps.IsPushOnly() != test.pushOnly {
+			t.Errorf("SafeParse #%d (%s) wrong push only: got %v",
+				i, test.name, ps.IsPushOnly())
+		}
+
+		// The opcodes must match those found by the tokenizer.
+		tokenizer := btcscript.NewScriptTokenizer(test.script)
+		for j := 0; tokenizer.Next(); j++ {
+			if ps.Opcode(j) != tokenizer.Opcode() ||
+				!bytes.Equal(ps.Data(j), tokenizer.Data()) {
+				t.Errorf("SafeParse #%d (%s) opcode %d: got "+
+					"%02x %x, want %02x %x", i, test.name,
+					j, ps.Opcode(j), ps.Data(j),
+					tokenizer.Opcode(), tokenizer.Data())
+			}
+		}
+	}
+}
+
+// TestSafeParseAdversarial ensures SafeParse returns errors for malformed
+// scripts and never panics, both for a table of scripts written to hit the
+// edge cases of parsing and for random scripts.
+func TestSafeParseAdversarial(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []byte
+		err    error
+	}{
+		{
+			name:   "truncated direct push",
+			script: []byte{btcscript.OP_DATA_2, 0x01},
+			err:    btcscript.ErrStackShortScript,
+		},
+		{
+			name:   "OP_PUSHDATA1 without length",
+			script: []byte{btcscript.OP_PUSHDATA1},
+			err:    btcscript.ErrStackShortScript,
+		},
+		{
+			name:   "OP_PUSHDATA2 with short length",
+			script: []byte{btcscript.OP_PUSHDATA2, 0x01},
+			err:    btcscript.ErrStackShortScript,
+		},
+		{
+			name:   "OP_PUSHDATA4 with short length",
+			script: []byte{btcscript.OP_PUSHDATA4, 0x01, 0x00, 0x00},
+			err:    btcscript.ErrStackShortScript,
+		},
+		{
+			name: "OP_PUSHDATA4 with sign extended length",
+			script: []byte{btcscript.OP_PUSHDATA4, 0xff, 0xff, 0xff,
+				0xff, 0x00},
+			err: btcscript.ErrStackShortScript,
+		},
+		{
+			name: "OP_PUSHDATA4 with length past end",
+			script: []byte{btcscript.OP_PUSHDATA4, 0x00, 0x00, 0x00,
+				0x80},
+			err: btcscript.ErrStackShortScript,
+		},
+		{
+			name: "truncated push after name prefix",
+			script: []byte{btcscript.OP_NAME_UPDATE,
+				btcscript.OP_DATA_1, 'a', btcscript.OP_DATA_5},
+			err: btcscript.ErrStackShortScript,
+		},
+		{
+			name:   "name prefix without base script",
+			script: []byte{btcscript.OP_NAME_NEW, btcscript.OP_2DROP},
+		},
+		{
+			name:   "name opcode alone",
+			script: []byte{btcscript.OP_NAME_FIRSTUPDATE},
+		},
+		{
+			name:   "undefined opcodes",
+			script: []byte{0xba, 0xfe, 0xff},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ps, err := btcscript.SafeParse(test.script)
+		if err != test.err {
+			t.Errorf("SafeParse #%d (%s) unexpected error - got %v, "+
+				"want %v", i, test.name, err, test.err)
+			continue
+		}
+		if err != nil && ps != nil {
+			t.Errorf("SafeParse #%d (%s) returned a script with an "+
+				"error", i, test.name)
+		}
+	}
+
+	// Random scripts are mostly malformed, and the seed is fixed so that
+	// any failure can be reproduced.
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 10000; i++ {
+		script := make([]byte, r.Intn(64))
+		r.Read(script)
+		if r.Intn(2) == 0 && len(script) != 0 {
+			script[0] = byte(btcscript.OP_NAME_NEW + r.Intn(3))
+		}
+		ps, err := btcscript.SafeParse(script)
+		if err != nil {
+			continue
+		}
+		for j := 0; j < ps.Len(); j++ {
+			ps.Opcode(j)
+			ps.Data(j)
+		}
+	}
+}
+
+// TestParseShortPushes ensures every data push whose declared length exceeds
+// the bytes left in the script fails to parse with ErrStackShortScript, and
+// that pushes with exactly the declared bytes are parsed in full.
+func TestParseShortPushes(t *testing.T) {
+	type push struct {
+		header []byte
+		length int64
+	}
+	var pushes []push
+	for n := byte(1); n <= btcscript.OP_DATA_75; n++ {
+		pushes = append(pushes, push{[]byte{n}, int64(n)})
+	}
+	for _, l := range []int64{0, 1, 75, 76, 255} {
+		pushes = append(pushes, push{[]byte{btcscript.OP_PUSHDATA1,
+			byte(l)}, l})
+	}
+	for _, l := range []int64{0, 1, 255, 256, 520, 521, 0xffff} {
+		pushes = append(pushes, push{[]byte{btcscript.OP_PUSHDATA2,
+			byte(l), byte(l >> 8)}, l})
+	}
+	for _, l := range []int64{0, 1, 520, 0x10000, 0x7fffffff, 0x80000000,
+		0xffffffff} {
+		pushes = append(pushes, push{[]byte{btcscript.OP_PUSHDATA4,
+			byte(l), byte(l >> 8), byte(l >> 16), byte(l >> 24)}, l})
+	}
+
+	// maxData bounds the data given to the pushes with the largest
+	// declared lengths, which can only be tested for failing.
+	const maxData = 0x10000
+
+	t.Logf("Running %d tests", len(pushes))
+	for i, test := range pushes {
+		tried := make(map[int64]bool)
+		for _, n := range []int64{0, 1, test.length / 2, test.length - 1} {
+			if n < 0 || n >= test.length || n > maxData || tried[n] {
+				continue
+			}
+			tried[n] = true
+
+			script := append(append([]byte(nil), test.header...),
+				bytes.Repeat([]byte{0xaa}, int(n))...)
+			if _, err := btcscript.SafeParse(script); err !=
+				btcscript.ErrStackShortScript {
+				t.Errorf("SafeParse #%d (%x) with %d of %d bytes "+
+					"wrong error - got %v, want %v", i,
+					test.header, n, test.length, err,
+					btcscript.ErrStackShortScript)
+			}
+			if _, err := btcscript.PushedData(script); err !=
+				btcscript.ErrStackShortScript {
+				t.Errorf("PushedData #%d (%x) with %d of %d "+
+					"bytes wrong error - got %v, want %v", i,
+					test.header, n, test.length, err,
+					btcscript.ErrStackShortScript)
+			}
+			tokenizer := btcscript.NewScriptTokenizer(script)
+			for tokenizer.Next() {
+			}
+			if tokenizer.Err() != btcscript.ErrStackShortScript {
+				t.Errorf("ScriptTokenizer #%d (%x) with %d of %d "+
+					"bytes wrong error - got %v, want %v", i,
+					test.header, n, test.length,
+					tokenizer.Err(),
+					btcscript.ErrStackShortScript)
+			}
+		}
+
+		// The header alone is short for any other header length.
+		for n := 1; n < len(test.header); n++ {
+			_, err := btcscript.SafeParse(test.header[:n])
+			if err != btcscript.ErrStackShortScript {
+				t.Errorf("SafeParse #%d (%x) with %d header bytes "+
+					"wrong error - got %v, want %v", i,
+					test.header, n, err,
+					btcscript.ErrStackShortScript)
+			}
+		}
+
+		// With all of its data the push is parsed in full, followed by
+		// the next opcode.
+		if test.length > maxData {
+			continue
+		}
+		data := bytes.Repeat([]byte{0xaa}, int(test.length))
+		script := append(append([]byte(nil), test.header...), data...)
+		script = append(script, btcscript.OP_1)
+		ps, err := btcscript.SafeParse(script)
+		if err != nil {
+			t.Errorf("SafeParse #%d (%x) with all %d bytes unexpected "+
+				"error: %v", i, test.header, test.length, err)
+			continue
+		}
+		if ps.Len() != 2 || !bytes.Equal(ps.Data(0), data) ||
+			ps.Opcode(1) != btcscript.OP_1 {
+			t.Errorf("SafeParse #%d (%x) with all %d bytes parsed "+
+				"%d opcodes with %d bytes of data", i,
+				test.header, test.length, ps.Len(),
+				len(ps.Data(0)))
+		}
+	}
+}
+
+// parseScriptsTestCorpus returns n scripts cycling through valid, empty and
+// malformed scripts, along with the error SafeParse returns for each.
+func parseScriptsTestCorpus(n int) ([][]byte, []error) {
+	corpus := []struct {
+		script []byte
+		err    error
+	}{
+		{nameTestP2PKH, nil},
+		{nil, nil},
+		{[]byte{btcscript.OP_DATA_2, 0x01}, btcscript.ErrStackShortScript},
+		{nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+			nameTestP2SH), nil},
+		{[]byte{btcscript.OP_PUSHDATA1}, btcscript.ErrStackShortScript},
+		{nameTestP2SH, nil},
+	}
+
+	scripts := make([][]byte, n)
+	errs := make([]error, n)
+	for i := range scripts {
+		scripts[i] = corpus[i%len(corpus)].script
+		errs[i] = corpus[i%len(corpus)].err
+	}
+	return scripts, errs
+}
+
+// TestParseScripts ensures scripts parsed together give the same results as
+// parsing each with SafeParse, in the order of the scripts, whether or not the
+// work is spread across goroutines.
+func TestParseScripts(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{"none", 0},
+		{"mixed", 6},
+		{"block of outputs", 3000},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		scripts, wantErrs := parseScriptsTestCorpus(test.n)
+		results, errs := btcscript.ParseScripts(scripts)
+		if len(results) != test.n || len(errs) != test.n {
+			t.Errorf("ParseScripts #%d (%s) got %d results and %d "+
+				"errors, want %d", i, test.name, len(results),
+				len(errs), test.n)
+			continue
+		}
+
+		for j, script := range scripts {
+			if errs[j] != wantErrs[j] {
+				t.Errorf("ParseScripts #%d (%s) script %d wrong "+
+					"error - got %v, want %v", i, test.name,
+					j, errs[j], wantErrs[j])
+				break
+			}
+			if (results[j] == nil) == (errs[j] == nil) {
+				t.Errorf("ParseScripts #%d (%s) script %d got "+
+					"result %v with error %v", i, test.name,
+					j, results[j], errs[j])
+				break
+			}
+			if results[j] == nil {
+				continue
+			}
+			want, _ := btcscript.SafeParse(script)
+			if !bytes.Equal(results[j].Script(), script) ||
+				results[j].Len() != want.Len() ||
+				results[j].Class() != want.Class() {
+				t.Errorf("ParseScripts #%d (%s) script %d does "+
+					"not match SafeParse", i, test.name, j)
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkParseScripts benchmarks parsing a block's worth of output scripts
+// with ParseScripts.
+func BenchmarkParseScripts(b *testing.B) {
+	scripts, _ := parseScriptsTestCorpus(4000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		btcscript.ParseScripts(scripts)
+	}
+}
+
+// BenchmarkParseScriptsLoop benchmarks parsing a block's worth of output
+// scripts by calling SafeParse for each and keeping the results.
+func BenchmarkParseScriptsLoop(b *testing.B) {
+	scripts, _ := parseScriptsTestCorpus(4000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results := make([]*btcscript.ParsedScript, len(scripts))
+		errs := make([]error, len(scripts))
+		for j, script := range scripts {
+			results[j], errs[j] = btcscript.SafeParse(script)
+		}
+	}
+}
+
+// TestParsedScriptBytes ensures scripts with canonical pushes round trip
+// through SafeParse and Bytes unchanged and that other scripts are normalized.
+func TestParsedScriptBytes(t *testing.T) {
+	multiSig := decodeHex("522102192d74d0cb94344c9569c2e77901573d8d790" +
+		"3c3ebec3a957724895dca52c6b42103b0bd634234abbb1ba1e986e884185c" +
+		"61cf43e001f9137f23c2c409273eb16e6552ae")
+	tests := []struct {
+		name   string
+		script []byte
+		want   []byte
+	}{
+		{name: "empty", script: []byte{}},
+		{name: "pay to pubkey hash", script: nameTestP2PKH},
+		{name: "pay to script hash", script: nameTestP2SH},
+		{name: "2 of 2 multisig", script: multiSig},
+		{
+			name: "name_new",
+			script: nameScript(btcscript.OP_NAME_NEW,
+				[][]byte{nameTestHash},
+				[]byte{btcscript.OP_2DROP}, nameTestP2PKH),
+		},
+		{
+			name: "name_firstupdate with long value",
+			script: nameScript(btcscript.OP_NAME_FIRSTUPDATE,
+				[][]byte{[]byte("d/example"), {0x01, 0x02},
+					bytes.Repeat([]byte{'v'}, 300)},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP},
+				multiSig),
+		},
+		{
+			name: "single byte pushes",
+			script: []byte{btcscript.OP_0, btcscript.OP_16,
+				btcscript.OP_DATA_1, 0x11, btcscript.OP_DATA_1,
+				0x81, btcscript.OP_1NEGATE},
+		},
+		{
+			name: "OP_PUSHDATA1 of 5 bytes",
+			script: []byte{btcscript.OP_PUSHDATA1, 0x05, 0x01, 0x02,
+				0x03, 0x04, 0x05},
+			want: []byte{btcscript.OP_DATA_5, 0x01, 0x02, 0x03, 0x04,
+				0x05},
+		},
+		{
+			name:   "small integer pushed as data",
+			script: []byte{btcscript.OP_DATA_1, 0x07},
+			want:   []byte{btcscript.OP_7},
+		},
+		{
+			name:   "empty OP_PUSHDATA2",
+			script: []byte{btcscript.OP_PUSHDATA2, 0x00, 0x00},
+			want:   []byte{btcscript.OP_0},
+		},
+		{
+			// Unlike OP_0, this pushes a non-empty byte, so it is
+			// kept as a data push.
+			name:   "zero byte",
+			script: []byte{btcscript.OP_DATA_1, 0x00},
+			want:   []byte{btcscript.OP_DATA_1, 0x00},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		want := test.want
+		if want == nil {
+			want = test.script
+			if !btcscript.HasCanonicalPushes(test.script) {
+				t.Errorf("HasCanonicalPushes #%d (%s) script is "+
+					"not canonical", i, test.name)
+				continue
+			}
+		}
+
+		ps, err := btcscript.SafeParse(test.script)
+		if err != nil {
+			t.Errorf("SafeParse #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if got := ps.Bytes(); !bytes.Equal(got, want) {
+			t.Errorf("Bytes #%d (%s) got %x, want %x", i, test.name,
+				got, want)
+		}
+	}
+}
+
+// TestCanonicalizeScript ensures scripts are re-encoded with minimal data
+// pushes which push the same data as the original scripts.
+func TestCanonicalizeScript(t *testing.T) {
+	data := bytes.Repeat([]byte{0x2a}, 80)
+
+	tests := []struct {
+		name   string
+		script []byte
+		want   []byte
+	}{
+		{
+			name:   "empty",
+			script: nil,
+			want:   []byte{},
+		},
+		{
+			name: "already minimal",
+			script: []byte{btcscript.OP_0, btcscript.OP_DATA_2, 0x01,
+				0x02, btcscript.OP_DUP, btcscript.OP_16},
+			want: []byte{btcscript.OP_0, btcscript.OP_DATA_2, 0x01,
+				0x02, btcscript.OP_DUP, btcscript.OP_16},
+		},
+		{
+			name: "small integers",
+			script: []byte{btcscript.OP_DATA_1, 0x05,
+				btcscript.OP_PUSHDATA1, 0x01, 0x10},
+			want: []byte{btcscript.OP_5, btcscript.OP_16},
+		},
+		{
+			name:   "negative one",
+			script: []byte{btcscript.OP_DATA_1, 0x81},
+			want:   []byte{btcscript.OP_1NEGATE},
+		},
+		{
+			name: "empty pushes",
+			script: []byte{btcscript.OP_PUSHDATA1, 0x00,
+				btcscript.OP_PUSHDATA4, 0x00, 0x00, 0x00, 0x00},
+			want: []byte{btcscript.OP_0, btcscript.OP_0},
+		},
+		{
+			name: "OP_PUSHDATA2 of 80 bytes",
+			script: append([]byte{btcscript.OP_PUSHDATA2, 80, 0x00},
+				data...),
+			want: append([]byte{btcscript.OP_PUSHDATA1, 80},
+				data...),
+		},
+		{
+			name: "OP_PUSHDATA1 of 3 bytes before opcodes",
+			script: []byte{btcscript.OP_PUSHDATA1, 0x03, 0x01, 0x02,
+				0x03, btcscript.OP_DUP, btcscript.OP_DROP},
+			want: []byte{btcscript.OP_DATA_3, 0x01, 0x02, 0x03,
+				btcscript.OP_DUP, btcscript.OP_DROP},
+		},
+	}
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got, err := btcscript.CanonicalizeScript(test.script)
+		if err != nil {
+			t.Errorf("CanonicalizeScript #%d (%s) unexpected error: "+
+				"%v", i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("CanonicalizeScript #%d (%s) got %x, want %x",
+				i, test.name, got, test.want)
+			continue
+		}
+		if !btcscript.ScriptsEqualCanonical(got, test.script) {
+			t.Errorf("CanonicalizeScript #%d (%s) result does not "+
+				"push the same data", i, test.name)
+			continue
+		}
+
+		// The result must be accepted when minimal data pushes are
+		// required.
+		pkScript := append(append([]byte(nil), got...),
+			btcscript.OP_1)
+		engine, err := btcscript.NewScript(nil, pkScript, 0, tx,
+			btcscript.ScriptVerifyMinimalData)
+		if err == nil {
+			err = engine.Execute()
+		}
+		if err != nil {
+			t.Errorf("Execute #%d (%s) unexpected error: %v", i,
+				test.name, err)
+		}
+	}
+
+	_, err := btcscript.CanonicalizeScript([]byte{btcscript.OP_DATA_2, 0x01})
+	if err != btcscript.ErrStackShortScript {
+		t.Errorf("CanonicalizeScript unparsable script wrong error - "+
+			"got %v, want %v", err, btcscript.ErrStackShortScript)
+	}
+}
+
+// TestScriptsEqualCanonical ensures scripts are compared by the data they push
+// rather than the opcodes used to push it.
+func TestScriptsEqualCanonical(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x03, 0x04, 0x05}
+	direct := append([]byte{btcscript.OP_DATA_5}, data...)
+	pushData1 := append([]byte{btcscript.OP_PUSHDATA1, 0x05}, data...)
+	pushData2 := append([]byte{btcscript.OP_PUSHDATA2, 0x05, 0x00}, data...)
+	pushData4 := append([]byte{btcscript.OP_PUSHDATA4, 0x05, 0x00, 0x00,
+		0x00}, data...)
+	withOp := func(script []byte, op byte) []byte {
+		return append(append([]byte(nil), script...), op)
+	}
+
+	tests := []struct {
+		name  string
+		a     []byte
+		b     []byte
+		equal bool
+	}{
+		{"empty", nil, []byte{}, true},
+		{"same bytes", nameTestP2PKH, nameTestP2PKH, true},
+		{"OP_PUSHDATA1", direct, pushData1, true},
+		{"OP_PUSHDATA2", withOp(pushData2, btcscript.OP_CHECKSIG),
+			withOp(direct, btcscript.OP_CHECKSIG), true},
+		{"OP_PUSHDATA4", pushData4, pushData1, true},
+		{"empty pushes", []byte{btcscript.OP_0},
+			[]byte{btcscript.OP_PUSHDATA1, 0x00}, true},
+		{"small integer", []byte{btcscript.OP_7},
+			[]byte{btcscript.OP_DATA_1, 0x07}, true},
+		{"negative one", []byte{btcscript.OP_1NEGATE},
+			[]byte{btcscript.OP_PUSHDATA1, 0x01, 0x81}, true},
+		{"zero byte and OP_0", []byte{btcscript.OP_0},
+			[]byte{btcscript.OP_DATA_1, 0x00}, false},
+		{"different data", direct,
+			[]byte{btcscript.OP_DATA_5, 0x01, 0x02, 0x03, 0x04, 0x06},
+			false},
+		{"different opcode", withOp(direct, btcscript.OP_CHECKSIG),
+			withOp(direct, btcscript.OP_CHECKSIGVERIFY), false},
+		{"extra opcode", direct, withOp(direct, btcscript.OP_CHECKSIG),
+			false},
+		{"push and opcode", []byte{btcscript.OP_1},
+			[]byte{btcscript.OP_NOP}, false},
+		{"OP_RESERVED", []byte{btcscript.OP_RESERVED},
+			[]byte{btcscript.OP_RESERVED}, true},
+		{"OP_RESERVED and OP_0", []byte{btcscript.OP_RESERVED},
+			[]byte{btcscript.OP_0}, false},
+		{"unparsable", []byte{btcscript.OP_DATA_2, 0x01},
+			[]byte{btcscript.OP_DATA_2, 0x01}, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if equal := btcscript.ScriptsEqualCanonical(test.a,
+			test.b); equal != test.equal {
+			t.Errorf("ScriptsEqualCanonical #%d (%s) got %v, want %v",
+				i, test.name, equal, test.equal)
+		}
+		if equal := btcscript.ScriptsEqualCanonical(test.b,
+			test.a); equal != test.equal {
+			t.Errorf("ScriptsEqualCanonical #%d (%s) reversed got "+
+				"%v, want %v", i, test.name, equal, test.equal)
+		}
+	}
+}
@@ -21,63 +21,80 @@ import (
 var (
 	// ErrStackShortScript is returned if the script has an opcode that is
 	// too long for the length of the script.
-	ErrStackShortScript = errors.New("execute past end of script")
+	ErrStackShortScript = scriptError(ErrCodeShortScript,
+		"execute past end of script")
 
 	// ErrStackLongScript is returned if the script has an opcode that is
 	// too long for the length of the script.
-	ErrStackLongScript = errors.New("script is longer than maximum allowed")
+	ErrStackLongScript = scriptError(ErrCodeScriptTooBig,
+		"script is longer than maximum allowed")
 
 	// ErrStackUnderflow is returned if an opcode requires more items on the
 	// stack than is present.f
-	ErrStackUnderflow = errors.New("stack underflow")
+	ErrStackUnderflow = scriptError(ErrCodeStackUnderflow,
+		"stack underflow")
 
 	// ErrStackInvalidArgs is returned if the argument for an opcode is out
 	// of acceptable range.
-	ErrStackInvalidArgs = errors.New("invalid argument")
+	ErrStackInvalidArgs = scriptError(ErrCodeInvalidArgs,
+		"invalid argument")
 
 	// ErrStackOpDisabled is returned when a disabled opcode is encountered
 	// in the script.
-	ErrStackOpDisabled = errors.New("Disabled Opcode")
+	ErrStackOpDisabled = scriptError(ErrCodeDisabledOpcode,
+		"Disabled Opcode")
 
 	// ErrStackVerifyFailed is returned when one of the OP_VERIFY or
 	// OP_*VERIFY instructions is executed and the conditions fails.
-	ErrStackVerifyFailed = errors.New("Verify failed")
+	ErrStackVerifyFailed = scriptError(ErrCodeVerifyFailed,
+		"Verify failed")
 
 	// ErrStackNumberTooBig is returned when the argument for an opcode that
 	// should be an offset is obviously far too large.
-	ErrStackNumberTooBig = errors.New("number too big")
+	ErrStackNumberTooBig = scriptError(ErrCodeNumberTooBig,
+		"number too big")
 
 	// ErrStackInvalidOpcode is returned when an opcode marked as invalid or
 	// a completely undefined opcode is encountered.
-	ErrStackInvalidOpcode = errors.New("Invalid Opcode")
+	ErrStackInvalidOpcode = scriptError(ErrCodeInvalidOpcode,
+		"Invalid Opcode")
 
 	// ErrStackReservedOpcode is returned when an opcode marked as reserved
 	// is encountered.
-	ErrStackReservedOpcode = errors.New("Reserved Opcode")
+	ErrStackReservedOpcode = scriptError(ErrCodeReservedOpcode,
+		"Reserved Opcode")
 
 	// ErrStackEarlyReturn is returned when OP_RETURN is executed in the
 	// script.
-	ErrStackEarlyReturn = errors.New("Script returned early")
+	ErrStackEarlyReturn = scriptError(ErrCodeEarlyReturn,
+		"Script returned early")
 
 	// ErrStackNoIf is returned if an OP_ELSE or OP_ENDIF is encountered
 	// without first having an OP_IF or OP_NOTIF in the script.
-	ErrStackNoIf = errors.New("OP_ELSE or OP_ENDIF with no matching OP_IF")
+	ErrStackNoIf = scriptError(ErrCodeUnbalancedConditional,
+		"OP_ELSE or OP_ENDIF with no matching OP_IF")
 
 	// ErrStackMissingEndif is returned if the end of a script is reached
 	// without and OP_ENDIF to correspond to a conditional expression.
-	ErrStackMissingEndif = fmt.Errorf("execute fail, in conditional execution")
+	ErrStackMissingEndif = scriptError(ErrCodeUnbalancedConditional,
+		"execute fail, in conditional execution")
 
 	// ErrStackTooManyPubkeys is returned if an OP_CHECKMULTISIG is
 	// encountered with more than MaxPubKeysPerMultiSig pubkeys present.
-	ErrStackTooManyPubkeys = errors.New("Invalid pubkey count in OP_CHECKMULTISIG")
+	ErrStackTooManyPubkeys = scriptError(ErrCodeInvalidPubKeyCount,
+		"Invalid pubkey count in OP_CHECKMULTISIG")
 
 	// ErrStackTooManyOperations is returned if a script has more than
-	// MaxOpsPerScript opcodes that do not push data.
-	ErrStackTooManyOperations = errors.New("Too many operations in script")
+	// MaxOpsPerScript, or the configured limit, opcodes that do not push
+	// data.
+	ErrStackTooManyOperations = scriptError(ErrCodeTooManyOperations,
+		"Too many operations in script")
 
 	// ErrStackElementTooBig is returned if the size of an element to be
-	// pushed to the stack is over MaxScriptElementSize.
-	ErrStackElementTooBig = errors.New("Element in script too large")
+	// pushed to the stack is over MaxScriptElementSize, or the configured
+	// limit.
+	ErrStackElementTooBig = scriptError(ErrCodeElementTooBig,
+		"Element in script too large")
 
 	// ErrStackUnknownAddress is returned when ScriptToAddrHash does not
 	// recognise the pattern of the script and thus can not find the address
@@ -87,42 +104,85 @@ var (
 	// ErrStackScriptFailed is returned when at the end of a script the
 	// boolean on top of the stack is false signifying that the script has
 	// failed.
-	ErrStackScriptFailed = errors.New("execute fail, fail on stack")
+	ErrStackScriptFailed = scriptError(ErrCodeEvalFalse,
+		"execute fail, fail on stack")
 
 	// ErrStackScriptUnfinished is returned when CheckErrorCondition is
 	// called on a script that has not finished executing.
-	ErrStackScriptUnfinished = errors.New("Error check when script unfinished")
+	ErrStackScriptUnfinished = scriptError(ErrCodeScriptUnfinished,
+		"Error check when script unfinished")
 
 	// ErrStackEmptyStack is returned when the stack is empty at the end of
 	// execution. Normal operation requires that a boolean is on top of the
 	// stack when the scripts have finished executing.
-	ErrStackEmptyStack = errors.New("Stack empty at end of execution")
+	ErrStackEmptyStack = scriptError(ErrCodeEmptyStack,
+		"Stack empty at end of execution")
 
 	// ErrStackP2SHNonPushOnly is returned when a Pay-to-Script-Hash
 	// transaction is encountered and the ScriptSig does operations other
 	// than push data (in violation of bip16).
-	ErrStackP2SHNonPushOnly = errors.New("pay to script hash with non " +
-		"pushonly input")
+	ErrStackP2SHNonPushOnly = scriptError(ErrCodeNotPushOnly,
+		"pay to script hash with non pushonly input")
 
 	// ErrStackInvalidParseType is an internal error returned from
 	// ScriptToAddrHash ony if the internal data tables are wrong.
-	ErrStackInvalidParseType = errors.New("internal error: invalid parsetype found")
+	ErrStackInvalidParseType = scriptError(ErrCodeInternal,
+		"internal error: invalid parsetype found")
 
 	// ErrStackInvalidAddrOffset is an internal error returned from
 	// ScriptToAddrHash ony if the internal data tables are wrong.
-	ErrStackInvalidAddrOffset = errors.New("internal error: invalid offset found")
+	ErrStackInvalidAddrOffset = scriptError(ErrCodeInternal,
+		"internal error: invalid offset found")
 
 	// ErrStackInvalidIndex is returned when an out-of-bounds index was
 	// passed to a function.
-	ErrStackInvalidIndex = errors.New("Invalid script index")
+	ErrStackInvalidIndex = scriptError(ErrCodeInvalidIndex,
+		"Invalid script index")
 
 	// ErrStackNonPushOnly is returned when ScriptInfo is called with a
 	// pkScript that peforms operations other that pushing data to the stack.
-	ErrStackNonPushOnly = errors.New("SigScript is non pushonly")
+	ErrStackNonPushOnly = scriptError(ErrCodeNotPushOnly,
+		"SigScript is non pushonly")
 
 	// ErrStackOverflow is returned when stack and altstack combined depth
 	// is over the limit.
-	ErrStackOverflow = errors.New("Stacks overflowed")
+	ErrStackOverflow = scriptError(ErrCodeStackOverflow,
+		"Stacks overflowed")
+
+	// ErrStackNegativeLockTime is returned when a lock time opcode is
+	// executed with a negative lock time on top of the stack.
+	ErrStackNegativeLockTime = scriptError(ErrCodeNegativeLockTime,
+		"negative lock time")
+
+	// ErrStackUnsatisfiedLockTime is returned when a lock time opcode is
+	// executed and the transaction does not satisfy the lock time on top
+	// of the stack.
+	ErrStackUnsatisfiedLockTime = scriptError(ErrCodeUnsatisfiedLockTime,
+		"lock time requirement not satisfied")
+
+	// ErrStackInvalidSignatureEncoding is returned when a signature which
+	// is not strictly DER encoded is passed to a signature checking opcode
+	// and strict DER encoding is being enforced.
+	ErrStackInvalidSignatureEncoding = scriptError(ErrCodeSigDER,
+		"signature is not strictly DER encoded")
+
+	// ErrStackHighS is returned when a signature with an S value greater
+	// than half the curve order is passed to a signature checking opcode
+	// and low S values are being enforced.
+	ErrStackHighS = scriptError(ErrCodeSigHighS,
+		"signature S value is higher than half the curve order")
+
+	// ErrStackMinimalData is returned when a data push which does not use
+	// the smallest possible opcode is executed, or when the lock time
+	// operand of OP_CHECKLOCKTIMEVERIFY or OP_CHECKSEQUENCEVERIFY is not
+	// minimally encoded, and minimal data is being enforced.
+	ErrStackMinimalData = scriptError(ErrCodeMinimalData,
+		"data push does not use the smallest possible opcode")
+
+	// ErrBudgetExceeded is returned when the total cost of the opcodes
+	// executed exceeds the budget given in the ScriptLimits of the engine.
+	ErrBudgetExceeded = scriptError(ErrCodeBudgetExceeded,
+		"script execution exceeds the opcode cost budget")
 )
 
 const (
@@ -162,6 +222,47 @@ const (
 	MaxScriptElementSize  = 520 // Max bytes pushable to the stack.
 )
 
+// ScriptLimits holds the limits enforced by the script engine which alternative
+// chains may want to change.  A zero value for a limit means the Bitcoin
+// consensus value given by the constants above is used.
+type ScriptLimits struct {
+	// MaxElementSize is the maximum number of bytes which may be pushed
+	// to the stack by a single opcode.
+	MaxElementSize int
+
+	// MaxOps is the maximum number of non-push operations in a script,
+	// with each public key of a multisig operation counting as one.
+	MaxOps int
+
+	// Budget is the maximum total cost of the opcodes executed by all of
+	// the scripts, including any redeem or witness script.  Every opcode
+	// stepped over counts, whether or not it is in a branch which is
+	// taken.  Zero means there is no budget.
+	Budget int64
+
+	// OpcodeCost returns the cost of executing an opcode.  It is only used
+	// when Budget is set, and DefaultOpcodeCost is used if it is nil.
+	OpcodeCost OpcodeCostFunc
+}
+
+// OpcodeCostFunc is the type of function which gives the cost of executing an
+// opcode against the budget of ScriptLimits.
+type OpcodeCostFunc func(opcode byte) int64
+
+// DefaultOpcodeCost returns the cost of executing an opcode which is used when
+// a budget is given in ScriptLimits without an OpcodeCost.  Hashing opcodes
+// cost 10 and signature checking opcodes 100, while all others cost 1.
+func DefaultOpcodeCost(opcode byte) int64 {
+	switch opcode {
+	case OP_RIPEMD160, OP_SHA1, OP_SHA256, OP_HASH160, OP_HASH256:
+		return 10
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY, OP_CHECKMULTISIG,
+		OP_CHECKMULTISIGVERIFY:
+		return 100
+	}
+	return 1
+}
+
 // ScriptClass is an enumeration for the list of standard types of script.
 type ScriptClass byte
 
@@ -173,6 +274,7 @@ const (
 	ScriptHashTy                     // Pay to script hash.
 	MultiSigTy                       // Multi signature.
 	NullDataTy                       // Empty data-only (provably prunable).
+	NameScriptTy                     // Name operation with unrecognized address.
 )
 
 var scriptClassToName = []string{
@@ -182,6 +284,7 @@ var scriptClassToName = []string{
 	ScriptHashTy:  "scripthash",
 	MultiSigTy:    "multisig",
 	NullDataTy:    "nulldata",
+	NameScriptTy:  "name",
 }
 
 // String implements the Stringer interface by returning the name of
@@ -206,10 +309,30 @@ type Script struct {
 	txidx           int
 	condStack       []int
 	numOps          int
-	bip16           bool     // treat execution as pay-to-script-hash
-	der             bool     // enforce DER encoding
-	strictMultiSig  bool     // verify multisig stack item is zero length
-	savedFirstStack [][]byte // stack from first script for bip16 scripts
+	bip16           bool           // treat execution as pay-to-script-hash
+	der             bool           // enforce DER encoding
+	strictMultiSig  bool           // verify multisig stack item is zero length
+	verifyCSV       bool           // treat OP_NOP3 as OP_CHECKSEQUENCEVERIFY
+	verifyDER       bool           // fail on signatures not strictly DER encoded
+	verifyLowS      bool           // fail on signatures with high S values
+	savedFirstStack [][]byte       // stack from first script for bip16 scripts
+	maxElementSize  int            // max bytes pushable to the stack
+	maxOps          int            // max number of non-push operations
+	stackBufs       *[2][][]byte   // pooled backing arrays of the stacks
+	witness         [][]byte       // witness stack of the input
+	amount          int64          // amount of the output being spent
+	witnessProgram  []parsedOpcode // witness program being spent, if any
+	witnessExec     bool           // executing the witness script
+	trace           TraceFunc      // called before each opcode executes
+	minimalData     bool           // fail on data pushes which are not minimal
+	verifyCLTV      bool           // treat OP_NOP2 as OP_CHECKLOCKTIMEVERIFY
+	sigHashes       *sigHashCache  // signature hashes calculated so far
+	budget          int64          // max total cost of opcodes, 0 if none
+	cost            int64          // total cost of opcodes executed so far
+	opcodeCost      OpcodeCostFunc // cost of each opcode against budget
+	sigCache        *SigCache      // signatures known to be valid, if any
+	strictWitness   bool           // fail on witness versions other than 0
+	checkedItems    [][]byte       // items popped by CheckErrorCondition
 }
 
 // isSmallInt returns whether or not the opcode is considered a small integer,
@@ -293,7 +416,8 @@ func isMultiSig(pops []parsedOpcode) bool {
 // false otherwise.
 func isNullData(pops []parsedOpcode) bool {
 	// A nulldata transaction is either a single OP_RETURN or an
-	// OP_RETURN SMALLDATA (where SMALLDATA is a push data up to 40 bytes).
+	// OP_RETURN SMALLDATA (where SMALLDATA is a push data up to
+	// MaxDataCarrierSize bytes).
 	l := len(pops)
 	if l == 1 && pops[0].opcode.value == OP_RETURN {
 		return true
@@ -302,7 +426,7 @@ func isNullData(pops []parsedOpcode) bool {
 	return l == 2 &&
 		pops[0].opcode.value == OP_RETURN &&
 		pops[1].opcode.value <= OP_PUSHDATA4 &&
-		len(pops[1].data) <= 40
+		len(pops[1].data) <= MaxDataCarrierSize
 }
 
 // isPushOnly returns true if the script only pushes data, false otherwise.
@@ -374,13 +498,156 @@ func HasCanonicalPushes(script []byte) bool {
 	return true
 }
 
+// IsMinimallyEncoded returns whether or not every data push in the passed
+// script uses the smallest opcode possible, as ScriptVerifyMinimalData requires
+// of executed pushes.  It differs from HasCanonicalPushes in also requiring a
+// single byte of 0x81 to be pushed with OP_1NEGATE, and a script is minimally
+// encoded exactly when CanonicalizeScript leaves it unchanged.  Since every
+// push is checked, including those in branches which are not executed, relay
+// policy may use it to reject scripts without executing them.  If the script
+// does not parse false will be returned.
+func IsMinimallyEncoded(script []byte) bool {
+	pops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+
+	for i := range pops {
+		if pops[i].opcode.value <= OP_PUSHDATA4 &&
+			!isMinimalDataPush(&pops[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasDisabledOpcode returns whether or not the passed script contains any of
+// the disabled opcodes, such as OP_CAT and OP_MUL.  A script containing one
+// always fails to execute, even when the opcode is in a branch which is not
+// executed, so scripts may be screened with it before executing them.  If the
+// script does not parse false will be returned.
+func HasDisabledOpcode(script []byte) bool {
+	pops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+
+	for i := range pops {
+		if pops[i].disabled() {
+			return true
+		}
+	}
+
+	return false
+}
+
 // GetScriptClass returns the class of the script passed. If the script does not
-// parse then NonStandardTy will be returned.
+// parse then NonStandardTy will be returned.  Name scripts are classified by
+// the address script which follows the name prefix, or as NameScriptTy if that
+// address script is not of a standard type.
 func GetScriptClass(script []byte) ScriptClass {
+	if class, ok := fastScriptClass(script); ok {
+		return class
+	}
+	return parsedScriptClass(script)
+}
+
+// ScriptClassification is the class of a script along with the class of the
+// address script of a name script, as returned by ClassifyScript.
+type ScriptClassification struct {
+	class  ScriptClass
+	base   ScriptClass
+	isName bool
+}
+
+// ClassifyScript returns the classification of the passed script.  Unlike
+// GetScriptClass, which reports a name script by the class of its address
+// script, it keeps both the fact that the script is a name script and the
+// class of the address script, so a name script with a non-standard address
+// script can be told apart from a script which is non-standard as a whole.
+func ClassifyScript(script []byte) ScriptClassification {
+	if class, ok := fastScriptClass(script); ok {
+		return ScriptClassification{class: class, base: class}
+	}
+	pops, err := parseScript(script)
+	if err != nil {
+		return ScriptClassification{class: NonStandardTy,
+			base: NonStandardTy}
+	}
+	if base, ok := stripNamePrefix(pops); ok {
+		return ScriptClassification{
+			class:  typeOfNameBase(base),
+			base:   typeOfScript(base),
+			isName: true,
+		}
+	}
+	class := typeOfScript(pops)
+	return ScriptClassification{class: class, base: class}
+}
+
+// Class returns the class of the script as GetScriptClass does.
+func (c ScriptClassification) Class() ScriptClass {
+	return c.class
+}
+
+// IsNameScript returns whether or not the script is a name script.
+func (c ScriptClassification) IsNameScript() bool {
+	return c.isName
+}
+
+// Layers returns the class of each layer of the script.  For name scripts the
+// outer class is NameScriptTy and the base class is that of the address
+// script following the name prefix, which may be NonStandardTy.  For all other
+// scripts both classes are the class of the script.
+func (c ScriptClassification) Layers() (outer, base ScriptClass) {
+	if c.isName {
+		return NameScriptTy, c.base
+	}
+	return c.class, c.base
+}
+
+// fastScriptClass returns the class of a script which is exactly the bytes of
+// a pay-to-pubkey-hash, pay-to-script-hash or version 0 witness program
+// template without parsing it.  False is returned for any other script, which
+// must then be classified by parsedScriptClass.  None of these templates start
+// with a name opcode, and witness programs have no class of their own, so the
+// result is always the same as that of parsedScriptClass.
+func fastScriptClass(script []byte) (ScriptClass, bool) {
+	switch len(script) {
+	case 25:
+		if script[0] == OP_DUP && script[1] == OP_HASH160 &&
+			script[2] == OP_DATA_20 && script[23] == OP_EQUALVERIFY &&
+			script[24] == OP_CHECKSIG {
+			return PubKeyHashTy, true
+		}
+	case 23:
+		if script[0] == OP_HASH160 && script[1] == OP_DATA_20 &&
+			script[22] == OP_EQUAL {
+			return ScriptHashTy, true
+		}
+	case 22:
+		if script[0] == OP_0 && script[1] == OP_DATA_20 {
+			return NonStandardTy, true
+		}
+	case 34:
+		if script[0] == OP_0 && script[1] == OP_DATA_32 {
+			return NonStandardTy, true
+		}
+	}
+	return NonStandardTy, false
+}
+
+// parsedScriptClass returns the class of the script as GetScriptClass does by
+// parsing it and matching the opcodes against each template.
+func parsedScriptClass(script []byte) ScriptClass {
 	pops, err := parseScript(script)
 	if err != nil {
 		return NonStandardTy
 	}
+	if base, ok := stripNamePrefix(pops); ok {
+		return typeOfNameBase(base)
+	}
 	return typeOfScript(pops)
 }
 
@@ -436,63 +703,11 @@ func parseScript(script []byte) ([]parsedOpcode, error) {
 // opcodes so far.
 func parseScriptTemplate(script []byte, opcodemap map[byte]*opcode) ([]parsedOpcode, error) {
 	retScript := make([]parsedOpcode, 0, len(script))
-	for i := 0; i < len(script); {
-		instr := script[i]
-		op, ok := opcodemap[instr]
-		if !ok {
-			return retScript, ErrStackInvalidOpcode
-		}
-		pop := parsedOpcode{opcode: op}
-		// parse data out of instruction.
-		switch {
-		case op.length == 1:
-			// no data, done here
-			i++
-		case op.length > 1:
-			if len(script[i:]) < op.length {
-				return retScript, ErrStackShortScript
-			}
-			// slice out the data.
-			pop.data = script[i+1 : i+op.length]
-			i += op.length
-		case op.length < 0:
-			var l uint
-			off := i + 1
-
-			if len(script[off:]) < -op.length {
-				return retScript, ErrStackShortScript
-			}
-
-			// Next -length bytes are little endian length of data.
-			switch op.length {
-			case -1:
-				l = uint(script[off])
-			case -2:
-				l = ((uint(script[off+1]) << 8) |
-					uint(script[off]))
-			case -4:
-				l = ((uint(script[off+3]) << 24) |
-					(uint(script[off+2]) << 16) |
-					(uint(script[off+1]) << 8) |
-					uint(script[off]))
-			default:
-				return retScript,
-					fmt.Errorf("invalid opcode length %d",
-						op.length)
-			}
-
-			off += -op.length // beginning of data
-			// Disallow entries that do not fit script or were
-			// sign extended.
-			if int(l) > len(script[off:]) || int(l) < 0 {
-				return retScript, ErrStackShortScript
-			}
-			pop.data = script[off : off+int(l)]
-			i += 1 - op.length + int(l)
-		}
-		retScript = append(retScript, pop)
+	tokenizer := newScriptTokenizer(script, opcodemap)
+	for tokenizer.Next() {
+		retScript = append(retScript, tokenizer.parsedOpcode())
 	}
-	return retScript, nil
+	return retScript, tokenizer.Err()
 }
 
 // unparseScript reversed the action of parseScript and returns the
@@ -533,26 +748,145 @@ const (
 	ScriptCanonicalSignatures
 
 	// ScriptStrictMultiSig defines whether to verify the stack item
-	// used by CHECKMULTISIG is zero length.
+	// used by CHECKMULTISIG is zero length.  The original implementation
+	// pops one item more than it uses, and without this flag the item may
+	// be anything, so third parties could change it.
 	ScriptStrictMultiSig
+
+	// ScriptVerifyCheckSequenceVerify defines whether OP_NOP3 is treated
+	// as OP_CHECKSEQUENCEVERIFY, which enforces the relative lock time of
+	// the input as defined by BIP0112.  Without this flag OP_NOP3 does
+	// nothing.
+	ScriptVerifyCheckSequenceVerify
+
+	// ScriptVerifyDERSignatures defines whether every non-empty signature
+	// passed to OP_CHECKSIG and OP_CHECKMULTISIG must be strictly DER
+	// encoded as defined by BIP0066.  Script execution fails with
+	// ErrStackInvalidSignatureEncoding when a signature is not, rather
+	// than the signature check merely failing.
+	ScriptVerifyDERSignatures
+
+	// ScriptVerifyLowS defines whether every non-empty signature passed to
+	// OP_CHECKSIG and OP_CHECKMULTISIG must be strictly DER encoded and
+	// have an S value of at most half the curve order, as defined by rule
+	// 5 of BIP0062.  Script execution fails with ErrStackHighS when a
+	// signature has a high S value.
+	ScriptVerifyLowS
+
+	// ScriptVerifyWitness defines whether outputs paying to a witness
+	// program, either directly or nested in a pay-to-script-hash output
+	// when ScriptBip16 is also set, are validated against the witness
+	// passed to NewScriptWithWitness as defined by BIP0141 and BIP0143.
+	ScriptVerifyWitness
+
+	// ScriptVerifyMinimalData defines whether executed data pushes must use
+	// the smallest possible opcode, as required by rule 3 of BIP0062, and
+	// whether the lock time operands of OP_CHECKLOCKTIMEVERIFY and
+	// OP_CHECKSEQUENCEVERIFY must be minimally encoded numbers as required
+	// by rule 4.
+	ScriptVerifyMinimalData
+
+	// ScriptVerifyCheckLockTimeVerify defines whether OP_NOP2 is treated
+	// as OP_CHECKLOCKTIMEVERIFY, which enforces the lock time of the
+	// transaction as defined by BIP0065.  Without this flag OP_NOP2 does
+	// nothing.
+	ScriptVerifyCheckLockTimeVerify
+
+	// ScriptVerifyDiscourageUpgradableWitnessProgram defines whether
+	// witness programs of versions other than 0 fail with
+	// ErrWitnessUnsupportedVersion when ScriptVerifyWitness is set.  Those
+	// versions are reserved for future soft forks and succeed without any
+	// checks under the consensus rules, so this flag keeps nodes from
+	// relaying spends of them which a later soft fork could invalidate.
+	ScriptVerifyDiscourageUpgradableWitnessProgram
+)
+
+// ScriptVerifyNullDummy is ScriptStrictMultiSig under the name given to the
+// rule by BIP0147, which requires the extra item popped by OP_CHECKMULTISIG and
+// OP_CHECKMULTISIGVERIFY to be empty.
+const ScriptVerifyNullDummy = ScriptStrictMultiSig
+
+const (
+	// ConsensusVerifyFlags are the flags enforcing the script rules which
+	// every block must follow once the soft forks introducing them are
+	// active: pay-to-script-hash (BIP0016), strict DER signatures
+	// (BIP0066), OP_CHECKLOCKTIMEVERIFY (BIP0065), OP_CHECKSEQUENCEVERIFY
+	// (BIP0112), witness programs (BIP0141) and empty multisig dummy items
+	// (BIP0147).  Witness programs can only be spent by engines created
+	// with NewScriptWithWitness.
+	ConsensusVerifyFlags = ScriptBip16 | ScriptVerifyDERSignatures |
+		ScriptVerifyCheckLockTimeVerify |
+		ScriptVerifyCheckSequenceVerify | ScriptVerifyWitness |
+		ScriptStrictMultiSig
+
+	// StandardVerifyFlags are the flags which transactions must pass to be
+	// relayed and mined by nodes following the standard policy.  They are
+	// ConsensusVerifyFlags along with canonical signature encoding, low S
+	// values and minimal data pushes, which prevent third parties from
+	// changing the scripts of transactions, and the rejection of witness
+	// programs of versions reserved for future soft forks.
+	StandardVerifyFlags = ConsensusVerifyFlags | ScriptCanonicalSignatures |
+		ScriptVerifyLowS | ScriptVerifyMinimalData |
+		ScriptVerifyDiscourageUpgradableWitnessProgram
 )
 
 // NewScript returns a new script engine for the provided tx and input idx with
 // a signature script scriptSig and a pubkeyscript scriptPubKey. If bip16 is
 // true then it will be treated as if the bip16 threshhold has passed and thus
 // pay-to-script hash transactions will be fully validated.
+//
+// Pay-to-script-hash is a single level.  The redeem script pushed by scriptSig
+// is executed exactly once after scriptPubKey, and a redeem script which is
+// itself of the pay-to-script-hash form is run as a plain script: it only
+// checks the hash of the item below it on the stack, which is never executed.
+//
+// A ScriptParseError is returned when either script fails to parse, telling
+// which of the two was malformed.
 func NewScript(scriptSig []byte, scriptPubKey []byte, txidx int, tx *btcwire.MsgTx, flags ScriptFlags) (*Script, error) {
+	return NewScriptWithLimits(scriptSig, scriptPubKey, txidx, tx, flags,
+		ScriptLimits{})
+}
+
+// NewScriptWithLimits is the same as NewScript but enforces the passed limits
+// during execution instead of the Bitcoin consensus values.  Exceeding them
+// results in the same errors as exceeding the default limits.
+func NewScriptWithLimits(scriptSig []byte, scriptPubKey []byte, txidx int,
+	tx *btcwire.MsgTx, flags ScriptFlags, limits ScriptLimits) (*Script, error) {
+	return newScript(scriptSig, scriptPubKey, nil, 0, txidx, tx, flags,
+		limits)
+}
+
+// NewScriptWithWitness is the same as NewScript but also takes the witness of
+// the input and the amount of the output it spends, which are used when
+// ScriptVerifyWitness is set.  The witness is the stack of items given for the
+// input, with the last item being the top of the stack.
+func NewScriptWithWitness(scriptSig []byte, scriptPubKey []byte,
+	witness [][]byte, amount int64, txidx int, tx *btcwire.MsgTx,
+	flags ScriptFlags) (*Script, error) {
+	return newScript(scriptSig, scriptPubKey, witness, amount, txidx, tx,
+		flags, ScriptLimits{})
+}
+
+// newScript returns a new script engine as described by NewScript with all of
+// the optional parameters of the other constructors.
+func newScript(scriptSig []byte, scriptPubKey []byte, witness [][]byte,
+	amount int64, txidx int, tx *btcwire.MsgTx, flags ScriptFlags,
+	limits ScriptLimits) (*Script, error) {
 	var m Script
 	scripts := [][]byte{scriptSig, scriptPubKey}
 	m.scripts = make([][]parsedOpcode, len(scripts))
 	for i, scr := range scripts {
+		code := ErrCodeMalformedSigScript
+		if i == 1 {
+			code = ErrCodeMalformedPkScript
+		}
 		if len(scr) > maxScriptSize {
-			return nil, ErrStackLongScript
+			return nil, ScriptParseError{code, ErrStackLongScript}
 		}
 		var err error
 		m.scripts[i], err = parseScript(scr)
 		if err != nil {
-			return nil, err
+			return nil, ScriptParseError{code, err}
 		}
 
 		// If the first scripts(s) are empty, must start on later ones.
@@ -580,10 +914,75 @@ func NewScript(scriptSig []byte, scriptPubKey []byte, txidx int, tx *btcwire.Msg
 	if flags&ScriptStrictMultiSig == ScriptStrictMultiSig {
 		m.strictMultiSig = true
 	}
+	if flags&ScriptVerifyCheckSequenceVerify == ScriptVerifyCheckSequenceVerify {
+		m.verifyCSV = true
+	}
+	if flags&ScriptVerifyDERSignatures == ScriptVerifyDERSignatures {
+		m.verifyDER = true
+	}
+	if flags&ScriptVerifyLowS == ScriptVerifyLowS {
+		m.verifyLowS = true
+	}
+	if flags&ScriptVerifyMinimalData == ScriptVerifyMinimalData {
+		m.minimalData = true
+	}
+	if flags&ScriptVerifyCheckLockTimeVerify == ScriptVerifyCheckLockTimeVerify {
+		m.verifyCLTV = true
+	}
+	if flags&ScriptVerifyDiscourageUpgradableWitnessProgram ==
+		ScriptVerifyDiscourageUpgradableWitnessProgram {
+		m.strictWitness = true
+	}
+	if flags&ScriptVerifyWitness == ScriptVerifyWitness {
+		if isWitnessProgram(m.scripts[1]) {
+			// The witness must provide everything needed to spend
+			// a native witness program.
+			if len(scriptSig) != 0 {
+				return nil, ErrWitnessMalleated
+			}
+			m.witnessProgram = m.scripts[1]
+		} else if m.bip16 && len(m.scripts[0]) != 0 {
+			// A witness program nested in pay-to-script-hash must
+			// be the only thing pushed by the signature script.
+			sigPops := m.scripts[0]
+			redeemScript := sigPops[len(sigPops)-1].data
+			pops, err := parseScript(redeemScript)
+			if err == nil && isWitnessProgram(pops) {
+				if len(sigPops) != 1 || !canonicalPush(sigPops[0]) {
+					return nil, ErrWitnessMalleatedP2SH
+				}
+				m.witnessProgram = pops
+			}
+		}
+		if m.witnessProgram == nil && len(witness) != 0 {
+			return nil, ErrWitnessUnexpected
+		}
+		m.witness = witness
+		m.amount = amount
+	}
+
+	m.maxElementSize = MaxScriptElementSize
+	if limits.MaxElementSize != 0 {
+		m.maxElementSize = limits.MaxElementSize
+	}
+	m.maxOps = MaxOpsPerScript
+	if limits.MaxOps != 0 {
+		m.maxOps = limits.MaxOps
+	}
+	m.budget = limits.Budget
+	m.opcodeCost = limits.OpcodeCost
+	if m.opcodeCost == nil {
+		m.opcodeCost = DefaultOpcodeCost
+	}
+
+	m.stackBufs = stackPool.Get().(*[2][][]byte)
+	m.dstack.stk = m.stackBufs[0]
+	m.astack.stk = m.stackBufs[1]
 
 	m.tx = *tx
 	m.txidx = txidx
 	m.condStack = []int{OpCondTrue}
+	m.sigHashes = &sigHashCache{}
 
 	return &m, nil
 }
@@ -623,6 +1022,137 @@ func (s *Script) Execute() (err error) {
 	return s.CheckErrorCondition()
 }
 
+// Release returns the memory used by the stacks of the script engine to a pool
+// so that it can be reused by script engines created later, which reduces
+// allocations when validating many inputs.  Calling it is optional.  The
+// stacks are emptied first, so no items are visible to later engines, and the
+// engine must not be used again after it has been released.
+func (s *Script) Release() {
+	if s.stackBufs == nil {
+		return
+	}
+	s.stackBufs[0] = clearStack(s.dstack.stk)
+	s.stackBufs[1] = clearStack(s.astack.stk)
+	stackPool.Put(s.stackBufs)
+	s.stackBufs = nil
+	s.dstack.stk = nil
+	s.astack.stk = nil
+}
+
+// Clone returns a copy of the script engine in its current state.  The stacks,
+// condition stack and program counter are copied, so stepping either engine
+// does not affect the other.  The items on the stacks and the parsed scripts
+// are shared since they are never modified in place, as is the transaction
+// being validated.  The stacks of the clone are not taken from the pool used by
+// Release.
+func (s *Script) Clone() *Script {
+	c := *s
+	c.scripts = append([][]parsedOpcode(nil), s.scripts...)
+	c.dstack.stk = append([][]byte(nil), s.dstack.stk...)
+	c.astack.stk = append([][]byte(nil), s.astack.stk...)
+	c.checkedItems = append([][]byte(nil), s.checkedItems...)
+	c.condStack = append([]int(nil), s.condStack...)
+	if s.savedFirstStack != nil {
+		c.savedFirstStack = append([][]byte(nil), s.savedFirstStack...)
+	}
+	if s.sigHashes != nil {
+		c.sigHashes = &sigHashCache{
+			entries: append([]sigHashEntry(nil), s.sigHashes.entries...),
+		}
+	}
+	c.stackBufs = nil
+	return &c
+}
+
+// TraceFunc is the type of function which may be installed on a script engine
+// with SetTraceFunc to observe execution.  It is called before each opcode is
+// executed with the position of the opcode, as would be shown by DisasmPC, its
+// value and the data it pushes, if any, along with the contents of the primary
+// stack as returned by GetStack.  The data and stack are copies, so changing
+// them does not affect the script engine.
+type TraceFunc func(scriptidx, scriptoff int, opcode byte, data []byte,
+	stack [][]byte)
+
+// SetTraceFunc installs fn to be called before each opcode executed by Step,
+// replacing any function installed earlier.  Passing nil stops tracing.
+func (s *Script) SetTraceFunc(fn TraceFunc) {
+	s.trace = fn
+}
+
+// TraceEntry records the execution of a single opcode by VerifyScriptWithTrace.
+type TraceEntry struct {
+	// ScriptIdx and ScriptOff are the index of the script and the offset
+	// of the opcode within it, as given to a TraceFunc.
+	ScriptIdx int
+	ScriptOff int
+
+	// Opcode is the value of the opcode executed and Data is the data it
+	// pushes, if any.
+	Opcode byte
+	Data   []byte
+
+	// StackBefore and StackAfter are the contents of the primary stack
+	// before and after the opcode executed, as returned by GetStack.  At
+	// the end of a pay-to-script-hash script StackAfter is the stack the
+	// redeem script starts with.
+	StackBefore [][]byte
+	StackAfter  [][]byte
+
+	// Err is the error the opcode failed with, if any.
+	Err error
+}
+
+// VerifyScriptWithTrace validates input txIdx of tx with the passed scripts as
+// NewScript and Execute do, and returns a log of every opcode executed along
+// with the result.  It is meant for building reports on failed validations
+// without installing a TraceFunc.  When an opcode fails, it is the last entry
+// of the log and its error is both recorded in the entry and returned.  A
+// failure of the check of the final stack, such as ErrStackScriptFailed, is
+// only returned, as are errors which stop execution before an opcode starts,
+// such as the scripts failing to parse.
+func VerifyScriptWithTrace(sigScript, pkScript []byte, txIdx int,
+	tx *btcwire.MsgTx, flags ScriptFlags) ([]TraceEntry, error) {
+	s, err := NewScript(sigScript, pkScript, txIdx, tx, flags)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Release()
+
+	var entries []TraceEntry
+	s.SetTraceFunc(func(scriptidx, scriptoff int, opcode byte, data []byte,
+		stack [][]byte) {
+		entries = append(entries, TraceEntry{
+			ScriptIdx:   scriptidx,
+			ScriptOff:   scriptoff,
+			Opcode:      opcode,
+			Data:        data,
+			StackBefore: stack,
+		})
+	})
+
+	for done := false; !done; {
+		traced := len(entries)
+		done, err = s.Step()
+		if len(entries) > traced {
+			entry := &entries[len(entries)-1]
+			entry.StackAfter = copyStack(s.GetStack())
+			entry.Err = err
+		}
+		if err != nil {
+			return entries, err
+		}
+	}
+	return entries, s.CheckErrorCondition()
+}
+
+// SetSigCache installs a cache of valid signatures on the engine, replacing
+// any installed earlier.  Signatures found in the cache are not verified again
+// and those found to be valid are added to it.  Passing nil verifies every
+// signature, which is the default.
+func (s *Script) SetSigCache(c *SigCache) {
+	s.sigCache = c
+}
+
 // CheckErrorCondition returns nil if the running script has ended and was
 // successful, leaving a a true boolean on the stack. An error otherwise,
 // including if the script has not finished.
@@ -635,6 +1165,12 @@ func (s *Script) CheckErrorCondition() (err error) {
 	if s.dstack.Depth() < 1 {
 		return ErrStackEmptyStack
 	}
+	if s.witnessExec && s.dstack.Depth() != 1 {
+		return ErrWitnessCleanStack
+	}
+	// PeekByteArray can't fail, the stack was checked to not be empty.
+	top, _ := s.dstack.PeekByteArray(0)
+	s.checkedItems = append(s.checkedItems, top)
 	v, err := s.dstack.PopBool()
 	if err == nil && v == false {
 		// log interesting data.
@@ -655,6 +1191,51 @@ func (s *Script) CheckErrorCondition() (err error) {
 // if an error is returned then the result of calling Step or any other method
 // is undefined.
 func (s *Script) Step() (done bool, err error) {
+	return s.step(nil)
+}
+
+// StepResult describes the execution of a single opcode by StepDetailed.
+type StepResult struct {
+	// ScriptIdx and ScriptOff are the index of the script and the offset
+	// of the opcode within it, as given to a TraceFunc.
+	ScriptIdx int
+	ScriptOff int
+
+	// Opcode is the value of the opcode executed and Data is the data it
+	// pushes, if any.
+	Opcode byte
+	Data   []byte
+
+	// Popped and Pushed are the items removed from and added to the top
+	// of the data stack by the opcode, with the top item last.  They are
+	// found by comparing the stack before and after the opcode, so items
+	// which are removed and added back unchanged are not included.
+	Popped [][]byte
+	Pushed [][]byte
+
+	// Done is true if the opcode was the last of the last script.
+	Done bool
+}
+
+// StepDetailed is the same as Step except that it returns a description of the
+// opcode executed and how it changed the data stack, which is useful for
+// debuggers.  A nil result is returned along with any error.  The data stack is
+// compared before it is replaced at the end of a pay-to-script-hash or witness
+// program script, so the replacement is not reported as pushes and pops.
+func (s *Script) StepDetailed() (*StepResult, error) {
+	result := &StepResult{}
+	done, err := s.step(result)
+	if err != nil {
+		return nil, err
+	}
+	result.Done = done
+	return result, nil
+}
+
+// step executes the next opcode as described by Step.  When result is not nil,
+// it is filled in with the opcode and the changes it made to the data stack,
+// apart from Done.
+func (s *Script) step(result *StepResult) (done bool, err error) {
 	// verify that it is pointing to a valid script address
 	err = s.validPC()
 	if err != nil {
@@ -662,11 +1243,42 @@ func (s *Script) Step() (done bool, err error) {
 	}
 	opcode := s.scripts[s.scriptidx][s.scriptoff]
 
+	if s.budget != 0 {
+		s.cost += s.opcodeCost(opcode.opcode.value)
+		if s.cost > s.budget {
+			return true, ErrBudgetExceeded
+		}
+	}
+
+	if s.trace != nil {
+		var data []byte
+		if opcode.data != nil {
+			data = append([]byte{}, opcode.data...)
+		}
+		s.trace(s.scriptidx, s.scriptoff, opcode.opcode.value, data,
+			copyStack(s.GetStack()))
+	}
+
+	var before [][]byte
+	if result != nil {
+		result.ScriptIdx = s.scriptidx
+		result.ScriptOff = s.scriptoff
+		result.Opcode = opcode.opcode.value
+		if opcode.data != nil {
+			result.Data = append([]byte{}, opcode.data...)
+		}
+		before = s.GetStack()
+	}
+
 	err = opcode.exec(s)
 	if err != nil {
 		return true, err
 	}
 
+	if result != nil {
+		result.Popped, result.Pushed = stackDelta(before, s.GetStack())
+	}
+
 	if s.dstack.Depth()+s.astack.Depth() > maxStackSize {
 		return false, ErrStackOverflow
 	}
@@ -692,10 +1304,15 @@ func (s *Script) Step() (done bool, err error) {
 			s.scriptidx++
 			// We check script ran ok, if so then we pull
 			// the script out of the first stack and executre that.
+			// It runs as the last script, so even a redeem script
+			// which looks like pay-to-script-hash adds no layer.
 			err := s.CheckErrorCondition()
 			if err != nil {
 				return false, err
 			}
+			// The stack is replaced, so the item checked is no
+			// longer part of the final stack.
+			s.checkedItems = nil
 
 			script := s.savedFirstStack[len(s.savedFirstStack)-1]
 			pops, err := parseScript(script)
@@ -709,6 +1326,20 @@ func (s *Script) Step() (done bool, err error) {
 		} else {
 			s.scriptidx++
 		}
+		// Once the script pushing the witness program has run, the
+		// witness script follows it.
+		if s.witnessProgram != nil && !s.witnessExec &&
+			s.scriptidx >= len(s.scripts) {
+			err := s.CheckErrorCondition()
+			if err != nil {
+				return false, err
+			}
+			s.checkedItems = nil
+			err = s.startWitness()
+			if err != nil {
+				return false, err
+			}
+		}
 		// there are zero length scripts in the wild
 		if s.scriptidx < len(s.scripts) && s.scriptoff >= len(s.scripts[s.scriptidx]) {
 			s.scriptidx++
@@ -735,10 +1366,15 @@ func (s *Script) curPC() (script int, off int, err error) {
 // execution, nil otherwise.
 func (s *Script) validPC() error {
 	if s.scriptidx >= len(s.scripts) {
-		return fmt.Errorf("Past input scripts %v:%v %v:xxxx", s.scriptidx, s.scriptoff, len(s.scripts))
+		return scriptError(ErrCodeInvalidProgramCounter,
+			fmt.Sprintf("Past input scripts %v:%v %v:xxxx",
+				s.scriptidx, s.scriptoff, len(s.scripts)))
 	}
 	if s.scriptoff >= len(s.scripts[s.scriptidx]) {
-		return fmt.Errorf("Past input scripts %v:%v %v:%04d", s.scriptidx, s.scriptoff, s.scriptidx, len(s.scripts[s.scriptidx]))
+		return scriptError(ErrCodeInvalidProgramCounter,
+			fmt.Sprintf("Past input scripts %v:%v %v:%04d",
+				s.scriptidx, s.scriptoff, s.scriptidx,
+				len(s.scripts[s.scriptidx])))
 	}
 	return nil
 }
@@ -765,6 +1401,28 @@ func (s *Script) DisasmPC() (disstr string, err error) {
 	return s.disasm(scriptidx, scriptoff), nil
 }
 
+// DisasmWithPC returns the disassembly of every script loaded into the engine,
+// one opcode per line as for DisasmScript, with the opcode that will be next to
+// execute when Step() is called marked by a leading ">>".  Other lines are
+// indented to match.  Once execution has moved past the end of a script
+// without a following opcode, a final ">> end" line marks the program counter.
+func (s *Script) DisasmWithPC() string {
+	var disstr string
+	for idx := range s.scripts {
+		for off := range s.scripts[idx] {
+			marker := "   "
+			if idx == s.scriptidx && off == s.scriptoff {
+				marker = ">> "
+			}
+			disstr += marker + s.disasm(idx, off) + "\n"
+		}
+	}
+	if s.validPC() != nil {
+		disstr += ">> end\n"
+	}
+	return disstr
+}
+
 // disasm is a helper member to produce the output for DisasmPC and
 // DisasmScript. It produces the opcode prefixed by the program counter at the
 // provided position in the script. it does no error checking and leaves that
@@ -808,11 +1466,79 @@ func removeOpcodeByData(pkscript []parsedOpcode, data []byte) []parsedOpcode {
 // script fails to parse, the returned string will contain the disassembled
 // script up to the point the failure occurred along with the string '[error]'
 // appended.  In addition, the reason the script failed to parse is returned
-// if the caller wants more information about the failure.
+// if the caller wants more information about the failure.  The operation of a
+// name script is shown by its mnemonic, such as OP_NAME_UPDATE, rather than by
+// the small integer opcode it shares a value with.
 func DisasmString(buf []byte) (string, error) {
+	return disasmString(buf, false)
+}
+
+// DisasmStringVerbose is the same as DisasmString except that data pushes are
+// shown with the name of the opcode before the data and pushes of one to eight
+// bytes are followed by the number they encode in parentheses, for example
+// "OP_DATA_3 80bb01 (113536)".  The numbers are interpreted as little endian
+// with a sign bit as for the numeric opcodes, which makes lock times and key
+// counts easy to read.
+func DisasmStringVerbose(buf []byte) (string, error) {
+	return disasmString(buf, true)
+}
+
+// DisasmInput returns the disassembly of the spend of an output, with the
+// signature script and the public key script each on a line of their own after
+// "sigScript: " and "pkScript: " respectively.  When the public key script is
+// pay-to-script-hash, the redeem script pushed last by the signature script
+// follows on a "redeemScript: " line.  Scripts are disassembled as by
+// DisasmString, so those which fail to parse end with "[error]", and the
+// redeem script is shown as "[missing]" when the signature script does not
+// parse, is not push only or pushes nothing.
+func DisasmInput(sigScript, pkScript []byte) string {
+	sigDis, _ := DisasmString(sigScript)
+	pkDis, _ := DisasmString(pkScript)
+	disbuf := "sigScript: " + sigDis + "\npkScript: " + pkDis + "\n"
+	if !IsPayToScriptHash(pkScript) {
+		return disbuf
+	}
+
+	redeemDis := "[missing]"
+	pops, err := parseScript(sigScript)
+	if err == nil && len(pops) != 0 && isPushOnly(pops) {
+		// OP_RESERVED counts as a push but does not push anything.
+		redeemScript, ok := pushedValue(pops[len(pops)-1])
+		if ok {
+			redeemDis, _ = DisasmString(redeemScript)
+		}
+	}
+	return disbuf + "redeemScript: " + redeemDis + "\n"
+}
+
+// disasmString disassembles the passed script for DisasmString and, when
+// verbose is true, DisasmStringVerbose.
+func disasmString(buf []byte, verbose bool) (string, error) {
 	disbuf := ""
 	opcodes, err := parseScript(buf)
-	for _, pop := range opcodes {
+	isName := false
+	if err == nil {
+		_, isName = stripNamePrefix(opcodes)
+	}
+	for i, pop := range opcodes {
+		if i == 0 && isName {
+			disbuf += nameOpcodeNames[pop.opcode.value] + " "
+			continue
+		}
+		if verbose && pop.opcode.length != 1 {
+			disbuf += pop.opcode.name
+			if len(pop.data) != 0 {
+				disbuf += " " + pop.print(true)
+			}
+			if len(pop.data) >= 1 && len(pop.data) <= 8 {
+				// asIntN can't fail with data of at most
+				// the maximum length.
+				num, _ := asIntN(pop.data, 8, false)
+				disbuf += " (" + num.String() + ")"
+			}
+			disbuf += " "
+			continue
+		}
 		disbuf += pop.print(true) + " "
 	}
 	if disbuf != "" {
@@ -824,6 +1550,138 @@ func DisasmString(buf []byte) (string, error) {
 	return disbuf, err
 }
 
+// CalcSignatureHash returns the hash of tx that a signature of the given
+// hashType must sign for input idx to redeem script.  Any OP_CODESEPARATOR
+// opcodes are removed from script before hashing.  As in the reference
+// implementation, a SigHashSingle hash for an input with no matching output
+// is the value one as a little endian uint256 rather than an error.  An
+// ErrStackInvalidIndex is returned if idx is not an input of tx.
+func CalcSignatureHash(script []byte, hashType SigHashType, tx *btcwire.MsgTx,
+	idx int) ([]byte, error) {
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return nil, ErrStackInvalidIndex
+	}
+	pops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+	return calcScriptHash(pops, hashType, tx, idx), nil
+}
+
+// ErrNoSignatureCheck is returned by DebugSignatureHash when the scripts finish
+// without executing an opcode which checks a signature.
+var ErrNoSignatureCheck = errors.New("scripts do not check a signature")
+
+// DebugSignatureHash executes sigScript and pkScript for input idx of tx up to
+// the first OP_CHECKSIG, OP_CHECKSIGVERIFY, OP_CHECKMULTISIG or
+// OP_CHECKMULTISIGVERIFY which would be executed, and returns the hash which a
+// signature of the passed hashType must sign there along with the subscript
+// which was hashed.  It is meant for debugging signatures which fail to verify,
+// so the signatures on the stack need not be valid and the hash type is given
+// rather than taken from them.  Pay-to-script-hash redeem scripts are executed,
+// while witness programs are not.
+//
+// As when the opcode executes, the subscript starts after the last executed
+// OP_CODESEPARATOR and has any pushes of the signatures on the stack removed,
+// as well as every OP_CODESEPARATOR.  Like the opcodes, OP_CHECKSIG and
+// OP_CHECKSIGVERIFY match the signature without its hash type, while the
+// multisig opcodes match each whole signature.  Passing the subscript to
+// CalcSignatureHash gives the same hash.  ErrNoSignatureCheck is returned if
+// the scripts finish without reaching such an opcode, and any error of the
+// scripts before it is returned as it happens.
+func DebugSignatureHash(sigScript, pkScript []byte, tx *btcwire.MsgTx, idx int,
+	hashType SigHashType) ([]byte, []byte, error) {
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return nil, nil, ErrStackInvalidIndex
+	}
+	s, err := NewScript(sigScript, pkScript, idx, tx, ScriptBip16)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.Release()
+
+	for {
+		if s.validPC() != nil {
+			return nil, nil, ErrNoSignatureCheck
+		}
+		pop := &s.scripts[s.scriptidx][s.scriptoff]
+		if s.condStack[0] == OpCondTrue && isSigCheck(pop.opcode.value) {
+			break
+		}
+		done, err := s.Step()
+		if err != nil {
+			return nil, nil, err
+		}
+		if done {
+			return nil, nil, ErrNoSignatureCheck
+		}
+	}
+
+	subScript := s.subScript()
+	op := s.scripts[s.scriptidx][s.scriptoff].opcode.value
+	for _, sig := range s.checkedSignatures() {
+		if op == OP_CHECKSIG || op == OP_CHECKSIGVERIFY {
+			if len(sig) == 0 {
+				continue
+			}
+			sig = sig[:len(sig)-1]
+		}
+		subScript = removeOpcodeByData(subScript, sig)
+	}
+	subScript = removeOpcode(subScript, OP_CODESEPARATOR)
+	script, err := unparseScript(subScript)
+	if err != nil {
+		return nil, nil, err
+	}
+	return calcScriptHash(subScript, hashType, &s.tx, idx), script, nil
+}
+
+// isSigCheck returns whether the opcode is one of those which check signatures.
+func isSigCheck(opcode byte) bool {
+	switch opcode {
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY, OP_CHECKMULTISIG,
+		OP_CHECKMULTISIGVERIFY:
+		return true
+	}
+	return false
+}
+
+// checkedSignatures returns the signatures, including their hash types, which
+// the signature checking opcode about to be executed would check.  Items which
+// are missing from the stack are left out.
+func (s *Script) checkedSignatures() [][]byte {
+	pop := s.scripts[s.scriptidx][s.scriptoff]
+	if pop.opcode.value == OP_CHECKSIG ||
+		pop.opcode.value == OP_CHECKSIGVERIFY {
+		sig, err := s.dstack.PeekByteArray(1)
+		if err != nil {
+			return nil
+		}
+		return [][]byte{sig}
+	}
+
+	// The stack holds the number of public keys on top, followed by the
+	// keys, the number of signatures and the signatures.
+	npk, err := s.dstack.PeekInt(0)
+	if err != nil || npk.Sign() < 0 || npk.Int64() > MaxPubKeysPerMultiSig {
+		return nil
+	}
+	nsigIdx := int(npk.Int64()) + 1
+	nsig, err := s.dstack.PeekInt(nsigIdx)
+	if err != nil || nsig.Sign() < 0 || nsig.Cmp(npk) > 0 {
+		return nil
+	}
+	var sigs [][]byte
+	for i := 1; i <= int(nsig.Int64()); i++ {
+		sig, err := s.dstack.PeekByteArray(nsigIdx + i)
+		if err != nil {
+			break
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
 // calcScriptHash will, given the a script and hashtype for the current
 // scriptmachine, calculate the doubleSha256 hash of the transaction and
 // script to be used for signature signing and verification.
@@ -921,6 +1779,31 @@ func getStack(stack *Stack) [][]byte {
 	return array
 }
 
+// copyStack returns a copy of the passed stack contents, including the items,
+// so that the copy can be modified without changing the original.
+func copyStack(data [][]byte) [][]byte {
+	array := make([][]byte, len(data))
+	for i, item := range data {
+		if item != nil {
+			array[i] = append([]byte{}, item...)
+		}
+	}
+	return array
+}
+
+// stackDelta returns copies of the items which were on top of the before stack
+// but not the after stack, and of those which are on top of the after stack
+// but were not on the before stack.  The stacks are compared from the bottom
+// and the items below the first which differs are taken to be unchanged.
+func stackDelta(before, after [][]byte) (popped, pushed [][]byte) {
+	same := 0
+	for same < len(before) && same < len(after) &&
+		bytes.Equal(before[same], after[same]) {
+		same++
+	}
+	return copyStack(before[same:]), copyStack(after[same:])
+}
+
 // setStack sets the stack to the contents of the array where the last item in
 // the array is the top item in the stack.
 func setStack(stack *Stack, data [][]byte) {
@@ -956,6 +1839,57 @@ func (s *Script) SetAltStack(data [][]byte) {
 	setStack(&s.astack, data)
 }
 
+// FinalStack returns a copy of the contents of the primary stack as left when
+// execution stopped, where the last item in the array is the top of the stack.
+// After Execute finishes running the scripts this is the stack checked by
+// CheckErrorCondition, including the item it pops, whether or not the scripts
+// succeeded.  After Execute or Step fails part way it is the stack as left by
+// the opcode which failed.  The items are copied too, so the result may be
+// kept and changed without affecting the script engine.  It must be called
+// before Release.
+func (s *Script) FinalStack() [][]byte {
+	stack := s.GetStack()
+	for i := len(s.checkedItems) - 1; i >= 0; i-- {
+		stack = append(stack, s.checkedItems[i])
+	}
+	return copyStack(stack)
+}
+
+// OpsRemaining returns how many more non-push operations the currently
+// executing script may perform before failing with ErrStackTooManyOperations.
+// The limit is MaxOpsPerScript unless another is given in ScriptLimits, and it
+// applies to each script separately, so once a script has finished the whole
+// limit is left for the next one.  As enforced by the engine, opcodes up to
+// OP_16, including OP_RESERVED, do not count, every other opcode counts whether
+// or not its branch is executed, and OP_CHECKMULTISIG and
+// OP_CHECKMULTISIGVERIFY also count each public key they check.  Zero is
+// returned once the limit has been exceeded.
+func (s *Script) OpsRemaining() int {
+	if s.numOps > s.maxOps {
+		return 0
+	}
+	return s.maxOps - s.numOps
+}
+
+// CondStackDepth returns the number of conditional blocks started by OP_IF or
+// OP_NOTIF which the program counter is currently inside.
+func (s *Script) CondStackDepth() int {
+	return len(s.condStack) - 1
+}
+
+// CondStack returns the state of each conditional block the program counter is
+// currently inside, where the last item in the array is the innermost block.
+// Each state is OpCondTrue if the current branch of the block is executing,
+// OpCondFalse if it is not, and OpCondSkip if the whole block is being skipped
+// because an enclosing branch is not executing.
+func (s *Script) CondStack() []int {
+	array := make([]int, len(s.condStack)-1)
+	for i := range array {
+		array[len(array)-i-1] = s.condStack[i]
+	}
+	return array
+}
+
 // GetSigOpCount provides a quick count of the number of signature operations
 // in a script. a CHECKSIG operations counts for 1, and a CHECK_MULTISIG for 20.
 // If the script fails to parse, then the count up to the point of failure is
@@ -972,11 +1906,26 @@ func GetSigOpCount(script []byte) int {
 // scriptPubKey. If bip16 is true then scriptSig may be searched for the
 // Pay-To-Script-Hash script in order to find the precise number of signature
 // operations in the transaction. If the script fails to parse, then the
-// count up to the point of failure is returned.
+// count up to the point of failure is returned.  A name script is counted by
+// its address script, so a name output paying to a script hash is counted by
+// its redeem script as for an ordinary P2SH output.
+//
+// This count does not match what the script engine executes for such name
+// outputs.  The engine only recognises a pay-to-script-hash output without a
+// name prefix, so it never executes the redeem script given for a name output
+// paying to a script hash.  The count should therefore not be relied on for
+// consensus until the engine treats these outputs as P2SH too.
 func GetPreciseSigOpCount(scriptSig, scriptPubKey []byte, bip16 bool) int {
 	// We don't check error since parseScript returns the parsed-up-to-error
 	// list of pops.
 	pops, _ := parseScript(scriptPubKey)
+
+	// The name prefix contains no sigops, so only the address script
+	// following it matters.
+	if base, ok := stripNamePrefix(pops); ok {
+		pops = base
+	}
+
 	// non P2SH transactions just treated as normal.
 	if !(bip16 && isScriptHash(pops)) {
 		return getSigOpCount(pops, true)
@@ -1041,7 +1990,7 @@ func getSigOpCount(pops []parsedOpcode, precise bool) int {
 // payToPubKeyHashScript creates a new script to pay a transaction
 // output to a 20-byte pubkey hash. It is expected that the input is a valid
 // hash.
-func payToPubKeyHashScript(pubKeyHash []byte) []byte {
+func payToPubKeyHashScript(pubKeyHash []byte) ([]byte, error) {
 	return NewScriptBuilder().AddOp(OP_DUP).AddOp(OP_HASH160).
 		AddData(pubKeyHash).AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).
 		Script()
@@ -1049,14 +1998,14 @@ func payToPubKeyHashScript(pubKeyHash []byte) []byte {
 
 // payToScriptHashScript creates a new script to pay a transaction output to a
 // script hash. It is expected that the input is a valid hash.
-func payToScriptHashScript(scriptHash []byte) []byte {
+func payToScriptHashScript(scriptHash []byte) ([]byte, error) {
 	return NewScriptBuilder().AddOp(OP_HASH160).AddData(scriptHash).
 		AddOp(OP_EQUAL).Script()
 }
 
 // payToPubkeyScript creates a new script to pay a transaction output to a
 // public key. It is expected that the input is a valid pubkey.
-func payToPubKeyScript(serializedPubKey []byte) []byte {
+func payToPubKeyScript(serializedPubKey []byte) ([]byte, error) {
 	return NewScriptBuilder().AddData(serializedPubKey).
 		AddOp(OP_CHECKSIG).Script()
 }
@@ -1069,45 +2018,81 @@ func PayToAddrScript(addr btcutil.Address) ([]byte, error) {
 		if addr == nil {
 			return nil, ErrUnsupportedAddress
 		}
-		return payToPubKeyHashScript(addr.ScriptAddress()), nil
+		return payToPubKeyHashScript(addr.ScriptAddress())
 
 	case *btcutil.AddressScriptHash:
 		if addr == nil {
 			return nil, ErrUnsupportedAddress
 		}
-		return payToScriptHashScript(addr.ScriptAddress()), nil
+		return payToScriptHashScript(addr.ScriptAddress())
 
 	case *btcutil.AddressPubKey:
 		if addr == nil {
 			return nil, ErrUnsupportedAddress
 		}
-		return payToPubKeyScript(addr.ScriptAddress()), nil
+		return payToPubKeyScript(addr.ScriptAddress())
 	}
 
 	return nil, ErrUnsupportedAddress
 }
 
+// ErrRedeemScriptTooBig is returned from PayToScriptHashScript and
+// ScriptHashAddr when the redeem script is larger than MaxScriptElementSize and
+// so could never be pushed by a signature script spending the output.
+var ErrRedeemScriptTooBig = errors.New("redeem script is too large to be " +
+	"spent through pay-to-script-hash")
+
+// PayToScriptHashScript creates a new script to pay a transaction output to
+// the hash of the passed redeem script.  An ErrRedeemScriptTooBig is returned
+// if the redeem script is larger than MaxScriptElementSize.
+func PayToScriptHashScript(redeemScript []byte) ([]byte, error) {
+	if len(redeemScript) > MaxScriptElementSize {
+		return nil, ErrRedeemScriptTooBig
+	}
+	return payToScriptHashScript(calcHash160(redeemScript))
+}
+
+// ScriptHashAddr returns the pay-to-script-hash address for the passed redeem
+// script on the given network.  An ErrRedeemScriptTooBig is returned if the
+// redeem script is larger than MaxScriptElementSize.
+func ScriptHashAddr(redeemScript []byte, net *btcnet.Params) (btcutil.Address, error) {
+	if len(redeemScript) > MaxScriptElementSize {
+		return nil, ErrRedeemScriptTooBig
+	}
+	return btcutil.NewAddressScriptHash(redeemScript, net)
+}
+
 // ErrBadNumRequired is returned from MultiSigScript when nrequired is larger
-// than the number of provided public keys.
+// than the number of provided public keys or is less than one.
 var ErrBadNumRequired = errors.New("more signatures required than keys present")
 
+// ErrTooManyPubKeys is returned from MultiSigScript when more than
+// MaxPubKeysPerMultiSig public keys are provided.
+var ErrTooManyPubKeys = errors.New("more public keys than allowed in a multisig script")
+
 // MultiSigScript returns a valid script for a multisignature redemption where
-// nrequired of the keys in pubkeys are required to have signed the transaction
-// for success. An ErrBadNumRequired will be returned if nrequired is larger than
-// the number of keys provided.
+// nrequired of the keys in pubkeys are required to have signed the
+// transaction for success. An ErrBadNumRequired will be returned if nrequired
+// is larger than the number of keys provided or is less than one, and
+// ErrTooManyPubKeys if more than MaxPubKeysPerMultiSig keys are provided.
+// Each key is pushed in its compressed serialization, whatever the format of
+// its address.
 func MultiSigScript(pubkeys []*btcutil.AddressPubKey, nrequired int) ([]byte, error) {
-	if len(pubkeys) < nrequired {
+	if len(pubkeys) < nrequired || nrequired < 1 {
 		return nil, ErrBadNumRequired
 	}
+	if len(pubkeys) > MaxPubKeysPerMultiSig {
+		return nil, ErrTooManyPubKeys
+	}
 
 	builder := NewScriptBuilder().AddInt64(int64(nrequired))
 	for _, key := range pubkeys {
-		builder.AddData(key.ScriptAddress())
+		builder.AddData(key.PubKey().SerializeCompressed())
 	}
 	builder.AddInt64(int64(len(pubkeys)))
 	builder.AddOp(OP_CHECKMULTISIG)
 
-	return builder.Script(), nil
+	return builder.Script()
 }
 
 // SignatureScript creates an input signature script for tx to spend
@@ -1133,7 +2118,29 @@ func SignatureScript(tx *btcwire.MsgTx, idx int, subscript []byte, hashType SigH
 		pkData = pk.SerializeUncompressed()
 	}
 
-	return NewScriptBuilder().AddData(sig).AddData(pkData).Script(), nil
+	return NewScriptBuilder().AddData(sig).AddData(pkData).Script()
+}
+
+// P2SHSignatureScript returns a signature script spending a pay-to-script-hash
+// output with the passed redeem script.  sigScript is the signature script
+// which satisfies the redeem script, such as one returned by SignatureScript
+// with the redeem script as the subscript, and the redeem script is pushed
+// after it as the final item.  An error is returned if sigScript does not
+// parse or does not only push data, since the whole signature script of a
+// pay-to-script-hash spend must be push only, or if the redeem script is too
+// large to be pushed.
+func P2SHSignatureScript(sigScript, redeemScript []byte) ([]byte, error) {
+	pops, err := parseScript(sigScript)
+	if err != nil {
+		return nil, err
+	}
+	if !isPushOnly(pops) {
+		return nil, ErrStackP2SHNonPushOnly
+	}
+
+	builder := NewScriptBuilder()
+	builder.script = append(builder.script, sigScript...)
+	return builder.AddData(redeemScript).Script()
 }
 
 func signTxOutput(tx *btcwire.MsgTx, idx int, subScript []byte,
@@ -1157,15 +2164,16 @@ func p2pkSignatureScript(tx *btcwire.MsgTx, idx int, subScript []byte, hashType
 		return nil, err
 	}
 
-	return NewScriptBuilder().AddData(sig).Script(), nil
+	return NewScriptBuilder().AddData(sig).Script()
 }
 
 // signMultiSig signs as many of the outputs in the provided multisig script as
 // possible. It returns the generated script and a boolean if the script fulfils
 // the contract (i.e. nrequired signatures are provided).  Since it is arguably
-// legal to not be able to sign any of the outputs, no error is returned.
+// legal to not be able to sign any of the outputs, no error is returned for
+// keys which are missing.  Any error building the script is returned.
 func signMultiSig(tx *btcwire.MsgTx, idx int, subScript []byte, hashType SigHashType,
-	addresses []btcutil.Address, nRequired int, kdb KeyDB) ([]byte, bool) {
+	addresses []btcutil.Address, nRequired int, kdb KeyDB) ([]byte, bool, error) {
 	// We start with a single OP_FALSE to work around the (now standard)
 	// but in the reference implementation that causes a spurious pop at
 	// the end of OP_CHECKMULTISIG.
@@ -1189,7 +2197,11 @@ func signMultiSig(tx *btcwire.MsgTx, idx int, subScript []byte, hashType SigHash
 
 	}
 
-	return builder.Script(), signed == nRequired
+	script, err := builder.Script()
+	if err != nil {
+		return nil, false, err
+	}
+	return script, signed == nRequired, nil
 }
 
 func sign(net *btcnet.Params, tx *btcwire.MsgTx, idx int, subScript []byte,
@@ -1238,8 +2250,11 @@ func sign(net *btcnet.Params, tx *btcwire.MsgTx, idx int, subScript []byte,
 
 		return script, class, addresses, nrequired, nil
 	case MultiSigTy:
-		script, _ := signMultiSig(tx, idx, subScript, hashType,
+		script, _, err := signMultiSig(tx, idx, subScript, hashType,
 			addresses, nrequired, kdb)
+		if err != nil {
+			return nil, class, nil, 0, err
+		}
 		return script, class, addresses, nrequired, nil
 	case NullDataTy:
 		return nil, class, nil, 0,
@@ -1253,12 +2268,13 @@ func sign(net *btcnet.Params, tx *btcwire.MsgTx, idx int, subScript []byte,
 // mergeScripts merges sigScript and prevScript assuming they are both
 // partial solutions for pkScript spending output idx of tx. class, addresses
 // and nrequired are the result of extracting the addresses from pkscript.
-// The return value is the best effort merging of the two scripts. Calling this
-// function with addresses, class and nrequired that do not match pkScript is
-// an error and results in undefined behaviour.
+// The return value is the best effort merging of the two scripts, or an error
+// if the merged script could not be built. Calling this function with
+// addresses, class and nrequired that do not match pkScript is an error and
+// results in undefined behaviour.
 func mergeScripts(net *btcnet.Params, tx *btcwire.MsgTx, idx int,
 	pkScript []byte, class ScriptClass, addresses []btcutil.Address,
-	nRequired int, sigScript, prevScript []byte) []byte {
+	nRequired int, sigScript, prevScript []byte) ([]byte, error) {
 
 	// TODO(oga) the scripthash and multisig paths here are overly
 	// inefficient in that they will recompute already known data.
@@ -1270,11 +2286,11 @@ func mergeScripts(net *btcnet.Params, tx *btcwire.MsgTx, idx int,
 		// this could be a lot less inefficient.
 		sigPops, err := parseScript(sigScript)
 		if err != nil || len(sigPops) == 0 {
-			return prevScript
+			return prevScript, nil
 		}
 		prevPops, err := parseScript(prevScript)
 		if err != nil || len(prevPops) == 0 {
-			return sigScript
+			return sigScript, nil
 		}
 
 		// assume that script in sigPops is the correct one, we just
@@ -1284,14 +2300,20 @@ func mergeScripts(net *btcnet.Params, tx *btcwire.MsgTx, idx int,
 		// We already know this information somewhere up the stack.
 		class, addresses, nrequired, err :=
 			ExtractPkScriptAddrs(script, net)
+		if err != nil {
+			return sigScript, nil
+		}
 
-		// regenerate scripts.
-		sigScript, _ := unparseScript(sigPops)
-		prevScript, _ := unparseScript(prevPops)
+		// regenerate scripts without the redeem script.
+		sigScript, _ := unparseScript(sigPops[:len(sigPops)-1])
+		prevScript, _ := unparseScript(prevPops[:len(prevPops)-1])
 
 		// Merge
-		mergedScript := mergeScripts(net, tx, idx, script, class,
+		mergedScript, err := mergeScripts(net, tx, idx, script, class,
 			addresses, nrequired, sigScript, prevScript)
+		if err != nil {
+			return nil, err
+		}
 
 		// Reappend the script and return the result.
 		builder := NewScriptBuilder()
@@ -1310,9 +2332,9 @@ func mergeScripts(net *btcnet.Params, tx *btcwire.MsgTx, idx int,
 	// correct (this matches behaviour of the reference implementation).
 	default:
 		if len(sigScript) > len(prevScript) {
-			return sigScript
+			return sigScript, nil
 		}
-		return prevScript
+		return prevScript, nil
 	}
 }
 
@@ -1321,9 +2343,10 @@ func mergeScripts(net *btcnet.Params, tx *btcwire.MsgTx, idx int,
 // and nRequired should be the results from extracting the addresses from
 // pkScript. Since this function is internal only we assume that the arguments
 // have come from other functions internally and thus are all consistent with
-// each other, behaviour is undefined if this contract is broken.
+// each other, behaviour is undefined if this contract is broken. Any error
+// building the merged script is returned.
 func mergeMultiSig(tx *btcwire.MsgTx, idx int, addresses []btcutil.Address,
-	nRequired int, pkScript, sigScript, prevScript []byte) []byte {
+	nRequired int, pkScript, sigScript, prevScript []byte) ([]byte, error) {
 
 	// This is an internal only function and we already parsed this script
 	// as ok for multisig (this is how we got here), so if this fails then
@@ -1332,12 +2355,12 @@ func mergeMultiSig(tx *btcwire.MsgTx, idx int, addresses []btcutil.Address,
 
 	sigPops, err := parseScript(sigScript)
 	if err != nil || len(sigPops) == 0 {
-		return prevScript
+		return prevScript, nil
 	}
 
 	prevPops, err := parseScript(prevScript)
 	if err != nil || len(prevPops) == 0 {
-		return sigScript
+		return sigScript, nil
 	}
 
 	// Convenience function to avoid duplication.
@@ -1430,6 +2453,35 @@ sigLoop:
 	return builder.Script()
 }
 
+// CombineSigs merges two signature scripts, sigScript1 and sigScript2, which
+// are both partial solutions for pkScript spending input idx of tx, and
+// returns the more complete result.  class must be the class of pkScript.
+// Multisig signatures, including those of a multisig redeem script behind a
+// pay-to-script-hash output, are combined by keeping every signature which
+// verifies up to the number required.  For all other script classes merging
+// is undefined and sigScript1 is returned unchanged, as it is when the merged
+// script can not be built because one of its pushes is larger than
+// MaxScriptElementSize.
+func CombineSigs(net *btcnet.Params, tx *btcwire.MsgTx, idx int,
+	pkScript []byte, class ScriptClass, sigScript1, sigScript2 []byte) []byte {
+
+	if class != ScriptHashTy && class != MultiSigTy {
+		return sigScript1
+	}
+
+	pkClass, addresses, nrequired, err := ExtractPkScriptAddrs(pkScript, net)
+	if err != nil || pkClass != class {
+		return sigScript1
+	}
+
+	merged, err := mergeScripts(net, tx, idx, pkScript, class, addresses,
+		nrequired, sigScript1, sigScript2)
+	if err != nil {
+		return sigScript1
+	}
+	return merged
+}
+
 // KeyDB is an interface type provided to SignTxOutput, it encapsulates
 // any user state required to get the private keys for an address.
 type KeyDB interface {
@@ -1465,7 +2517,12 @@ func (sc ScriptClosure) GetScript(address btcutil.Address) ([]byte, error) {
 // Any pay-to-script-hash signatures will be similarly looked up by calling
 // getScript. If previousScript is provided then the results in previousScript
 // will be merged in a type-dependant manner with the newly generated.
-// signature script.
+// signature script.  For multisig outputs, including those redeemed through
+// pay-to-script-hash, this allows each key holder to sign in turn: the
+// signatures from both scripts which verify are placed in the order of the
+// public keys in the script and a public key is only given one signature.
+// An error is returned if the signature script can not be built, such as when
+// a redeem script is larger than MaxScriptElementSize and so can not be pushed.
 func SignTxOutput(net *btcnet.Params, tx *btcwire.MsgTx, idx int,
 	pkScript []byte, hashType SigHashType, kdb KeyDB, sdb ScriptDB,
 	previousScript []byte) ([]byte, error) {
@@ -1490,13 +2547,16 @@ func SignTxOutput(net *btcnet.Params, tx *btcwire.MsgTx, idx int,
 		builder.script = realSigScript
 		builder.AddData(sigScript)
 
-		sigScript = builder.Script()
+		sigScript, err = builder.Script()
+		if err != nil {
+			return nil, err
+		}
 		// TODO keep a copy of the script for merging.
 	}
 
 	// Merge scripts. with any previous data, if any.
-	mergedScript := mergeScripts(net, tx, idx, pkScript, class, addresses,
-		nrequired, sigScript, previousScript)
+	mergedScript, err := mergeScripts(net, tx, idx, pkScript, class,
+		addresses, nrequired, sigScript, previousScript)
 	if err != nil {
 		return nil, err
 	}
@@ -1609,6 +2669,86 @@ func CalcScriptInfo(sigscript, pkscript []byte, bip16 bool) (*ScriptInfo, error)
 	return si, nil
 }
 
+// maxStandardSigScriptSize is the maximum size of a signature script accepted
+// by ValidateSignatureScript.  It is large enough for a pay-to-script-hash
+// input redeeming a 15-of-15 multisig script with compressed pubkeys.
+const maxStandardSigScriptSize = 1650
+
+var (
+	// ErrSigScriptTooBig is returned from ValidateSignatureScript when the
+	// signature script is larger than standard inputs may be.
+	ErrSigScriptTooBig = errors.New("signature script is too large")
+
+	// ErrNoRedeemScript is returned from ValidateSignatureScript when a
+	// pay-to-script-hash signature script does not push a redeem script.
+	ErrNoRedeemScript = errors.New("pay to script hash input is missing " +
+		"the redeem script")
+
+	// ErrNonStandardRedeemScript is returned from ValidateSignatureScript
+	// when the redeem script of a pay-to-script-hash input is not of a
+	// standard type which can be spent.
+	ErrNonStandardRedeemScript = errors.New("redeem script is not a " +
+		"standard script")
+
+	// ErrWrongNumInputs is returned from ValidateSignatureScript when a
+	// pay-to-script-hash signature script does not push the number of
+	// items its redeem script expects.
+	ErrWrongNumInputs = errors.New("signature script pushes the wrong " +
+		"number of items")
+)
+
+// ValidateSignatureScript returns an error if sigScript is not a standard
+// signature script for spending an output of class pkScriptClass.  The script
+// must parse, be no larger than the standard limit and only push data, in which
+// case ErrStackNonPushOnly is returned.  For pay-to-script-hash outputs the
+// last push must also be a redeem script of a standard type other than
+// pay-to-script-hash, with exactly the number of items it expects pushed
+// before it.
+func ValidateSignatureScript(sigScript []byte, pkScriptClass ScriptClass) error {
+	if len(sigScript) > maxStandardSigScriptSize {
+		return ErrSigScriptTooBig
+	}
+
+	sigPops, err := parseScript(sigScript)
+	if err != nil {
+		return err
+	}
+
+	if !isPushOnly(sigPops) {
+		return ErrStackNonPushOnly
+	}
+
+	if pkScriptClass != ScriptHashTy {
+		return nil
+	}
+
+	if len(sigPops) == 0 {
+		return ErrNoRedeemScript
+	}
+	script := sigPops[len(sigPops)-1].data
+	if len(script) > MaxScriptElementSize {
+		return ErrStackElementTooBig
+	}
+	shPops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	shClass := typeOfScript(shPops)
+	if shClass == ScriptHashTy {
+		return ErrNonStandardRedeemScript
+	}
+	shInputs := expectedInputs(shPops, shClass)
+	if shInputs == -1 {
+		return ErrNonStandardRedeemScript
+	}
+	if len(sigPops)-1 != shInputs {
+		return ErrWrongNumInputs
+	}
+
+	return nil
+}
+
 // asSmallInt returns the passed opcode, which must be true according to
 // isSmallInt(), as an integer.
 func asSmallInt(op *opcode) int {
@@ -1644,8 +2784,345 @@ func CalcMultiSigStats(script []byte) (int, int, error) {
 	return numPubKeys, numSigs, nil
 }
 
+// ErrNotMultiSig is returned by RequiredPubKeys and RequiredPubKeysP2SH when a
+// script, or the address script of a name script, is not a multisig script.
+var ErrNotMultiSig = errors.New("script is not a multisig script")
+
+// ErrRedeemScriptMismatch is returned by RequiredPubKeysP2SH when the hash of
+// the passed redeem script is not the one the output script pays to.
+var ErrRedeemScriptMismatch = errors.New("redeem script does not match the " +
+	"script hash")
+
+// RequiredPubKeys returns the public keys of the passed multisig script, in
+// the order in which their signatures must be given, along with the number of
+// signatures required, for coordinating the signing of a spend.  Name scripts
+// are judged by the address script which follows the name prefix.  A
+// pay-to-script-hash output only holds the hash of its redeem script, so its
+// keys are found by passing the redeem script to RequiredPubKeysP2SH.
+//
+// ErrNotMultiSig is returned for scripts of any other class and for multisig
+// scripts whose number of public keys does not match the keys pushed or which
+// require more signatures than they have keys.  An error is also returned if
+// any of the public keys is invalid, since the keys would not be exact.
+func RequiredPubKeys(pkScript []byte) ([]*btcec.PublicKey, int, error) {
+	pops, err := parseScript(pkScript)
+	if err != nil {
+		return nil, 0, err
+	}
+	if base, ok := stripNamePrefix(pops); ok {
+		pops = base
+	} else {
+		pops = skipComment(pops) // namecoin
+	}
+	return multiSigPubKeys(pops)
+}
+
+// RequiredPubKeysP2SH is the same as RequiredPubKeys for a pay-to-script-hash
+// output script, or a name script whose address script is one, which is
+// spent with the passed redeem script.  ErrRedeemScriptMismatch is returned
+// if the hash160 of the redeem script is not the hash the output script pays
+// to, and ErrNotMultiSig if the output script is not pay-to-script-hash or the
+// redeem script is not a valid multisig script.
+func RequiredPubKeysP2SH(pkScript, redeemScript []byte) ([]*btcec.PublicKey, int, error) {
+	pops, err := parseScript(pkScript)
+	if err != nil {
+		return nil, 0, err
+	}
+	if base, ok := stripNamePrefix(pops); ok {
+		pops = base
+	} else {
+		pops = skipComment(pops) // namecoin
+	}
+	if !isScriptHash(pops) {
+		return nil, 0, ErrNotMultiSig
+	}
+	if !bytes.Equal(calcHash160(redeemScript), pops[1].data) {
+		return nil, 0, ErrRedeemScriptMismatch
+	}
+
+	redeemPops, err := parseScript(redeemScript)
+	if err != nil {
+		return nil, 0, err
+	}
+	return multiSigPubKeys(redeemPops)
+}
+
+// multiSigPubKeys returns the public keys and the number of required
+// signatures of the passed multisig script for RequiredPubKeys and
+// RequiredPubKeysP2SH.
+func multiSigPubKeys(pops []parsedOpcode) ([]*btcec.PublicKey, int, error) {
+	if !isMultiSig(pops) {
+		return nil, 0, ErrNotMultiSig
+	}
+
+	// A multi-signature script is of the form:
+	//  <numsigs> <pubkey> <pubkey> <pubkey>... <numpubkeys> OP_CHECKMULTISIG
+	keyPops := pops[1 : len(pops)-2]
+	numSigs := asSmallInt(pops[0].opcode)
+	if asSmallInt(pops[len(pops)-2].opcode) != len(keyPops) ||
+		numSigs > len(keyPops) {
+		return nil, 0, ErrNotMultiSig
+	}
+
+	pubKeys := make([]*btcec.PublicKey, 0, len(keyPops))
+	for _, pop := range keyPops {
+		pubKey, err := btcec.ParsePubKey(pop.data, btcec.S256())
+		if err != nil {
+			return nil, 0, err
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	return pubKeys, numSigs, nil
+}
+
+// These are the serialized sizes of the inputs which spend the standard script
+// classes, used by IsDustOutput.  Each is made up of the 36 byte previous
+// outpoint, the signature script with its length prefix and the 4 byte
+// sequence number, with signatures assumed to be at most 72 bytes long.
+const (
+	// pubKeyInputSize spends a pay-to-pubkey output with a signature
+	// script of a single signature push.
+	pubKeyInputSize = 36 + 1 + pubKeySigScriptSize + 4
+
+	// pubKeyHashInputSize spends a pay-to-pubkey-hash output with a
+	// signature script of a signature push and a compressed pubkey push.
+	pubKeyHashInputSize = 36 + 1 + pubKeyHashSigScriptSize + 4
+
+	// multiSigInputOverhead is the size of an input spending a multisig
+	// output, leaving out the signature pushes but including the extra
+	// OP_0 consumed by OP_CHECKMULTISIG.
+	multiSigInputOverhead = 36 + 1 + multiSigSigScriptOverhead + 4
+)
+
+// These are the sizes of the signature scripts which spend the standard script
+// classes, as estimated by EstimateSpendSize.
+const (
+	// sigPushSize is the size of the push of a signature of at most 72
+	// bytes including its hash type byte.
+	sigPushSize = 1 + 72
+
+	// pubKeySigScriptSize is the size of a signature script spending a
+	// pay-to-pubkey output.
+	pubKeySigScriptSize = sigPushSize
+
+	// pubKeyHashSigScriptSize is the size of a signature script spending
+	// a pay-to-pubkey-hash output with a compressed public key.
+	pubKeyHashSigScriptSize = sigPushSize + 1 + 33
+
+	// multiSigSigScriptOverhead is the size of a signature script spending
+	// a multisig output apart from the signature pushes, which is the
+	// extra OP_0 consumed by OP_CHECKMULTISIG.
+	multiSigSigScriptOverhead = 1
+)
+
+var (
+	// ErrSpendSizeUnknown is returned from EstimateSpendSize for scripts
+	// which are not of a class with a known way of spending them.
+	ErrSpendSizeUnknown = errors.New("size of the signature script " +
+		"spending the output script is not known")
+
+	// ErrSpendSizeNeedsRedeemScript is returned from EstimateSpendSize for
+	// pay-to-script-hash scripts, whose spend size depends on the redeem
+	// script and must be estimated by EstimateP2SHSpendSize.
+	ErrSpendSizeNeedsRedeemScript = errors.New("size of the signature " +
+		"script spending a pay-to-script-hash output depends on the " +
+		"redeem script")
+)
+
+// EstimateSpendSize returns the size in bytes of the signature script which
+// spends an output paying to pkScript, assuming signatures of at most 72 bytes
+// including the hash type and compressed public keys.  This is the size of the
+// script itself, without the length prefix it is given in an input.  Name
+// scripts are spent like the address script which follows the name prefix.
+//
+// ErrSpendSizeNeedsRedeemScript is returned for pay-to-script-hash scripts,
+// which should be estimated with EstimateP2SHSpendSize, and
+// ErrSpendSizeUnknown for all other scripts which are not pay-to-pubkey,
+// pay-to-pubkey-hash or multisig.
+func EstimateSpendSize(pkScript []byte) (int, error) {
+	pops, err := parseScript(pkScript)
+	if err != nil {
+		return 0, err
+	}
+	base, _ := stripNamePrefix(pops)
+
+	switch typeOfScript(base) {
+	case PubKeyTy:
+		return pubKeySigScriptSize, nil
+	case PubKeyHashTy:
+		return pubKeyHashSigScriptSize, nil
+	case MultiSigTy:
+		numSigs := asSmallInt(base[0].opcode)
+		return multiSigSigScriptOverhead + numSigs*sigPushSize, nil
+	case ScriptHashTy:
+		return 0, ErrSpendSizeNeedsRedeemScript
+	}
+	return 0, ErrSpendSizeUnknown
+}
+
+// EstimateP2SHSpendSize returns the size in bytes of the signature script which
+// spends a pay-to-script-hash output with the passed redeem script.  It is the
+// size EstimateSpendSize gives for the redeem script plus the push of the
+// redeem script itself, and any error EstimateSpendSize returns for the redeem
+// script is returned.
+func EstimateP2SHSpendSize(redeemScript []byte) (int, error) {
+	size, err := EstimateSpendSize(redeemScript)
+	if err != nil {
+		return 0, err
+	}
+
+	size += len(redeemScript)
+	switch {
+	case len(redeemScript) < OP_PUSHDATA1:
+		size++
+	case len(redeemScript) <= 0xff:
+		size += 2
+	case len(redeemScript) <= 0xffff:
+		size += 3
+	default:
+		size += 5
+	}
+	return size, nil
+}
+
+// IsDustOutput returns whether an output of value paying to pkScript is dust,
+// that is whether spending it would cost more than a third of its value at the
+// given relay fee in satoshi per kilobyte.  The cost counts both the output
+// itself and the input needed to spend it, whose size depends on the class of
+// the script.  Name scripts are spent like the address script which follows
+// the name prefix.  Pay-to-script-hash outputs are assumed to be spent like a
+// pay-to-pubkey-hash output since the redeem script is not known.  Null data
+// and non-standard outputs, including scripts which do not parse, are never
+// considered dust.
+func IsDustOutput(pkScript []byte, value int64, relayFeePerKB int64) bool {
+	pops, err := parseScript(pkScript)
+	if err != nil {
+		return false
+	}
+	base, _ := stripNamePrefix(pops)
+
+	var inputSize int
+	switch typeOfScript(base) {
+	case PubKeyTy:
+		inputSize = pubKeyInputSize
+	case PubKeyHashTy, ScriptHashTy:
+		inputSize = pubKeyHashInputSize
+	case MultiSigTy:
+		// The script was classified as multisig, so it can't fail to
+		// give the number of signatures.
+		numSigs := asSmallInt(base[0].opcode)
+		inputSize = multiSigInputOverhead + numSigs*sigPushSize
+	default:
+		return false
+	}
+
+	// The output is an 8 byte value followed by the script and its
+	// variable length integer length prefix.
+	outputSize := 8 + len(pkScript)
+	switch {
+	case len(pkScript) < 0xfd:
+		outputSize++
+	case len(pkScript) <= 0xffff:
+		outputSize += 3
+	default:
+		outputSize += 5
+	}
+
+	totalSize := int64(outputSize + inputSize)
+	return value*1000/(3*totalSize) < relayFeePerKB
+}
+
+// These are the policy limits enforced by IsStandardPkScript.
+const (
+	// maxStandardMultiSigKeys is the maximum number of public keys a bare
+	// multisig output may have.
+	maxStandardMultiSigKeys = 3
+
+	// maxStandardPkScriptSigOps is the maximum number of signature
+	// operations, counted precisely, in a standard output script.
+	maxStandardPkScriptSigOps = maxStandardMultiSigKeys
+)
+
+var (
+	// ErrPkScriptTooBig is returned from IsStandardPkScript when the script
+	// is larger than the maximum script size.
+	ErrPkScriptTooBig = errors.New("output script is larger than the " +
+		"maximum script size")
+
+	// ErrNonStandardPkScript is returned from IsStandardPkScript when the
+	// script, or the address script of a name script, is not of one of the
+	// standard classes.
+	ErrNonStandardPkScript = errors.New("output script is not of a " +
+		"standard type")
+
+	// ErrNonStandardMultiSig is returned from IsStandardPkScript for bare
+	// multisig scripts with more public keys than policy allows or with
+	// signature and key counts which don't match the script.
+	ErrNonStandardMultiSig = errors.New("bare multisig output script is " +
+		"not standard")
+
+	// ErrTooManyPkScriptSigOps is returned from IsStandardPkScript when
+	// the script contains more signature operations than policy allows.
+	ErrTooManyPkScriptSigOps = errors.New("output script has too many " +
+		"signature operations")
+)
+
+// IsStandardPkScript returns whether pkScript is a standard output script,
+// along with an error describing why it is not when it isn't.  The script must
+// parse, be no larger than the maximum script size and be of a standard class
+// other than NameScriptTy.  Name scripts are judged by the address script which
+// follows the name prefix.  Bare multisig scripts may have at most three public
+// keys.
+func IsStandardPkScript(pkScript []byte) (bool, error) {
+	if len(pkScript) > maxScriptSize {
+		return false, ErrPkScriptTooBig
+	}
+
+	pops, err := parseScript(pkScript)
+	if err != nil {
+		return false, err
+	}
+	base, _ := stripNamePrefix(pops)
+
+	switch typeOfScript(base) {
+	case PubKeyTy, PubKeyHashTy, ScriptHashTy, NullDataTy:
+	case MultiSigTy:
+		numSigs := asSmallInt(base[0].opcode)
+		numPubKeys := asSmallInt(base[len(base)-2].opcode)
+		if numPubKeys != len(base)-3 || numSigs < 1 ||
+			numSigs > numPubKeys ||
+			numPubKeys > maxStandardMultiSigKeys {
+			return false, ErrNonStandardMultiSig
+		}
+	default:
+		return false, ErrNonStandardPkScript
+	}
+
+	if getSigOpCount(base, true) > maxStandardPkScriptSigOps {
+		return false, ErrTooManyPkScriptSigOps
+	}
+	return true, nil
+}
+
+// IsUnspendable returns whether pkScript is provably unspendable, in which case
+// an output paying to it may be left out of the set of unspent outputs.  As in
+// the reference implementation, only scripts which begin with OP_RETURN and
+// scripts longer than the maximum script size are detected.  Other scripts
+// might never evaluate to true either, but proving it would need more than a
+// look at the script, so they are not treated as unspendable.
+func IsUnspendable(pkScript []byte) bool {
+	return (len(pkScript) > 0 && pkScript[0] == OP_RETURN) ||
+		len(pkScript) > maxScriptSize
+}
+
 // PushedData returns an array of byte slices containing any pushed data found
 // in the passed script.  This includes OP_0, but not OP_1 - OP_16.
+//
+// The data is returned in the order it is pushed, with OP_0 and empty pushes by
+// OP_PUSHDATA1, OP_PUSHDATA2 and OP_PUSHDATA4 giving empty slices.  OP_1NEGATE
+// and OP_1 through OP_16 push numbers rather than data, so they are skipped
+// along with all other opcodes.  The returned slices share the memory of the
+// script.
 func PushedData(script []byte) ([][]byte, error) {
 	pops, err := parseScript(script)
 	if err != nil {
@@ -1661,3 +3138,152 @@ func PushedData(script []byte) ([][]byte, error) {
 	}
 	return data, nil
 }
+
+// MaxDataCarrierSize is the maximum number of bytes of data which may be
+// carried by a standard null data output, which is a script classified as
+// NullDataTy.
+const MaxDataCarrierSize = 40
+
+var (
+	// ErrNotNullData is returned from ExtractNullData when the script is
+	// not an OP_RETURN followed only by data pushes.
+	ErrNotNullData = errors.New("script is not a null data script")
+
+	// ErrNullDataTooBig is returned from ExtractNullData when standardness
+	// is enforced and the script carries more than MaxDataCarrierSize
+	// bytes of data.
+	ErrNullDataTooBig = errors.New("null data script carries too much data")
+)
+
+// ExtractNullData returns the data carried by a provably unspendable null data
+// output script, which is the concatenation of the data pushed after its
+// leading OP_RETURN.  OP_1NEGATE and OP_1 through OP_16 contribute the single
+// byte they push.  An ErrNotNullData is returned if the script does not start
+// with OP_RETURN or anything other than a data push follows it.  When standard
+// is true the script must also be a standard null data output, as
+// IsStandardPkScript requires: an ErrNullDataTooBig is returned if more than
+// MaxDataCarrierSize bytes of data are carried, and an ErrNonStandardPkScript
+// if the data is not given by a single push opcode.
+func ExtractNullData(pkScript []byte, standard bool) ([]byte, error) {
+	pops, err := parseScript(pkScript)
+	if err != nil {
+		return nil, err
+	}
+	if len(pops) == 0 || pops[0].opcode.value != OP_RETURN ||
+		!isPushOnly(pops[1:]) {
+		return nil, ErrNotNullData
+	}
+
+	data := []byte{}
+	for _, pop := range pops[1:] {
+		switch {
+		case pop.opcode.value == OP_RESERVED:
+			// OP_RESERVED counts as a push but pushes nothing, and
+			// makes the script fail if executed.
+			return nil, ErrNotNullData
+		case pop.opcode.value == OP_1NEGATE:
+			data = append(data, 0x81)
+		case isSmallInt(pop.opcode) && pop.opcode.value != OP_0:
+			data = append(data, byte(asSmallInt(pop.opcode)))
+		default:
+			data = append(data, pop.data...)
+		}
+	}
+	if standard {
+		if len(data) > MaxDataCarrierSize {
+			return nil, ErrNullDataTooBig
+		}
+		if !isNullData(pops) {
+			return nil, ErrNonStandardPkScript
+		}
+	}
+	return data, nil
+}
+
+// AtomicSwapData holds the data pushed by an atomic swap contract as returned
+// by ExtractAtomicSwapDataPushes.
+type AtomicSwapData struct {
+	RecipientHash160 [20]byte
+	RefundHash160    [20]byte
+	SecretHash       [32]byte
+	SecretSize       int64
+	LockTime         int64
+}
+
+// ExtractAtomicSwapDataPushes returns the data pushed by the passed redeem
+// script if it is an atomic swap contract of the form:
+//  OP_IF
+//   OP_SIZE <secret size> OP_EQUALVERIFY OP_SHA256 <secret hash> OP_EQUALVERIFY
+//   OP_DUP OP_HASH160 <recipient hash>
+//  OP_ELSE
+//   <lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <refund hash>
+//  OP_ENDIF
+//  OP_EQUALVERIFY OP_CHECKSIG
+// The secret size and lock time must be canonical pushes of numbers of up to 5
+// bytes.  Nil is returned for both the data and the error if the script is not
+// an atomic swap contract, and an error is only returned if the script does
+// not parse.
+func ExtractAtomicSwapDataPushes(redeemScript []byte) (*AtomicSwapData, error) {
+	pops, err := parseScript(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+	if len(pops) != 20 {
+		return nil, nil
+	}
+	isAtomicSwap := pops[0].opcode.value == OP_IF &&
+		pops[1].opcode.value == OP_SIZE &&
+		pops[2].opcode.value <= OP_16 && canonicalPush(pops[2]) &&
+		pops[3].opcode.value == OP_EQUALVERIFY &&
+		pops[4].opcode.value == OP_SHA256 &&
+		pops[5].opcode.value == OP_DATA_32 &&
+		pops[6].opcode.value == OP_EQUALVERIFY &&
+		pops[7].opcode.value == OP_DUP &&
+		pops[8].opcode.value == OP_HASH160 &&
+		pops[9].opcode.value == OP_DATA_20 &&
+		pops[10].opcode.value == OP_ELSE &&
+		pops[11].opcode.value <= OP_16 && canonicalPush(pops[11]) &&
+		pops[12].opcode.value == OP_CHECKLOCKTIMEVERIFY &&
+		pops[13].opcode.value == OP_DROP &&
+		pops[14].opcode.value == OP_DUP &&
+		pops[15].opcode.value == OP_HASH160 &&
+		pops[16].opcode.value == OP_DATA_20 &&
+		pops[17].opcode.value == OP_ENDIF &&
+		pops[18].opcode.value == OP_EQUALVERIFY &&
+		pops[19].opcode.value == OP_CHECKSIG
+	if !isAtomicSwap {
+		return nil, nil
+	}
+
+	secretSize, ok := swapPushedInt(pops[2])
+	if !ok {
+		return nil, nil
+	}
+	lockTime, ok := swapPushedInt(pops[11])
+	if !ok {
+		return nil, nil
+	}
+
+	data := &AtomicSwapData{SecretSize: secretSize, LockTime: lockTime}
+	copy(data.SecretHash[:], pops[5].data)
+	copy(data.RecipientHash160[:], pops[9].data)
+	copy(data.RefundHash160[:], pops[16].data)
+	return data, nil
+}
+
+// swapPushedInt returns the number pushed by the passed opcode of an atomic
+// swap contract, which is either a small integer opcode or a data push of up
+// to 5 bytes.  False is returned if the opcode does not push such a number.
+func swapPushedInt(pop parsedOpcode) (int64, bool) {
+	if isSmallInt(pop.opcode) {
+		return int64(asSmallInt(pop.opcode)), true
+	}
+	if pop.opcode.value == OP_1NEGATE || pop.opcode.value == OP_RESERVED {
+		return 0, false
+	}
+	num, err := asIntN(pop.data, 5, false)
+	if err != nil {
+		return 0, false
+	}
+	return num.Int64(), true
+}
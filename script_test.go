@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/conformal/btcec"
@@ -17,6 +18,18 @@ import (
 	"github.com/hlandauf/btcwire"
 )
 
+// builderScript returns the script built by the passed builder.  It panics if
+// an error occurs.  This is only used in the tests as a helper since the only
+// way it can fail is if there is an error in the test source code.
+func builderScript(builder *btcscript.ScriptBuilder) []byte {
+	script, err := builder.Script()
+	if err != nil {
+		panic("invalid script in test source: " + err.Error())
+	}
+
+	return script
+}
+
 func TestPushedData(t *testing.T) {
 	var tests = []struct {
 		in    []byte
@@ -29,7 +42,7 @@ func TestPushedData(t *testing.T) {
 			true,
 		},
 		{
-			btcscript.NewScriptBuilder().AddInt64(16777216).AddInt64(10000000).Script(),
+			builderScript(btcscript.NewScriptBuilder().AddInt64(16777216).AddInt64(10000000)),
 			[][]byte{
 				{0x00, 0x00, 0x00, 0x01}, // 16777216
 				{0x80, 0x96, 0x98, 0x00}, // 10000000
@@ -37,9 +50,9 @@ func TestPushedData(t *testing.T) {
 			true,
 		},
 		{
-			btcscript.NewScriptBuilder().AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_HASH160).
+			builderScript(btcscript.NewScriptBuilder().AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_HASH160).
 				AddData([]byte("17VZNX1SN5NtKa8UQFxwQbFeFc3iqRYhem")).AddOp(btcscript.OP_EQUALVERIFY).
-				AddOp(btcscript.OP_CHECKSIG).Script(),
+				AddOp(btcscript.OP_CHECKSIG)),
 			[][]byte{
 				// 17VZNX1SN5NtKa8UQFxwQbFeFc3iqRYhem
 				{
@@ -52,11 +65,22 @@ func TestPushedData(t *testing.T) {
 			true,
 		},
 		{
-			btcscript.NewScriptBuilder().AddOp(btcscript.OP_PUSHDATA4).AddInt64(1000).
-				AddOp(btcscript.OP_EQUAL).Script(),
+			builderScript(btcscript.NewScriptBuilder().AddOp(btcscript.OP_PUSHDATA4).AddInt64(1000).
+				AddOp(btcscript.OP_EQUAL)),
 			[][]byte{},
 			false,
 		},
+		{
+			[]byte{btcscript.OP_DATA_2, 0x01, 0x02, btcscript.OP_1NEGATE,
+				btcscript.OP_PUSHDATA1, 0x01, 0x03, btcscript.OP_1,
+				btcscript.OP_PUSHDATA2, 0x02, 0x00, 0x04, 0x05,
+				btcscript.OP_16, btcscript.OP_DROP,
+				btcscript.OP_PUSHDATA4, 0x01, 0x00, 0x00, 0x00, 0x06,
+				btcscript.OP_PUSHDATA1, 0x00, btcscript.OP_0,
+				btcscript.OP_CHECKSIG},
+			[][]byte{{0x01, 0x02}, {0x03}, {0x04, 0x05}, {0x06}, {}, {}},
+			true,
+		},
 	}
 
 	for x, test := range tests {
@@ -68,6 +92,11 @@ func TestPushedData(t *testing.T) {
 			t.Errorf("TestPushedData failed test #%d: test should be invalid\n", x)
 			continue
 		}
+		if test.valid && len(pushedData) != len(test.out) {
+			t.Errorf("TestPushedData failed test #%d: want %d pushes "+
+				"got %d\n", x, len(test.out), len(pushedData))
+			continue
+		}
 		for x, data := range pushedData {
 			if !bytes.Equal(data, test.out[x]) {
 				t.Errorf("TestPushedData failed test #%d: want: %x got: %x\n",
@@ -77,26 +106,274 @@ func TestPushedData(t *testing.T) {
 	}
 }
 
+// TestExtractNullData ensures the data carried by null data scripts is
+// extracted, and that other scripts and oversized data are rejected.
+func TestExtractNullData(t *testing.T) {
+	big := bytes.Repeat([]byte{0xaa}, btcscript.MaxDataCarrierSize)
+	tests := []struct {
+		name     string
+		script   []byte
+		data     []byte
+		err      error
+		stdError error
+	}{
+		{
+			name:   "OP_RETURN alone",
+			script: []byte{btcscript.OP_RETURN},
+			data:   []byte{},
+		},
+		{
+			name: "single push",
+			script: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_RETURN).
+				AddData([]byte("namecoin"))),
+			data: []byte("namecoin"),
+		},
+		{
+			name: "multiple pushes",
+			script: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_RETURN).AddData([]byte("abc")).
+				AddOp(btcscript.OP_0).AddOp(btcscript.OP_16).
+				AddOp(btcscript.OP_1NEGATE).
+				AddData([]byte("de"))),
+			data:     []byte{'a', 'b', 'c', 0x10, 0x81, 'd', 'e'},
+			stdError: btcscript.ErrNonStandardPkScript,
+		},
+		{
+			name: "small integer",
+			script: []byte{btcscript.OP_RETURN,
+				btcscript.OP_16},
+			data:     []byte{0x10},
+			stdError: btcscript.ErrNonStandardPkScript,
+		},
+		{
+			name: "max standard size",
+			script: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_RETURN).AddData(big)),
+			data: big,
+		},
+		{
+			name: "over max standard size",
+			script: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_RETURN).
+				AddData(append([]byte{0x01}, big...))),
+			data:     append([]byte{0x01}, big...),
+			stdError: btcscript.ErrNullDataTooBig,
+		},
+		{
+			name: "over max standard size across pushes",
+			script: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_RETURN).AddData(big).
+				AddData([]byte{0x01, 0x02})),
+			data:     append(append([]byte{}, big...), 0x01, 0x02),
+			stdError: btcscript.ErrNullDataTooBig,
+		},
+		{
+			name: "pay to pubkey hash",
+			script: decodeHex("76a914ad06dd6ddee55cbca9a9e3713bd" +
+				"7587509a3056488ac"),
+			err: btcscript.ErrNotNullData,
+		},
+		{
+			name:   "non-push after OP_RETURN",
+			script: []byte{btcscript.OP_RETURN, btcscript.OP_DUP},
+			err:    btcscript.ErrNotNullData,
+		},
+		{
+			name:   "OP_RESERVED after OP_RETURN",
+			script: []byte{btcscript.OP_RETURN, btcscript.OP_RESERVED},
+			err:    btcscript.ErrNotNullData,
+		},
+		{
+			name:   "empty",
+			script: nil,
+			err:    btcscript.ErrNotNullData,
+		},
+		{
+			name:   "unparsable",
+			script: []byte{btcscript.OP_RETURN, btcscript.OP_DATA_2},
+			err:    btcscript.ErrStackShortScript,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		for _, standard := range []bool{false, true} {
+			want := test.err
+			if standard && want == nil {
+				want = test.stdError
+			}
+			data, err := btcscript.ExtractNullData(test.script,
+				standard)
+			if err != want {
+				t.Errorf("ExtractNullData #%d (%s) standard %v "+
+					"unexpected error - got %v, want %v", i,
+					test.name, standard, err, want)
+				continue
+			}
+			if want == nil && !bytes.Equal(data, test.data) {
+				t.Errorf("ExtractNullData #%d (%s) got %x, want "+
+					"%x", i, test.name, data, test.data)
+			}
+
+			// The null data scripts accepted as standard are
+			// exactly those which IsStandardPkScript accepts.
+			isStandard, _ := btcscript.IsStandardPkScript(test.script)
+			if standard && test.err == nil &&
+				(err == nil) != isStandard {
+				t.Errorf("ExtractNullData #%d (%s) standard "+
+					"error %v disagrees with "+
+					"IsStandardPkScript %v", i, test.name,
+					err, isStandard)
+			}
+		}
+	}
+}
+
+// TestExtractAtomicSwapDataPushes ensures the data pushed by atomic swap
+// contracts is extracted and scripts differing from the template are not
+// treated as contracts.
+func TestExtractAtomicSwapDataPushes(t *testing.T) {
+	secretHash := bytes.Repeat([]byte{0x11}, 32)
+	recipient := bytes.Repeat([]byte{0x22}, 20)
+	refund := bytes.Repeat([]byte{0x33}, 20)
+	const cltv = btcscript.OP_CHECKLOCKTIMEVERIFY
+
+	// contract returns an atomic swap contract using the passed secret
+	// size, lock time, lock time opcode and secret hash.
+	contract := func(secretSize, lockTime int64, lockOp byte,
+		hash []byte) []byte {
+		return builderScript(btcscript.NewScriptBuilder().
+			AddOp(btcscript.OP_IF).
+			AddOp(btcscript.OP_SIZE).AddInt64(secretSize).
+			AddOp(btcscript.OP_EQUALVERIFY).
+			AddOp(btcscript.OP_SHA256).AddData(hash).
+			AddOp(btcscript.OP_EQUALVERIFY).
+			AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_HASH160).
+			AddData(recipient).
+			AddOp(btcscript.OP_ELSE).
+			AddInt64(lockTime).AddOp(lockOp).
+			AddOp(btcscript.OP_DROP).
+			AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_HASH160).
+			AddData(refund).
+			AddOp(btcscript.OP_ENDIF).
+			AddOp(btcscript.OP_EQUALVERIFY).
+			AddOp(btcscript.OP_CHECKSIG))
+	}
+
+	tests := []struct {
+		name       string
+		script     []byte
+		secretSize int64
+		lockTime   int64
+		match      bool
+		err        error
+	}{
+		{
+			name:       "contract with time lock",
+			script:     contract(32, 1500000000, cltv, secretHash),
+			secretSize: 32,
+			lockTime:   1500000000,
+			match:      true,
+		},
+		{
+			name:       "contract with small int lock time",
+			script:     contract(16, 7, cltv, secretHash),
+			secretSize: 16,
+			lockTime:   7,
+			match:      true,
+		},
+		{
+			name:       "contract with 5 byte lock time",
+			script:     contract(32, 0xffffffff, cltv, secretHash),
+			secretSize: 32,
+			lockTime:   0xffffffff,
+			match:      true,
+		},
+		{
+			name: "OP_CHECKSEQUENCEVERIFY instead of lock time",
+			script: contract(32, 1500000000,
+				btcscript.OP_CHECKSEQUENCEVERIFY, secretHash),
+		},
+		{
+			name:   "short secret hash",
+			script: contract(32, 1500000000, cltv, secretHash[:31]),
+		},
+		{
+			name:   "lock time too big",
+			script: contract(32, 1<<40, cltv, secretHash),
+		},
+		{
+			name: "trailing opcode",
+			script: append(contract(32, 1500000000, cltv,
+				secretHash), btcscript.OP_NOP),
+		},
+		{
+			name:   "pay to pubkey hash",
+			script: nameTestP2PKH,
+		},
+		{
+			name:   "unparsable",
+			script: []byte{btcscript.OP_IF, btcscript.OP_DATA_2},
+			err:    btcscript.ErrStackShortScript,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		data, err := btcscript.ExtractAtomicSwapDataPushes(test.script)
+		if err != test.err {
+			t.Errorf("ExtractAtomicSwapDataPushes #%d (%s) "+
+				"unexpected error - got %v, want %v", i,
+				test.name, err, test.err)
+			continue
+		}
+		if !test.match {
+			if data != nil {
+				t.Errorf("ExtractAtomicSwapDataPushes #%d (%s) "+
+					"unexpectedly matched: %+v", i,
+					test.name, data)
+			}
+			continue
+		}
+		if data == nil {
+			t.Errorf("ExtractAtomicSwapDataPushes #%d (%s) did "+
+				"not match", i, test.name)
+			continue
+		}
+		if data.SecretSize != test.secretSize ||
+			data.LockTime != test.lockTime ||
+			!bytes.Equal(data.SecretHash[:], secretHash) ||
+			!bytes.Equal(data.RecipientHash160[:], recipient) ||
+			!bytes.Equal(data.RefundHash160[:], refund) {
+			t.Errorf("ExtractAtomicSwapDataPushes #%d (%s) wrong "+
+				"data: %+v", i, test.name, data)
+		}
+	}
+}
+
 func TestStandardPushes(t *testing.T) {
 	for i := 0; i < 1000; i++ {
 		builder := btcscript.NewScriptBuilder()
 		builder.AddInt64(int64(i))
-		if result := btcscript.IsPushOnlyScript(builder.Script()); !result {
-			t.Errorf("StandardPushesTests IsPushOnlyScript test #%d failed: %x\n", i, builder.Script())
+		script := builderScript(builder)
+		if result := btcscript.IsPushOnlyScript(script); !result {
+			t.Errorf("StandardPushesTests IsPushOnlyScript test #%d failed: %x\n", i, script)
 		}
-		if result := btcscript.HasCanonicalPushes(builder.Script()); !result {
-			t.Errorf("StandardPushesTests HasCanonicalPushes test #%d failed: %x\n", i, builder.Script())
+		if result := btcscript.HasCanonicalPushes(script); !result {
+			t.Errorf("StandardPushesTests HasCanonicalPushes test #%d failed: %x\n", i, script)
 			continue
 		}
 	}
-	for i := 0; i < 1000; i++ {
+	for i := 0; i <= btcscript.MaxScriptElementSize; i++ {
 		builder := btcscript.NewScriptBuilder()
 		builder.AddData(bytes.Repeat([]byte{0x49}, i))
-		if result := btcscript.IsPushOnlyScript(builder.Script()); !result {
-			t.Errorf("StandardPushesTests IsPushOnlyScript test #%d failed: %x\n", i, builder.Script())
+		script := builderScript(builder)
+		if result := btcscript.IsPushOnlyScript(script); !result {
+			t.Errorf("StandardPushesTests IsPushOnlyScript test #%d failed: %x\n", i, script)
 		}
-		if result := btcscript.HasCanonicalPushes(builder.Script()); !result {
-			t.Errorf("StandardPushesTests HasCanonicalPushes test #%d failed: %x\n", i, builder.Script())
+		if result := btcscript.HasCanonicalPushes(script); !result {
+			t.Errorf("StandardPushesTests HasCanonicalPushes test #%d failed: %x\n", i, script)
 			continue
 		}
 	}
@@ -1708,313 +1985,2375 @@ func TestGetPreciseSignOps(t *testing.T) {
 	}
 }
 
-type scriptInfoTest struct {
-	name          string
-	sigScript     []byte
-	pkScript      []byte
-	bip16         bool
-	scriptInfo    btcscript.ScriptInfo
-	scriptInfoErr error
-}
+// TestGetPreciseSigOpCountName ensures that name scripts paying to a script
+// hash are counted by their redeem script and that the name prefix contributes
+// no sigops.
+func TestGetPreciseSigOpCountName(t *testing.T) {
+	// A 3-of-5 multisig redeem script.  The public keys are never checked,
+	// so arbitrary 33 byte pushes will do.
+	builder := btcscript.NewScriptBuilder().AddOp(btcscript.OP_3)
+	for i := 0; i < 5; i++ {
+		builder.AddData(bytes.Repeat([]byte{byte(0x02 + i)}, 33))
+	}
+	builder.AddOp(btcscript.OP_5).AddOp(btcscript.OP_CHECKMULTISIG)
+	redeemScript := builderScript(builder)
+
+	scriptHash := btcutil.Hash160(redeemScript)
+	p2sh := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_HASH160).AddData(scriptHash).
+		AddOp(btcscript.OP_EQUAL))
+	sigScript := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_0).AddData(redeemScript))
+
+	name, err := btcscript.NewNameScriptBuilder().
+		NameUpdate([]byte("d/example"), []byte("value")).Script(p2sh)
+	if err != nil {
+		t.Fatalf("NameScriptBuilder: unexpected error: %v", err)
+	}
+	nameCheckSig, err := btcscript.NewNameScriptBuilder().
+		NameUpdate([]byte("d/example"), []byte("value")).
+		Script([]byte{btcscript.OP_CHECKSIG})
+	if err != nil {
+		t.Fatalf("NameScriptBuilder: unexpected error: %v", err)
+	}
 
-func TestScriptInfo(t *testing.T) {
-	for _, test := range txTests {
-		si, err := btcscript.CalcScriptInfo(
-			test.tx.TxIn[test.idx].SignatureScript,
+	tests := []struct {
+		name      string
+		scriptSig []byte
+		pkScript  []byte
+		bip16     bool
+		nSigOps   int
+	}{
+		{
+			name:      "p2sh 3 of 5 multisig",
+			scriptSig: sigScript,
+			pkScript:  p2sh,
+			bip16:     true,
+			nSigOps:   5,
+		},
+		{
+			name:      "name_update p2sh 3 of 5 multisig",
+			scriptSig: sigScript,
+			pkScript:  name,
+			bip16:     true,
+			nSigOps:   5,
+		},
+		{
+			name:      "name_update p2sh without bip16",
+			scriptSig: sigScript,
+			pkScript:  name,
+			bip16:     false,
+			nSigOps:   0,
+		},
+		{
+			name:     "name_update with checksig base",
+			pkScript: nameCheckSig,
+			bip16:    true,
+			nSigOps:  1,
+		},
+	}
+
+	for _, test := range tests {
+		count := btcscript.GetPreciseSigOpCount(test.scriptSig,
 			test.pkScript, test.bip16)
+		if count != test.nSigOps {
+			t.Errorf("%s: expected count of %d, got %d", test.name,
+				test.nSigOps, count)
+		}
+	}
+
+	// Counted as a bare script, the redeem script counts the maximum.
+	if count := btcscript.GetSigOpCount(redeemScript); count != 20 {
+		t.Errorf("bare 3 of 5 multisig: expected count of 20, got %d",
+			count)
+	}
+}
+
+// TestNestedPayToScriptHash ensures a redeem script which is itself of the
+// pay-to-script-hash form is executed exactly once as a plain script rather
+// than being treated as another layer of pay-to-script-hash.
+func TestNestedPayToScriptHash(t *testing.T) {
+	p2sh := func(script []byte) []byte {
+		return builderScript(btcscript.NewScriptBuilder().
+			AddOp(btcscript.OP_HASH160).
+			AddData(btcutil.Hash160(script)).
+			AddOp(btcscript.OP_EQUAL))
+	}
+	sigScript := func(datas ...[]byte) []byte {
+		builder := btcscript.NewScriptBuilder()
+		for _, data := range datas {
+			builder.AddData(data)
+		}
+		return builderScript(builder)
+	}
+
+	// Each inner script fails if it is ever executed.
+	falseScript := []byte{btcscript.OP_0, btcscript.OP_VERIFY}
+	returnScript := []byte{btcscript.OP_RETURN}
+	nested := p2sh(falseScript)
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+	tx.AddTxOut(btcwire.NewTxOut(0, []byte{btcscript.OP_TRUE}))
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		steps     int
+		err       error
+	}{
+		{
+			name: "redeem script checks hash of false script",
+			sigScript: sigScript(falseScript,
+				p2sh(falseScript)),
+			pkScript: p2sh(p2sh(falseScript)),
+			steps:    8,
+		},
+		{
+			name: "redeem script checks hash of OP_RETURN",
+			sigScript: sigScript(returnScript,
+				p2sh(returnScript)),
+			pkScript: p2sh(p2sh(returnScript)),
+			steps:    8,
+		},
+		{
+			name: "three levels of script hash",
+			sigScript: sigScript(falseScript, nested,
+				p2sh(nested)),
+			pkScript: p2sh(p2sh(nested)),
+			steps:    9,
+		},
+		{
+			name: "redeem script hash mismatch",
+			sigScript: sigScript(returnScript,
+				p2sh(falseScript)),
+			pkScript: p2sh(p2sh(falseScript)),
+			steps:    8,
+			err:      btcscript.ErrStackScriptFailed,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		engine, err := btcscript.NewScript(test.sigScript,
+			test.pkScript, 0, tx, btcscript.ScriptBip16)
 		if err != nil {
-			if err != test.scriptInfoErr {
-				t.Errorf("scriptinfo test \"%s\": got \"%v\""+
-					"expected \"%v\"", test.name, err,
-					test.scriptInfoErr)
-			}
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
 			continue
 		}
-		if test.scriptInfoErr != nil {
-			t.Errorf("%s: succeeded when expecting \"%v\"",
-				test.name, test.scriptInfoErr)
-			continue
+
+		steps := 0
+		for done := false; !done; {
+			done, err = engine.Step()
+			if err != nil {
+				break
+			}
+			steps++
 		}
-		if *si != test.scriptInfo {
-			t.Errorf("%s: scriptinfo doesn't match expected. "+
-				"got: \"%v\" expected \"%v\"", test.name,
-				*si, test.scriptInfo)
+		if err == nil {
+			err = engine.CheckErrorCondition()
+		}
+		if err != test.err {
+			t.Errorf("Step #%d (%s) wrong error - got %v, want %v", i,
+				test.name, err, test.err)
 			continue
 		}
+		if steps != test.steps {
+			t.Errorf("Step #%d (%s) executed %d opcodes, want %d", i,
+				test.name, steps, test.steps)
+		}
+	}
+}
+
+// TestVerifyFlagBundles ensures the flag bundles hold the expected flags and
+// that a script breaking the rule of any one member fails under them.
+func TestVerifyFlagBundles(t *testing.T) {
+	consensus := []btcscript.ScriptFlags{
+		btcscript.ScriptBip16,
+		btcscript.ScriptVerifyDERSignatures,
+		btcscript.ScriptVerifyCheckLockTimeVerify,
+		btcscript.ScriptVerifyCheckSequenceVerify,
+		btcscript.ScriptVerifyWitness,
+		btcscript.ScriptStrictMultiSig,
+	}
+	policy := []btcscript.ScriptFlags{
+		btcscript.ScriptCanonicalSignatures,
+		btcscript.ScriptVerifyLowS,
+		btcscript.ScriptVerifyMinimalData,
+		btcscript.ScriptVerifyDiscourageUpgradableWitnessProgram,
+	}
+	var want btcscript.ScriptFlags
+	for _, flag := range consensus {
+		want |= flag
+	}
+	if btcscript.ConsensusVerifyFlags != want {
+		t.Errorf("ConsensusVerifyFlags got %x, want %x",
+			btcscript.ConsensusVerifyFlags, want)
+	}
+	for _, flag := range policy {
+		want |= flag
+	}
+	if btcscript.StandardVerifyFlags != want {
+		t.Errorf("StandardVerifyFlags got %x, want %x",
+			btcscript.StandardVerifyFlags, want)
 	}
 
-	extraTests := []scriptInfoTest{
+	// Each script succeeds without flags, and fails with the bundles
+	// unless the flags of the rule it breaks are removed.
+	redeemScript := []byte{btcscript.OP_0}
+	p2sh := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_HASH160).AddData(btcutil.Hash160(redeemScript)).
+		AddOp(btcscript.OP_EQUAL))
+	badSig := builderScript(btcscript.NewScriptBuilder().
+		AddData([]byte{0x30, 0x01, 0x01}).
+		AddData(bytes.Repeat([]byte{0x02}, 33)).
+		AddOp(btcscript.OP_CHECKSIG).AddOp(btcscript.OP_NOT))
+	witnessProgram := append([]byte{btcscript.OP_0, btcscript.OP_DATA_20},
+		bytes.Repeat([]byte{0x01}, 20)...)
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		consensus bool
+		rule      btcscript.ScriptFlags
+	}{
 		{
-			// Invented scripts, the hashes do not match
-			name: "pkscript doesn't parse",
-			sigScript: []byte{btcscript.OP_TRUE,
-				btcscript.OP_DATA_1, 81,
-				btcscript.OP_DATA_8,
-				btcscript.OP_2DUP, btcscript.OP_EQUAL,
-				btcscript.OP_NOT, btcscript.OP_VERIFY,
-				btcscript.OP_ABS, btcscript.OP_SWAP,
-				btcscript.OP_ABS, btcscript.OP_EQUAL,
-			},
-			// truncated version of test below:
-			pkScript: []byte{btcscript.OP_HASH160,
-				btcscript.OP_DATA_20,
-				0xfe, 0x44, 0x10, 0x65, 0xb6, 0x53, 0x22, 0x31,
-				0xde, 0x2f, 0xac, 0x56, 0x31, 0x52, 0x20, 0x5e,
-				0xc4, 0xf5, 0x9c,
-			},
-			bip16:         true,
-			scriptInfoErr: btcscript.ErrStackShortScript,
+			name:      "pay to script hash",
+			sigScript: []byte{btcscript.OP_DATA_1, btcscript.OP_0},
+			pkScript:  p2sh,
+			consensus: true,
+			rule:      btcscript.ScriptBip16,
 		},
 		{
-			name: "sigScript doesn't parse",
-			// Truncated version of p2sh script below.
-			sigScript: []byte{btcscript.OP_TRUE,
-				btcscript.OP_DATA_1, 81,
-				btcscript.OP_DATA_8,
-				btcscript.OP_2DUP, btcscript.OP_EQUAL,
-				btcscript.OP_NOT, btcscript.OP_VERIFY,
-				btcscript.OP_ABS, btcscript.OP_SWAP,
-				btcscript.OP_ABS,
-			},
-			pkScript: []byte{btcscript.OP_HASH160,
-				btcscript.OP_DATA_20,
-				0xfe, 0x44, 0x10, 0x65, 0xb6, 0x53, 0x22, 0x31,
-				0xde, 0x2f, 0xac, 0x56, 0x31, 0x52, 0x20, 0x5e,
-				0xc4, 0xf5, 0x9c, 0x74, btcscript.OP_EQUAL,
-			},
-			bip16:         true,
-			scriptInfoErr: btcscript.ErrStackShortScript,
+			name:      "non-DER signature",
+			pkScript:  badSig,
+			consensus: true,
+			rule: btcscript.ScriptVerifyDERSignatures |
+				btcscript.ScriptCanonicalSignatures |
+				btcscript.ScriptVerifyLowS,
 		},
 		{
-			// Invented scripts, the hashes do not match
-			name: "p2sh standard script",
-			sigScript: []byte{btcscript.OP_TRUE,
-				btcscript.OP_DATA_1, 81,
-				btcscript.OP_DATA_25,
-				btcscript.OP_DUP, btcscript.OP_HASH160,
-				btcscript.OP_DATA_20, 0x1, 0x2, 0x3, 0x4, 0x5,
-				0x6, 0x7, 0x8, 0x9, 0xa, 0xb, 0xc, 0xd, 0xe,
-				0xf, 0x10, 0x11, 0x12, 0x13, 0x14,
-				btcscript.OP_EQUALVERIFY, btcscript.OP_CHECKSIG,
-			},
-			pkScript: []byte{btcscript.OP_HASH160,
-				btcscript.OP_DATA_20,
-				0xfe, 0x44, 0x10, 0x65, 0xb6, 0x53, 0x22, 0x31,
-				0xde, 0x2f, 0xac, 0x56, 0x31, 0x52, 0x20, 0x5e,
-				0xc4, 0xf5, 0x9c, 0x74, btcscript.OP_EQUAL,
-			},
-			bip16: true,
-			scriptInfo: btcscript.ScriptInfo{
-				PkScriptClass:  btcscript.ScriptHashTy,
-				NumInputs:      3,
-				ExpectedInputs: 3, // nonstandard p2sh.
-				SigOps:         1,
-			},
+			name: "lock time",
+			pkScript: []byte{btcscript.OP_1, btcscript.OP_NOP2,
+				btcscript.OP_DROP, btcscript.OP_1},
+			consensus: true,
+			rule:      btcscript.ScriptVerifyCheckLockTimeVerify,
 		},
 		{
-			// from 567a53d1ce19ce3d07711885168484439965501536d0d0294c5d46d46c10e53b
-			// from the blockchain.
-			name: "p2sh nonstandard script",
-			sigScript: []byte{btcscript.OP_TRUE,
-				btcscript.OP_DATA_1, 81,
-				btcscript.OP_DATA_8,
-				btcscript.OP_2DUP, btcscript.OP_EQUAL,
-				btcscript.OP_NOT, btcscript.OP_VERIFY,
-				btcscript.OP_ABS, btcscript.OP_SWAP,
-				btcscript.OP_ABS, btcscript.OP_EQUAL,
-			},
-			pkScript: []byte{btcscript.OP_HASH160,
-				btcscript.OP_DATA_20,
-				0xfe, 0x44, 0x10, 0x65, 0xb6, 0x53, 0x22, 0x31,
-				0xde, 0x2f, 0xac, 0x56, 0x31, 0x52, 0x20, 0x5e,
-				0xc4, 0xf5, 0x9c, 0x74, btcscript.OP_EQUAL,
-			},
-			bip16: true,
-			scriptInfo: btcscript.ScriptInfo{
-				PkScriptClass:  btcscript.ScriptHashTy,
-				NumInputs:      3,
-				ExpectedInputs: -1, // nonstandard p2sh.
-				SigOps:         0,
-			},
+			name: "sequence",
+			pkScript: []byte{btcscript.OP_1, btcscript.OP_NOP3,
+				btcscript.OP_DROP, btcscript.OP_1},
+			consensus: true,
+			rule:      btcscript.ScriptVerifyCheckSequenceVerify,
 		},
 		{
-			// Script is invented, numbers all fake.
-			name: "multisig script",
-			sigScript: []byte{btcscript.OP_TRUE,
-				btcscript.OP_TRUE, btcscript.OP_TRUE,
-				btcscript.OP_0, // Extra arg for OP_CHECKMULTISIG bug
-			},
-			pkScript: []byte{
-				btcscript.OP_3, btcscript.OP_DATA_33,
-				0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9,
-				0xa, 0xb, 0xc, 0xd, 0xe, 0xf, 0x10, 0x11, 0x12,
-				0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a,
-				0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20, 0x21,
-				btcscript.OP_DATA_33,
-				0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9,
-				0xa, 0xb, 0xc, 0xd, 0xe, 0xf, 0x10, 0x11, 0x12,
-				0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a,
-				0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20, 0x21,
-				btcscript.OP_DATA_33,
-				0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9,
-				0xa, 0xb, 0xc, 0xd, 0xe, 0xf, 0x10, 0x11, 0x12,
-				0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a,
-				0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20, 0x21,
-				btcscript.OP_3, btcscript.OP_CHECKMULTISIG,
-			},
-			bip16: true,
-			scriptInfo: btcscript.ScriptInfo{
-				PkScriptClass:  btcscript.MultiSigTy,
-				NumInputs:      4,
-				ExpectedInputs: 4,
-				SigOps:         3,
-			},
+			name:      "witness program without witness",
+			pkScript:  witnessProgram,
+			consensus: true,
+			rule:      btcscript.ScriptVerifyWitness,
+		},
+		{
+			name: "multisig dummy",
+			pkScript: []byte{btcscript.OP_1, btcscript.OP_0,
+				btcscript.OP_0, btcscript.OP_CHECKMULTISIG},
+			consensus: true,
+			rule:      btcscript.ScriptStrictMultiSig,
+		},
+		{
+			name: "non-minimal push",
+			pkScript: []byte{btcscript.OP_DATA_1, 0x05,
+				btcscript.OP_DROP, btcscript.OP_1},
+			rule: btcscript.ScriptVerifyMinimalData,
+		},
+		{
+			name: "future witness version",
+			pkScript: append([]byte{btcscript.OP_1,
+				btcscript.OP_DATA_32}, bytes.Repeat([]byte{0x01},
+				32)...),
+			rule: btcscript.ScriptVerifyDiscourageUpgradableWitnessProgram,
 		},
 	}
 
-	for _, test := range extraTests {
-		si, err := btcscript.CalcScriptInfo(test.sigScript,
-			test.pkScript, test.bip16)
+	execute := func(sigScript, pkScript []byte,
+		flags btcscript.ScriptFlags) error {
+		tx := btcwire.NewMsgTx()
+		tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+		engine, err := btcscript.NewScriptWithWitness(sigScript,
+			pkScript, nil, 0, 0, tx, flags)
 		if err != nil {
-			if err != test.scriptInfoErr {
-				t.Errorf("scriptinfo test \"%s\": got \"%v\""+
-					"expected \"%v\"", test.name, err,
-					test.scriptInfoErr)
-			}
-			continue
+			return err
 		}
-		if test.scriptInfoErr != nil {
-			t.Errorf("%s: succeeded when expecting \"%v\"",
-				test.name, test.scriptInfoErr)
+		return engine.Execute()
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if err := execute(test.sigScript, test.pkScript, 0); err != nil {
+			t.Errorf("Execute #%d (%s) without flags unexpected "+
+				"error: %v", i, test.name, err)
 			continue
 		}
-		if *si != test.scriptInfo {
-			t.Errorf("%s: scriptinfo doesn't match expected. "+
-				"got: \"%v\" expected \"%v\"", test.name,
-				*si, test.scriptInfo)
-			continue
+		err := execute(test.sigScript, test.pkScript,
+			btcscript.ConsensusVerifyFlags)
+		if (err != nil) != test.consensus {
+			t.Errorf("Execute #%d (%s) with ConsensusVerifyFlags got "+
+				"error %v, want failure %v", i, test.name, err,
+				test.consensus)
+		}
+		err = execute(test.sigScript, test.pkScript,
+			btcscript.StandardVerifyFlags)
+		if err == nil {
+			t.Errorf("Execute #%d (%s) with StandardVerifyFlags "+
+				"succeeded", i, test.name)
+		}
+		err = execute(test.sigScript, test.pkScript,
+			btcscript.StandardVerifyFlags&^test.rule)
+		if err != nil {
+			t.Errorf("Execute #%d (%s) without the rule's flags "+
+				"unexpected error: %v", i, test.name, err)
 		}
 	}
-
 }
 
-type removeOpcodeTest struct {
-	name   string
-	before []byte
-	remove byte
-	err    error
-	after  []byte
-}
+// TestCheckSequenceVerify tests OP_CHECKSEQUENCEVERIFY against the relative
+// lock time cases of BIP0112.
+func TestCheckSequenceVerify(t *testing.T) {
+	const (
+		disabled = 1 << 31
+		seconds  = 1 << 22
+	)
+
+	// csvScript returns a pkScript which checks the passed relative lock
+	// time and then succeeds.
+	csvScript := func(lockTime int64) []byte {
+		return builderScript(btcscript.NewScriptBuilder().
+			AddInt64(lockTime).
+			AddOp(btcscript.OP_CHECKSEQUENCEVERIFY).
+			AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE))
+	}
 
-var removeOpcodeTests = []removeOpcodeTest{
-	// Nothing to remove.
-	{
-		name:   "nothing to remove",
-		before: []byte{btcscript.OP_NOP},
-		remove: btcscript.OP_CODESEPARATOR,
-		after:  []byte{btcscript.OP_NOP},
-	},
-	// Test basic opcode removal
-	{
-		name: "codeseparator 1",
-		before: []byte{btcscript.OP_NOP, btcscript.OP_CODESEPARATOR,
-			btcscript.OP_TRUE},
-		remove: btcscript.OP_CODESEPARATOR,
-		after:  []byte{btcscript.OP_NOP, btcscript.OP_TRUE},
-	},
-	// The opcode in question is actually part of the data in a previous
-	// opcode
-	{
-		name: "codeseparator by coincidence",
-		before: []byte{btcscript.OP_NOP, btcscript.OP_DATA_1, btcscript.OP_CODESEPARATOR,
-			btcscript.OP_TRUE},
-		remove: btcscript.OP_CODESEPARATOR,
-		after: []byte{btcscript.OP_NOP, btcscript.OP_DATA_1, btcscript.OP_CODESEPARATOR,
-			btcscript.OP_TRUE},
-	},
-	{
-		name:   "invalid opcode",
-		before: []byte{btcscript.OP_UNKNOWN186},
-		remove: btcscript.OP_CODESEPARATOR,
-		after:  []byte{btcscript.OP_UNKNOWN186},
-	},
-	{
-		name:   "invalid length (insruction)",
-		before: []byte{btcscript.OP_PUSHDATA1},
-		remove: btcscript.OP_CODESEPARATOR,
-		err:    btcscript.ErrStackShortScript,
-	},
-	{
-		name:   "invalid length (data)",
-		before: []byte{btcscript.OP_PUSHDATA1, 255, 254},
-		remove: btcscript.OP_CODESEPARATOR,
-		err:    btcscript.ErrStackShortScript,
-	},
-}
+	tests := []struct {
+		name     string
+		pkScript []byte
+		version  int32
+		sequence uint32
+		noFlag   bool
+		minimal  bool
+		err      error
+	}{
+		{
+			name:     "blocks satisfied exactly",
+			pkScript: csvScript(10),
+			version:  2,
+			sequence: 10,
+		},
+		{
+			name:     "blocks satisfied",
+			pkScript: csvScript(10),
+			version:  2,
+			sequence: 11,
+		},
+		{
+			name:     "blocks unsatisfied",
+			pkScript: csvScript(10),
+			version:  2,
+			sequence: 9,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "seconds satisfied",
+			pkScript: csvScript(seconds | 5),
+			version:  2,
+			sequence: seconds | 5,
+		},
+		{
+			name:     "seconds unsatisfied",
+			pkScript: csvScript(seconds | 5),
+			version:  2,
+			sequence: seconds | 4,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "blocks required, seconds given",
+			pkScript: csvScript(5),
+			version:  2,
+			sequence: seconds | 5,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "seconds required, blocks given",
+			pkScript: csvScript(seconds | 5),
+			version:  2,
+			sequence: 5,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "bits outside the mask are ignored",
+			pkScript: csvScript(1<<16 | 5),
+			version:  2,
+			sequence: 5,
+		},
+		{
+			name:     "version 1 transaction",
+			pkScript: csvScript(10),
+			version:  1,
+			sequence: 10,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "negative version is a high version",
+			pkScript: csvScript(10),
+			version:  -1,
+			sequence: 10,
+		},
+		{
+			name:     "negative version unsatisfied",
+			pkScript: csvScript(10),
+			version:  -0x80000000,
+			sequence: 9,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "input sequence disabled",
+			pkScript: csvScript(10),
+			version:  2,
+			sequence: disabled | 10,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "operand disabled is a nop",
+			pkScript: csvScript(disabled),
+			version:  1,
+			sequence: 0xffffffff,
+		},
+		{
+			name:     "negative operand",
+			pkScript: csvScript(-1),
+			version:  2,
+			sequence: 10,
+			err:      btcscript.ErrStackNegativeLockTime,
+		},
+		{
+			name: "operand over 5 bytes",
+			pkScript: builderScript(btcscript.NewScriptBuilder().
+				AddData([]byte{1, 0, 0, 0, 0, 0}).
+				AddOp(btcscript.OP_CHECKSEQUENCEVERIFY)),
+			version:  2,
+			sequence: 10,
+			err:      btcscript.ErrStackNumberTooBig,
+		},
+		{
+			name: "empty stack",
+			pkScript: []byte{btcscript.OP_CHECKSEQUENCEVERIFY,
+				btcscript.OP_TRUE},
+			version:  2,
+			sequence: 10,
+			err:      btcscript.ErrStackUnderflow,
+		},
+		{
+			name:     "unsatisfied without flag is a nop",
+			pkScript: csvScript(10),
+			version:  1,
+			sequence: 0,
+			noFlag:   true,
+		},
+		{
+			name: "non-minimal operand",
+			pkScript: builderScript(btcscript.NewScriptBuilder().
+				AddData([]byte{10, 0}).
+				AddOp(btcscript.OP_CHECKSEQUENCEVERIFY).
+				AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE)),
+			version:  2,
+			sequence: 10,
+		},
+		{
+			name: "non-minimal operand with minimal data",
+			pkScript: builderScript(btcscript.NewScriptBuilder().
+				AddData([]byte{10, 0}).
+				AddOp(btcscript.OP_CHECKSEQUENCEVERIFY).
+				AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE)),
+			version:  2,
+			sequence: 10,
+			minimal:  true,
+			err:      btcscript.ErrStackMinimalData,
+		},
+		{
+			name: "negative zero operand with minimal data",
+			pkScript: builderScript(btcscript.NewScriptBuilder().
+				AddData([]byte{0x80}).
+				AddOp(btcscript.OP_CHECKSEQUENCEVERIFY).
+				AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE)),
+			version:  2,
+			sequence: 10,
+			minimal:  true,
+			err:      btcscript.ErrStackMinimalData,
+		},
+		{
+			name:     "operand needing a sign byte with minimal data",
+			pkScript: csvScript(0x80),
+			version:  2,
+			sequence: 0x80,
+			minimal:  true,
+		},
+	}
 
-func testRemoveOpcode(t *testing.T, test *removeOpcodeTest) {
-	result, err := btcscript.TstRemoveOpcode(test.before, test.remove)
-	if test.err != nil {
+	for _, test := range tests {
+		tx := btcwire.NewMsgTx()
+		tx.Version = test.version
+		txIn := btcwire.NewTxIn(&btcwire.OutPoint{}, nil)
+		txIn.Sequence = test.sequence
+		tx.AddTxIn(txIn)
+
+		flags := btcscript.ScriptVerifyCheckSequenceVerify
+		if test.noFlag {
+			flags = 0
+		}
+		if test.minimal {
+			flags |= btcscript.ScriptVerifyMinimalData
+		}
+		engine, err := btcscript.NewScript(nil, test.pkScript, 0, tx,
+			flags)
+		if err != nil {
+			t.Errorf("%s: failed to create script: %v", test.name,
+				err)
+			continue
+		}
+		err = engine.Execute()
 		if err != test.err {
-			t.Errorf("%s: got unexpected error. exp: \"%v\" "+
-				"got: \"%v\"", test.name, test.err, err)
+			t.Errorf("%s: unexpected error: got %v, want %v",
+				test.name, err, test.err)
 		}
-		return
-	}
-	if err != nil {
-		t.Errorf("%s: unexpected failure: \"%v\"", test.name, err)
-		return
-	}
-	if !bytes.Equal(test.after, result) {
-		t.Errorf("%s: value does not equal expected: exp: \"%v\""+
-			" got: \"%v\"", test.name, test.after, result)
 	}
 }
 
-func TestRemoveOpcodes(t *testing.T) {
-	for i := range removeOpcodeTests {
-		testRemoveOpcode(t, &removeOpcodeTests[i])
+// TestSequenceEnablesRBF ensures sequence numbers signal replacement below the
+// BIP0125 threshold and that every sequence number satisfying a relative lock
+// time of zero with OP_CHECKSEQUENCEVERIFY signals it.
+func TestSequenceEnablesRBF(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence uint32
+		rbf      bool
+		csv      bool
+	}{
+		{"zero", 0, true, true},
+		{"relative lock time", 10, true, true},
+		{"highest relative lock time in blocks", 0x7fbfffff, true,
+			true},
+		{"relative lock time disabled", 0x80000000, true, false},
+		{"highest signalling", 0xfffffffd, true, false},
+		{"lowest not signalling", 0xfffffffe, false, false},
+		{"final", 0xffffffff, false, false},
 	}
-}
 
-type removeOpcodeByDataTest struct {
-	name   string
-	before []byte
-	remove []byte
-	err    error
-	after  []byte
+	pkScript := builderScript(btcscript.NewScriptBuilder().AddInt64(0).
+		AddOp(btcscript.OP_CHECKSEQUENCEVERIFY).
+		AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE))
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		rbf := btcscript.SequenceEnablesRBF(test.sequence)
+		if rbf != test.rbf {
+			t.Errorf("SequenceEnablesRBF #%d (%s) got %v, want %v", i,
+				test.name, rbf, test.rbf)
+		}
+
+		tx := btcwire.NewMsgTx()
+		tx.Version = 2
+		txIn := btcwire.NewTxIn(&btcwire.OutPoint{}, nil)
+		txIn.Sequence = test.sequence
+		tx.AddTxIn(txIn)
+		engine, err := btcscript.NewScript(nil, pkScript, 0, tx,
+			btcscript.ScriptVerifyCheckSequenceVerify)
+		if err != nil {
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		csv := engine.Execute() == nil
+		if csv != test.csv {
+			t.Errorf("Execute #%d (%s) passed %v, want %v", i,
+				test.name, csv, test.csv)
+		}
+		if csv && !rbf {
+			t.Errorf("SequenceEnablesRBF #%d (%s) false for a "+
+				"sequence satisfying OP_CHECKSEQUENCEVERIFY", i,
+				test.name)
+		}
+	}
 }
 
-var removeOpcodeByDataTests = []removeOpcodeByDataTest{
-	{
-		name:   "nothing to do",
-		before: []byte{btcscript.OP_NOP},
-		remove: []byte{1, 2, 3, 4},
-		after:  []byte{btcscript.OP_NOP},
-	},
-	{
-		name:   "simple case",
-		before: []byte{btcscript.OP_DATA_4, 1, 2, 3, 4},
-		remove: []byte{1, 2, 3, 4},
-		after:  []byte{},
-	},
-	{
-		name:   "simple case (miss)",
-		before: []byte{btcscript.OP_DATA_4, 1, 2, 3, 4},
-		remove: []byte{1, 2, 3, 5},
-		after:  []byte{btcscript.OP_DATA_4, 1, 2, 3, 4},
-	},
-	{
-		// padded to keep it canonical.
-		name: "simple case (pushdata1)",
-		before: append(append([]byte{btcscript.OP_PUSHDATA1, 76},
-			bytes.Repeat([]byte{0}, 72)...), []byte{1, 2, 3, 4}...),
-		remove: []byte{1, 2, 3, 4},
-		after:  []byte{},
-	},
-	{
-		name: "simple case (pushdata1 miss)",
-		before: append(append([]byte{btcscript.OP_PUSHDATA1, 76},
+// TestCheckLockTimeVerify tests OP_CHECKLOCKTIMEVERIFY against the lock time
+// cases of BIP0065.
+func TestCheckLockTimeVerify(t *testing.T) {
+	const threshold = 500000000
+
+	// cltvScript returns a pkScript which checks the passed lock time and
+	// then succeeds.
+	cltvScript := func(lockTime int64) []byte {
+		return builderScript(btcscript.NewScriptBuilder().
+			AddInt64(lockTime).
+			AddOp(btcscript.OP_CHECKLOCKTIMEVERIFY).
+			AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE))
+	}
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+		lockTime uint32
+		sequence uint32
+		noFlag   bool
+		minimal  bool
+		err      error
+	}{
+		{
+			name:     "height satisfied exactly",
+			pkScript: cltvScript(100),
+			lockTime: 100,
+		},
+		{
+			name:     "height satisfied",
+			pkScript: cltvScript(100),
+			lockTime: 101,
+		},
+		{
+			name:     "height unsatisfied",
+			pkScript: cltvScript(100),
+			lockTime: 99,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "time satisfied",
+			pkScript: cltvScript(threshold + 10),
+			lockTime: threshold + 10,
+		},
+		{
+			name:     "time unsatisfied",
+			pkScript: cltvScript(threshold + 10),
+			lockTime: threshold + 9,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "height required, time given",
+			pkScript: cltvScript(threshold - 1),
+			lockTime: threshold,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "time required, height given",
+			pkScript: cltvScript(threshold),
+			lockTime: threshold - 1,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "max time needing 5 bytes",
+			pkScript: cltvScript(0xffffffff),
+			lockTime: 0xffffffff,
+		},
+		{
+			name:     "finalized input",
+			pkScript: cltvScript(100),
+			lockTime: 100,
+			sequence: btcwire.MaxTxInSequenceNum,
+			err:      btcscript.ErrStackUnsatisfiedLockTime,
+		},
+		{
+			name:     "negative operand",
+			pkScript: cltvScript(-1),
+			lockTime: 100,
+			err:      btcscript.ErrStackNegativeLockTime,
+		},
+		{
+			name: "operand over 5 bytes",
+			pkScript: builderScript(btcscript.NewScriptBuilder().
+				AddData([]byte{1, 0, 0, 0, 0, 0}).
+				AddOp(btcscript.OP_CHECKLOCKTIMEVERIFY)),
+			lockTime: 100,
+			err:      btcscript.ErrStackNumberTooBig,
+		},
+		{
+			name: "empty stack",
+			pkScript: []byte{btcscript.OP_CHECKLOCKTIMEVERIFY,
+				btcscript.OP_TRUE},
+			lockTime: 100,
+			err:      btcscript.ErrStackUnderflow,
+		},
+		{
+			name:     "unsatisfied without flag is a nop",
+			pkScript: cltvScript(100),
+			lockTime: 0,
+			sequence: btcwire.MaxTxInSequenceNum,
+			noFlag:   true,
+		},
+		{
+			name: "non-minimal operand",
+			pkScript: builderScript(btcscript.NewScriptBuilder().
+				AddData([]byte{100, 0, 0, 0, 0}).
+				AddOp(btcscript.OP_CHECKLOCKTIMEVERIFY).
+				AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE)),
+			lockTime: 100,
+		},
+		{
+			name: "non-minimal operand with minimal data",
+			pkScript: builderScript(btcscript.NewScriptBuilder().
+				AddData([]byte{100, 0, 0, 0, 0}).
+				AddOp(btcscript.OP_CHECKLOCKTIMEVERIFY).
+				AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE)),
+			lockTime: 100,
+			minimal:  true,
+			err:      btcscript.ErrStackMinimalData,
+		},
+		{
+			name:     "5 byte operand with minimal data",
+			pkScript: cltvScript(0xffffffff),
+			lockTime: 0xffffffff,
+			minimal:  true,
+		},
+	}
+
+	for _, test := range tests {
+		tx := btcwire.NewMsgTx()
+		tx.LockTime = test.lockTime
+		txIn := btcwire.NewTxIn(&btcwire.OutPoint{}, nil)
+		txIn.Sequence = test.sequence
+		tx.AddTxIn(txIn)
+
+		flags := btcscript.ScriptVerifyCheckLockTimeVerify
+		if test.noFlag {
+			flags = 0
+		}
+		if test.minimal {
+			flags |= btcscript.ScriptVerifyMinimalData
+		}
+		engine, err := btcscript.NewScript(nil, test.pkScript, 0, tx,
+			flags)
+		if err != nil {
+			t.Errorf("%s: failed to create script: %v", test.name,
+				err)
+			continue
+		}
+		err = engine.Execute()
+		if err != test.err {
+			t.Errorf("%s: unexpected error: got %v, want %v",
+				test.name, err, test.err)
+		}
+	}
+}
+
+// TestScriptLimits ensures the element size and operation limits passed to
+// NewScriptWithLimits are enforced and that the defaults are unchanged.
+func TestScriptLimits(t *testing.T) {
+	// pushScript returns a pkScript which pushes size bytes and then
+	// succeeds.
+	pushScript := func(size int) []byte {
+		return builderScript(btcscript.NewScriptBuilder().
+			AddFullData(bytes.Repeat([]byte{0x01}, size)).
+			AddOp(btcscript.OP_DROP).AddOp(btcscript.OP_TRUE))
+	}
+	// nopScript returns a pkScript which runs n OP_NOP operations and
+	// then succeeds.
+	nopScript := func(n int) []byte {
+		return append(bytes.Repeat([]byte{btcscript.OP_NOP}, n),
+			btcscript.OP_TRUE)
+	}
+	// multiSigScript returns a pkScript running a 0-of-n multisig check
+	// with empty pubkeys.
+	multiSigScript := func(n int) []byte {
+		builder := btcscript.NewScriptBuilder().AddOp(btcscript.OP_0).
+			AddOp(btcscript.OP_0)
+		for i := 0; i < n; i++ {
+			builder.AddOp(btcscript.OP_0)
+		}
+		return builderScript(builder.AddInt64(int64(n)).
+			AddOp(btcscript.OP_CHECKMULTISIG))
+	}
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+		limits   btcscript.ScriptLimits
+		err      error
+	}{
+		{
+			name:     "default element size",
+			pkScript: pushScript(btcscript.MaxScriptElementSize),
+		},
+		{
+			name:     "default element size exceeded",
+			pkScript: pushScript(btcscript.MaxScriptElementSize + 1),
+			err:      btcscript.ErrStackElementTooBig,
+		},
+		{
+			name:     "small element size",
+			pkScript: pushScript(10),
+			limits:   btcscript.ScriptLimits{MaxElementSize: 10},
+		},
+		{
+			name:     "small element size exceeded",
+			pkScript: pushScript(11),
+			limits:   btcscript.ScriptLimits{MaxElementSize: 10},
+			err:      btcscript.ErrStackElementTooBig,
+		},
+		{
+			name:     "large element size",
+			pkScript: pushScript(1000),
+			limits:   btcscript.ScriptLimits{MaxElementSize: 1000},
+		},
+		{
+			name:     "default ops",
+			pkScript: nopScript(btcscript.MaxOpsPerScript),
+		},
+		{
+			name:     "default ops exceeded",
+			pkScript: nopScript(btcscript.MaxOpsPerScript + 1),
+			err:      btcscript.ErrStackTooManyOperations,
+		},
+		{
+			name:     "small ops",
+			pkScript: nopScript(3),
+			limits:   btcscript.ScriptLimits{MaxOps: 3},
+		},
+		{
+			name:     "small ops exceeded",
+			pkScript: nopScript(4),
+			limits:   btcscript.ScriptLimits{MaxOps: 3},
+			err:      btcscript.ErrStackTooManyOperations,
+		},
+		{
+			name:     "large ops",
+			pkScript: nopScript(500),
+			limits:   btcscript.ScriptLimits{MaxOps: 500},
+		},
+		{
+			name:     "multisig pubkeys count as ops",
+			pkScript: multiSigScript(3),
+			limits:   btcscript.ScriptLimits{MaxOps: 4},
+		},
+		{
+			name:     "multisig pubkeys exceed ops",
+			pkScript: multiSigScript(4),
+			limits:   btcscript.ScriptLimits{MaxOps: 4},
+			err:      btcscript.ErrStackTooManyOperations,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		tx := btcwire.NewMsgTx()
+		tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+		engine, err := btcscript.NewScriptWithLimits(nil, test.pkScript,
+			0, tx, 0, test.limits)
+		if err != nil {
+			t.Errorf("NewScriptWithLimits #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		err = engine.Execute()
+		if err != test.err {
+			t.Errorf("Execute #%d (%s) wrong error - got %v, want %v",
+				i, test.name, err, test.err)
+		}
+	}
+}
+
+// TestScriptBudget ensures execution is aborted once the total cost of the
+// opcodes executed exceeds the budget passed to NewScriptWithLimits.
+func TestScriptBudget(t *testing.T) {
+	// hashScript pushes a value and hashes it n times, costing 1 + 10n
+	// with the default costs.
+	hashScript := func(n int) []byte {
+		return append([]byte{btcscript.OP_1},
+			bytes.Repeat([]byte{btcscript.OP_SHA256}, n)...)
+	}
+	// A branch which is not taken still costs 1 + 1 + 10 + 1 + 1.
+	branchScript := []byte{btcscript.OP_0, btcscript.OP_IF,
+		btcscript.OP_SHA256, btcscript.OP_ENDIF, btcscript.OP_1}
+	unitCost := func(byte) int64 { return 1 }
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		limits    btcscript.ScriptLimits
+		err       error
+	}{
+		{
+			name:     "no budget",
+			pkScript: hashScript(100),
+		},
+		{
+			name:     "tiny budget",
+			pkScript: hashScript(100),
+			limits:   btcscript.ScriptLimits{Budget: 20},
+			err:      btcscript.ErrBudgetExceeded,
+		},
+		{
+			name:     "budget met exactly",
+			pkScript: hashScript(20),
+			limits:   btcscript.ScriptLimits{Budget: 201},
+		},
+		{
+			name:     "budget exceeded by one",
+			pkScript: hashScript(20),
+			limits:   btcscript.ScriptLimits{Budget: 200},
+			err:      btcscript.ErrBudgetExceeded,
+		},
+		{
+			name:      "budget covers signature script",
+			sigScript: []byte{btcscript.OP_1, btcscript.OP_1},
+			pkScript:  []byte{btcscript.OP_DROP},
+			limits:    btcscript.ScriptLimits{Budget: 2},
+			err:       btcscript.ErrBudgetExceeded,
+		},
+		{
+			name:     "branch not taken",
+			pkScript: branchScript,
+			limits:   btcscript.ScriptLimits{Budget: 14},
+		},
+		{
+			name:     "branch not taken exceeded",
+			pkScript: branchScript,
+			limits:   btcscript.ScriptLimits{Budget: 13},
+			err:      btcscript.ErrBudgetExceeded,
+		},
+		{
+			name:     "custom costs",
+			pkScript: hashScript(20),
+			limits: btcscript.ScriptLimits{Budget: 21,
+				OpcodeCost: unitCost},
+		},
+		{
+			name:     "custom costs exceeded",
+			pkScript: hashScript(20),
+			limits: btcscript.ScriptLimits{Budget: 20,
+				OpcodeCost: unitCost},
+			err: btcscript.ErrBudgetExceeded,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		tx := btcwire.NewMsgTx()
+		tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+		engine, err := btcscript.NewScriptWithLimits(test.sigScript,
+			test.pkScript, 0, tx, 0, test.limits)
+		if err != nil {
+			t.Errorf("NewScriptWithLimits #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		err = engine.Execute()
+		if err != test.err {
+			t.Errorf("Execute #%d (%s) wrong error - got %v, want %v",
+				i, test.name, err, test.err)
+			continue
+		}
+		if test.err != nil && !btcscript.IsErrorCode(err,
+			btcscript.ErrCodeBudgetExceeded) {
+			t.Errorf("Execute #%d (%s) wrong error code - got %v", i,
+				test.name, err)
+		}
+	}
+}
+
+// TestFinalStack ensures the stack left when execution stops is returned,
+// including the item popped when checking the result of the scripts.
+func TestFinalStack(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		flags     btcscript.ScriptFlags
+		err       error
+		want      [][]byte
+	}{
+		{
+			name:      "leaves true",
+			sigScript: []byte{btcscript.OP_2},
+			pkScript: []byte{btcscript.OP_DATA_2, 0xab, 0xcd,
+				btcscript.OP_1},
+			want: [][]byte{{0x02}, {0xab, 0xcd}, {0x01}},
+		},
+		{
+			name:     "leaves false",
+			pkScript: []byte{btcscript.OP_3, btcscript.OP_0},
+			err:      btcscript.ErrStackScriptFailed,
+			want:     [][]byte{{0x03}, {}},
+		},
+		{
+			name: "fails mid-way",
+			pkScript: []byte{btcscript.OP_4, btcscript.OP_5,
+				btcscript.OP_EQUALVERIFY, btcscript.OP_6},
+			err:  btcscript.ErrStackVerifyFailed,
+			want: [][]byte{},
+		},
+		{
+			name: "pay to script hash",
+			sigScript: []byte{btcscript.OP_8, btcscript.OP_DATA_2,
+				btcscript.OP_DROP, btcscript.OP_1},
+			pkScript: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_HASH160).
+				AddData(btcutil.Hash160([]byte{btcscript.OP_DROP,
+					btcscript.OP_1})).
+				AddOp(btcscript.OP_EQUAL)),
+			flags: btcscript.ScriptBip16,
+			want:  [][]byte{{0x01}},
+		},
+		{
+			name: "fails in the first script",
+			sigScript: []byte{btcscript.OP_7, btcscript.OP_DROP,
+				btcscript.OP_DROP},
+			pkScript: []byte{btcscript.OP_1},
+			err:      btcscript.ErrStackUnderflow,
+			want:     [][]byte{},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		engine, err := btcscript.NewScript(test.sigScript,
+			test.pkScript, 0, tx, test.flags)
+		if err != nil {
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if err := engine.Execute(); err != test.err {
+			t.Errorf("Execute #%d (%s) wrong error - got %v, want %v",
+				i, test.name, err, test.err)
+			continue
+		}
+		got := engine.FinalStack()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("FinalStack #%d (%s) got %x, want %x", i,
+				test.name, got, test.want)
+			continue
+		}
+
+		// The result is a copy.
+		if len(got) != 0 && len(got[0]) != 0 {
+			got[0][0] ^= 0xff
+			if reflect.DeepEqual(engine.FinalStack(), got) {
+				t.Errorf("FinalStack #%d (%s) shares memory "+
+					"with the engine", i, test.name)
+			}
+		}
+	}
+}
+
+// TestOpsRemaining ensures the number of non-push operations left decreases
+// only for the opcodes which count against the limit.
+func TestOpsRemaining(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	// Each opcode of the script along with the number of operations it
+	// counts as.
+	steps := []struct {
+		op   []byte
+		cost int
+	}{
+		{[]byte{btcscript.OP_0}, 0},
+		{[]byte{btcscript.OP_0}, 0},
+		{[]byte{btcscript.OP_CHECKSIG}, 1},
+		{[]byte{btcscript.OP_DROP}, 1},
+		{[]byte{btcscript.OP_DATA_1, 0x01}, 0},
+		{[]byte{btcscript.OP_0}, 0},
+		{[]byte{btcscript.OP_CHECKSIG}, 1},
+		{[]byte{btcscript.OP_2DROP}, 1},
+		{[]byte{btcscript.OP_0}, 0},
+		{[]byte{btcscript.OP_IF}, 1},
+		{[]byte{btcscript.OP_RESERVED}, 0},
+		{[]byte{btcscript.OP_CHECKSIG}, 1},
+		{[]byte{btcscript.OP_ENDIF}, 1},
+		{[]byte{btcscript.OP_0}, 0},
+		{[]byte{btcscript.OP_0}, 0},
+		{[]byte{btcscript.OP_DATA_1, 0x02}, 0},
+		{[]byte{btcscript.OP_DATA_1, 0x03}, 0},
+		{[]byte{btcscript.OP_2}, 0},
+		{[]byte{btcscript.OP_CHECKMULTISIG}, 3},
+	}
+	var pkScript []byte
+	for _, step := range steps {
+		pkScript = append(pkScript, step.op...)
+	}
+
+	engine, err := btcscript.NewScript([]byte{btcscript.OP_1}, pkScript, 0,
+		tx, 0)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	if got := engine.OpsRemaining(); got != btcscript.MaxOpsPerScript {
+		t.Fatalf("OpsRemaining before execution got %d, want %d", got,
+			btcscript.MaxOpsPerScript)
+	}
+
+	// The signature script pushes an item.
+	if _, err := engine.Step(); err != nil {
+		t.Fatalf("Step unexpected error: %v", err)
+	}
+	want := btcscript.MaxOpsPerScript
+	t.Logf("Running %d tests", len(steps))
+	for i, step := range steps {
+		done, err := engine.Step()
+		if err != nil {
+			t.Fatalf("Step #%d unexpected error: %v", i, err)
+		}
+		want -= step.cost
+		if done {
+			// The count starts again once the script ends.
+			want = btcscript.MaxOpsPerScript
+		}
+		if got := engine.OpsRemaining(); got != want {
+			t.Errorf("OpsRemaining #%d (%x) got %d, want %d", i,
+				step.op, got, want)
+		}
+		if done != (i == len(steps)-1) {
+			t.Fatalf("Step #%d got done %v", i, done)
+		}
+	}
+	if err := engine.CheckErrorCondition(); err != nil {
+		t.Errorf("CheckErrorCondition unexpected error: %v", err)
+	}
+
+	// Going over the limit leaves none.
+	engine, err = btcscript.NewScriptWithLimits(nil,
+		[]byte{btcscript.OP_1, btcscript.OP_DUP, btcscript.OP_DUP}, 0,
+		tx, 0, btcscript.ScriptLimits{MaxOps: 1})
+	if err != nil {
+		t.Fatalf("NewScriptWithLimits unexpected error: %v", err)
+	}
+	if err := engine.Execute(); err != btcscript.ErrStackTooManyOperations {
+		t.Errorf("Execute wrong error - got %v, want %v", err,
+			btcscript.ErrStackTooManyOperations)
+	}
+	if got := engine.OpsRemaining(); got != 0 {
+		t.Errorf("OpsRemaining over the limit got %d, want 0", got)
+	}
+}
+
+// TestScriptRelease ensures script engines created after another engine has
+// been released start with empty stacks and execute normally.
+func TestScriptRelease(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	// Leave items on both stacks by stopping before the end of the
+	// script, since the alt stack is emptied between scripts.
+	pkScript := []byte{btcscript.OP_1, btcscript.OP_2, btcscript.OP_3,
+		btcscript.OP_TOALTSTACK, btcscript.OP_TRUE}
+	for i := 0; i < 10; i++ {
+		engine, err := btcscript.NewScript(nil, pkScript, 0, tx, 0)
+		if err != nil {
+			t.Fatalf("NewScript #%d unexpected error: %v", i, err)
+		}
+		if len(engine.GetStack()) != 0 || len(engine.GetAltStack()) != 0 {
+			t.Fatalf("NewScript #%d reused stack not empty: %x %x",
+				i, engine.GetStack(), engine.GetAltStack())
+		}
+		for j := 0; j < 4; j++ {
+			if _, err := engine.Step(); err != nil {
+				t.Fatalf("Step #%d unexpected error: %v", i, err)
+			}
+		}
+		if len(engine.GetStack()) != 2 || len(engine.GetAltStack()) != 1 {
+			t.Fatalf("Step #%d wrong stacks: %x %x", i,
+				engine.GetStack(), engine.GetAltStack())
+		}
+		engine.Release()
+		// Releasing more than once is harmless.
+		engine.Release()
+	}
+
+	engine, err := btcscript.NewScript(nil, pkScript, 0, tx, 0)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	if err := engine.Execute(); err != nil {
+		t.Fatalf("Execute unexpected error: %v", err)
+	}
+	// The final true value is consumed by the success check.
+	if want := [][]byte{{1}, {2}}; !reflect.DeepEqual(
+		engine.GetStack(), want) {
+		t.Fatalf("Execute wrong stack: got %x, want %x",
+			engine.GetStack(), want)
+	}
+	engine.Release()
+}
+
+// TestScriptClone ensures a cloned script engine can be stepped to completion
+// without affecting the original, which then reaches the same result.
+func TestScriptClone(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	// Use a pay-to-script-hash redeem script with a conditional so that
+	// the saved first stack and condition stack are exercised.
+	redeemScript := []byte{btcscript.OP_IF, btcscript.OP_2,
+		btcscript.OP_TOALTSTACK, btcscript.OP_FROMALTSTACK,
+		btcscript.OP_ELSE, btcscript.OP_3, btcscript.OP_ENDIF,
+		btcscript.OP_2, btcscript.OP_EQUAL}
+	scriptAddr, err := btcutil.NewAddressScriptHash(redeemScript,
+		&btcnet.MainNetParams)
+	if err != nil {
+		t.Fatalf("failed to make p2sh addr: %v", err)
+	}
+	pkScript, err := btcscript.PayToAddrScript(scriptAddr)
+	if err != nil {
+		t.Fatalf("failed to make p2sh script: %v", err)
+	}
+	sigScript := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_1).AddData(redeemScript))
+
+	// run steps the passed engine to completion and returns the final
+	// stack along with the error from the final success check.
+	run := func(engine *btcscript.Script) ([][]byte, error) {
+		for {
+			done, err := engine.Step()
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return engine.GetStack(),
+					engine.CheckErrorCondition()
+			}
+		}
+	}
+
+	for cloneAt := 0; cloneAt < 14; cloneAt++ {
+		engine, err := btcscript.NewScript(sigScript, pkScript, 0, tx,
+			btcscript.ScriptBip16)
+		if err != nil {
+			t.Fatalf("NewScript unexpected error: %v", err)
+		}
+		for i := 0; i < cloneAt; i++ {
+			if _, err := engine.Step(); err != nil {
+				t.Fatalf("Step %d unexpected error: %v", i, err)
+			}
+		}
+
+		pc, _ := engine.DisasmPC()
+		stack, altStack := engine.GetStack(), engine.GetAltStack()
+
+		clone := engine.Clone()
+		cloneStack, cloneErr := run(clone)
+		if cloneErr != nil {
+			t.Errorf("Clone at %d unexpected error: %v", cloneAt,
+				cloneErr)
+			continue
+		}
+
+		// Stepping the clone must not have changed the original.
+		if newPC, _ := engine.DisasmPC(); newPC != pc {
+			t.Errorf("Clone at %d changed original pc: got %q, "+
+				"want %q", cloneAt, newPC, pc)
+		}
+		if !reflect.DeepEqual(engine.GetStack(), stack) ||
+			!reflect.DeepEqual(engine.GetAltStack(), altStack) {
+			t.Errorf("Clone at %d changed original stacks", cloneAt)
+		}
+
+		origStack, origErr := run(engine)
+		if origErr != nil {
+			t.Errorf("Clone at %d original unexpected error: %v",
+				cloneAt, origErr)
+			continue
+		}
+		if !reflect.DeepEqual(origStack, cloneStack) {
+			t.Errorf("Clone at %d different final stacks: got %x, "+
+				"want %x", cloneAt, cloneStack, origStack)
+		}
+	}
+}
+
+// TestScriptTrace ensures an installed trace function is called before each
+// opcode with the position, opcode and stack, and that changing what it is
+// passed does not affect execution.
+func TestScriptTrace(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	sigScript := []byte{btcscript.OP_1, btcscript.OP_2}
+	pkScript := []byte{btcscript.OP_ADD, btcscript.OP_DATA_1, 0x03,
+		btcscript.OP_EQUAL}
+
+	type traceEntry struct {
+		scriptidx int
+		scriptoff int
+		opcode    byte
+		data      []byte
+		depth     int
+	}
+	want := []traceEntry{
+		{0, 0, btcscript.OP_1, nil, 0},
+		{0, 1, btcscript.OP_2, nil, 1},
+		{1, 0, btcscript.OP_ADD, nil, 2},
+		{1, 1, btcscript.OP_DATA_1, []byte{0x03}, 1},
+		{1, 2, btcscript.OP_EQUAL, nil, 2},
+	}
+
+	engine, err := btcscript.NewScript(sigScript, pkScript, 0, tx, 0)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	var got []traceEntry
+	engine.SetTraceFunc(func(scriptidx, scriptoff int, opcode byte,
+		data []byte, stack [][]byte) {
+		got = append(got, traceEntry{scriptidx, scriptoff, opcode,
+			append([]byte(nil), data...), len(stack)})
+
+		// Scribble over everything passed in, which must not
+		// change the result of executing the script.
+		for i := range data {
+			data[i] = 0xff
+		}
+		for _, item := range stack {
+			for i := range item {
+				item[i] = 0xff
+			}
+		}
+	})
+	if err := engine.Execute(); err != nil {
+		t.Fatalf("Execute unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Execute wrong trace:\ngot: %v\nwant: %v", got, want)
+	}
+
+	// Removing the trace function stops further calls.
+	engine, err = btcscript.NewScript(sigScript, pkScript, 0, tx, 0)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	got = nil
+	engine.SetTraceFunc(func(int, int, byte, []byte, [][]byte) {
+		t.Errorf("trace function called after removal")
+	})
+	engine.SetTraceFunc(nil)
+	if err := engine.Execute(); err != nil {
+		t.Fatalf("Execute unexpected error: %v", err)
+	}
+}
+
+// TestStepDetailed ensures the opcodes executed by StepDetailed are reported
+// along with the items they pop from and push to the data stack.
+func TestStepDetailed(t *testing.T) {
+	tx := sigHashTestTx()
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("failed to make key: %v", err)
+	}
+	pk := (*btcec.PublicKey)(&key.PublicKey).SerializeCompressed()
+	pkScript := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_2).AddOp(btcscript.OP_3).
+		AddOp(btcscript.OP_ADD).AddOp(btcscript.OP_DROP).
+		AddData(pk).AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_DROP).
+		AddOp(btcscript.OP_CHECKSIG))
+	sig, err := sigHashTestSign(tx, pkScript, btcscript.SigHashAll, key)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	sigScript := builderScript(btcscript.NewScriptBuilder().AddData(sig))
+
+	tests := []struct {
+		name   string
+		opcode byte
+		popped [][]byte
+		pushed [][]byte
+	}{
+		{"push signature", byte(len(sig)), nil, [][]byte{sig}},
+		{"OP_2", btcscript.OP_2, nil, [][]byte{{2}}},
+		{"OP_3", btcscript.OP_3, nil, [][]byte{{3}}},
+		{"OP_ADD", btcscript.OP_ADD, [][]byte{{2}, {3}}, [][]byte{{5}}},
+		{"OP_DROP", btcscript.OP_DROP, [][]byte{{5}}, nil},
+		{"push pubkey", btcscript.OP_DATA_33, nil, [][]byte{pk}},
+		{"OP_DUP", btcscript.OP_DUP, nil, [][]byte{pk}},
+		{"OP_DROP pubkey", btcscript.OP_DROP, [][]byte{pk}, nil},
+		{"OP_CHECKSIG", btcscript.OP_CHECKSIG, [][]byte{sig, pk},
+			[][]byte{{1}}},
+	}
+
+	engine, err := btcscript.NewScript(sigScript, pkScript, 0, tx, 0)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result, err := engine.StepDetailed()
+		if err != nil {
+			t.Fatalf("StepDetailed #%d (%s) unexpected error: %v", i,
+				test.name, err)
+		}
+		if result.Opcode != test.opcode {
+			t.Errorf("StepDetailed #%d (%s) wrong opcode - got %02x, "+
+				"want %02x", i, test.name, result.Opcode,
+				test.opcode)
+		}
+		if !stacksEqual(result.Popped, test.popped) {
+			t.Errorf("StepDetailed #%d (%s) wrong popped items - "+
+				"got %x, want %x", i, test.name, result.Popped,
+				test.popped)
+		}
+		if !stacksEqual(result.Pushed, test.pushed) {
+			t.Errorf("StepDetailed #%d (%s) wrong pushed items - "+
+				"got %x, want %x", i, test.name, result.Pushed,
+				test.pushed)
+		}
+		if done := i == len(tests)-1; result.Done != done {
+			t.Errorf("StepDetailed #%d (%s) wrong done - got %v, "+
+				"want %v", i, test.name, result.Done, done)
+		}
+	}
+	if err := engine.CheckErrorCondition(); err != nil {
+		t.Errorf("CheckErrorCondition unexpected error: %v", err)
+	}
+
+	// The position of the opcode in the scripts is reported, and errors
+	// give no result.
+	engine, err = btcscript.NewScript([]byte{btcscript.OP_1},
+		[]byte{btcscript.OP_RETURN}, 0, tx, 0)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	result, err := engine.StepDetailed()
+	if err != nil || result.ScriptIdx != 0 || result.ScriptOff != 0 {
+		t.Errorf("StepDetailed wrong result for first opcode - got "+
+			"%+v, %v", result, err)
+	}
+	result, err = engine.StepDetailed()
+	if result != nil || err != btcscript.ErrStackEarlyReturn {
+		t.Errorf("StepDetailed wrong result for OP_RETURN - got %+v, "+
+			"%v, want nil, %v", result, err,
+			btcscript.ErrStackEarlyReturn)
+	}
+}
+
+// TestVerifyScriptWithTrace ensures the log of a validation records each
+// opcode executed with the stacks around it, ending at the opcode which failed.
+func TestVerifyScriptWithTrace(t *testing.T) {
+	tx := sigHashTestTx()
+	var keys []*btcec.PrivateKey
+	var pubKeys []*btcutil.AddressPubKey
+	for i := 0; i < 3; i++ {
+		key, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("failed to make key: %v", err)
+		}
+		pk, err := btcutil.NewAddressPubKey((*btcec.PublicKey)(
+			&key.PublicKey).SerializeCompressed(),
+			&btcnet.MainNetParams)
+		if err != nil {
+			t.Fatalf("NewAddressPubKey unexpected error: %v", err)
+		}
+		keys = append(keys, key)
+		pubKeys = append(pubKeys, pk)
+	}
+	// A 1 of 2 multisig of the first two keys, with the third signing
+	// for the wrong key.
+	pkScript, err := btcscript.MultiSigScript(pubKeys[:2], 1)
+	if err != nil {
+		t.Fatalf("MultiSigScript unexpected error: %v", err)
+	}
+	sig, err := sigHashTestSign(tx, pkScript, btcscript.SigHashAll,
+		keys[0])
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	wrongSig, err := sigHashTestSign(tx, pkScript, btcscript.SigHashAll,
+		keys[2])
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	multiSigScript := func(dummy byte, sig []byte) []byte {
+		return builderScript(btcscript.NewScriptBuilder().AddOp(dummy).
+			AddData(sig))
+	}
+
+	// The multisig script is OP_1 <pubkey> <pubkey> OP_2
+	// OP_CHECKMULTISIG, which follows the two opcodes of the signature
+	// script.
+	const checkMultiSig = 6
+	tests := []struct {
+		name      string
+		sigScript []byte
+		code      btcscript.ErrorCode
+		opErr     bool
+		after     [][]byte
+	}{
+		{
+			name:      "valid",
+			sigScript: multiSigScript(btcscript.OP_0, sig),
+			after:     [][]byte{{0x01}},
+		},
+		{
+			name:      "signature by another key",
+			sigScript: multiSigScript(btcscript.OP_0, wrongSig),
+			code:      btcscript.ErrCodeEvalFalse,
+			after:     [][]byte{{0x00}},
+		},
+		{
+			name:      "dummy not empty",
+			sigScript: multiSigScript(btcscript.OP_1, sig),
+			code:      btcscript.ErrCodeSigNullDummy,
+			opErr:     true,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		entries, err := btcscript.VerifyScriptWithTrace(test.sigScript,
+			pkScript, 0, tx, btcscript.ScriptBip16|
+				btcscript.ScriptStrictMultiSig)
+		if test.code == 0 && err != nil ||
+			test.code != 0 && !btcscript.IsErrorCode(err, test.code) {
+			t.Errorf("VerifyScriptWithTrace #%d (%s) wrong error - "+
+				"got %v, want code %v", i, test.name, err,
+				test.code)
+			continue
+		}
+		if len(entries) != checkMultiSig+1 {
+			t.Errorf("VerifyScriptWithTrace #%d (%s) got %d entries, "+
+				"want %d", i, test.name, len(entries),
+				checkMultiSig+1)
+			continue
+		}
+
+		// Each opcode starts with the stack the one before left.
+		var stack [][]byte
+		for j, entry := range entries {
+			if !reflect.DeepEqual(entry.StackBefore, stack) &&
+				(len(entry.StackBefore) != 0 || len(stack) != 0) {
+				t.Errorf("VerifyScriptWithTrace #%d (%s) entry %d "+
+					"stack before %x, want %x", i, test.name, j,
+					entry.StackBefore, stack)
+			}
+			if j != checkMultiSig && entry.Err != nil {
+				t.Errorf("VerifyScriptWithTrace #%d (%s) entry %d "+
+					"unexpected error: %v", i, test.name, j,
+					entry.Err)
+			}
+			stack = entry.StackAfter
+		}
+
+		last := entries[checkMultiSig]
+		if last.ScriptIdx != 1 || last.ScriptOff != 4 ||
+			last.Opcode != btcscript.OP_CHECKMULTISIG {
+			t.Errorf("VerifyScriptWithTrace #%d (%s) last entry is "+
+				"opcode %x at %d:%d, want OP_CHECKMULTISIG at 1:4",
+				i, test.name, last.Opcode, last.ScriptIdx,
+				last.ScriptOff)
+		}
+		if len(last.StackBefore) != 6 {
+			t.Errorf("VerifyScriptWithTrace #%d (%s) last entry got "+
+				"%d items before, want 6", i, test.name,
+				len(last.StackBefore))
+		}
+		if (last.Err != nil) != test.opErr ||
+			test.opErr && last.Err != err {
+			t.Errorf("VerifyScriptWithTrace #%d (%s) last entry "+
+				"wrong error - got %v, returned %v", i, test.name,
+				last.Err, err)
+		}
+		if !test.opErr && !reflect.DeepEqual(last.StackAfter,
+			test.after) {
+			t.Errorf("VerifyScriptWithTrace #%d (%s) last entry "+
+				"stack after %x, want %x", i, test.name,
+				last.StackAfter, test.after)
+		}
+	}
+
+	// Scripts which fail to parse give no log.
+	entries, err := btcscript.VerifyScriptWithTrace(nil,
+		[]byte{btcscript.OP_DATA_2}, 0, tx, 0)
+	if !btcscript.IsErrorCode(err, btcscript.ErrCodeShortScript) ||
+		entries != nil {
+		t.Errorf("VerifyScriptWithTrace unparsable script got %d "+
+			"entries and error %v", len(entries), err)
+	}
+}
+
+// TestCondStack ensures the condition stack reported while stepping through
+// nested conditionals reflects the branches being executed.
+func TestCondStack(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	const (
+		condTrue  = btcscript.OpCondTrue
+		condFalse = btcscript.OpCondFalse
+		condSkip  = btcscript.OpCondSkip
+	)
+	pkScript := []byte{
+		btcscript.OP_1, btcscript.OP_IF,
+		btcscript.OP_0, btcscript.OP_IF,
+		btcscript.OP_0, btcscript.OP_IF,
+		btcscript.OP_ELSE,
+		btcscript.OP_ENDIF,
+		btcscript.OP_ELSE,
+		btcscript.OP_ENDIF,
+		btcscript.OP_ENDIF,
+		btcscript.OP_1,
+	}
+	// want is the condition stack after each opcode has executed.
+	want := [][]int{
+		{},
+		{condTrue},
+		{condTrue},
+		{condTrue, condFalse},
+		{condTrue, condFalse},
+		{condTrue, condFalse, condSkip},
+		{condTrue, condFalse, condSkip},
+		{condTrue, condFalse},
+		{condTrue, condTrue},
+		{condTrue},
+		{},
+		{},
+	}
+
+	engine, err := btcscript.NewScript(nil, pkScript, 0, tx, 0)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	if depth := engine.CondStackDepth(); depth != 0 {
+		t.Fatalf("NewScript wrong condition stack depth: got %d",
+			depth)
+	}
+	for i, wantStates := range want {
+		if _, err := engine.Step(); err != nil {
+			t.Fatalf("Step %d unexpected error: %v", i, err)
+		}
+		if depth := engine.CondStackDepth(); depth != len(wantStates) {
+			t.Errorf("Step %d wrong condition stack depth: got %d, "+
+				"want %d", i, depth, len(wantStates))
+		}
+		if states := engine.CondStack(); !reflect.DeepEqual(states,
+			wantStates) {
+			t.Errorf("Step %d wrong condition stack: got %v, want %v",
+				i, states, wantStates)
+		}
+	}
+	if err := engine.CheckErrorCondition(); err != nil {
+		t.Fatalf("CheckErrorCondition unexpected error: %v", err)
+	}
+}
+
+// benchmarkExecute executes a script which pushes and drops many items,
+// releasing each script engine when done if release is set.
+func benchmarkExecute(b *testing.B, release bool) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	builder := btcscript.NewScriptBuilder()
+	for i := 0; i < 50; i++ {
+		builder.AddOp(btcscript.OP_1)
+	}
+	for i := 0; i < 25; i++ {
+		builder.AddOp(btcscript.OP_2DROP)
+	}
+	pkScript := builderScript(builder.AddOp(btcscript.OP_TRUE))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine, err := btcscript.NewScript(nil, pkScript, 0, tx, 0)
+		if err != nil {
+			b.Fatalf("NewScript unexpected error: %v", err)
+		}
+		if err := engine.Execute(); err != nil {
+			b.Fatalf("Execute unexpected error: %v", err)
+		}
+		if release {
+			engine.Release()
+		}
+	}
+}
+
+// BenchmarkExecute benchmarks script execution without reusing stacks.
+func BenchmarkExecute(b *testing.B) {
+	benchmarkExecute(b, false)
+}
+
+// BenchmarkExecuteRelease benchmarks script execution with the stacks of each
+// engine released for reuse.
+func BenchmarkExecuteRelease(b *testing.B) {
+	benchmarkExecute(b, true)
+}
+
+// TestNullDummy ensures the extra item popped by OP_CHECKMULTISIG must be empty
+// with ScriptVerifyNullDummy and may be anything without it.
+func TestNullDummy(t *testing.T) {
+	// multiSig returns a 0-of-0 multisig check, which always succeeds,
+	// preceded by the passed dummy.
+	multiSig := func(dummy ...byte) []byte {
+		return append(dummy, btcscript.OP_0, btcscript.OP_0,
+			btcscript.OP_CHECKMULTISIG)
+	}
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+		flags    btcscript.ScriptFlags
+		err      bool
+	}{
+		{"OP_0 dummy", multiSig(btcscript.OP_0),
+			btcscript.ScriptVerifyNullDummy, false},
+		{"empty OP_PUSHDATA1 dummy",
+			multiSig(btcscript.OP_PUSHDATA1, 0x00),
+			btcscript.ScriptVerifyNullDummy, false},
+		{"OP_1 dummy", multiSig(btcscript.OP_1),
+			btcscript.ScriptVerifyNullDummy, true},
+		{"zero byte dummy", multiSig(btcscript.OP_DATA_1, 0x00),
+			btcscript.ScriptVerifyNullDummy, true},
+		{"OP_1 dummy without flag", multiSig(btcscript.OP_1), 0, false},
+		{"zero byte dummy without flag",
+			multiSig(btcscript.OP_DATA_1, 0x00), 0, false},
+		{"OP_1 dummy with OP_CHECKMULTISIGVERIFY",
+			append(multiSig(btcscript.OP_1)[:3],
+				btcscript.OP_CHECKMULTISIGVERIFY, btcscript.OP_1),
+			btcscript.ScriptVerifyNullDummy, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		tx := btcwire.NewMsgTx()
+		tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+		engine, err := btcscript.NewScript(nil, test.pkScript, 0, tx,
+			test.flags)
+		if err != nil {
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		err = engine.Execute()
+		if test.err && !btcscript.IsErrorCode(err,
+			btcscript.ErrCodeSigNullDummy) {
+			t.Errorf("Execute #%d (%s) wrong error - got %v, want "+
+				"code %v", i, test.name, err,
+				btcscript.ErrCodeSigNullDummy)
+		} else if !test.err && err != nil {
+			t.Errorf("Execute #%d (%s) unexpected error: %v", i,
+				test.name, err)
+		}
+	}
+}
+
+// TestDERSignatures tests that signatures which are not strictly DER encoded
+// cause script failure with ScriptVerifyDERSignatures and are merely invalid
+// signatures without it.
+func TestDERSignatures(t *testing.T) {
+	// The public key is never parsed successfully, so any signature which
+	// passes the encoding checks leaves false on the stack.
+	pubKey := bytes.Repeat([]byte{0x02}, 33)
+
+	tests := []struct {
+		name  string
+		sig   []byte
+		valid bool
+	}{
+		{
+			name: "valid DER",
+			sig: []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01,
+				0x01, 0x01},
+			valid: true,
+		},
+		{
+			name: "valid DER with padded R",
+			sig: []byte{0x30, 0x07, 0x02, 0x02, 0x00, 0x81, 0x02,
+				0x01, 0x01, 0x01},
+			valid: true,
+		},
+		{
+			name:  "empty signature",
+			sig:   []byte{},
+			valid: true,
+		},
+		{
+			name: "extra trailing byte",
+			sig: []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01,
+				0x01, 0x00, 0x01},
+		},
+		{
+			name: "negative R",
+			sig: []byte{0x30, 0x06, 0x02, 0x01, 0x81, 0x02, 0x01,
+				0x01, 0x01},
+		},
+		{
+			name: "negative S",
+			sig: []byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01,
+				0x81, 0x01},
+		},
+		{
+			name: "unnecessary leading zero in R",
+			sig: []byte{0x30, 0x07, 0x02, 0x02, 0x00, 0x01, 0x02,
+				0x01, 0x01, 0x01},
+		},
+		{
+			name: "zero length S",
+			sig: []byte{0x30, 0x05, 0x02, 0x01, 0x01, 0x02, 0x00,
+				0x01, 0x01},
+		},
+		{
+			name: "wrong sequence tag",
+			sig: []byte{0x31, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01,
+				0x01, 0x01},
+		},
+	}
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	for _, test := range tests {
+		checkSig := builderScript(btcscript.NewScriptBuilder().
+			AddData(test.sig).AddData(pubKey).
+			AddOp(btcscript.OP_CHECKSIG))
+		checkMultiSig := builderScript(btcscript.NewScriptBuilder().
+			AddOp(btcscript.OP_0).AddData(test.sig).AddOp(btcscript.OP_1).
+			AddData(pubKey).AddOp(btcscript.OP_1).
+			AddOp(btcscript.OP_CHECKMULTISIG))
+
+		for _, pkScript := range [][]byte{checkSig, checkMultiSig} {
+			want := btcscript.ErrStackInvalidSignatureEncoding
+			if test.valid {
+				want = btcscript.ErrStackScriptFailed
+			}
+			engine, err := btcscript.NewScript(nil, pkScript, 0,
+				tx, btcscript.ScriptVerifyDERSignatures)
+			if err != nil {
+				t.Errorf("%s: failed to create script: %v",
+					test.name, err)
+				continue
+			}
+			if err := engine.Execute(); err != want {
+				t.Errorf("%s: unexpected error: got %v, want %v",
+					test.name, err, want)
+			}
+
+			// Without the flag the signature check only fails.
+			engine, err = btcscript.NewScript(nil, pkScript, 0, tx,
+				0)
+			if err != nil {
+				t.Errorf("%s: failed to create script: %v",
+					test.name, err)
+				continue
+			}
+			err = engine.Execute()
+			if err != btcscript.ErrStackScriptFailed {
+				t.Errorf("%s: unexpected error without flag: "+
+					"got %v, want %v", test.name, err,
+					btcscript.ErrStackScriptFailed)
+			}
+		}
+	}
+}
+
+// derSignature returns a DER encoded signature with the passed R and S values
+// given as big endian hex.
+func derSignature(r, s string) []byte {
+	encodeInt := func(v string) []byte {
+		b := decodeHex(v)
+		if b[0]&0x80 != 0 {
+			b = append([]byte{0x00}, b...)
+		}
+		return append([]byte{0x02, byte(len(b))}, b...)
+	}
+	ints := append(encodeInt(r), encodeInt(s)...)
+	return append([]byte{0x30, byte(len(ints))}, ints...)
+}
+
+// TestLowS tests the detection of signatures with high S values both by
+// IsLowSSignature and by the engine with ScriptVerifyLowS.
+func TestLowS(t *testing.T) {
+	const r = "01"
+	tests := []struct {
+		name string
+		sig  []byte
+		lowS bool
+	}{
+		{
+			name: "low S",
+			sig: derSignature(r, "2b5f7e3c6a1d0f8e9b4c3a2d1e0f9a8b"+
+				"7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"),
+			lowS: true,
+		},
+		{
+			// The curve order minus the S value of the low S
+			// signature.
+			name: "high S counterpart",
+			sig: derSignature(r, "d4a081c395e2f07164b3c5d2e1f06573"+
+				"3e417e97751d842e2142e42173e90312"),
+			lowS: false,
+		},
+		{
+			name: "S of half the order",
+			sig: derSignature(r, "7fffffffffffffffffffffffffffffff"+
+				"5d576e7357a4501ddfe92f46681b20a0"),
+			lowS: true,
+		},
+		{
+			name: "S of half the order plus one",
+			sig: derSignature(r, "7fffffffffffffffffffffffffffffff"+
+				"5d576e7357a4501ddfe92f46681b20a1"),
+			lowS: false,
+		},
+	}
+
+	// The public key is never parsed successfully, so any signature which
+	// passes the encoding checks leaves false on the stack.
+	pubKey := bytes.Repeat([]byte{0x02}, 33)
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	for _, test := range tests {
+		if btcscript.IsLowSSignature(test.sig) != test.lowS {
+			t.Errorf("%s: IsLowSSignature: got %v, want %v",
+				test.name, !test.lowS, test.lowS)
+		}
+
+		sig := append(test.sig, byte(btcscript.SigHashAll))
+		pkScript := builderScript(btcscript.NewScriptBuilder().
+			AddData(sig).AddData(pubKey).
+			AddOp(btcscript.OP_CHECKSIG))
+		engine, err := btcscript.NewScript(nil, pkScript, 0, tx,
+			btcscript.ScriptVerifyLowS)
+		if err != nil {
+			t.Errorf("%s: failed to create script: %v", test.name,
+				err)
+			continue
+		}
+		want := btcscript.ErrStackHighS
+		if test.lowS {
+			want = btcscript.ErrStackScriptFailed
+		}
+		if err := engine.Execute(); err != want {
+			t.Errorf("%s: unexpected error: got %v, want %v",
+				test.name, err, want)
+		}
+	}
+
+	// Signatures which are not DER encoded can not be low S.
+	if btcscript.IsLowSSignature([]byte{0x30, 0x00}) {
+		t.Errorf("IsLowSSignature accepted an invalid signature")
+	}
+}
+
+// TestMinimalData ensures data pushes which do not use the smallest possible
+// opcode are rejected with ScriptVerifyMinimalData and allowed without it.
+func TestMinimalData(t *testing.T) {
+	tests := []struct {
+		name    string
+		push    []byte
+		minimal bool
+	}{
+		{"OP_0", []byte{btcscript.OP_0}, true},
+		{"empty OP_PUSHDATA1", []byte{btcscript.OP_PUSHDATA1, 0x00}, false},
+		{"OP_1", []byte{btcscript.OP_1}, true},
+		{"0x01 pushed as data", []byte{btcscript.OP_DATA_1, 0x01}, false},
+		{"0x10 pushed as data", []byte{btcscript.OP_DATA_1, 0x10}, false},
+		{"0x81 pushed as data", []byte{btcscript.OP_DATA_1, 0x81}, false},
+		{"0x00 pushed as data", []byte{btcscript.OP_DATA_1, 0x00}, true},
+		{"0x11 pushed as data", []byte{btcscript.OP_DATA_1, 0x11}, true},
+		{
+			name:    "direct push of 0x05 through OP_PUSHDATA1",
+			push:    []byte{btcscript.OP_PUSHDATA1, 0x01, 0x05},
+			minimal: false,
+		},
+		{
+			name:    "direct push of 2 bytes through OP_PUSHDATA1",
+			push:    []byte{btcscript.OP_PUSHDATA1, 0x02, 0x05, 0x06},
+			minimal: false,
+		},
+		{
+			name: "75 bytes through OP_PUSHDATA1",
+			push: append([]byte{btcscript.OP_PUSHDATA1, 75},
+				make([]byte, 75)...),
+			minimal: false,
+		},
+		{
+			name: "76 bytes through OP_PUSHDATA1",
+			push: append([]byte{btcscript.OP_PUSHDATA1, 76},
+				make([]byte, 76)...),
+			minimal: true,
+		},
+		{
+			name: "255 bytes through OP_PUSHDATA2",
+			push: append([]byte{btcscript.OP_PUSHDATA2, 0xff, 0x00},
+				make([]byte, 255)...),
+			minimal: false,
+		},
+		{
+			name: "256 bytes through OP_PUSHDATA2",
+			push: append([]byte{btcscript.OP_PUSHDATA2, 0x00, 0x01},
+				make([]byte, 256)...),
+			minimal: true,
+		},
+		{
+			name: "256 bytes through OP_PUSHDATA4",
+			push: append([]byte{btcscript.OP_PUSHDATA4, 0x00, 0x01,
+				0x00, 0x00}, make([]byte, 256)...),
+			minimal: false,
+		},
+	}
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		pkScript := append(append([]byte{}, test.push...),
+			btcscript.OP_DROP, btcscript.OP_TRUE)
+		// Pushes in branches which are not executed are not checked.
+		skipped := append(append([]byte{btcscript.OP_0, btcscript.OP_IF},
+			test.push...), btcscript.OP_ENDIF, btcscript.OP_TRUE)
+
+		for _, flags := range []btcscript.ScriptFlags{0,
+			btcscript.ScriptVerifyMinimalData} {
+			var want error
+			if flags != 0 && !test.minimal {
+				want = btcscript.ErrStackMinimalData
+			}
+			engine, err := btcscript.NewScript(nil, pkScript, 0, tx,
+				flags)
+			if err != nil {
+				t.Errorf("NewScript #%d (%s) unexpected error: %v",
+					i, test.name, err)
+				continue
+			}
+			if err := engine.Execute(); err != want {
+				t.Errorf("Execute #%d (%s) flags %v unexpected "+
+					"error - got %v, want %v", i, test.name,
+					flags, err, want)
+			}
+
+			engine, err = btcscript.NewScript(nil, skipped, 0, tx,
+				flags)
+			if err != nil {
+				t.Errorf("NewScript #%d (%s) unexpected error: %v",
+					i, test.name, err)
+				continue
+			}
+			if err := engine.Execute(); err != nil {
+				t.Errorf("Execute #%d (%s) flags %v unexpected "+
+					"error in skipped branch: %v", i,
+					test.name, flags, err)
+			}
+		}
+	}
+}
+
+type scriptInfoTest struct {
+	name          string
+	sigScript     []byte
+	pkScript      []byte
+	bip16         bool
+	scriptInfo    btcscript.ScriptInfo
+	scriptInfoErr error
+}
+
+func TestScriptInfo(t *testing.T) {
+	for _, test := range txTests {
+		si, err := btcscript.CalcScriptInfo(
+			test.tx.TxIn[test.idx].SignatureScript,
+			test.pkScript, test.bip16)
+		if err != nil {
+			if err != test.scriptInfoErr {
+				t.Errorf("scriptinfo test \"%s\": got \"%v\""+
+					"expected \"%v\"", test.name, err,
+					test.scriptInfoErr)
+			}
+			continue
+		}
+		if test.scriptInfoErr != nil {
+			t.Errorf("%s: succeeded when expecting \"%v\"",
+				test.name, test.scriptInfoErr)
+			continue
+		}
+		if *si != test.scriptInfo {
+			t.Errorf("%s: scriptinfo doesn't match expected. "+
+				"got: \"%v\" expected \"%v\"", test.name,
+				*si, test.scriptInfo)
+			continue
+		}
+	}
+
+	extraTests := []scriptInfoTest{
+		{
+			// Invented scripts, the hashes do not match
+			name: "pkscript doesn't parse",
+			sigScript: []byte{btcscript.OP_TRUE,
+				btcscript.OP_DATA_1, 81,
+				btcscript.OP_DATA_8,
+				btcscript.OP_2DUP, btcscript.OP_EQUAL,
+				btcscript.OP_NOT, btcscript.OP_VERIFY,
+				btcscript.OP_ABS, btcscript.OP_SWAP,
+				btcscript.OP_ABS, btcscript.OP_EQUAL,
+			},
+			// truncated version of test below:
+			pkScript: []byte{btcscript.OP_HASH160,
+				btcscript.OP_DATA_20,
+				0xfe, 0x44, 0x10, 0x65, 0xb6, 0x53, 0x22, 0x31,
+				0xde, 0x2f, 0xac, 0x56, 0x31, 0x52, 0x20, 0x5e,
+				0xc4, 0xf5, 0x9c,
+			},
+			bip16:         true,
+			scriptInfoErr: btcscript.ErrStackShortScript,
+		},
+		{
+			name: "sigScript doesn't parse",
+			// Truncated version of p2sh script below.
+			sigScript: []byte{btcscript.OP_TRUE,
+				btcscript.OP_DATA_1, 81,
+				btcscript.OP_DATA_8,
+				btcscript.OP_2DUP, btcscript.OP_EQUAL,
+				btcscript.OP_NOT, btcscript.OP_VERIFY,
+				btcscript.OP_ABS, btcscript.OP_SWAP,
+				btcscript.OP_ABS,
+			},
+			pkScript: []byte{btcscript.OP_HASH160,
+				btcscript.OP_DATA_20,
+				0xfe, 0x44, 0x10, 0x65, 0xb6, 0x53, 0x22, 0x31,
+				0xde, 0x2f, 0xac, 0x56, 0x31, 0x52, 0x20, 0x5e,
+				0xc4, 0xf5, 0x9c, 0x74, btcscript.OP_EQUAL,
+			},
+			bip16:         true,
+			scriptInfoErr: btcscript.ErrStackShortScript,
+		},
+		{
+			// Invented scripts, the hashes do not match
+			name: "p2sh standard script",
+			sigScript: []byte{btcscript.OP_TRUE,
+				btcscript.OP_DATA_1, 81,
+				btcscript.OP_DATA_25,
+				btcscript.OP_DUP, btcscript.OP_HASH160,
+				btcscript.OP_DATA_20, 0x1, 0x2, 0x3, 0x4, 0x5,
+				0x6, 0x7, 0x8, 0x9, 0xa, 0xb, 0xc, 0xd, 0xe,
+				0xf, 0x10, 0x11, 0x12, 0x13, 0x14,
+				btcscript.OP_EQUALVERIFY, btcscript.OP_CHECKSIG,
+			},
+			pkScript: []byte{btcscript.OP_HASH160,
+				btcscript.OP_DATA_20,
+				0xfe, 0x44, 0x10, 0x65, 0xb6, 0x53, 0x22, 0x31,
+				0xde, 0x2f, 0xac, 0x56, 0x31, 0x52, 0x20, 0x5e,
+				0xc4, 0xf5, 0x9c, 0x74, btcscript.OP_EQUAL,
+			},
+			bip16: true,
+			scriptInfo: btcscript.ScriptInfo{
+				PkScriptClass:  btcscript.ScriptHashTy,
+				NumInputs:      3,
+				ExpectedInputs: 3, // nonstandard p2sh.
+				SigOps:         1,
+			},
+		},
+		{
+			// from 567a53d1ce19ce3d07711885168484439965501536d0d0294c5d46d46c10e53b
+			// from the blockchain.
+			name: "p2sh nonstandard script",
+			sigScript: []byte{btcscript.OP_TRUE,
+				btcscript.OP_DATA_1, 81,
+				btcscript.OP_DATA_8,
+				btcscript.OP_2DUP, btcscript.OP_EQUAL,
+				btcscript.OP_NOT, btcscript.OP_VERIFY,
+				btcscript.OP_ABS, btcscript.OP_SWAP,
+				btcscript.OP_ABS, btcscript.OP_EQUAL,
+			},
+			pkScript: []byte{btcscript.OP_HASH160,
+				btcscript.OP_DATA_20,
+				0xfe, 0x44, 0x10, 0x65, 0xb6, 0x53, 0x22, 0x31,
+				0xde, 0x2f, 0xac, 0x56, 0x31, 0x52, 0x20, 0x5e,
+				0xc4, 0xf5, 0x9c, 0x74, btcscript.OP_EQUAL,
+			},
+			bip16: true,
+			scriptInfo: btcscript.ScriptInfo{
+				PkScriptClass:  btcscript.ScriptHashTy,
+				NumInputs:      3,
+				ExpectedInputs: -1, // nonstandard p2sh.
+				SigOps:         0,
+			},
+		},
+		{
+			// Script is invented, numbers all fake.
+			name: "multisig script",
+			sigScript: []byte{btcscript.OP_TRUE,
+				btcscript.OP_TRUE, btcscript.OP_TRUE,
+				btcscript.OP_0, // Extra arg for OP_CHECKMULTISIG bug
+			},
+			pkScript: []byte{
+				btcscript.OP_3, btcscript.OP_DATA_33,
+				0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9,
+				0xa, 0xb, 0xc, 0xd, 0xe, 0xf, 0x10, 0x11, 0x12,
+				0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a,
+				0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20, 0x21,
+				btcscript.OP_DATA_33,
+				0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9,
+				0xa, 0xb, 0xc, 0xd, 0xe, 0xf, 0x10, 0x11, 0x12,
+				0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a,
+				0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20, 0x21,
+				btcscript.OP_DATA_33,
+				0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9,
+				0xa, 0xb, 0xc, 0xd, 0xe, 0xf, 0x10, 0x11, 0x12,
+				0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a,
+				0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20, 0x21,
+				btcscript.OP_3, btcscript.OP_CHECKMULTISIG,
+			},
+			bip16: true,
+			scriptInfo: btcscript.ScriptInfo{
+				PkScriptClass:  btcscript.MultiSigTy,
+				NumInputs:      4,
+				ExpectedInputs: 4,
+				SigOps:         3,
+			},
+		},
+	}
+
+	for _, test := range extraTests {
+		si, err := btcscript.CalcScriptInfo(test.sigScript,
+			test.pkScript, test.bip16)
+		if err != nil {
+			if err != test.scriptInfoErr {
+				t.Errorf("scriptinfo test \"%s\": got \"%v\""+
+					"expected \"%v\"", test.name, err,
+					test.scriptInfoErr)
+			}
+			continue
+		}
+		if test.scriptInfoErr != nil {
+			t.Errorf("%s: succeeded when expecting \"%v\"",
+				test.name, test.scriptInfoErr)
+			continue
+		}
+		if *si != test.scriptInfo {
+			t.Errorf("%s: scriptinfo doesn't match expected. "+
+				"got: \"%v\" expected \"%v\"", test.name,
+				*si, test.scriptInfo)
+			continue
+		}
+	}
+
+}
+
+type removeOpcodeTest struct {
+	name   string
+	before []byte
+	remove byte
+	err    error
+	after  []byte
+}
+
+var removeOpcodeTests = []removeOpcodeTest{
+	// Nothing to remove.
+	{
+		name:   "nothing to remove",
+		before: []byte{btcscript.OP_NOP},
+		remove: btcscript.OP_CODESEPARATOR,
+		after:  []byte{btcscript.OP_NOP},
+	},
+	// Test basic opcode removal
+	{
+		name: "codeseparator 1",
+		before: []byte{btcscript.OP_NOP, btcscript.OP_CODESEPARATOR,
+			btcscript.OP_TRUE},
+		remove: btcscript.OP_CODESEPARATOR,
+		after:  []byte{btcscript.OP_NOP, btcscript.OP_TRUE},
+	},
+	// The opcode in question is actually part of the data in a previous
+	// opcode
+	{
+		name: "codeseparator by coincidence",
+		before: []byte{btcscript.OP_NOP, btcscript.OP_DATA_1, btcscript.OP_CODESEPARATOR,
+			btcscript.OP_TRUE},
+		remove: btcscript.OP_CODESEPARATOR,
+		after: []byte{btcscript.OP_NOP, btcscript.OP_DATA_1, btcscript.OP_CODESEPARATOR,
+			btcscript.OP_TRUE},
+	},
+	{
+		name:   "invalid opcode",
+		before: []byte{btcscript.OP_UNKNOWN186},
+		remove: btcscript.OP_CODESEPARATOR,
+		after:  []byte{btcscript.OP_UNKNOWN186},
+	},
+	{
+		name:   "invalid length (insruction)",
+		before: []byte{btcscript.OP_PUSHDATA1},
+		remove: btcscript.OP_CODESEPARATOR,
+		err:    btcscript.ErrStackShortScript,
+	},
+	{
+		name:   "invalid length (data)",
+		before: []byte{btcscript.OP_PUSHDATA1, 255, 254},
+		remove: btcscript.OP_CODESEPARATOR,
+		err:    btcscript.ErrStackShortScript,
+	},
+}
+
+func testRemoveOpcode(t *testing.T, test *removeOpcodeTest) {
+	result, err := btcscript.TstRemoveOpcode(test.before, test.remove)
+	if test.err != nil {
+		if err != test.err {
+			t.Errorf("%s: got unexpected error. exp: \"%v\" "+
+				"got: \"%v\"", test.name, test.err, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%s: unexpected failure: \"%v\"", test.name, err)
+		return
+	}
+	if !bytes.Equal(test.after, result) {
+		t.Errorf("%s: value does not equal expected: exp: \"%v\""+
+			" got: \"%v\"", test.name, test.after, result)
+	}
+}
+
+func TestRemoveOpcodes(t *testing.T) {
+	for i := range removeOpcodeTests {
+		testRemoveOpcode(t, &removeOpcodeTests[i])
+	}
+}
+
+type removeOpcodeByDataTest struct {
+	name   string
+	before []byte
+	remove []byte
+	err    error
+	after  []byte
+}
+
+var removeOpcodeByDataTests = []removeOpcodeByDataTest{
+	{
+		name:   "nothing to do",
+		before: []byte{btcscript.OP_NOP},
+		remove: []byte{1, 2, 3, 4},
+		after:  []byte{btcscript.OP_NOP},
+	},
+	{
+		name:   "simple case",
+		before: []byte{btcscript.OP_DATA_4, 1, 2, 3, 4},
+		remove: []byte{1, 2, 3, 4},
+		after:  []byte{},
+	},
+	{
+		name:   "simple case (miss)",
+		before: []byte{btcscript.OP_DATA_4, 1, 2, 3, 4},
+		remove: []byte{1, 2, 3, 5},
+		after:  []byte{btcscript.OP_DATA_4, 1, 2, 3, 4},
+	},
+	{
+		// padded to keep it canonical.
+		name: "simple case (pushdata1)",
+		before: append(append([]byte{btcscript.OP_PUSHDATA1, 76},
+			bytes.Repeat([]byte{0}, 72)...), []byte{1, 2, 3, 4}...),
+		remove: []byte{1, 2, 3, 4},
+		after:  []byte{},
+	},
+	{
+		name: "simple case (pushdata1 miss)",
+		before: append(append([]byte{btcscript.OP_PUSHDATA1, 76},
 			bytes.Repeat([]byte{0}, 72)...), []byte{1, 2, 3, 4}...),
 		remove: []byte{1, 2, 3, 5},
 		after: append(append([]byte{btcscript.OP_PUSHDATA1, 76},
@@ -2326,6 +4665,61 @@ var scriptTypeTests = []scriptTypeTest{
 		},
 		scripttype: btcscript.NonStandardTy,
 	},
+	{
+		name: "name_update pay pubkeyhash",
+		script: []byte{
+			btcscript.OP_NAME_UPDATE,
+			btcscript.OP_DATA_9,
+			'd', '/', 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+			btcscript.OP_DATA_5,
+			'v', 'a', 'l', 'u', 'e',
+			btcscript.OP_2DROP,
+			btcscript.OP_DROP,
+			btcscript.OP_DUP,
+			btcscript.OP_HASH160,
+			btcscript.OP_DATA_20,
+			0x66, 0x0d, 0x4e, 0xf3, 0xa7, 0x43, 0xe3, 0xe6, 0x96,
+			0xad, 0x99, 0x03, 0x64, 0xe5, 0x55, 0xc2, 0x71, 0xad,
+			0x50, 0x4b,
+			btcscript.OP_EQUALVERIFY,
+			btcscript.OP_CHECKSIG,
+		},
+		scripttype: btcscript.PubKeyHashTy,
+	},
+	{
+		name: "name_new multisig",
+		script: []byte{
+			btcscript.OP_NAME_NEW,
+			btcscript.OP_DATA_20,
+			0x05, 0x42, 0x8e, 0x47, 0x4f, 0x5b, 0x1b, 0x1c, 0x5e,
+			0x3b, 0x3d, 0x10, 0x44, 0x36, 0xb3, 0xc5, 0x17, 0x0e,
+			0x8e, 0x42,
+			btcscript.OP_2DROP,
+			btcscript.OP_TRUE,
+			btcscript.OP_DATA_33,
+			0x02, 0x32, 0xab, 0xdc, 0x89, 0x3e, 0x7f, 0x06, 0x31,
+			0x36, 0x4d, 0x7f, 0xd0, 0x1c, 0xb3, 0x3d, 0x24, 0xda,
+			0x45, 0x32, 0x9a, 0x00, 0x35, 0x7b, 0x3a, 0x78, 0x86,
+			0x21, 0x1a, 0xb4, 0x14, 0xd5, 0x5a,
+			btcscript.OP_TRUE,
+			btcscript.OP_CHECKMULTISIG,
+		},
+		scripttype: btcscript.MultiSigTy,
+	},
+	{
+		name: "name_update with nonstandard address",
+		script: []byte{
+			btcscript.OP_NAME_UPDATE,
+			btcscript.OP_DATA_9,
+			'd', '/', 'e', 'x', 'a', 'm', 'p', 'l', 'e',
+			btcscript.OP_DATA_5,
+			'v', 'a', 'l', 'u', 'e',
+			btcscript.OP_2DROP,
+			btcscript.OP_DROP,
+			btcscript.OP_TRUE,
+		},
+		scripttype: btcscript.NameScriptTy,
+	},
 }
 
 func testScriptType(t *testing.T, test *scriptTypeTest) {
@@ -2353,6 +4747,139 @@ func TestIsPayToScriptHash(t *testing.T) {
 	}
 }
 
+// scriptClassCorpus returns the scripts of scriptTypeTests along with each of
+// the templates recognized without parsing and many scripts which differ from
+// them by a single byte, a missing byte or an extra byte.
+func scriptClassCorpus() [][]byte {
+	hash20 := bytes.Repeat([]byte{0x11}, 20)
+	hash32 := bytes.Repeat([]byte{0x22}, 32)
+	templates := [][]byte{
+		nameTestP2PKH,
+		nameTestP2SH,
+		append([]byte{btcscript.OP_0, btcscript.OP_DATA_20}, hash20...),
+		append([]byte{btcscript.OP_0, btcscript.OP_DATA_32}, hash32...),
+	}
+
+	var corpus [][]byte
+	for _, test := range scriptTypeTests {
+		corpus = append(corpus, test.script)
+	}
+	for _, template := range templates {
+		corpus = append(corpus, template, template[:len(template)-1],
+			append(append([]byte(nil), template...),
+				btcscript.OP_NOP))
+		for i := range template {
+			for _, b := range []byte{btcscript.OP_0,
+				btcscript.OP_DATA_20, btcscript.OP_DATA_32,
+				btcscript.OP_PUSHDATA1, btcscript.OP_1,
+				btcscript.OP_NAME_UPDATE, btcscript.OP_DUP,
+				btcscript.OP_HASH160, btcscript.OP_EQUAL,
+				btcscript.OP_EQUALVERIFY, btcscript.OP_CHECKSIG,
+				template[i] + 1} {
+				script := append([]byte(nil), template...)
+				script[i] = b
+				corpus = append(corpus, script)
+			}
+		}
+		name := nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, template)
+		corpus = append(corpus, name)
+	}
+	return corpus
+}
+
+// TestGetScriptClassFastPath ensures GetScriptClass gives the same class as
+// parsing the script and matching templates for every script of a corpus.
+func TestGetScriptClassFastPath(t *testing.T) {
+	corpus := scriptClassCorpus()
+	t.Logf("Running %d tests", len(corpus))
+	for i, script := range corpus {
+		got := btcscript.GetScriptClass(script)
+		want := btcscript.TstParsedScriptClass(script)
+		if got != want {
+			t.Errorf("GetScriptClass #%d (%x) got %v, want %v", i,
+				script, got, want)
+		}
+	}
+}
+
+// TestClassifyScript ensures scripts are classified with the class of each of
+// their layers, so that name scripts keep the class of their address script.
+func TestClassifyScript(t *testing.T) {
+	update := func(base []byte) []byte {
+		return nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, base)
+	}
+	nonStandard := []byte{btcscript.OP_1, btcscript.OP_ADD}
+
+	tests := []struct {
+		name   string
+		script []byte
+		class  btcscript.ScriptClass
+		outer  btcscript.ScriptClass
+		base   btcscript.ScriptClass
+		isName bool
+	}{
+		{"pay to pubkey hash", nameTestP2PKH, btcscript.PubKeyHashTy,
+			btcscript.PubKeyHashTy, btcscript.PubKeyHashTy, false},
+		{"non-standard", nonStandard, btcscript.NonStandardTy,
+			btcscript.NonStandardTy, btcscript.NonStandardTy, false},
+		{"unparsable", []byte{btcscript.OP_DATA_2},
+			btcscript.NonStandardTy, btcscript.NonStandardTy,
+			btcscript.NonStandardTy, false},
+		{"name over pay to pubkey hash", update(nameTestP2PKH),
+			btcscript.PubKeyHashTy, btcscript.NameScriptTy,
+			btcscript.PubKeyHashTy, true},
+		{"name over pay to script hash", update(nameTestP2SH),
+			btcscript.ScriptHashTy, btcscript.NameScriptTy,
+			btcscript.ScriptHashTy, true},
+		{"name over non-standard", update(nonStandard),
+			btcscript.NameScriptTy, btcscript.NameScriptTy,
+			btcscript.NonStandardTy, true},
+		{"name with empty base", update(nil), btcscript.NameScriptTy,
+			btcscript.NameScriptTy, btcscript.NonStandardTy, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		c := btcscript.ClassifyScript(test.script)
+		if c.Class() != test.class ||
+			c.Class() != btcscript.GetScriptClass(test.script) {
+			t.Errorf("ClassifyScript #%d (%s) wrong class - got %v, "+
+				"want %v", i, test.name, c.Class(), test.class)
+		}
+		if c.IsNameScript() != test.isName {
+			t.Errorf("ClassifyScript #%d (%s) name script got %v, "+
+				"want %v", i, test.name, c.IsNameScript(),
+				test.isName)
+		}
+		outer, base := c.Layers()
+		if outer != test.outer || base != test.base {
+			t.Errorf("ClassifyScript #%d (%s) wrong layers - got "+
+				"%v/%v, want %v/%v", i, test.name, outer, base,
+				test.outer, test.base)
+		}
+	}
+}
+
+// BenchmarkGetScriptClassP2PKH benchmarks classifying a pay-to-pubkey-hash
+// script.
+func BenchmarkGetScriptClassP2PKH(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		btcscript.GetScriptClass(nameTestP2PKH)
+	}
+}
+
+// BenchmarkGetScriptClassP2PKHParsed benchmarks classifying a
+// pay-to-pubkey-hash script by parsing it.
+func BenchmarkGetScriptClassP2PKHParsed(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		btcscript.TstParsedScriptClass(nameTestP2PKH)
+	}
+}
+
 // This test sets the pc to a deliberately bad result then confirms that Step()
 //  and Disasm fail correctly.
 func TestBadPC(t *testing.T) {
@@ -2425,6 +4952,56 @@ func TestBadPC(t *testing.T) {
 	}
 }
 
+// TestDisasmWithPC tests that the program counter marker of DisasmWithPC
+// follows execution through both scripts and past their end.
+func TestDisasmWithPC(t *testing.T) {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	sigScript := []byte{btcscript.OP_1, btcscript.OP_2}
+	pkScript := []byte{btcscript.OP_ADD, btcscript.OP_3, btcscript.OP_EQUAL}
+	engine, err := btcscript.NewScript(sigScript, pkScript, 0, tx, 0)
+	if err != nil {
+		t.Fatalf("failed to create script: %v", err)
+	}
+
+	lines := []string{
+		"00:0000: OP_1",
+		"00:0001: OP_2",
+		"01:0000: OP_ADD",
+		"01:0001: OP_3",
+		"01:0002: OP_EQUAL",
+	}
+	expected := func(pc int) string {
+		var dis string
+		for i, line := range lines {
+			if i == pc {
+				dis += ">> " + line + "\n"
+			} else {
+				dis += "   " + line + "\n"
+			}
+		}
+		if pc >= len(lines) {
+			dis += ">> end\n"
+		}
+		return dis
+	}
+
+	for pc := 0; ; pc++ {
+		if dis := engine.DisasmWithPC(); dis != expected(pc) {
+			t.Errorf("DisasmWithPC at step %d: got\n%s\nwant\n%s",
+				pc, dis, expected(pc))
+		}
+		if pc >= len(lines) {
+			break
+		}
+
+		if _, err := engine.Step(); err != nil {
+			t.Fatalf("Step %d: unexpected error: %v", pc, err)
+		}
+	}
+}
+
 // Most codepaths in CheckErrorCondition() are testd elsewhere, this tests
 // the execute early test.
 func TestCheckErrorCondition(t *testing.T) {
@@ -2844,6 +5421,74 @@ nexttest:
 	}
 }
 
+// TestP2SHSignatureScript ensures signature scripts spending a
+// pay-to-script-hash output end with the redeem script and validate.
+func TestP2SHSignatureScript(t *testing.T) {
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), privKeyD)
+	pubKey := (*btcec.PublicKey)(&privKey.PublicKey).SerializeCompressed()
+	redeemScript := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_HASH160).
+		AddData(btcutil.Hash160(pubKey)).
+		AddOp(btcscript.OP_EQUALVERIFY).AddOp(btcscript.OP_CHECKSIG))
+	pkScript := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_HASH160).AddData(btcutil.Hash160(redeemScript)).
+		AddOp(btcscript.OP_EQUAL))
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(coinbaseOutPoint, nil))
+	tx.AddTxOut(btcwire.NewTxOut(500, []byte{btcscript.OP_RETURN}))
+
+	sigScript, err := btcscript.SignatureScript(tx, 0, redeemScript,
+		btcscript.SigHashAll, privKey, true)
+	if err != nil {
+		t.Fatalf("SignatureScript unexpected error: %v", err)
+	}
+	script, err := btcscript.P2SHSignatureScript(sigScript, redeemScript)
+	if err != nil {
+		t.Fatalf("P2SHSignatureScript unexpected error: %v", err)
+	}
+	if !btcscript.IsPushOnlyScript(script) {
+		t.Errorf("P2SHSignatureScript script %x is not push only", script)
+	}
+	pushes, err := btcscript.PushedData(script)
+	if err != nil || len(pushes) != 3 ||
+		!bytes.Equal(pushes[2], redeemScript) {
+		t.Errorf("P2SHSignatureScript script %x does not end with the "+
+			"redeem script", script)
+	}
+
+	tx.TxIn[0].SignatureScript = script
+	flags := btcscript.ScriptBip16 | btcscript.ScriptCanonicalSignatures
+	engine, err := btcscript.NewScript(script, pkScript, 0, tx, flags)
+	if err != nil {
+		t.Fatalf("NewScript unexpected error: %v", err)
+	}
+	if err := engine.Execute(); err != nil {
+		t.Errorf("Execute unexpected error: %v", err)
+	}
+
+	// The signature script given must be push only and the redeem script
+	// small enough to push.
+	_, err = btcscript.P2SHSignatureScript([]byte{btcscript.OP_DUP},
+		redeemScript)
+	if err != btcscript.ErrStackP2SHNonPushOnly {
+		t.Errorf("P2SHSignatureScript non push only got error %v, "+
+			"want %v", err, btcscript.ErrStackP2SHNonPushOnly)
+	}
+	_, err = btcscript.P2SHSignatureScript([]byte{btcscript.OP_DATA_2},
+		redeemScript)
+	if err != btcscript.ErrStackShortScript {
+		t.Errorf("P2SHSignatureScript unparsable got error %v, want %v",
+			err, btcscript.ErrStackShortScript)
+	}
+	_, err = btcscript.P2SHSignatureScript(sigScript,
+		make([]byte, btcscript.MaxScriptElementSize+1))
+	if err != btcscript.ErrStackElementTooBig {
+		t.Errorf("P2SHSignatureScript oversized got error %v, want %v",
+			err, btcscript.ErrStackElementTooBig)
+	}
+}
+
 var classStringifyTests = []struct {
 	name        string
 	scriptclass btcscript.ScriptClass
@@ -2879,6 +5524,11 @@ var classStringifyTests = []struct {
 		scriptclass: btcscript.NullDataTy,
 		stringed:    "nulldata",
 	},
+	{
+		name:        "namescriptty",
+		scriptclass: btcscript.NameScriptTy,
+		stringed:    "name",
+	},
 	{
 		name:        "broken",
 		scriptclass: btcscript.ScriptClass(255),
@@ -3077,6 +5727,87 @@ func TestPayToAddrScript(t *testing.T) {
 	}
 }
 
+// TestPayToAddrScriptPubKeyRoundTrip ensures pay-to-pubkey scripts keep the
+// exact encoding of the public key, so that a script built from an address and
+// the address extracted from it again give back the same bytes.
+func TestPayToAddrScriptPubKeyRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		pubKey string
+		format btcutil.PubKeyFormat
+	}{
+		{"compressed (0x02)", "02192d74d0cb94344c9569c2e77901573d8d7903c3" +
+			"ebec3a957724895dca52c6b4", btcutil.PKFCompressed},
+		{"compressed (0x03)", "03b0bd634234abbb1ba1e986e884185c61cf43e001" +
+			"f9137f23c2c409273eb16e65", btcutil.PKFCompressed},
+		{"uncompressed (0x04)", "0411db93e1dcdb8a016b49840f8c53bc1eb68a38" +
+			"2e97b1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e16" +
+			"0bfa9b8b64f9d4c03f999b8643f656b412a3",
+			btcutil.PKFUncompressed},
+		{"hybrid (0x06)", "06192d74d0cb94344c9569c2e77901573d8d7903c3ebec" +
+			"3a957724895dca52c6b40d45264838c0bd96852662ce6a847b1973" +
+			"76830160c6d2eb5e6a4c44d33f453e", btcutil.PKFHybrid},
+		{"hybrid (0x07)", "07b0bd634234abbb1ba1e986e884185c61cf43e001f913" +
+			"7f23c2c409273eb16e6537a576782eba668a7ef8bd3b3cfb1edb71" +
+			"17ab65129b8a2e681f3c1e0908ef7b", btcutil.PKFHybrid},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		pubKey := decodeHex(test.pubKey)
+		want := builderScript(btcscript.NewScriptBuilder().
+			AddData(pubKey).AddOp(btcscript.OP_CHECKSIG))
+
+		addr, err := btcutil.NewAddressPubKey(pubKey,
+			&btcnet.MainNetParams)
+		if err != nil {
+			t.Errorf("NewAddressPubKey #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		script, err := btcscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Errorf("PayToAddrScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(script, want) {
+			t.Errorf("PayToAddrScript #%d (%s) got %x, want %x", i,
+				test.name, script, want)
+			continue
+		}
+
+		_, addrs, _, err := btcscript.ExtractPkScriptAddrs(script,
+			&btcnet.MainNetParams)
+		if err != nil || len(addrs) != 1 {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) got %v, %v", i,
+				test.name, addrs, err)
+			continue
+		}
+		extracted, ok := addrs[0].(*btcutil.AddressPubKey)
+		if !ok {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) got %T, want "+
+				"*btcutil.AddressPubKey", i, test.name, addrs[0])
+			continue
+		}
+		if extracted.Format() != test.format {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) wrong format - "+
+				"got %v, want %v", i, test.name,
+				extracted.Format(), test.format)
+		}
+		if got := extracted.ScriptAddress(); !bytes.Equal(got, pubKey) {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) wrong key - got "+
+				"%x, want %x", i, test.name, got, pubKey)
+		}
+		script, err = btcscript.PayToAddrScript(extracted)
+		if err != nil || !bytes.Equal(script, want) {
+			t.Errorf("PayToAddrScript #%d (%s) of extracted address "+
+				"got %x, %v, want %x", i, test.name, script, err,
+				want)
+		}
+	}
+}
+
 func TestMultiSigScript(t *testing.T) {
 	//  mainnet p2pk 13CG6SJ3yHUXo4Cr2RY4THLLJrNFuG3gUg
 	p2pkCompressedMain, err := btcutil.NewAddressPubKey([]byte{
@@ -3178,50 +5909,213 @@ func TestMultiSigScript(t *testing.T) {
 			btcscript.ErrBadNumRequired,
 		},
 		{
+			// Uncompressed keys are pushed compressed.
 			[]*btcutil.AddressPubKey{
 				p2pkUncompressedMain,
 			},
 			1,
 			[]byte{
-				btcscript.OP_1, btcscript.OP_DATA_65,
-				0x04, 0x11, 0xdb, 0x93, 0xe1, 0xdc, 0xdb, 0x8a,
+				btcscript.OP_1, btcscript.OP_DATA_33,
+				0x03, 0x11, 0xdb, 0x93, 0xe1, 0xdc, 0xdb, 0x8a,
 				0x01, 0x6b, 0x49, 0x84, 0x0f, 0x8c, 0x53, 0xbc,
 				0x1e, 0xb6, 0x8a, 0x38, 0x2e, 0x97, 0xb1, 0x48,
 				0x2e, 0xca, 0xd7, 0xb1, 0x48, 0xa6, 0x90, 0x9a,
-				0x5c, 0xb2, 0xe0, 0xea, 0xdd, 0xfb, 0x84, 0xcc,
-				0xf9, 0x74, 0x44, 0x64, 0xf8, 0x2e, 0x16, 0x0b,
-				0xfa, 0x9b, 0x8b, 0x64, 0xf9, 0xd4, 0xc0, 0x3f,
-				0x99, 0x9b, 0x86, 0x43, 0xf6, 0x56, 0xb4, 0x12,
-				0xa3,
+				0x5c,
 				btcscript.OP_1, btcscript.OP_CHECKMULTISIG,
 			},
 			nil,
 		},
 		{
-			[]*btcutil.AddressPubKey{
-				p2pkUncompressedMain,
-			},
-			2,
-			[]byte{},
-			btcscript.ErrBadNumRequired,
+			[]*btcutil.AddressPubKey{
+				p2pkUncompressedMain,
+			},
+			2,
+			[]byte{},
+			btcscript.ErrBadNumRequired,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		script, err := btcscript.MultiSigScript(test.keys,
+			test.nrequired)
+		if err != test.err {
+			t.Errorf("MultiSigScript #%d unexpected error - "+
+				"got %v, want %v", i, err, test.err)
+			continue
+		}
+
+		if !bytes.Equal(script, test.expected) {
+			t.Errorf("MultiSigScript #%d got: %x\nwant: %x",
+				i, script, test.expected)
+			continue
+		}
+	}
+}
+
+// TestMultiSigScriptRoundTrip ensures multisig scripts built by MultiSigScript
+// are classified as multisig and give back the keys and required signatures
+// they were built with, and that invalid key counts are rejected.
+func TestMultiSigScriptRoundTrip(t *testing.T) {
+	var keys []*btcutil.AddressPubKey
+	for _, serialized := range []string{
+		"02192d74d0cb94344c9569c2e77901573d8d7903c3ebec3a957724895dca52c6b4",
+		"03b0bd634234abbb1ba1e986e884185c61cf43e001f9137f23c2c409273eb16e65",
+		"03b0bd634234abbb1ba1e986e884185c61cf43e001f9137f23c2c409273eb16e65",
+	} {
+		key, err := btcutil.NewAddressPubKey(decodeHex(serialized),
+			&btcnet.MainNetParams)
+		if err != nil {
+			t.Fatalf("Unable to create pubkey address: %v", err)
+		}
+		keys = append(keys, key)
+	}
+
+	for nrequired := 1; nrequired <= len(keys); nrequired++ {
+		script, err := btcscript.MultiSigScript(keys, nrequired)
+		if err != nil {
+			t.Errorf("MultiSigScript %d of %d unexpected error: %v",
+				nrequired, len(keys), err)
+			continue
+		}
+
+		if class := btcscript.GetScriptClass(script); class != btcscript.MultiSigTy {
+			t.Errorf("MultiSigScript %d of %d wrong class: got %v",
+				nrequired, len(keys), class)
+			continue
+		}
+
+		_, addrs, reqSigs, err := btcscript.ExtractPkScriptAddrs(script,
+			&btcnet.MainNetParams)
+		if err != nil {
+			t.Errorf("ExtractPkScriptAddrs %d of %d unexpected "+
+				"error: %v", nrequired, len(keys), err)
+			continue
+		}
+		if reqSigs != nrequired {
+			t.Errorf("ExtractPkScriptAddrs %d of %d wrong required "+
+				"signatures: got %d", nrequired, len(keys), reqSigs)
+		}
+		if len(addrs) != len(keys) {
+			t.Errorf("ExtractPkScriptAddrs %d of %d wrong number of "+
+				"addresses: got %d", nrequired, len(keys),
+				len(addrs))
+			continue
+		}
+		for i, addr := range addrs {
+			if addr.EncodeAddress() != keys[i].EncodeAddress() {
+				t.Errorf("ExtractPkScriptAddrs %d of %d wrong "+
+					"address %d: got %v, want %v", nrequired,
+					len(keys), i, addr, keys[i])
+			}
+		}
+	}
+
+	tooMany := make([]*btcutil.AddressPubKey, btcscript.MaxPubKeysPerMultiSig+1)
+	for i := range tooMany {
+		tooMany[i] = keys[0]
+	}
+	errTests := []struct {
+		name      string
+		keys      []*btcutil.AddressPubKey
+		nrequired int
+		err       error
+	}{
+		{"zero required", keys, 0, btcscript.ErrBadNumRequired},
+		{"negative required", keys, -1, btcscript.ErrBadNumRequired},
+		{"more required than keys", keys, 4, btcscript.ErrBadNumRequired},
+		{"max keys", tooMany[1:], 1, nil},
+		{"too many keys", tooMany, 1, btcscript.ErrTooManyPubKeys},
+	}
+	for _, test := range errTests {
+		_, err := btcscript.MultiSigScript(test.keys, test.nrequired)
+		if err != test.err {
+			t.Errorf("MultiSigScript (%s) unexpected error - got "+
+				"%v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+// TestPayToScriptHashScript ensures the pay-to-script-hash scripts and
+// addresses built from redeem scripts are recognised as such and extract back
+// to the same address.
+func TestPayToScriptHashScript(t *testing.T) {
+	tests := []struct {
+		name         string
+		redeemScript []byte
+		err          error
+	}{
+		{
+			name:         "empty redeem script",
+			redeemScript: []byte{},
+		},
+		{
+			name: "1 of 1 multisig",
+			redeemScript: decodeHex("51210279be667ef9dcbbac55a06295" +
+				"ce870b07029bfcdb2dce28d959f2815b16f8179851ae"),
+		},
+		{
+			name:         "max size redeem script",
+			redeemScript: make([]byte, btcscript.MaxScriptElementSize),
+		},
+		{
+			name:         "redeem script too big",
+			redeemScript: make([]byte, btcscript.MaxScriptElementSize+1),
+			err:          btcscript.ErrRedeemScriptTooBig,
 		},
 	}
 
 	t.Logf("Running %d tests", len(tests))
 	for i, test := range tests {
-		script, err := btcscript.MultiSigScript(test.keys,
-			test.nrequired)
+		script, err := btcscript.PayToScriptHashScript(test.redeemScript)
 		if err != test.err {
-			t.Errorf("MultiSigScript #%d unexpected error - "+
-				"got %v, want %v", i, err, test.err)
+			t.Errorf("PayToScriptHashScript #%d (%s) unexpected "+
+				"error - got %v, want %v", i, test.name, err,
+				test.err)
+			continue
+		}
+		addr, err := btcscript.ScriptHashAddr(test.redeemScript,
+			&btcnet.MainNetParams)
+		if err != test.err {
+			t.Errorf("ScriptHashAddr #%d (%s) unexpected error - "+
+				"got %v, want %v", i, test.name, err, test.err)
+			continue
+		}
+		if test.err != nil {
 			continue
 		}
 
-		if !bytes.Equal(script, test.expected) {
-			t.Errorf("MultiSigScript #%d got: %x\nwant: %x",
-				i, script, test.expected)
+		if class := btcscript.GetScriptClass(script); class != btcscript.ScriptHashTy {
+			t.Errorf("PayToScriptHashScript #%d (%s) wrong class: "+
+				"got %v", i, test.name, class)
+			continue
+		}
+
+		_, addrs, reqSigs, err := btcscript.ExtractPkScriptAddrs(script,
+			&btcnet.MainNetParams)
+		if err != nil {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if reqSigs != 1 || len(addrs) != 1 ||
+			addrs[0].EncodeAddress() != addr.EncodeAddress() {
+			t.Errorf("ExtractPkScriptAddrs #%d (%s) got %v (%d "+
+				"required), want %v", i, test.name, addrs,
+				reqSigs, addr)
+			continue
+		}
+
+		addrScript, err := btcscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Errorf("PayToAddrScript #%d (%s) unexpected error: %v",
+				i, test.name, err)
 			continue
 		}
+		if !bytes.Equal(addrScript, script) {
+			t.Errorf("PayToAddrScript #%d (%s) got %x, want %x", i,
+				test.name, addrScript, script)
+		}
 	}
 }
 
@@ -3587,7 +6481,224 @@ func TestSignTxOutput(t *testing.T) {
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
-
+
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			if err != nil {
+				t.Errorf("failed to make privKey for %s: %v",
+					msg, err)
+				break
+			}
+
+			pk := (*btcec.PublicKey)(&key.PublicKey).
+				SerializeUncompressed()
+			address, err := btcutil.NewAddressPubKey(pk,
+				&btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make address for %s: %v",
+					msg, err)
+				break
+			}
+
+			pkScript, err := btcscript.PayToAddrScript(address)
+			if err != nil {
+				t.Errorf("failed to make pkscript "+
+					"for %s: %v", msg, err)
+			}
+
+			sigScript, err := btcscript.SignTxOutput(
+				&btcnet.TestNet3Params, tx, i, pkScript,
+				hashType, mkGetKey(map[string]addressToKey{
+					address.EncodeAddress(): {key, false},
+				}), mkGetScript(nil), []byte{})
+			if err != nil {
+				t.Errorf("failed to sign output %s: %v", msg,
+					err)
+				break
+			}
+
+			// by the above loop, this should be valid, now sign
+			// again and merge.
+			sigScript, err = btcscript.SignTxOutput(
+				&btcnet.TestNet3Params, tx, i, pkScript,
+				hashType, mkGetKey(map[string]addressToKey{
+					address.EncodeAddress(): {key, false},
+				}), mkGetScript(nil), sigScript)
+			if err != nil {
+				t.Errorf("failed to sign output %s a "+
+					"second time: %v", msg, err)
+				break
+			}
+
+			err = checkScripts(msg, tx, i, sigScript, pkScript)
+			if err != nil {
+				t.Errorf("twice signed script invalid for "+
+					"%s: %v", msg, err)
+				break
+			}
+		}
+	}
+
+	// Pay to PubKey (compressed)
+	for _, hashType := range hashTypes {
+		for i := range tx.TxIn {
+			msg := fmt.Sprintf("%d:%d", hashType, i)
+
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			if err != nil {
+				t.Errorf("failed to make privKey for %s: %v",
+					msg, err)
+				break
+			}
+
+			pk := (*btcec.PublicKey)(&key.PublicKey).
+				SerializeCompressed()
+			address, err := btcutil.NewAddressPubKey(pk,
+				&btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make address for %s: %v",
+					msg, err)
+				break
+			}
+
+			pkScript, err := btcscript.PayToAddrScript(address)
+			if err != nil {
+				t.Errorf("failed to make pkscript "+
+					"for %s: %v", msg, err)
+			}
+
+			if err := signAndCheck(msg, tx, i, pkScript, hashType,
+				mkGetKey(map[string]addressToKey{
+					address.EncodeAddress(): {key, true},
+				}), mkGetScript(nil), []byte{}); err != nil {
+				t.Error(err)
+				break
+			}
+		}
+	}
+
+	// Pay to PubKey (compressed) with duplicate merge
+	for _, hashType := range hashTypes {
+		for i := range tx.TxIn {
+			msg := fmt.Sprintf("%d:%d", hashType, i)
+
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			if err != nil {
+				t.Errorf("failed to make privKey for %s: %v",
+					msg, err)
+				break
+			}
+
+			pk := (*btcec.PublicKey)(&key.PublicKey).
+				SerializeCompressed()
+			address, err := btcutil.NewAddressPubKey(pk,
+				&btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make address for %s: %v",
+					msg, err)
+				break
+			}
+
+			pkScript, err := btcscript.PayToAddrScript(address)
+			if err != nil {
+				t.Errorf("failed to make pkscript "+
+					"for %s: %v", msg, err)
+			}
+
+			sigScript, err := btcscript.SignTxOutput(
+				&btcnet.TestNet3Params, tx, i, pkScript,
+				hashType, mkGetKey(map[string]addressToKey{
+					address.EncodeAddress(): {key, true},
+				}), mkGetScript(nil), []byte{})
+			if err != nil {
+				t.Errorf("failed to sign output %s: %v", msg,
+					err)
+				break
+			}
+
+			// by the above loop, this should be valid, now sign
+			// again and merge.
+			sigScript, err = btcscript.SignTxOutput(
+				&btcnet.TestNet3Params, tx, i, pkScript,
+				hashType, mkGetKey(map[string]addressToKey{
+					address.EncodeAddress(): {key, true},
+				}), mkGetScript(nil), sigScript)
+			if err != nil {
+				t.Errorf("failed to sign output %s a "+
+					"second time: %v", msg, err)
+				break
+			}
+
+			err = checkScripts(msg, tx, i, sigScript, pkScript)
+			if err != nil {
+				t.Errorf("twice signed script invalid for "+
+					"%s: %v", msg, err)
+				break
+			}
+		}
+	}
+
+	// As before, but with p2sh now.
+	// Pay to Pubkey Hash (uncompressed)
+	for _, hashType := range hashTypes {
+		for i := range tx.TxIn {
+			msg := fmt.Sprintf("%d:%d", hashType, i)
+			key, err := btcec.NewPrivateKey(btcec.S256())
+			if err != nil {
+				t.Errorf("failed to make privKey for %s: %v",
+					msg, err)
+				break
+			}
+
+			pk := (*btcec.PublicKey)(&key.PublicKey).
+				SerializeUncompressed()
+			address, err := btcutil.NewAddressPubKeyHash(
+				btcutil.Hash160(pk), &btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make address for %s: %v",
+					msg, err)
+				break
+			}
+
+			pkScript, err := btcscript.PayToAddrScript(address)
+			if err != nil {
+				t.Errorf("failed to make pkscript "+
+					"for %s: %v", msg, err)
+				break
+			}
+
+			scriptAddr, err := btcutil.NewAddressScriptHash(
+				pkScript, &btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make p2sh addr for %s: %v",
+					msg, err)
+				break
+			}
+
+			scriptPkScript, err := btcscript.PayToAddrScript(
+				scriptAddr)
+			if err != nil {
+				t.Errorf("failed to make script pkscript for "+
+					"%s: %v", msg, err)
+				break
+			}
+
+			if err := signAndCheck(msg, tx, i, scriptPkScript,
+				hashType,
+				mkGetKey(map[string]addressToKey{
+					address.EncodeAddress(): {key, false},
+				}), mkGetScript(map[string][]byte{
+					scriptAddr.EncodeAddress(): pkScript,
+				}), []byte{}); err != nil {
+				t.Error(err)
+				break
+			}
+		}
+	}
+
+	// Pay to Pubkey Hash (uncompressed) with duplicate merge
+	for _, hashType := range hashTypes {
+		for i := range tx.TxIn {
+			msg := fmt.Sprintf("%d:%d", hashType, i)
 			key, err := btcec.NewPrivateKey(btcec.S256())
 			if err != nil {
 				t.Errorf("failed to make privKey for %s: %v",
@@ -3597,8 +6708,8 @@ func TestSignTxOutput(t *testing.T) {
 
 			pk := (*btcec.PublicKey)(&key.PublicKey).
 				SerializeUncompressed()
-			address, err := btcutil.NewAddressPubKey(pk,
-				&btcnet.TestNet3Params)
+			address, err := btcutil.NewAddressPubKeyHash(
+				btcutil.Hash160(pk), &btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
 					msg, err)
@@ -3609,13 +6720,32 @@ func TestSignTxOutput(t *testing.T) {
 			if err != nil {
 				t.Errorf("failed to make pkscript "+
 					"for %s: %v", msg, err)
+				break
+			}
+
+			scriptAddr, err := btcutil.NewAddressScriptHash(
+				pkScript, &btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make p2sh addr for %s: %v",
+					msg, err)
+				break
+			}
+
+			scriptPkScript, err := btcscript.PayToAddrScript(
+				scriptAddr)
+			if err != nil {
+				t.Errorf("failed to make script pkscript for "+
+					"%s: %v", msg, err)
+				break
 			}
 
 			sigScript, err := btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, pkScript,
+				&btcnet.TestNet3Params, tx, i, scriptPkScript,
 				hashType, mkGetKey(map[string]addressToKey{
 					address.EncodeAddress(): {key, false},
-				}), mkGetScript(nil), []byte{})
+				}), mkGetScript(map[string][]byte{
+					scriptAddr.EncodeAddress(): pkScript,
+				}), []byte{})
 			if err != nil {
 				t.Errorf("failed to sign output %s: %v", msg,
 					err)
@@ -3625,17 +6755,19 @@ func TestSignTxOutput(t *testing.T) {
 			// by the above loop, this should be valid, now sign
 			// again and merge.
 			sigScript, err = btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, pkScript,
+				&btcnet.TestNet3Params, tx, i, scriptPkScript,
 				hashType, mkGetKey(map[string]addressToKey{
 					address.EncodeAddress(): {key, false},
-				}), mkGetScript(nil), sigScript)
+				}), mkGetScript(map[string][]byte{
+					scriptAddr.EncodeAddress(): pkScript,
+				}), []byte{})
 			if err != nil {
 				t.Errorf("failed to sign output %s a "+
 					"second time: %v", msg, err)
 				break
 			}
 
-			err = checkScripts(msg, tx, i, sigScript, pkScript)
+			err = checkScripts(msg, tx, i, sigScript, scriptPkScript)
 			if err != nil {
 				t.Errorf("twice signed script invalid for "+
 					"%s: %v", msg, err)
@@ -3644,7 +6776,7 @@ func TestSignTxOutput(t *testing.T) {
 		}
 	}
 
-	// Pay to PubKey (compressed)
+	// Pay to Pubkey Hash (compressed)
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
@@ -3658,8 +6790,8 @@ func TestSignTxOutput(t *testing.T) {
 
 			pk := (*btcec.PublicKey)(&key.PublicKey).
 				SerializeCompressed()
-			address, err := btcutil.NewAddressPubKey(pk,
-				&btcnet.TestNet3Params)
+			address, err := btcutil.NewAddressPubKeyHash(
+				btcutil.Hash160(pk), &btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
 					msg, err)
@@ -3672,17 +6804,36 @@ func TestSignTxOutput(t *testing.T) {
 					"for %s: %v", msg, err)
 			}
 
-			if err := signAndCheck(msg, tx, i, pkScript, hashType,
+			scriptAddr, err := btcutil.NewAddressScriptHash(
+				pkScript, &btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make p2sh addr for %s: %v",
+					msg, err)
+				break
+			}
+
+			scriptPkScript, err := btcscript.PayToAddrScript(
+				scriptAddr)
+			if err != nil {
+				t.Errorf("failed to make script pkscript for "+
+					"%s: %v", msg, err)
+				break
+			}
+
+			if err := signAndCheck(msg, tx, i, scriptPkScript,
+				hashType,
 				mkGetKey(map[string]addressToKey{
 					address.EncodeAddress(): {key, true},
-				}), mkGetScript(nil), []byte{}); err != nil {
+				}), mkGetScript(map[string][]byte{
+					scriptAddr.EncodeAddress(): pkScript,
+				}), []byte{}); err != nil {
 				t.Error(err)
 				break
 			}
 		}
 	}
 
-	// Pay to PubKey (compressed) with duplicate merge
+	// Pay to Pubkey Hash (compressed) with duplicate merge
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
@@ -3696,8 +6847,8 @@ func TestSignTxOutput(t *testing.T) {
 
 			pk := (*btcec.PublicKey)(&key.PublicKey).
 				SerializeCompressed()
-			address, err := btcutil.NewAddressPubKey(pk,
-				&btcnet.TestNet3Params)
+			address, err := btcutil.NewAddressPubKeyHash(
+				btcutil.Hash160(pk), &btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
 					msg, err)
@@ -3710,11 +6861,29 @@ func TestSignTxOutput(t *testing.T) {
 					"for %s: %v", msg, err)
 			}
 
+			scriptAddr, err := btcutil.NewAddressScriptHash(
+				pkScript, &btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make p2sh addr for %s: %v",
+					msg, err)
+				break
+			}
+
+			scriptPkScript, err := btcscript.PayToAddrScript(
+				scriptAddr)
+			if err != nil {
+				t.Errorf("failed to make script pkscript for "+
+					"%s: %v", msg, err)
+				break
+			}
+
 			sigScript, err := btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, pkScript,
+				&btcnet.TestNet3Params, tx, i, scriptPkScript,
 				hashType, mkGetKey(map[string]addressToKey{
 					address.EncodeAddress(): {key, true},
-				}), mkGetScript(nil), []byte{})
+				}), mkGetScript(map[string][]byte{
+					scriptAddr.EncodeAddress(): pkScript,
+				}), []byte{})
 			if err != nil {
 				t.Errorf("failed to sign output %s: %v", msg,
 					err)
@@ -3724,17 +6893,19 @@ func TestSignTxOutput(t *testing.T) {
 			// by the above loop, this should be valid, now sign
 			// again and merge.
 			sigScript, err = btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, pkScript,
+				&btcnet.TestNet3Params, tx, i, scriptPkScript,
 				hashType, mkGetKey(map[string]addressToKey{
 					address.EncodeAddress(): {key, true},
-				}), mkGetScript(nil), sigScript)
+				}), mkGetScript(map[string][]byte{
+					scriptAddr.EncodeAddress(): pkScript,
+				}), []byte{})
 			if err != nil {
 				t.Errorf("failed to sign output %s a "+
 					"second time: %v", msg, err)
 				break
 			}
 
-			err = checkScripts(msg, tx, i, sigScript, pkScript)
+			err = checkScripts(msg, tx, i, sigScript, scriptPkScript)
 			if err != nil {
 				t.Errorf("twice signed script invalid for "+
 					"%s: %v", msg, err)
@@ -3743,11 +6914,11 @@ func TestSignTxOutput(t *testing.T) {
 		}
 	}
 
-	// As before, but with p2sh now.
-	// Pay to Pubkey Hash (uncompressed)
+	// Pay to PubKey (uncompressed)
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
+
 			key, err := btcec.NewPrivateKey(btcec.S256())
 			if err != nil {
 				t.Errorf("failed to make privKey for %s: %v",
@@ -3757,8 +6928,8 @@ func TestSignTxOutput(t *testing.T) {
 
 			pk := (*btcec.PublicKey)(&key.PublicKey).
 				SerializeUncompressed()
-			address, err := btcutil.NewAddressPubKeyHash(
-				btcutil.Hash160(pk), &btcnet.TestNet3Params)
+			address, err := btcutil.NewAddressPubKey(pk,
+				&btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
 					msg, err)
@@ -3769,7 +6940,6 @@ func TestSignTxOutput(t *testing.T) {
 			if err != nil {
 				t.Errorf("failed to make pkscript "+
 					"for %s: %v", msg, err)
-				break
 			}
 
 			scriptAddr, err := btcutil.NewAddressScriptHash(
@@ -3801,10 +6971,11 @@ func TestSignTxOutput(t *testing.T) {
 		}
 	}
 
-	// Pay to Pubkey Hash (uncompressed) with duplicate merge
+	// Pay to PubKey (uncompressed) with duplicate merge
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
+
 			key, err := btcec.NewPrivateKey(btcec.S256())
 			if err != nil {
 				t.Errorf("failed to make privKey for %s: %v",
@@ -3814,8 +6985,8 @@ func TestSignTxOutput(t *testing.T) {
 
 			pk := (*btcec.PublicKey)(&key.PublicKey).
 				SerializeUncompressed()
-			address, err := btcutil.NewAddressPubKeyHash(
-				btcutil.Hash160(pk), &btcnet.TestNet3Params)
+			address, err := btcutil.NewAddressPubKey(pk,
+				&btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
 					msg, err)
@@ -3826,7 +6997,6 @@ func TestSignTxOutput(t *testing.T) {
 			if err != nil {
 				t.Errorf("failed to make pkscript "+
 					"for %s: %v", msg, err)
-				break
 			}
 
 			scriptAddr, err := btcutil.NewAddressScriptHash(
@@ -3882,7 +7052,7 @@ func TestSignTxOutput(t *testing.T) {
 		}
 	}
 
-	// Pay to Pubkey Hash (compressed)
+	// Pay to PubKey (compressed)
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
@@ -3896,8 +7066,8 @@ func TestSignTxOutput(t *testing.T) {
 
 			pk := (*btcec.PublicKey)(&key.PublicKey).
 				SerializeCompressed()
-			address, err := btcutil.NewAddressPubKeyHash(
-				btcutil.Hash160(pk), &btcnet.TestNet3Params)
+			address, err := btcutil.NewAddressPubKey(pk,
+				&btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
 					msg, err)
@@ -3939,7 +7109,7 @@ func TestSignTxOutput(t *testing.T) {
 		}
 	}
 
-	// Pay to Pubkey Hash (compressed) with duplicate merge
+	// Pay to PubKey (compressed)
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
@@ -3953,8 +7123,8 @@ func TestSignTxOutput(t *testing.T) {
 
 			pk := (*btcec.PublicKey)(&key.PublicKey).
 				SerializeCompressed()
-			address, err := btcutil.NewAddressPubKeyHash(
-				btcutil.Hash160(pk), &btcnet.TestNet3Params)
+			address, err := btcutil.NewAddressPubKey(pk,
+				&btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
 					msg, err)
@@ -4020,21 +7190,21 @@ func TestSignTxOutput(t *testing.T) {
 		}
 	}
 
-	// Pay to PubKey (uncompressed)
+	// Basic Multisig
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
 
-			key, err := btcec.NewPrivateKey(btcec.S256())
+			key1, err := btcec.NewPrivateKey(btcec.S256())
 			if err != nil {
 				t.Errorf("failed to make privKey for %s: %v",
 					msg, err)
 				break
 			}
 
-			pk := (*btcec.PublicKey)(&key.PublicKey).
-				SerializeUncompressed()
-			address, err := btcutil.NewAddressPubKey(pk,
+			pk1 := (*btcec.PublicKey)(&key1.PublicKey).
+				SerializeCompressed()
+			address1, err := btcutil.NewAddressPubKey(pk1,
 				&btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
@@ -4042,7 +7212,26 @@ func TestSignTxOutput(t *testing.T) {
 				break
 			}
 
-			pkScript, err := btcscript.PayToAddrScript(address)
+			key2, err := btcec.NewPrivateKey(btcec.S256())
+			if err != nil {
+				t.Errorf("failed to make privKey 2 for %s: %v",
+					msg, err)
+				break
+			}
+
+			pk2 := (*btcec.PublicKey)(&key2.PublicKey).
+				SerializeCompressed()
+			address2, err := btcutil.NewAddressPubKey(pk2,
+				&btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make address 2 for %s: %v",
+					msg, err)
+				break
+			}
+
+			pkScript, err := btcscript.MultiSigScript(
+				[]*btcutil.AddressPubKey{address1, address2},
+				2)
 			if err != nil {
 				t.Errorf("failed to make pkscript "+
 					"for %s: %v", msg, err)
@@ -4067,7 +7256,8 @@ func TestSignTxOutput(t *testing.T) {
 			if err := signAndCheck(msg, tx, i, scriptPkScript,
 				hashType,
 				mkGetKey(map[string]addressToKey{
-					address.EncodeAddress(): {key, false},
+					address1.EncodeAddress(): {key1, true},
+					address2.EncodeAddress(): {key2, true},
 				}), mkGetScript(map[string][]byte{
 					scriptAddr.EncodeAddress(): pkScript,
 				}), []byte{}); err != nil {
@@ -4077,21 +7267,21 @@ func TestSignTxOutput(t *testing.T) {
 		}
 	}
 
-	// Pay to PubKey (uncompressed) with duplicate merge
+	// Two part multisig, sign with one key then the other.
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
 
-			key, err := btcec.NewPrivateKey(btcec.S256())
+			key1, err := btcec.NewPrivateKey(btcec.S256())
 			if err != nil {
 				t.Errorf("failed to make privKey for %s: %v",
 					msg, err)
 				break
 			}
 
-			pk := (*btcec.PublicKey)(&key.PublicKey).
-				SerializeUncompressed()
-			address, err := btcutil.NewAddressPubKey(pk,
+			pk1 := (*btcec.PublicKey)(&key1.PublicKey).
+				SerializeCompressed()
+			address1, err := btcutil.NewAddressPubKey(pk1,
 				&btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
@@ -4099,7 +7289,26 @@ func TestSignTxOutput(t *testing.T) {
 				break
 			}
 
-			pkScript, err := btcscript.PayToAddrScript(address)
+			key2, err := btcec.NewPrivateKey(btcec.S256())
+			if err != nil {
+				t.Errorf("failed to make privKey 2 for %s: %v",
+					msg, err)
+				break
+			}
+
+			pk2 := (*btcec.PublicKey)(&key2.PublicKey).
+				SerializeCompressed()
+			address2, err := btcutil.NewAddressPubKey(pk2,
+				&btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make address 2 for %s: %v",
+					msg, err)
+				break
+			}
+
+			pkScript, err := btcscript.MultiSigScript(
+				[]*btcutil.AddressPubKey{address1, address2},
+				2)
 			if err != nil {
 				t.Errorf("failed to make pkscript "+
 					"for %s: %v", msg, err)
@@ -4124,7 +7333,7 @@ func TestSignTxOutput(t *testing.T) {
 			sigScript, err := btcscript.SignTxOutput(
 				&btcnet.TestNet3Params, tx, i, scriptPkScript,
 				hashType, mkGetKey(map[string]addressToKey{
-					address.EncodeAddress(): {key, false},
+					address1.EncodeAddress(): {key1, true},
 				}), mkGetScript(map[string][]byte{
 					scriptAddr.EncodeAddress(): pkScript,
 				}), []byte{})
@@ -4134,102 +7343,52 @@ func TestSignTxOutput(t *testing.T) {
 				break
 			}
 
-			// by the above loop, this should be valid, now sign
-			// again and merge.
-			sigScript, err = btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, scriptPkScript,
-				hashType, mkGetKey(map[string]addressToKey{
-					address.EncodeAddress(): {key, false},
-				}), mkGetScript(map[string][]byte{
-					scriptAddr.EncodeAddress(): pkScript,
-				}), []byte{})
-			if err != nil {
-				t.Errorf("failed to sign output %s a "+
-					"second time: %v", msg, err)
-				break
-			}
-
-			err = checkScripts(msg, tx, i, sigScript, scriptPkScript)
-			if err != nil {
-				t.Errorf("twice signed script invalid for "+
-					"%s: %v", msg, err)
-				break
-			}
-		}
-	}
-
-	// Pay to PubKey (compressed)
-	for _, hashType := range hashTypes {
-		for i := range tx.TxIn {
-			msg := fmt.Sprintf("%d:%d", hashType, i)
-
-			key, err := btcec.NewPrivateKey(btcec.S256())
-			if err != nil {
-				t.Errorf("failed to make privKey for %s: %v",
-					msg, err)
-				break
-			}
-
-			pk := (*btcec.PublicKey)(&key.PublicKey).
-				SerializeCompressed()
-			address, err := btcutil.NewAddressPubKey(pk,
-				&btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make address for %s: %v",
-					msg, err)
+			// Only 1 out of 2 signed, this *should* fail.
+			if checkScripts(msg, tx, i, sigScript,
+				scriptPkScript) == nil {
+				t.Errorf("part signed script valid for %s", msg)
 				break
 			}
 
-			pkScript, err := btcscript.PayToAddrScript(address)
-			if err != nil {
-				t.Errorf("failed to make pkscript "+
-					"for %s: %v", msg, err)
-			}
-
-			scriptAddr, err := btcutil.NewAddressScriptHash(
-				pkScript, &btcnet.TestNet3Params)
+			// Sign with the other key and merge
+			sigScript, err = btcscript.SignTxOutput(
+				&btcnet.TestNet3Params, tx, i, scriptPkScript,
+				hashType, mkGetKey(map[string]addressToKey{
+					address2.EncodeAddress(): {key2, true},
+				}), mkGetScript(map[string][]byte{
+					scriptAddr.EncodeAddress(): pkScript,
+				}), sigScript)
 			if err != nil {
-				t.Errorf("failed to make p2sh addr for %s: %v",
-					msg, err)
+				t.Errorf("failed to sign output %s: %v", msg, err)
 				break
 			}
 
-			scriptPkScript, err := btcscript.PayToAddrScript(
-				scriptAddr)
+			err = checkScripts(msg, tx, i, sigScript,
+				scriptPkScript)
 			if err != nil {
-				t.Errorf("failed to make script pkscript for "+
+				t.Errorf("fully signed script invalid for "+
 					"%s: %v", msg, err)
 				break
 			}
-
-			if err := signAndCheck(msg, tx, i, scriptPkScript,
-				hashType,
-				mkGetKey(map[string]addressToKey{
-					address.EncodeAddress(): {key, true},
-				}), mkGetScript(map[string][]byte{
-					scriptAddr.EncodeAddress(): pkScript,
-				}), []byte{}); err != nil {
-				t.Error(err)
-				break
-			}
 		}
 	}
 
-	// Pay to PubKey (compressed)
+	// Two part multisig, sign with one key then both, check key dedup
+	// correctly.
 	for _, hashType := range hashTypes {
 		for i := range tx.TxIn {
 			msg := fmt.Sprintf("%d:%d", hashType, i)
 
-			key, err := btcec.NewPrivateKey(btcec.S256())
+			key1, err := btcec.NewPrivateKey(btcec.S256())
 			if err != nil {
 				t.Errorf("failed to make privKey for %s: %v",
 					msg, err)
 				break
 			}
 
-			pk := (*btcec.PublicKey)(&key.PublicKey).
+			pk1 := (*btcec.PublicKey)(&key1.PublicKey).
 				SerializeCompressed()
-			address, err := btcutil.NewAddressPubKey(pk,
+			address1, err := btcutil.NewAddressPubKey(pk1,
 				&btcnet.TestNet3Params)
 			if err != nil {
 				t.Errorf("failed to make address for %s: %v",
@@ -4237,7 +7396,26 @@ func TestSignTxOutput(t *testing.T) {
 				break
 			}
 
-			pkScript, err := btcscript.PayToAddrScript(address)
+			key2, err := btcec.NewPrivateKey(btcec.S256())
+			if err != nil {
+				t.Errorf("failed to make privKey 2 for %s: %v",
+					msg, err)
+				break
+			}
+
+			pk2 := (*btcec.PublicKey)(&key2.PublicKey).
+				SerializeCompressed()
+			address2, err := btcutil.NewAddressPubKey(pk2,
+				&btcnet.TestNet3Params)
+			if err != nil {
+				t.Errorf("failed to make address 2 for %s: %v",
+					msg, err)
+				break
+			}
+
+			pkScript, err := btcscript.MultiSigScript(
+				[]*btcutil.AddressPubKey{address1, address2},
+				2)
 			if err != nil {
 				t.Errorf("failed to make pkscript "+
 					"for %s: %v", msg, err)
@@ -4262,7 +7440,7 @@ func TestSignTxOutput(t *testing.T) {
 			sigScript, err := btcscript.SignTxOutput(
 				&btcnet.TestNet3Params, tx, i, scriptPkScript,
 				hashType, mkGetKey(map[string]addressToKey{
-					address.EncodeAddress(): {key, true},
+					address1.EncodeAddress(): {key1, true},
 				}), mkGetScript(map[string][]byte{
 					scriptAddr.EncodeAddress(): pkScript,
 				}), []byte{})
@@ -4272,319 +7450,1187 @@ func TestSignTxOutput(t *testing.T) {
 				break
 			}
 
-			// by the above loop, this should be valid, now sign
-			// again and merge.
+			// Only 1 out of 2 signed, this *should* fail.
+			if checkScripts(msg, tx, i, sigScript,
+				scriptPkScript) == nil {
+				t.Errorf("part signed script valid for %s", msg)
+				break
+			}
+
+			// Sign with the other key and merge
 			sigScript, err = btcscript.SignTxOutput(
 				&btcnet.TestNet3Params, tx, i, scriptPkScript,
 				hashType, mkGetKey(map[string]addressToKey{
-					address.EncodeAddress(): {key, true},
+					address1.EncodeAddress(): {key1, true},
+					address2.EncodeAddress(): {key2, true},
 				}), mkGetScript(map[string][]byte{
 					scriptAddr.EncodeAddress(): pkScript,
-				}), []byte{})
+				}), sigScript)
 			if err != nil {
-				t.Errorf("failed to sign output %s a "+
-					"second time: %v", msg, err)
+				t.Errorf("failed to sign output %s: %v", msg, err)
 				break
 			}
 
-			err = checkScripts(msg, tx, i, sigScript, scriptPkScript)
+			// Now we should pass.
+			err = checkScripts(msg, tx, i, sigScript,
+				scriptPkScript)
 			if err != nil {
-				t.Errorf("twice signed script invalid for "+
+				t.Errorf("fully signed script invalid for "+
 					"%s: %v", msg, err)
 				break
 			}
 		}
 	}
+}
 
-	// Basic Multisig
-	for _, hashType := range hashTypes {
-		for i := range tx.TxIn {
-			msg := fmt.Sprintf("%d:%d", hashType, i)
+// TestSignTxOutputMultiSigPartial ensures a bare 2-of-3 multisig output can be
+// signed by two key holders in separate calls, with the signatures ending up
+// in pubkey order and without duplicates regardless of the order of signing.
+func TestSignTxOutputMultiSigPartial(t *testing.T) {
+	tx := &btcwire.MsgTx{
+		Version: 1,
+		TxIn: []*btcwire.TxIn{
+			&btcwire.TxIn{
+				PreviousOutPoint: btcwire.OutPoint{
+					Hash:  btcwire.ShaHash{},
+					Index: 0,
+				},
+				Sequence: 4294967295,
+			},
+		},
+		TxOut: []*btcwire.TxOut{
+			&btcwire.TxOut{
+				Value: 1,
+			},
+		},
+		LockTime: 0,
+	}
 
-			key1, err := btcec.NewPrivateKey(btcec.S256())
-			if err != nil {
-				t.Errorf("failed to make privKey for %s: %v",
-					msg, err)
-				break
-			}
+	var keys []*btcec.PrivateKey
+	var addrs []*btcutil.AddressPubKey
+	for i := 0; i < 3; i++ {
+		key, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("failed to make privKey %d: %v", i, err)
+		}
+		pk := (*btcec.PublicKey)(&key.PublicKey).SerializeCompressed()
+		addr, err := btcutil.NewAddressPubKey(pk, &btcnet.TestNet3Params)
+		if err != nil {
+			t.Fatalf("failed to make address %d: %v", i, err)
+		}
+		keys = append(keys, key)
+		addrs = append(addrs, addr)
+	}
 
-			pk1 := (*btcec.PublicKey)(&key1.PublicKey).
-				SerializeCompressed()
-			address1, err := btcutil.NewAddressPubKey(pk1,
-				&btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make address for %s: %v",
-					msg, err)
-				break
-			}
+	pkScript, err := btcscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatalf("failed to make pkscript: %v", err)
+	}
 
-			key2, err := btcec.NewPrivateKey(btcec.S256())
-			if err != nil {
-				t.Errorf("failed to make privKey 2 for %s: %v",
-					msg, err)
-				break
-			}
+	signWith := func(i int, prev []byte) []byte {
+		sigScript, err := btcscript.SignTxOutput(&btcnet.TestNet3Params,
+			tx, 0, pkScript, btcscript.SigHashAll,
+			mkGetKey(map[string]addressToKey{
+				addrs[i].EncodeAddress(): {keys[i], true},
+			}), mkGetScript(nil), prev)
+		if err != nil {
+			t.Fatalf("failed to sign with key %d: %v", i, err)
+		}
+		return sigScript
+	}
+
+	// Sign with the last key first.
+	sigScript := signWith(2, nil)
+	pushes, err := btcscript.PushedData(sigScript)
+	if err != nil {
+		t.Fatalf("failed to parse part signed script: %v", err)
+	}
+	if len(pushes) != 2 || len(pushes[0]) != 0 || len(pushes[1]) == 0 {
+		t.Fatalf("part signed script has wrong pushes: %x", pushes)
+	}
+	sig2 := pushes[1]
+	if checkScripts("part signed", tx, 0, sigScript, pkScript) == nil {
+		t.Fatalf("part signed script valid")
+	}
+
+	// Sign with the first key, which must be placed before the signature
+	// for the last key to match the order of the pubkeys.
+	sigScript = signWith(0, sigScript)
+	pushes, err = btcscript.PushedData(sigScript)
+	if err != nil {
+		t.Fatalf("failed to parse fully signed script: %v", err)
+	}
+	if len(pushes) != 3 || len(pushes[1]) == 0 ||
+		!bytes.Equal(pushes[2], sig2) {
+		t.Fatalf("fully signed script has wrong pushes: %x", pushes)
+	}
+	if err := checkScripts("fully signed", tx, 0, sigScript,
+		pkScript); err != nil {
+		t.Fatalf("fully signed script invalid: %v", err)
+	}
+
+	// Signing again with a key that has already signed must not add a
+	// duplicate signature.
+	sigScript = signWith(0, sigScript)
+	pushes, err = btcscript.PushedData(sigScript)
+	if err != nil {
+		t.Fatalf("failed to parse resigned script: %v", err)
+	}
+	if len(pushes) != 3 || !bytes.Equal(pushes[2], sig2) {
+		t.Fatalf("resigned script has wrong pushes: %x", pushes)
+	}
+	if err := checkScripts("resigned", tx, 0, sigScript,
+		pkScript); err != nil {
+		t.Fatalf("resigned script invalid: %v", err)
+	}
+}
+
+// TestCombineSigs ensures signature scripts produced independently by the
+// holders of different keys are combined into a complete script for multisig
+// and pay-to-script-hash multisig outputs.
+func TestCombineSigs(t *testing.T) {
+	tx := &btcwire.MsgTx{
+		Version: 1,
+		TxIn: []*btcwire.TxIn{
+			&btcwire.TxIn{
+				PreviousOutPoint: btcwire.OutPoint{
+					Hash:  btcwire.ShaHash{},
+					Index: 0,
+				},
+				Sequence: 4294967295,
+			},
+		},
+		TxOut: []*btcwire.TxOut{
+			&btcwire.TxOut{
+				Value: 1,
+			},
+		},
+		LockTime: 0,
+	}
+
+	keys := make(map[string]addressToKey)
+	var addrs []*btcutil.AddressPubKey
+	for i := 0; i < 3; i++ {
+		key, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("failed to make privKey %d: %v", i, err)
+		}
+		pk := (*btcec.PublicKey)(&key.PublicKey).SerializeCompressed()
+		addr, err := btcutil.NewAddressPubKey(pk, &btcnet.TestNet3Params)
+		if err != nil {
+			t.Fatalf("failed to make address %d: %v", i, err)
+		}
+		keys[addr.EncodeAddress()] = addressToKey{key, true}
+		addrs = append(addrs, addr)
+	}
+
+	multiSig, err := btcscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatalf("failed to make multisig script: %v", err)
+	}
+	scriptAddr, err := btcutil.NewAddressScriptHash(multiSig,
+		&btcnet.TestNet3Params)
+	if err != nil {
+		t.Fatalf("failed to make p2sh addr: %v", err)
+	}
+	p2sh, err := btcscript.PayToAddrScript(scriptAddr)
+	if err != nil {
+		t.Fatalf("failed to make p2sh script: %v", err)
+	}
+	scripts := mkGetScript(map[string][]byte{
+		scriptAddr.EncodeAddress(): multiSig,
+	})
+
+	pkHash, err := btcutil.NewAddressPubKeyHash(
+		btcutil.Hash160(addrs[0].ScriptAddress()),
+		&btcnet.TestNet3Params)
+	if err != nil {
+		t.Fatalf("failed to make pubkey hash addr: %v", err)
+	}
+	p2pkh, err := btcscript.PayToAddrScript(pkHash)
+	if err != nil {
+		t.Fatalf("failed to make pubkey hash script: %v", err)
+	}
+
+	// signWith signs pkScript with only the key for addrs[i].
+	signWith := func(pkScript []byte, i int) []byte {
+		a := addrs[i].EncodeAddress()
+		sigScript, err := btcscript.SignTxOutput(&btcnet.TestNet3Params,
+			tx, 0, pkScript, btcscript.SigHashAll,
+			mkGetKey(map[string]addressToKey{a: keys[a]}), scripts,
+			nil)
+		if err != nil {
+			t.Fatalf("failed to sign with key %d: %v", i, err)
+		}
+		return sigScript
+	}
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+		class    btcscript.ScriptClass
+		first    int
+		second   int
+		valid    bool
+	}{
+		{"multisig", multiSig, btcscript.MultiSigTy, 0, 1, true},
+		{"multisig reversed", multiSig, btcscript.MultiSigTy, 2, 0, true},
+		{"multisig same key", multiSig, btcscript.MultiSigTy, 1, 1, false},
+		{"p2sh multisig", p2sh, btcscript.ScriptHashTy, 1, 2, true},
+		{"p2sh multisig same key", p2sh, btcscript.ScriptHashTy, 2, 2,
+			false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		sigScript1 := signWith(test.pkScript, test.first)
+		sigScript2 := signWith(test.pkScript, test.second)
+		if checkScripts(test.name, tx, 0, sigScript1,
+			test.pkScript) == nil {
+			t.Errorf("CombineSigs #%d (%s) part signed script "+
+				"valid", i, test.name)
+			continue
+		}
+
+		combined := btcscript.CombineSigs(&btcnet.TestNet3Params, tx, 0,
+			test.pkScript, test.class, sigScript1, sigScript2)
+		err := checkScripts(test.name, tx, 0, combined, test.pkScript)
+		if test.valid && err != nil {
+			t.Errorf("CombineSigs #%d (%s) combined script "+
+				"invalid: %v", i, test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("CombineSigs #%d (%s) combined script "+
+				"unexpectedly valid", i, test.name)
+		}
+	}
+
+	// Merging is undefined for pubkey hash outputs, so the first script
+	// must be returned unchanged.
+	sigScript1 := []byte{btcscript.OP_TRUE}
+	sigScript2 := signWith(p2pkh, 0)
+	combined := btcscript.CombineSigs(&btcnet.TestNet3Params, tx, 0, p2pkh,
+		btcscript.PubKeyHashTy, sigScript1, sigScript2)
+	if !bytes.Equal(combined, sigScript1) {
+		t.Errorf("CombineSigs (pubkey hash) changed first script: %x",
+			combined)
+	}
+}
+
+// TestSignTxOutputOversizedRedeemScript ensures signing a pay-to-script-hash
+// output whose redeem script is too large to push fails instead of returning a
+// truncated signature script.
+func TestSignTxOutputOversizedRedeemScript(t *testing.T) {
+	tx := &btcwire.MsgTx{
+		Version: 1,
+		TxIn: []*btcwire.TxIn{
+			&btcwire.TxIn{
+				PreviousOutPoint: btcwire.OutPoint{
+					Hash:  btcwire.ShaHash{},
+					Index: 0,
+				},
+				Sequence: 4294967295,
+			},
+		},
+		TxOut: []*btcwire.TxOut{
+			&btcwire.TxOut{
+				Value: 1,
+			},
+		},
+		LockTime: 0,
+	}
+
+	// A 1-of-16 multisig script of compressed keys is 547 bytes, which is
+	// more than MaxScriptElementSize.
+	keys := make(map[string]addressToKey)
+	var addrs []*btcutil.AddressPubKey
+	for i := 0; i < 16; i++ {
+		key, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("failed to make privKey %d: %v", i, err)
+		}
+		pk := (*btcec.PublicKey)(&key.PublicKey).SerializeCompressed()
+		addr, err := btcutil.NewAddressPubKey(pk, &btcnet.TestNet3Params)
+		if err != nil {
+			t.Fatalf("failed to make address %d: %v", i, err)
+		}
+		keys[addr.EncodeAddress()] = addressToKey{key, true}
+		addrs = append(addrs, addr)
+	}
+	redeemScript, err := btcscript.MultiSigScript(addrs, 1)
+	if err != nil {
+		t.Fatalf("failed to make multisig script: %v", err)
+	}
+	if len(redeemScript) <= btcscript.MaxScriptElementSize {
+		t.Fatalf("redeem script is only %d bytes", len(redeemScript))
+	}
+	scriptAddr, err := btcutil.NewAddressScriptHash(redeemScript,
+		&btcnet.TestNet3Params)
+	if err != nil {
+		t.Fatalf("failed to make p2sh addr: %v", err)
+	}
+	p2sh, err := btcscript.PayToAddrScript(scriptAddr)
+	if err != nil {
+		t.Fatalf("failed to make p2sh script: %v", err)
+	}
+	scripts := mkGetScript(map[string][]byte{
+		scriptAddr.EncodeAddress(): redeemScript,
+	})
+
+	for i, previousScript := range [][]byte{nil, {btcscript.OP_0}} {
+		sigScript, err := btcscript.SignTxOutput(&btcnet.TestNet3Params,
+			tx, 0, p2sh, btcscript.SigHashAll, mkGetKey(keys), scripts,
+			previousScript)
+		if err != btcscript.ErrStackElementTooBig {
+			t.Errorf("SignTxOutput #%d unexpected error - got %v, "+
+				"want %v", i, err, btcscript.ErrStackElementTooBig)
+		}
+		if sigScript != nil {
+			t.Errorf("SignTxOutput #%d returned a script with an "+
+				"error: %x", i, sigScript)
+		}
+	}
+
+	// Merged scripts which can not be pushed leave the first script as it
+	// is.
+	sigScript1 := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_0).AddOp(btcscript.OP_0).
+		AddFullData(redeemScript))
+	sigScript2 := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_0).AddFullData(redeemScript))
+	combined := btcscript.CombineSigs(&btcnet.TestNet3Params, tx, 0, p2sh,
+		btcscript.ScriptHashTy, sigScript1, sigScript2)
+	if !bytes.Equal(combined, sigScript1) {
+		t.Errorf("CombineSigs changed first script: %x", combined)
+	}
+}
+
+// TestCalcSignatureHash ensures the signature hash commits to the parts of the
+// transaction selected by each hash type, and that the SigHashSingle bug is
+// reproduced for inputs without a matching output.
+func TestCalcSignatureHash(t *testing.T) {
+	// mkTx returns a transaction with three inputs and two outputs, with
+	// the passed function applied to allow tests to modify it.
+	mkTx := func(modify func(*btcwire.MsgTx)) *btcwire.MsgTx {
+		tx := &btcwire.MsgTx{Version: 1}
+		for i := 0; i < 3; i++ {
+			tx.TxIn = append(tx.TxIn, &btcwire.TxIn{
+				PreviousOutPoint: btcwire.OutPoint{
+					Hash:  btcwire.ShaHash{byte(i + 1)},
+					Index: uint32(i),
+				},
+				SignatureScript: []byte{btcscript.OP_TRUE},
+				Sequence:        4294967295,
+			})
+		}
+		for i := 0; i < 2; i++ {
+			tx.TxOut = append(tx.TxOut, &btcwire.TxOut{
+				Value:    int64(i + 1),
+				PkScript: []byte{btcscript.OP_TRUE},
+			})
+		}
+		if modify != nil {
+			modify(tx)
+		}
+		return tx
+	}
 
-			pk2 := (*btcec.PublicKey)(&key2.PublicKey).
-				SerializeCompressed()
-			address2, err := btcutil.NewAddressPubKey(pk2,
-				&btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make address 2 for %s: %v",
-					msg, err)
-				break
-			}
+	otherOutput := func(tx *btcwire.MsgTx) { tx.TxOut[1].Value = 10 }
+	ownOutput := func(tx *btcwire.MsgTx) { tx.TxOut[0].Value = 10 }
+	extraOutput := func(tx *btcwire.MsgTx) {
+		tx.TxOut = append(tx.TxOut, &btcwire.TxOut{Value: 3})
+	}
+	otherSequence := func(tx *btcwire.MsgTx) { tx.TxIn[1].Sequence = 0 }
+	otherInput := func(tx *btcwire.MsgTx) {
+		tx.TxIn[1].PreviousOutPoint.Index = 5
+	}
 
-			pkScript, err := btcscript.MultiSigScript(
-				[]*btcutil.AddressPubKey{address1, address2},
-				2)
-			if err != nil {
-				t.Errorf("failed to make pkscript "+
-					"for %s: %v", msg, err)
-			}
+	// Each test signs input 0 unless noted otherwise and compares the
+	// hash of the unmodified transaction with that of the modified one.
+	tests := []struct {
+		name     string
+		hashType btcscript.SigHashType
+		idx      int
+		modify   func(*btcwire.MsgTx)
+		same     bool
+	}{
+		{"all other output", btcscript.SigHashAll, 0, otherOutput, false},
+		{"all other sequence", btcscript.SigHashAll, 0, otherSequence, false},
+		{"none other output", btcscript.SigHashNone, 0, otherOutput, true},
+		{"none own output", btcscript.SigHashNone, 0, ownOutput, true},
+		{"none extra output", btcscript.SigHashNone, 0, extraOutput, true},
+		{"none other sequence", btcscript.SigHashNone, 0, otherSequence, true},
+		{"none other input", btcscript.SigHashNone, 0, otherInput, false},
+		{"single other output", btcscript.SigHashSingle, 0, otherOutput, true},
+		{"single own output", btcscript.SigHashSingle, 0, ownOutput, false},
+		{"single extra output", btcscript.SigHashSingle, 0, extraOutput, true},
+		{"single other sequence", btcscript.SigHashSingle, 0, otherSequence,
+			true},
+		{"single earlier output", btcscript.SigHashSingle, 1, ownOutput,
+			true},
+		{"anyonecanpay other input",
+			btcscript.SigHashAll | btcscript.SigHashAnyOneCanPay, 0,
+			otherInput, true},
+		{"anyonecanpay other output",
+			btcscript.SigHashAll | btcscript.SigHashAnyOneCanPay, 0,
+			otherOutput, false},
+		{"none anyonecanpay other input",
+			btcscript.SigHashNone | btcscript.SigHashAnyOneCanPay, 0,
+			otherInput, true},
+		{"none anyonecanpay other output",
+			btcscript.SigHashNone | btcscript.SigHashAnyOneCanPay, 0,
+			otherOutput, true},
+	}
 
-			scriptAddr, err := btcutil.NewAddressScriptHash(
-				pkScript, &btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make p2sh addr for %s: %v",
-					msg, err)
-				break
-			}
+	script := []byte{btcscript.OP_DUP, btcscript.OP_CODESEPARATOR,
+		btcscript.OP_CHECKSIG}
 
-			scriptPkScript, err := btcscript.PayToAddrScript(
-				scriptAddr)
-			if err != nil {
-				t.Errorf("failed to make script pkscript for "+
-					"%s: %v", msg, err)
-				break
-			}
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		hash, err := btcscript.CalcSignatureHash(script, test.hashType,
+			mkTx(nil), test.idx)
+		if err != nil {
+			t.Errorf("CalcSignatureHash #%d (%s) unexpected error: "+
+				"%v", i, test.name, err)
+			continue
+		}
+		modHash, err := btcscript.CalcSignatureHash(script,
+			test.hashType, mkTx(test.modify), test.idx)
+		if err != nil {
+			t.Errorf("CalcSignatureHash #%d (%s) unexpected error: "+
+				"%v", i, test.name, err)
+			continue
+		}
+		if same := bytes.Equal(hash, modHash); same != test.same {
+			t.Errorf("CalcSignatureHash #%d (%s) hash unchanged: "+
+				"%v, want %v", i, test.name, same, test.same)
+		}
+	}
 
-			if err := signAndCheck(msg, tx, i, scriptPkScript,
-				hashType,
-				mkGetKey(map[string]addressToKey{
-					address1.EncodeAddress(): {key1, true},
-					address2.EncodeAddress(): {key2, true},
-				}), mkGetScript(map[string][]byte{
-					scriptAddr.EncodeAddress(): pkScript,
-				}), []byte{}); err != nil {
-				t.Error(err)
-				break
-			}
+	// Input 2 has no matching output, so SigHashSingle gives the value one
+	// regardless of the transaction or anyone can pay flag.
+	bugHash := make([]byte, 32)
+	bugHash[0] = 0x01
+	for _, hashType := range []btcscript.SigHashType{
+		btcscript.SigHashSingle,
+		btcscript.SigHashSingle | btcscript.SigHashAnyOneCanPay,
+	} {
+		hash, err := btcscript.CalcSignatureHash(script, hashType,
+			mkTx(nil), 2)
+		if err != nil {
+			t.Errorf("CalcSignatureHash single bug (%v) unexpected "+
+				"error: %v", hashType, err)
+			continue
+		}
+		if !bytes.Equal(hash, bugHash) {
+			t.Errorf("CalcSignatureHash single bug (%v) wrong hash: "+
+				"got %x, want %x", hashType, hash, bugHash)
 		}
 	}
 
-	// Two part multisig, sign with one key then the other.
-	for _, hashType := range hashTypes {
-		for i := range tx.TxIn {
-			msg := fmt.Sprintf("%d:%d", hashType, i)
+	// OP_CODESEPARATOR is not part of the signed script.
+	hash, _ := btcscript.CalcSignatureHash(script, btcscript.SigHashAll,
+		mkTx(nil), 0)
+	stripped, _ := btcscript.CalcSignatureHash([]byte{btcscript.OP_DUP,
+		btcscript.OP_CHECKSIG}, btcscript.SigHashAll, mkTx(nil), 0)
+	if !bytes.Equal(hash, stripped) {
+		t.Errorf("CalcSignatureHash hash includes OP_CODESEPARATOR")
+	}
 
-			key1, err := btcec.NewPrivateKey(btcec.S256())
-			if err != nil {
-				t.Errorf("failed to make privKey for %s: %v",
-					msg, err)
-				break
-			}
+	_, err := btcscript.CalcSignatureHash(script, btcscript.SigHashAll,
+		mkTx(nil), 3)
+	if err != btcscript.ErrStackInvalidIndex {
+		t.Errorf("CalcSignatureHash bad index wrong error: got %v, "+
+			"want %v", err, btcscript.ErrStackInvalidIndex)
+	}
+	_, err = btcscript.CalcSignatureHash([]byte{btcscript.OP_DATA_2, 0x01},
+		btcscript.SigHashAll, mkTx(nil), 0)
+	if err != btcscript.ErrStackShortScript {
+		t.Errorf("CalcSignatureHash short script wrong error: got %v, "+
+			"want %v", err, btcscript.ErrStackShortScript)
+	}
+}
 
-			pk1 := (*btcec.PublicKey)(&key1.PublicKey).
-				SerializeCompressed()
-			address1, err := btcutil.NewAddressPubKey(pk1,
-				&btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make address for %s: %v",
-					msg, err)
-				break
-			}
+// TestDebugSignatureHash ensures the signature hash and subscript reported for
+// the first signature check of a spend are those the signature must commit to.
+func TestDebugSignatureHash(t *testing.T) {
+	jobs, err := batchTestJobs(2)
+	if err != nil {
+		t.Fatalf("failed to make jobs: %v", err)
+	}
+	job := jobs[1]
+	pushes, err := btcscript.PushedData(job.SigScript)
+	if err != nil {
+		t.Fatalf("PushedData unexpected error: %v", err)
+	}
+	pubKey := pushes[1]
+	// The placeholder signature is too long to be found in the pushes of
+	// the scripts, which would have them removed from the subscript.
+	placeholderSig := append(bytes.Repeat([]byte{0x30}, 71),
+		byte(btcscript.SigHashAll))
+	placeholder := builderScript(btcscript.NewScriptBuilder().
+		AddData(placeholderSig).AddData(pubKey))
+
+	redeemScript := builderScript(btcscript.NewScriptBuilder().
+		AddData(pubKey).AddOp(btcscript.OP_CHECKSIG))
+	p2sh := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_HASH160).
+		AddData(btcutil.Hash160(redeemScript)).
+		AddOp(btcscript.OP_EQUAL))
 
-			key2, err := btcec.NewPrivateKey(btcec.S256())
-			if err != nil {
-				t.Errorf("failed to make privKey 2 for %s: %v",
-					msg, err)
-				break
-			}
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		hashType  btcscript.SigHashType
+		subScript []byte
+		err       error
+	}{
+		{
+			name:      "p2pkh",
+			sigScript: job.SigScript,
+			pkScript:  job.PkScript,
+			hashType:  btcscript.SigHashAll,
+			subScript: job.PkScript,
+		},
+		{
+			name:      "p2pkh placeholder signature",
+			sigScript: placeholder,
+			pkScript:  job.PkScript,
+			hashType:  btcscript.SigHashSingle,
+			subScript: job.PkScript,
+		},
+		{
+			name: "p2sh",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddData(placeholderSig).AddData(redeemScript)),
+			pkScript:  p2sh,
+			hashType:  btcscript.SigHashAll,
+			subScript: redeemScript,
+		},
+		{
+			name:      "after code separator",
+			sigScript: placeholder,
+			pkScript: []byte{btcscript.OP_1, btcscript.OP_DROP,
+				btcscript.OP_CODESEPARATOR, btcscript.OP_CHECKSIG},
+			hashType:  btcscript.SigHashNone,
+			subScript: []byte{btcscript.OP_CHECKSIG},
+		},
+		{
+			name: "signature pushed by pkScript",
+			pkScript: []byte{btcscript.OP_DATA_2, 0xaa, 0x01,
+				btcscript.OP_DATA_2, 0x02, 0x03,
+				btcscript.OP_CHECKSIGVERIFY},
+			hashType: btcscript.SigHashAll,
+			subScript: []byte{btcscript.OP_DATA_2, 0x02, 0x03,
+				btcscript.OP_CHECKSIGVERIFY},
+		},
+		{
+			name: "multisig signature pushed by pkScript",
+			pkScript: []byte{btcscript.OP_0, btcscript.OP_DATA_2,
+				0xcc, 0x01, btcscript.OP_1, btcscript.OP_DATA_2,
+				0x02, 0x03, btcscript.OP_1,
+				btcscript.OP_CHECKMULTISIG},
+			hashType: btcscript.SigHashAll,
+			subScript: []byte{btcscript.OP_0, btcscript.OP_1,
+				btcscript.OP_DATA_2, 0x02, 0x03, btcscript.OP_1,
+				btcscript.OP_CHECKMULTISIG},
+		},
+		{
+			name: "multisig push of signature without hash type",
+			sigScript: []byte{btcscript.OP_0, btcscript.OP_DATA_3,
+				0xcc, 0xdd, 0x01},
+			pkScript: []byte{btcscript.OP_DATA_2, 0xcc, 0xdd,
+				btcscript.OP_DROP, btcscript.OP_1,
+				btcscript.OP_DATA_2, 0x02, 0x03, btcscript.OP_1,
+				btcscript.OP_CHECKMULTISIG},
+			hashType: btcscript.SigHashAll,
+			subScript: []byte{btcscript.OP_DATA_2, 0xcc, 0xdd,
+				btcscript.OP_DROP, btcscript.OP_1,
+				btcscript.OP_DATA_2, 0x02, 0x03, btcscript.OP_1,
+				btcscript.OP_CHECKMULTISIG},
+		},
+		{
+			name: "check not executed",
+			pkScript: []byte{btcscript.OP_0, btcscript.OP_IF,
+				btcscript.OP_CHECKSIG, btcscript.OP_ENDIF,
+				btcscript.OP_1},
+			hashType: btcscript.SigHashAll,
+			err:      btcscript.ErrNoSignatureCheck,
+		},
+		{
+			name:     "no check",
+			pkScript: []byte{btcscript.OP_1},
+			hashType: btcscript.SigHashAll,
+			err:      btcscript.ErrNoSignatureCheck,
+		},
+		{
+			name:     "no scripts",
+			hashType: btcscript.SigHashAll,
+			err:      btcscript.ErrNoSignatureCheck,
+		},
+		{
+			name: "failure before check",
+			pkScript: []byte{btcscript.OP_0, btcscript.OP_VERIFY,
+				btcscript.OP_CHECKSIG},
+			hashType: btcscript.SigHashAll,
+			err:      btcscript.ErrStackVerifyFailed,
+		},
+	}
 
-			pk2 := (*btcec.PublicKey)(&key2.PublicKey).
-				SerializeCompressed()
-			address2, err := btcutil.NewAddressPubKey(pk2,
-				&btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make address 2 for %s: %v",
-					msg, err)
-				break
-			}
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		hash, subScript, err := btcscript.DebugSignatureHash(
+			test.sigScript, test.pkScript, job.Tx, job.TxIdx,
+			test.hashType)
+		if err != test.err {
+			t.Errorf("DebugSignatureHash #%d (%s) unexpected error - "+
+				"got %v, want %v", i, test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(subScript, test.subScript) {
+			t.Errorf("DebugSignatureHash #%d (%s) wrong subscript - "+
+				"got %x, want %x", i, test.name, subScript,
+				test.subScript)
+		}
+		want, err := btcscript.CalcSignatureHash(test.subScript,
+			test.hashType, job.Tx, job.TxIdx)
+		if err != nil {
+			t.Errorf("CalcSignatureHash #%d (%s) unexpected error: "+
+				"%v", i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(hash, want) {
+			t.Errorf("DebugSignatureHash #%d (%s) wrong hash - got "+
+				"%x, want %x", i, test.name, hash, want)
+		}
+	}
 
-			pkScript, err := btcscript.MultiSigScript(
-				[]*btcutil.AddressPubKey{address1, address2},
-				2)
-			if err != nil {
-				t.Errorf("failed to make pkscript "+
-					"for %s: %v", msg, err)
-			}
+	// The hash of the real spend is the one its signature signs.
+	hash, _, err := btcscript.DebugSignatureHash(job.SigScript,
+		job.PkScript, job.Tx, job.TxIdx, btcscript.SigHashAll)
+	if err != nil {
+		t.Fatalf("DebugSignatureHash unexpected error: %v", err)
+	}
+	sig, err := btcec.ParseDERSignature(
+		btcscript.StripSignatureHashType(pushes[0]), btcec.S256())
+	if err != nil {
+		t.Fatalf("ParseDERSignature unexpected error: %v", err)
+	}
+	key, err := btcec.ParsePubKey(pubKey, btcec.S256())
+	if err != nil {
+		t.Fatalf("ParsePubKey unexpected error: %v", err)
+	}
+	if !sig.Verify(hash, key) {
+		t.Errorf("DebugSignatureHash hash not signed by the spend")
+	}
 
-			scriptAddr, err := btcutil.NewAddressScriptHash(
-				pkScript, &btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make p2sh addr for %s: %v",
-					msg, err)
-				break
-			}
+	_, _, err = btcscript.DebugSignatureHash(job.SigScript, job.PkScript,
+		job.Tx, 2, btcscript.SigHashAll)
+	if err != btcscript.ErrStackInvalidIndex {
+		t.Errorf("DebugSignatureHash invalid index wrong error - got "+
+			"%v, want %v", err, btcscript.ErrStackInvalidIndex)
+	}
+}
 
-			scriptPkScript, err := btcscript.PayToAddrScript(
-				scriptAddr)
-			if err != nil {
-				t.Errorf("failed to make script pkscript for "+
-					"%s: %v", msg, err)
-				break
-			}
+// TestCodeSeparator ensures signatures are checked against the subscript used
+// by the reference implementation, which starts after the last executed
+// OP_CODESEPARATOR and has any pushes of the signature removed.
+func TestCodeSeparator(t *testing.T) {
+	tx := sigHashTestTx()
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("failed to make key: %v", err)
+	}
+	pk := (*btcec.PublicKey)(&key.PublicKey).SerializeCompressed()
+	sign := func(script []byte) []byte {
+		sig, err := sigHashTestSign(tx, script, btcscript.SigHashAll, key)
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return sig
+	}
+	checkSig := builderScript(btcscript.NewScriptBuilder().AddData(pk).
+		AddOp(btcscript.OP_CHECKSIG))
+
+	codeSep := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_NOP).AddOp(btcscript.OP_CODESEPARATOR).
+		AddData(pk).AddOp(btcscript.OP_CHECKSIG))
+	twoCodeSeps := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_CODESEPARATOR).AddOp(btcscript.OP_NOP).
+		AddOp(btcscript.OP_CODESEPARATOR).AddData(pk).
+		AddOp(btcscript.OP_CHECKSIG))
+	unexecuted := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_0).AddOp(btcscript.OP_IF).
+		AddOp(btcscript.OP_CODESEPARATOR).AddOp(btcscript.OP_ENDIF).
+		AddData(pk).AddOp(btcscript.OP_CHECKSIG))
+	afterCheckSig := builderScript(btcscript.NewScriptBuilder().
+		AddData(pk).AddOp(btcscript.OP_CHECKSIG).
+		AddOp(btcscript.OP_CODESEPARATOR))
+	multiSigTail := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_1).AddData(pk).AddOp(btcscript.OP_1).
+		AddOp(btcscript.OP_CHECKMULTISIG))
+	multiSig := append([]byte{btcscript.OP_NOP, btcscript.OP_CODESEPARATOR},
+		multiSigTail...)
+
+	// The signature is pushed by the script it signs, after the separator,
+	// so it is removed from the subscript.
+	dropTail := append([]byte{btcscript.OP_DROP}, checkSig...)
+	dropSig := sign(dropTail)
+	sigAfter := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_CODESEPARATOR).AddData(dropSig))
+	sigAfter = append(sigAfter, dropTail...)
+	// The signature is pushed before the separator, so it is not part of
+	// the subscript to begin with.
+	checkSigSig := sign(checkSig)
+	sigBefore := builderScript(btcscript.NewScriptBuilder().
+		AddData(checkSigSig).AddOp(btcscript.OP_DROP).
+		AddOp(btcscript.OP_CODESEPARATOR))
+	sigBefore = append(sigBefore, checkSig...)
+
+	push := func(sig []byte) []byte {
+		return builderScript(btcscript.NewScriptBuilder().AddData(sig))
+	}
 
-			sigScript, err := btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, scriptPkScript,
-				hashType, mkGetKey(map[string]addressToKey{
-					address1.EncodeAddress(): {key1, true},
-				}), mkGetScript(map[string][]byte{
-					scriptAddr.EncodeAddress(): pkScript,
-				}), []byte{})
-			if err != nil {
-				t.Errorf("failed to sign output %s: %v", msg,
-					err)
-				break
-			}
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		err       error
+	}{
+		{"signs after separator", push(sign(checkSig)), codeSep, nil},
+		{"signs whole script", push(sign(codeSep)), codeSep,
+			btcscript.ErrStackScriptFailed},
+		{"signs after last separator", push(sign(checkSig)),
+			twoCodeSeps, nil},
+		{"signs after first separator", push(sign(twoCodeSeps[1:])),
+			twoCodeSeps, btcscript.ErrStackScriptFailed},
+		{"unexecuted separator signs whole script",
+			push(sign(unexecuted)), unexecuted, nil},
+		{"unexecuted separator signs after separator",
+			push(sign(checkSig)), unexecuted,
+			btcscript.ErrStackScriptFailed},
+		{"separator after checksig", push(sign(afterCheckSig)),
+			afterCheckSig, nil},
+		{"separator in signature script",
+			append(push(sign(checkSig)), btcscript.OP_CODESEPARATOR),
+			checkSig, nil},
+		{"multisig signs after separator", builderScript(btcscript.
+			NewScriptBuilder().AddOp(btcscript.OP_0).
+			AddData(sign(multiSigTail))), multiSig, nil},
+		{"multisig signs whole script", builderScript(btcscript.
+			NewScriptBuilder().AddOp(btcscript.OP_0).
+			AddData(sign(multiSig))), multiSig,
+			btcscript.ErrStackScriptFailed},
+		{"signature pushed after separator", push(dropSig), sigAfter,
+			nil},
+		{"signature pushed before separator", push(checkSigSig),
+			sigBefore, nil},
+	}
 
-			// Only 1 out of 2 signed, this *should* fail.
-			if checkScripts(msg, tx, i, sigScript,
-				scriptPkScript) == nil {
-				t.Errorf("part signed script valid for %s", msg)
-				break
-			}
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		engine, err := btcscript.NewScript(test.sigScript, test.pkScript,
+			0, tx, 0)
+		if err == nil {
+			err = engine.Execute()
+		}
+		if err != test.err {
+			t.Errorf("NewScript #%d (%s) wrong error - got %v, want %v",
+				i, test.name, err, test.err)
+		}
+	}
+}
 
-			// Sign with the other key and merge
-			sigScript, err = btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, scriptPkScript,
-				hashType, mkGetKey(map[string]addressToKey{
-					address2.EncodeAddress(): {key2, true},
-				}), mkGetScript(map[string][]byte{
-					scriptAddr.EncodeAddress(): pkScript,
-				}), sigScript)
-			if err != nil {
-				t.Errorf("failed to sign output %s: %v", msg, err)
-				break
-			}
+// TestIsDustOutput ensures the dust threshold is computed from the size of the
+// output and of the input which spends it for each script class.
+func TestIsDustOutput(t *testing.T) {
+	p2pkh := decodeHex("76a914433ec2ac1ffa1b7b7d027f564529c57197f9ae8" +
+		"888ac")
+	p2sh := decodeHex("a91463bcc565f9e68ee0189dd5cc67f1b0e5f02f45cb87")
+	p2pk := decodeHex("410411db93e1dcdb8a016b49840f8c53bc1eb68a382e97b" +
+		"1482ecad7b148a6909a5cb2e0eaddfb84ccf9744464f82e160bfa9b8b64f9d4" +
+		"c03f999b8643f656b412a3ac")
+	pubKey := decodeHex("02192d74d0cb94344c9569c2e77901573d8d7903c3ebec3" +
+		"a957724895dca52c6b4")
+	multiSig := builderScript(btcscript.NewScriptBuilder().AddOp(
+		btcscript.OP_2).AddData(pubKey).AddData(pubKey).AddData(pubKey).
+		AddOp(btcscript.OP_3).AddOp(btcscript.OP_CHECKMULTISIG))
+	nameP2PKH := builderScript(btcscript.NewScriptBuilder().AddOp(
+		btcscript.OP_NAME_UPDATE).AddData([]byte("d/example")).
+		AddData([]byte("{}")).AddOp(btcscript.OP_2DROP).
+		AddOp(btcscript.OP_DROP))
+	nameP2PKH = append(nameP2PKH, p2pkh...)
 
-			err = checkScripts(msg, tx, i, sigScript,
-				scriptPkScript)
-			if err != nil {
-				t.Errorf("fully signed script invalid for "+
-					"%s: %v", msg, err)
-				break
-			}
+	tests := []struct {
+		name     string
+		pkScript []byte
+		value    int64
+		relayFee int64
+		isDust   bool
+	}{
+		// The well known 546 satoshi threshold for pay-to-pubkey-hash
+		// at a relay fee of 1000 satoshi per kilobyte.
+		{"p2pkh at threshold", p2pkh, 546, 1000, false},
+		{"p2pkh below threshold", p2pkh, 545, 1000, true},
+		{"p2pkh zero value", p2pkh, 0, 1000, true},
+		{"p2pkh zero relay fee", p2pkh, 0, 0, false},
+		{"p2pkh higher relay fee", p2pkh, 5459, 10000, true},
+		{"p2pkh above higher relay fee", p2pkh, 5460, 10000, false},
+		{"p2pk at threshold", p2pk, 570, 1000, false},
+		{"p2pk below threshold", p2pk, 569, 1000, true},
+		{"p2sh at threshold", p2sh, 540, 1000, false},
+		{"p2sh below threshold", p2sh, 539, 1000, true},
+		{"multisig at threshold", multiSig, 906, 1000, false},
+		{"multisig below threshold", multiSig, 905, 1000, true},
+		{"name p2pkh at threshold", nameP2PKH, 594, 1000, false},
+		{"name p2pkh below threshold", nameP2PKH, 593, 1000, true},
+		{"null data", []byte{btcscript.OP_RETURN}, 0, 1000, false},
+		{"non-standard", []byte{btcscript.OP_TRUE}, 0, 1000, false},
+		{"unparsable", []byte{btcscript.OP_DATA_2, 0x01}, 0, 1000,
+			false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		isDust := btcscript.IsDustOutput(test.pkScript, test.value,
+			test.relayFee)
+		if isDust != test.isDust {
+			t.Errorf("IsDustOutput #%d (%s) wrong result - got %v, "+
+				"want %v", i, test.name, isDust, test.isDust)
+		}
+	}
+}
+
+// TestEstimateSpendSize ensures the sizes of the signature scripts spending
+// each class of output script are estimated, and that real signature scripts
+// are no larger.
+func TestEstimateSpendSize(t *testing.T) {
+	builder := btcscript.NewScriptBuilder().AddOp(btcscript.OP_2)
+	for i := 0; i < 3; i++ {
+		builder.AddData(bytes.Repeat([]byte{byte(0x02 + i)}, 33))
+	}
+	multiSig := builderScript(builder.AddOp(btcscript.OP_3).
+		AddOp(btcscript.OP_CHECKMULTISIG))
+	pubKey := builderScript(btcscript.NewScriptBuilder().
+		AddData(bytes.Repeat([]byte{0x02}, 33)).
+		AddOp(btcscript.OP_CHECKSIG))
+	nullData := []byte{btcscript.OP_RETURN, btcscript.OP_DATA_1, 0x01}
+	update := func(base []byte) []byte {
+		return nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, base)
+	}
+
+	tests := []struct {
+		name     string
+		pkScript []byte
+		size     int
+		err      error
+	}{
+		{"pay to pubkey", pubKey, 73, nil},
+		{"pay to pubkey hash", nameTestP2PKH, 107, nil},
+		{"2 of 3 multisig", multiSig, 147, nil},
+		{"name_update pay to pubkey hash", update(nameTestP2PKH), 107,
+			nil},
+		{"name_update multisig", update(multiSig), 147, nil},
+		{"pay to script hash", nameTestP2SH, 0,
+			btcscript.ErrSpendSizeNeedsRedeemScript},
+		{"null data", nullData, 0, btcscript.ErrSpendSizeUnknown},
+		{"non-standard", []byte{btcscript.OP_TRUE}, 0,
+			btcscript.ErrSpendSizeUnknown},
+		{"unparsable", []byte{btcscript.OP_DATA_2}, 0,
+			btcscript.ErrStackShortScript},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		size, err := btcscript.EstimateSpendSize(test.pkScript)
+		if err != test.err || size != test.size {
+			t.Errorf("EstimateSpendSize #%d (%s) got %d, %v, want "+
+				"%d, %v", i, test.name, size, err, test.size,
+				test.err)
 		}
 	}
 
-	// Two part multisig, sign with one key then both, check key dedup
-	// correctly.
-	for _, hashType := range hashTypes {
-		for i := range tx.TxIn {
-			msg := fmt.Sprintf("%d:%d", hashType, i)
-
-			key1, err := btcec.NewPrivateKey(btcec.S256())
-			if err != nil {
-				t.Errorf("failed to make privKey for %s: %v",
-					msg, err)
-				break
-			}
+	// The 105 byte multisig redeem script is pushed with OP_PUSHDATA1
+	// after its signatures.
+	size, err := btcscript.EstimateP2SHSpendSize(multiSig)
+	if err != nil || size != 147+2+105 {
+		t.Errorf("EstimateP2SHSpendSize of 2 of 3 multisig got %d, %v, "+
+			"want %d", size, err, 147+2+105)
+	}
+	size, err = btcscript.EstimateP2SHSpendSize(nameTestP2PKH)
+	if err != nil || size != 107+1+25 {
+		t.Errorf("EstimateP2SHSpendSize of pay to pubkey hash got %d, "+
+			"%v, want %d", size, err, 107+1+25)
+	}
+	_, err = btcscript.EstimateP2SHSpendSize(nameTestP2SH)
+	if err != btcscript.ErrSpendSizeNeedsRedeemScript {
+		t.Errorf("EstimateP2SHSpendSize of nested script hash got "+
+			"error %v, want %v", err,
+			btcscript.ErrSpendSizeNeedsRedeemScript)
+	}
 
-			pk1 := (*btcec.PublicKey)(&key1.PublicKey).
-				SerializeCompressed()
-			address1, err := btcutil.NewAddressPubKey(pk1,
-				&btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make address for %s: %v",
-					msg, err)
-				break
-			}
+	// A real signature script is never larger than the estimate.
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), privKeyD)
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(coinbaseOutPoint, nil))
+	tx.AddTxOut(btcwire.NewTxOut(500, []byte{btcscript.OP_RETURN}))
+	for i := 0; i < 20; i++ {
+		tx.TxOut[0].Value = int64(i)
+		sigScript, err := btcscript.SignatureScript(tx, 0, nameTestP2PKH,
+			btcscript.SigHashAll, privKey, true)
+		if err != nil {
+			t.Fatalf("SignatureScript unexpected error: %v", err)
+		}
+		if len(sigScript) > 107 {
+			t.Errorf("SignatureScript #%d got %d bytes, more than "+
+				"the estimate of 107", i, len(sigScript))
+		}
+	}
+}
 
-			key2, err := btcec.NewPrivateKey(btcec.S256())
-			if err != nil {
-				t.Errorf("failed to make privKey 2 for %s: %v",
-					msg, err)
-				break
-			}
+// TestIsUnspendable ensures scripts beginning with OP_RETURN and oversized
+// scripts are detected as unspendable.
+func TestIsUnspendable(t *testing.T) {
+	p2pkh := decodeHex("76a914433ec2ac1ffa1b7b7d027f564529c57197f9ae8" +
+		"888ac")
 
-			pk2 := (*btcec.PublicKey)(&key2.PublicKey).
-				SerializeCompressed()
-			address2, err := btcutil.NewAddressPubKey(pk2,
-				&btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make address 2 for %s: %v",
-					msg, err)
-				break
-			}
+	tests := []struct {
+		name        string
+		pkScript    []byte
+		unspendable bool
+	}{
+		{"null data", decodeHex("6a0b68656c6c6f20776f726c64"), true},
+		{"bare OP_RETURN", []byte{btcscript.OP_RETURN}, true},
+		{"OP_RETURN followed by unparsable data",
+			[]byte{btcscript.OP_RETURN, btcscript.OP_DATA_2}, true},
+		{"oversized", bytes.Repeat([]byte{btcscript.OP_NOP}, 10001), true},
+		{"max size", bytes.Repeat([]byte{btcscript.OP_NOP}, 10000), false},
+		{"p2pkh", p2pkh, false},
+		{"name p2pkh", nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, p2pkh), false},
+		{"OP_RETURN after first opcode",
+			[]byte{btcscript.OP_TRUE, btcscript.OP_RETURN}, false},
+		{"empty", nil, false},
+	}
 
-			pkScript, err := btcscript.MultiSigScript(
-				[]*btcutil.AddressPubKey{address1, address2},
-				2)
-			if err != nil {
-				t.Errorf("failed to make pkscript "+
-					"for %s: %v", msg, err)
-			}
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		unspendable := btcscript.IsUnspendable(test.pkScript)
+		if unspendable != test.unspendable {
+			t.Errorf("IsUnspendable #%d (%s) wrong result - got %v, "+
+				"want %v", i, test.name, unspendable,
+				test.unspendable)
+		}
+	}
+}
 
-			scriptAddr, err := btcutil.NewAddressScriptHash(
-				pkScript, &btcnet.TestNet3Params)
-			if err != nil {
-				t.Errorf("failed to make p2sh addr for %s: %v",
-					msg, err)
-				break
-			}
+// TestIsStandardPkScript ensures output scripts are checked against the
+// standardness rules.
+func TestIsStandardPkScript(t *testing.T) {
+	p2pkh := decodeHex("76a914433ec2ac1ffa1b7b7d027f564529c57197f9ae8" +
+		"888ac")
+	p2sh := decodeHex("a91463bcc565f9e68ee0189dd5cc67f1b0e5f02f45cb87")
+	pubKey := decodeHex("02192d74d0cb94344c9569c2e77901573d8d7903c3ebec3" +
+		"a957724895dca52c6b4")
+	multiSig := func(m, n byte) []byte {
+		builder := btcscript.NewScriptBuilder().AddInt64(int64(m))
+		for i := byte(0); i < n; i++ {
+			builder.AddData(pubKey)
+		}
+		return builderScript(builder.AddInt64(int64(n)).
+			AddOp(btcscript.OP_CHECKMULTISIG))
+	}
+	// A multisig script which claims more keys than it has.
+	wrongCount := multiSig(1, 2)
+	wrongCount[len(wrongCount)-2] = btcscript.OP_3
 
-			scriptPkScript, err := btcscript.PayToAddrScript(
-				scriptAddr)
-			if err != nil {
-				t.Errorf("failed to make script pkscript for "+
-					"%s: %v", msg, err)
-				break
-			}
+	tests := []struct {
+		name     string
+		pkScript []byte
+		err      error
+	}{
+		{"p2pkh", p2pkh, nil},
+		{"p2sh", p2sh, nil},
+		{"p2pk", builderScript(btcscript.NewScriptBuilder().
+			AddData(pubKey).AddOp(btcscript.OP_CHECKSIG)), nil},
+		{"1-of-1 multisig", multiSig(1, 1), nil},
+		{"3-of-3 multisig", multiSig(3, 3), nil},
+		{"4-of-7 multisig", multiSig(4, 7),
+			btcscript.ErrNonStandardMultiSig},
+		{"1-of-4 multisig", multiSig(1, 4),
+			btcscript.ErrNonStandardMultiSig},
+		{"3-of-2 multisig", multiSig(3, 2),
+			btcscript.ErrNonStandardMultiSig},
+		{"0-of-2 multisig", multiSig(0, 2),
+			btcscript.ErrNonStandardMultiSig},
+		{"multisig wrong key count", wrongCount,
+			btcscript.ErrNonStandardMultiSig},
+		{"null data", []byte{btcscript.OP_RETURN}, nil},
+		{"null data too big", builderScript(btcscript.NewScriptBuilder().
+			AddOp(btcscript.OP_RETURN).AddData(bytes.Repeat([]byte{1},
+			41))), btcscript.ErrNonStandardPkScript},
+		{"name p2pkh", nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+			nameTestP2PKH), nil},
+		{"name 4-of-7 multisig", nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+			multiSig(4, 7)), btcscript.ErrNonStandardMultiSig},
+		{"name non-standard", nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+			[]byte{btcscript.OP_TRUE}), btcscript.ErrNonStandardPkScript},
+		{"non-standard", []byte{btcscript.OP_TRUE},
+			btcscript.ErrNonStandardPkScript},
+		{"max size", bytes.Repeat([]byte{btcscript.OP_NOP}, 10000),
+			btcscript.ErrNonStandardPkScript},
+		{"oversized", bytes.Repeat([]byte{btcscript.OP_NOP}, 10001),
+			btcscript.ErrPkScriptTooBig},
+		{"unparsable", []byte{btcscript.OP_DATA_2, 0x01},
+			btcscript.ErrStackShortScript},
+	}
 
-			sigScript, err := btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, scriptPkScript,
-				hashType, mkGetKey(map[string]addressToKey{
-					address1.EncodeAddress(): {key1, true},
-				}), mkGetScript(map[string][]byte{
-					scriptAddr.EncodeAddress(): pkScript,
-				}), []byte{})
-			if err != nil {
-				t.Errorf("failed to sign output %s: %v", msg,
-					err)
-				break
-			}
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ok, err := btcscript.IsStandardPkScript(test.pkScript)
+		if err != test.err || ok != (test.err == nil) {
+			t.Errorf("IsStandardPkScript #%d (%s) wrong result - got "+
+				"%v (%v), want %v", i, test.name, ok, err, test.err)
+		}
+	}
+}
 
-			// Only 1 out of 2 signed, this *should* fail.
-			if checkScripts(msg, tx, i, sigScript,
-				scriptPkScript) == nil {
-				t.Errorf("part signed script valid for %s", msg)
-				break
-			}
+// TestValidateSignatureScript ensures signature scripts are checked against the
+// standardness rules for the class of the output they spend.
+func TestValidateSignatureScript(t *testing.T) {
+	sig := bytes.Repeat([]byte{0x30}, 72)
+	pubKey := decodeHex("02192d74d0cb94344c9569c2e77901573d8d7903c3ebec3" +
+		"a957724895dca52c6b4")
+	multiSig := builderScript(btcscript.NewScriptBuilder().AddOp(
+		btcscript.OP_2).AddData(pubKey).AddData(pubKey).AddData(pubKey).
+		AddOp(btcscript.OP_3).AddOp(btcscript.OP_CHECKMULTISIG))
+	p2sh := decodeHex("a91463bcc565f9e68ee0189dd5cc67f1b0e5f02f45cb87")
+
+	// p2shSigScript returns a signature script pushing the passed items
+	// followed by the redeem script.
+	p2shSigScript := func(redeemScript []byte, items ...[]byte) []byte {
+		builder := btcscript.NewScriptBuilder()
+		for _, item := range items {
+			builder.AddData(item)
+		}
+		return builderScript(builder.AddFullData(redeemScript))
+	}
 
-			// Sign with the other key and merge
-			sigScript, err = btcscript.SignTxOutput(
-				&btcnet.TestNet3Params, tx, i, scriptPkScript,
-				hashType, mkGetKey(map[string]addressToKey{
-					address1.EncodeAddress(): {key1, true},
-					address2.EncodeAddress(): {key2, true},
-				}), mkGetScript(map[string][]byte{
-					scriptAddr.EncodeAddress(): pkScript,
-				}), sigScript)
-			if err != nil {
-				t.Errorf("failed to sign output %s: %v", msg, err)
-				break
-			}
+	tests := []struct {
+		name      string
+		sigScript []byte
+		class     btcscript.ScriptClass
+		err       error
+	}{
+		{
+			name: "p2pkh",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddData(sig).AddData(pubKey)),
+			class: btcscript.PubKeyHashTy,
+			err:   nil,
+		},
+		{
+			name: "p2pkh non push only",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddData(sig).AddData(pubKey).
+				AddOp(btcscript.OP_NOP)),
+			class: btcscript.PubKeyHashTy,
+			err:   btcscript.ErrStackNonPushOnly,
+		},
+		{
+			name:      "p2sh multisig",
+			sigScript: p2shSigScript(multiSig, nil, sig, sig),
+			class:     btcscript.ScriptHashTy,
+			err:       nil,
+		},
+		{
+			name: "p2sh multisig non push only",
+			sigScript: append([]byte{btcscript.OP_NOP},
+				p2shSigScript(multiSig, nil, sig, sig)...),
+			class: btcscript.ScriptHashTy,
+			err:   btcscript.ErrStackNonPushOnly,
+		},
+		{
+			name:      "p2sh multisig missing signature",
+			sigScript: p2shSigScript(multiSig, nil, sig),
+			class:     btcscript.ScriptHashTy,
+			err:       btcscript.ErrWrongNumInputs,
+		},
+		{
+			name:      "p2sh multisig extra push",
+			sigScript: p2shSigScript(multiSig, nil, nil, sig, sig),
+			class:     btcscript.ScriptHashTy,
+			err:       btcscript.ErrWrongNumInputs,
+		},
+		{
+			name:      "p2sh empty",
+			sigScript: nil,
+			class:     btcscript.ScriptHashTy,
+			err:       btcscript.ErrNoRedeemScript,
+		},
+		{
+			name:      "p2sh non-standard redeem script",
+			sigScript: p2shSigScript([]byte{btcscript.OP_TRUE}),
+			class:     btcscript.ScriptHashTy,
+			err:       btcscript.ErrNonStandardRedeemScript,
+		},
+		{
+			name:      "p2sh nested p2sh redeem script",
+			sigScript: p2shSigScript(p2sh, nil),
+			class:     btcscript.ScriptHashTy,
+			err:       btcscript.ErrNonStandardRedeemScript,
+		},
+		{
+			name: "p2sh oversized redeem script",
+			sigScript: p2shSigScript(
+				bytes.Repeat([]byte{btcscript.OP_NOP},
+					btcscript.MaxScriptElementSize+1)),
+			class: btcscript.ScriptHashTy,
+			err:   btcscript.ErrStackElementTooBig,
+		},
+		{
+			name: "p2sh unparsable redeem script",
+			sigScript: p2shSigScript([]byte{btcscript.OP_DATA_2,
+				0x01}),
+			class: btcscript.ScriptHashTy,
+			err:   btcscript.ErrStackShortScript,
+		},
+		{
+			name: "too big",
+			sigScript: p2shSigScript(multiSig,
+				bytes.Repeat([]byte{0}, 520),
+				bytes.Repeat([]byte{0}, 520),
+				bytes.Repeat([]byte{0}, 520)),
+			class: btcscript.ScriptHashTy,
+			err:   btcscript.ErrSigScriptTooBig,
+		},
+		{
+			name:      "unparsable",
+			sigScript: []byte{btcscript.OP_DATA_2, 0x01},
+			class:     btcscript.PubKeyHashTy,
+			err:       btcscript.ErrStackShortScript,
+		},
+	}
 
-			// Now we should pass.
-			err = checkScripts(msg, tx, i, sigScript,
-				scriptPkScript)
-			if err != nil {
-				t.Errorf("fully signed script invalid for "+
-					"%s: %v", msg, err)
-				break
-			}
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		err := btcscript.ValidateSignatureScript(test.sigScript,
+			test.class)
+		if err != test.err {
+			t.Errorf("ValidateSignatureScript #%d (%s) wrong error - "+
+				"got %v, want %v", i, test.name, err, test.err)
 		}
 	}
 }
@@ -4666,6 +8712,152 @@ func TestCalcMultiSigStats(t *testing.T) {
 	}
 }
 
+// TestRequiredPubKeys ensures the public keys and number of signatures of bare
+// and name-wrapped multisig scripts are returned, and that other scripts are
+// rejected.
+func TestRequiredPubKeys(t *testing.T) {
+	keyHexes := []string{
+		"04cb9c3c222c5f7a7d3b9bd152f363a0b6d54c9eb312c4d4f9af1e8551b" +
+			"6c421a6a4ab0e29105f24de20ff463c1c91fcf3bf662cdde4783d" +
+			"4799f787cb7c08869b",
+		"04ccc588420deeebea22a7e900cc8b68620d2212c374604e3487ca08f1f" +
+			"f3ae12bdc639514d0ec8612a2d3c519f084d9a00cbbe3b53d071e" +
+			"9b09e71e610b036aa2",
+		"04ab47ad1939edcb3db65f7fedea62bbf781c5410d3f22a7a3a56ffefb2" +
+			"238af8627363bdf2ed97c1f89784a1aecdb43384f11d2acc64443" +
+			"c7fc299cef0400421a",
+	}
+	var keys [][]byte
+	var addrs []*btcutil.AddressPubKey
+	for _, keyHex := range keyHexes {
+		key := decodeHex(keyHex)
+		keys = append(keys, key)
+		addrs = append(addrs, newAddressPubKey(key).(*btcutil.AddressPubKey))
+	}
+	multiSig, err := btcscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatalf("MultiSigScript unexpected error: %v", err)
+	}
+	nameUpdate := func(base []byte) []byte {
+		return nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP}, base)
+	}
+	p2shOf := func(redeemScript []byte) []byte {
+		return builderScript(btcscript.NewScriptBuilder().
+			AddOp(btcscript.OP_HASH160).
+			AddData(btcutil.Hash160(redeemScript)).
+			AddOp(btcscript.OP_EQUAL))
+	}
+	p2sh := p2shOf(multiSig)
+	multiSigOf := func(numSigs, numKeys byte, keys ...[]byte) []byte {
+		builder := btcscript.NewScriptBuilder().AddOp(numSigs)
+		for _, key := range keys {
+			builder.AddData(key)
+		}
+		return builderScript(builder.AddOp(numKeys).
+			AddOp(btcscript.OP_CHECKMULTISIG))
+	}
+
+	// Tests with a redeem script are run through RequiredPubKeysP2SH.
+	tests := []struct {
+		name         string
+		script       []byte
+		redeemScript []byte
+		keys         [][]byte
+		numSigs      int
+		err          error
+	}{
+		{"bare 2 of 3", multiSig, nil, keys, 2, nil},
+		{"name_update 2 of 3", nameUpdate(multiSig), nil, keys, 2, nil},
+		{"name_firstupdate 2 of 3", nameScript(
+			btcscript.OP_NAME_FIRSTUPDATE, [][]byte{[]byte("d/example"),
+				[]byte("rand"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_2DROP}, multiSig),
+			nil, keys, 2, nil},
+		{"1 of 1", multiSigOf(btcscript.OP_1, btcscript.OP_1, keys[1]),
+			nil, keys[1:2], 1, nil},
+		{"pay to script hash", p2sh, nil, nil, 0,
+			btcscript.ErrNotMultiSig},
+		{"name_update pay to script hash", nameUpdate(p2sh), nil, nil,
+			0, btcscript.ErrNotMultiSig},
+		{"pay to pubkey hash", nameTestP2PKH, nil, nil, 0,
+			btcscript.ErrNotMultiSig},
+		{"name_update pay to pubkey hash", nameUpdate(nameTestP2PKH),
+			nil, nil, 0, btcscript.ErrNotMultiSig},
+		{"key count mismatch", multiSigOf(btcscript.OP_2,
+			btcscript.OP_5, keys...), nil, nil, 0,
+			btcscript.ErrNotMultiSig},
+		{"more signatures than keys", multiSigOf(btcscript.OP_3,
+			btcscript.OP_2, keys[:2]...), nil, nil, 0,
+			btcscript.ErrNotMultiSig},
+		{"unparsable", []byte{btcscript.OP_DATA_2}, nil, nil, 0,
+			btcscript.ErrStackShortScript},
+		{"p2sh 2 of 3", p2sh, multiSig, keys, 2, nil},
+		{"name_update p2sh 2 of 3", nameUpdate(p2sh), multiSig, keys, 2,
+			nil},
+		{"p2sh wrong redeem script", p2sh, multiSigOf(btcscript.OP_1,
+			btcscript.OP_1, keys[1]), nil, 0,
+			btcscript.ErrRedeemScriptMismatch},
+		{"p2sh redeem script not multisig", p2shOf(nameTestP2PKH),
+			nameTestP2PKH, nil, 0, btcscript.ErrNotMultiSig},
+		{"p2sh unparsable redeem script",
+			p2shOf([]byte{btcscript.OP_DATA_2}),
+			[]byte{btcscript.OP_DATA_2}, nil, 0,
+			btcscript.ErrStackShortScript},
+		{"redeem script for bare multisig", multiSig, multiSig, nil, 0,
+			btcscript.ErrNotMultiSig},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var pubKeys []*btcec.PublicKey
+		var numSigs int
+		var err error
+		if test.redeemScript != nil {
+			pubKeys, numSigs, err = btcscript.RequiredPubKeysP2SH(
+				test.script, test.redeemScript)
+		} else {
+			pubKeys, numSigs, err = btcscript.RequiredPubKeys(
+				test.script)
+		}
+		if err != test.err {
+			t.Errorf("RequiredPubKeys #%d (%s) unexpected error - "+
+				"got %v, want %v", i, test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if numSigs != test.numSigs {
+			t.Errorf("RequiredPubKeys #%d (%s) got %d signatures, "+
+				"want %d", i, test.name, numSigs, test.numSigs)
+		}
+		if len(pubKeys) != len(test.keys) {
+			t.Errorf("RequiredPubKeys #%d (%s) got %d keys, want %d",
+				i, test.name, len(pubKeys), len(test.keys))
+			continue
+		}
+		for j, pubKey := range pubKeys {
+			if got := pubKey.SerializeUncompressed(); !bytes.Equal(got,
+				test.keys[j]) {
+				t.Errorf("RequiredPubKeys #%d (%s) key %d got %x, "+
+					"want %x", i, test.name, j, got,
+					test.keys[j])
+			}
+		}
+	}
+
+	// Keys which do not parse are an error rather than being skipped.
+	badKey := bytes.Repeat([]byte{0x05}, 33)
+	_, _, err = btcscript.RequiredPubKeys(multiSigOf(btcscript.OP_1,
+		btcscript.OP_2, keys[0], badKey))
+	if err == nil || err == btcscript.ErrNotMultiSig {
+		t.Errorf("RequiredPubKeys with invalid key unexpected error: %v",
+			err)
+	}
+}
+
 func TestHasCanonicalPushes(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -4698,6 +8890,166 @@ func TestHasCanonicalPushes(t *testing.T) {
 	}
 }
 
+// TestIsMinimallyEncoded ensures scripts are only reported as minimally
+// encoded when all of their data pushes use the smallest opcode possible, and
+// that this agrees with CanonicalizeScript.
+func TestIsMinimallyEncoded(t *testing.T) {
+	data := bytes.Repeat([]byte{0x2a}, 76)
+
+	minimal := [][]byte{
+		nil,
+		{btcscript.OP_0},
+		{btcscript.OP_1NEGATE, btcscript.OP_1, btcscript.OP_16},
+		{btcscript.OP_DATA_1, 0x00},
+		{btcscript.OP_DATA_1, 0x11},
+		{btcscript.OP_DATA_1, 0x80},
+		append([]byte{btcscript.OP_PUSHDATA1, 76}, data...),
+		nameTestP2PKH,
+		nameScript(btcscript.OP_NAME_UPDATE,
+			[][]byte{[]byte("d/example"), []byte("value")},
+			[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+			nameTestP2PKH),
+		// Pushes in branches which are not executed are checked too.
+		{btcscript.OP_0, btcscript.OP_IF, btcscript.OP_5,
+			btcscript.OP_ENDIF},
+	}
+	nonMinimal := [][]byte{
+		{btcscript.OP_PUSHDATA1, 10, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		{btcscript.OP_PUSHDATA1, 0},
+		{btcscript.OP_PUSHDATA2, 0, 0},
+		{btcscript.OP_PUSHDATA4, 0, 0, 0, 0},
+		{btcscript.OP_DATA_1, 0x05},
+		{btcscript.OP_DATA_1, 0x10},
+		{btcscript.OP_DATA_1, 0x81},
+		append([]byte{btcscript.OP_PUSHDATA2, 76, 0}, data...),
+		append([]byte{btcscript.OP_PUSHDATA4, 76, 0, 0, 0}, data...),
+		{btcscript.OP_0, btcscript.OP_IF, btcscript.OP_DATA_1, 0x05,
+			btcscript.OP_ENDIF},
+		// Scripts which do not parse are never minimally encoded.
+		{btcscript.OP_DATA_2, 0x01},
+	}
+
+	t.Logf("Running %d tests", len(minimal)+len(nonMinimal))
+	for i, script := range minimal {
+		if !btcscript.IsMinimallyEncoded(script) {
+			t.Errorf("IsMinimallyEncoded minimal #%d (%x) got false",
+				i, script)
+			continue
+		}
+		canonical, err := btcscript.CanonicalizeScript(script)
+		if err != nil || !bytes.Equal(canonical, script) {
+			t.Errorf("CanonicalizeScript minimal #%d (%x) changed "+
+				"the script to %x (err %v)", i, script,
+				canonical, err)
+		}
+	}
+	for i, script := range nonMinimal {
+		if btcscript.IsMinimallyEncoded(script) {
+			t.Errorf("IsMinimallyEncoded non-minimal #%d (%x) got "+
+				"true", i, script)
+			continue
+		}
+		canonical, err := btcscript.CanonicalizeScript(script)
+		if err != nil {
+			continue
+		}
+		if !btcscript.IsMinimallyEncoded(canonical) {
+			t.Errorf("IsMinimallyEncoded non-minimal #%d "+
+				"canonicalized (%x) got false", i, canonical)
+		}
+	}
+}
+
+// TestHasDisabledOpcode ensures scripts containing disabled opcodes are found
+// wherever the opcodes are in the script, and that executing them fails whether
+// or not the branch holding the opcode is executed.
+func TestHasDisabledOpcode(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   []byte
+		expected bool
+	}{
+		{
+			name: "OP_CAT executed",
+			script: []byte{btcscript.OP_1, btcscript.OP_1,
+				btcscript.OP_CAT},
+			expected: true,
+		},
+		{
+			name: "OP_CAT in a false branch",
+			script: []byte{btcscript.OP_1, btcscript.OP_0,
+				btcscript.OP_IF, btcscript.OP_CAT,
+				btcscript.OP_ENDIF},
+			expected: true,
+		},
+		{
+			name: "OP_MUL in a branch not taken",
+			script: []byte{btcscript.OP_1, btcscript.OP_1,
+				btcscript.OP_IF, btcscript.OP_ELSE,
+				btcscript.OP_MUL, btcscript.OP_ENDIF},
+			expected: true,
+		},
+		{
+			name: "OP_CAT as pushed data",
+			script: []byte{btcscript.OP_DATA_1, btcscript.OP_CAT,
+				btcscript.OP_DROP, btcscript.OP_1},
+			expected: false,
+		},
+		{
+			name:     "no disabled opcodes",
+			script:   []byte{btcscript.OP_1, btcscript.OP_DUP},
+			expected: false,
+		},
+		{
+			name:     "does not parse",
+			script:   []byte{btcscript.OP_CAT, btcscript.OP_DATA_2},
+			expected: false,
+		},
+	}
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got := btcscript.HasDisabledOpcode(test.script)
+		if got != test.expected {
+			t.Errorf("HasDisabledOpcode #%d (%s) wrong result - "+
+				"got %v, want %v", i, test.name, got,
+				test.expected)
+			continue
+		}
+		if !test.expected {
+			continue
+		}
+
+		engine, err := btcscript.NewScript(nil, test.script, 0, tx, 0)
+		if err != nil {
+			t.Errorf("NewScript #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if err := engine.Execute(); err != btcscript.ErrStackOpDisabled {
+			t.Errorf("Execute #%d (%s) wrong error - got %v, want "+
+				"%v", i, test.name, err,
+				btcscript.ErrStackOpDisabled)
+		}
+	}
+
+	// Every opcode reported as disabled is found.
+	for _, detail := range btcscript.AllOpcodes() {
+		if detail.Length != 1 {
+			continue
+		}
+		script := []byte{detail.Value}
+		if btcscript.HasDisabledOpcode(script) != detail.Disabled {
+			t.Errorf("HasDisabledOpcode (%s) wrong result - got "+
+				"%v, want %v", detail.Name, !detail.Disabled,
+				detail.Disabled)
+		}
+	}
+}
+
 func TestIsPushOnlyScript(t *testing.T) {
 	test := struct {
 		name     string
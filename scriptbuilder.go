@@ -18,10 +18,34 @@ const (
 	defaultScriptAlloc = 500
 )
 
+// PushOverflowPolicy defines how a ScriptBuilder handles data pushed with
+// AddData which is larger than MaxScriptElementSize and so could never be
+// pushed to the stack by an executing script.
+type PushOverflowPolicy int
+
+const (
+	// PushOverflowError causes the builder to record ErrStackElementTooBig,
+	// which is then returned by Script.  This is the default policy.
+	PushOverflowError PushOverflowPolicy = iota
+
+	// PushOverflowSplit causes oversized data to be split into consecutive
+	// pushes of MaxScriptElementSize bytes, with any remainder pushed
+	// last.  Every part is pushed verbatim, even a single byte which a
+	// small integer opcode could represent, so that concatenating the
+	// pushed items gives back the data.  The script which consumes the
+	// pushes is responsible for concatenating them again.
+	PushOverflowSplit
+)
+
 // ScriptBuilder provides a facility for building custom scripts.  It allows
 // you to push opcodes, ints, and data while respecting canonical encoding.  It
 // does not ensure the script will execute correctly.
 //
+// Any error encountered while building the script, such as pushing data which
+// is too large under the default PushOverflowError policy, is recorded by the
+// builder and returned by Script.  Once an error has occurred, further calls
+// to add to the script have no effect.
+//
 // For example, the following would build a 2-of-3 multisig script for usage in
 // a pay-to-script-hash (although in this situation MultiSigScript() would be a
 // better choice to generate the script):
@@ -29,13 +53,31 @@ const (
 // 	builder.AddOp(btcscript.OP_2).AddData(pubKey1).AddData(pubKey2)
 // 	builder.AddData(pubKey3).AddOp(btcscript.OP_3)
 // 	builder.AddOp(btcscript.OP_CHECKMULTISIG)
-// 	fmt.Printf("Final multi-sig script: %x\n", builder.Script())
+// 	script, err := builder.Script()
+// 	if err != nil {
+// 		// Handle the error.
+// 		return
+// 	}
+// 	fmt.Printf("Final multi-sig script: %x\n", script)
 type ScriptBuilder struct {
-	script []byte
+	script   []byte
+	overflow PushOverflowPolicy
+	err      error
+}
+
+// SetOverflowPolicy sets how data larger than MaxScriptElementSize which is
+// passed to AddData is handled.  See PushOverflowPolicy for details.
+func (b *ScriptBuilder) SetOverflowPolicy(policy PushOverflowPolicy) *ScriptBuilder {
+	b.overflow = policy
+	return b
 }
 
 // AddOp pushes the passed opcode to the end of the script.
 func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	if b.err != nil {
+		return b
+	}
+
 	b.script = append(b.script, opcode)
 	return b
 }
@@ -43,7 +85,53 @@ func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
 // AddData pushes the passed data to the end of the script.  It automatically
 // chooses canonical opcodes depending on the length of the data. A zero length
 // buffer will lead to a push of empty data onto the stack.
+//
+// Data larger than MaxScriptElementSize is handled according to the builder's
+// overflow policy, which by default records an error to be returned by Script.
+// Use AddFullData to push such data as is.
 func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	if len(data) > MaxScriptElementSize {
+		switch b.overflow {
+		case PushOverflowSplit:
+			for len(data) > MaxScriptElementSize {
+				b.addData(data[:MaxScriptElementSize])
+				data = data[MaxScriptElementSize:]
+			}
+
+			// The remainder is pushed verbatim like the full
+			// chunks, since a small integer opcode in its place
+			// would push different bytes.
+			return b.addData(data)
+		default:
+			b.err = ErrStackElementTooBig
+			return b
+		}
+	}
+
+	return b.addCanonicalData(data)
+}
+
+// AddFullData pushes the passed data to the end of the script using canonical
+// opcodes like AddData, but without regard to MaxScriptElementSize or the
+// overflow policy.  Data of any length is pushed with the smallest
+// OP_PUSHDATA# opcode that can represent its length.  Scripts containing such
+// pushes will fail to execute if the push is reached, so this should only be
+// used when that is intended, such as when building test scripts.
+func (b *ScriptBuilder) AddFullData(data []byte) *ScriptBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	return b.addCanonicalData(data)
+}
+
+// addCanonicalData pushes the passed data to the end of the script, using a
+// small integer opcode in place of a data push where possible.
+func (b *ScriptBuilder) addCanonicalData(data []byte) *ScriptBuilder {
 	dataLen := len(data)
 
 	// When the data consists of a single number that can be represented
@@ -57,6 +145,20 @@ func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
 		return b
 	}
 
+	return b.addData(data)
+}
+
+// addData pushes the passed data to the end of the script using the smallest
+// data push opcode which can hold it.  Unlike AddData, it never substitutes a
+// small integer opcode for single byte data, so the data is always pushed
+// verbatim.  A zero length buffer will lead to a push of OP_0.
+func (b *ScriptBuilder) addData(data []byte) *ScriptBuilder {
+	dataLen := len(data)
+	if dataLen == 0 {
+		b.script = append(b.script, OP_0)
+		return b
+	}
+
 	// Use one of the OP_DATA_# opcodes if the length of the data is small
 	// enough so the data push instruction is only a single byte.
 	// Otherwise, choose the smallest possible OP_PUSHDATA# opcode that
@@ -85,6 +187,10 @@ func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
 
 // AddInt64 pushes the passed integer to the end of the script.
 func (b *ScriptBuilder) AddInt64(val int64) *ScriptBuilder {
+	if b.err != nil {
+		return b
+	}
+
 	// Fast path for small integers and OP_1NEGATE.
 	if val == 0 {
 		b.script = append(b.script, OP_0)
@@ -100,6 +206,10 @@ func (b *ScriptBuilder) AddInt64(val int64) *ScriptBuilder {
 
 // AddUint64 pushes the passed integer to the end of the script.
 func (b *ScriptBuilder) AddUint64(val uint64) *ScriptBuilder {
+	if b.err != nil {
+		return b
+	}
+
 	// Fast path for small integers.
 	if val == 0 {
 		b.script = append(b.script, OP_0)
@@ -113,15 +223,24 @@ func (b *ScriptBuilder) AddUint64(val uint64) *ScriptBuilder {
 	return b.AddData(fromInt(new(big.Int).SetUint64(val)))
 }
 
-// Reset resets the script so it has no content.
+// Reset resets the script so it has no content and clears any error which was
+// recorded while building it.  The overflow policy is kept.
+//
+// The memory of the script is reused, so a single builder may build many
+// scripts without allocating for each.  This means a script returned by Script
+// is overwritten by whatever is built after a Reset, and it must be copied
+// first if it is still needed.
 func (b *ScriptBuilder) Reset() *ScriptBuilder {
 	b.script = b.script[0:0]
+	b.err = nil
 	return b
 }
 
-// Script returns the currently built script.
-func (b *ScriptBuilder) Script() []byte {
-	return b.script
+// Script returns the currently built script.  When any errors occurred while
+// building the script, the script will be returned up to the point of the
+// first error along with the error.
+func (b *ScriptBuilder) Script() ([]byte, error) {
+	return b.script, b.err
 }
 
 // NewScriptBuilder returns a new instance of a script builder.  See
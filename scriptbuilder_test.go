@@ -6,9 +6,12 @@ package btcscript_test
 
 import (
 	"bytes"
+	"fmt"
+	"math"
 	"testing"
 
 	"github.com/hlandauf/btcscript"
+	"github.com/hlandauf/btcwire"
 )
 
 // TestScriptBuilderAddOp tests that pushing opcodes to a script via the
@@ -43,7 +46,12 @@ func TestScriptBuilderAddOp(t *testing.T) {
 		for _, opcode := range test.opcodes {
 			builder.AddOp(opcode)
 		}
-		result := builder.Script()
+		result, err := builder.Script()
+		if err != nil {
+			t.Errorf("ScriptBuilder.AddOp #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
 		if !bytes.Equal(result, test.expected) {
 			t.Errorf("ScriptBuilder.AddOp #%d (%s) wrong result\n"+
 				"got: %x\nwant: %x", i, test.name, result,
@@ -99,19 +107,45 @@ func TestScriptBuilderAddInt64(t *testing.T) {
 		{name: "push -256", val: -256, expected: []byte{btcscript.OP_DATA_2, 0x00, 0x81}},
 		{name: "push -32767", val: -32767, expected: []byte{btcscript.OP_DATA_2, 0xff, 0xff}},
 		{name: "push -32768", val: -32768, expected: []byte{btcscript.OP_DATA_3, 0x00, 0x80, 0x80}},
+		{name: "push 2147483647", val: 2147483647, expected: []byte{btcscript.OP_DATA_4, 0xff, 0xff, 0xff, 0x7f}},
+		{name: "push -2147483648", val: -2147483648, expected: []byte{btcscript.OP_DATA_5, 0x00, 0x00, 0x00, 0x80, 0x80}},
+		{name: "push max int64", val: math.MaxInt64, expected: []byte{btcscript.OP_DATA_8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{name: "push -max int64", val: -math.MaxInt64, expected: []byte{btcscript.OP_DATA_8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "push min int64", val: math.MinInt64, expected: []byte{btcscript.OP_DATA_9, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x80}},
 	}
 
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
 	builder := btcscript.NewScriptBuilder()
 	t.Logf("Running %d tests", len(tests))
 	for i, test := range tests {
 		builder.Reset().AddInt64(test.val)
-		result := builder.Script()
+		result, err := builder.Script()
+		if err != nil {
+			t.Errorf("ScriptBuilder.AddInt64 #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
 		if !bytes.Equal(result, test.expected) {
 			t.Errorf("ScriptBuilder.AddInt64 #%d (%s) wrong result\n"+
 				"got: %x\nwant: %x", i, test.name, result,
 				test.expected)
 			continue
 		}
+
+		// Every push must be accepted when minimal data pushes are
+		// enforced.
+		pkScript := append(result, btcscript.OP_DROP, btcscript.OP_TRUE)
+		engine, err := btcscript.NewScript(nil, pkScript, 0, tx,
+			btcscript.ScriptVerifyMinimalData)
+		if err == nil {
+			err = engine.Execute()
+		}
+		if err != nil {
+			t.Errorf("ScriptBuilder.AddInt64 #%d (%s) push is not "+
+				"minimal: %v", i, test.name, err)
+		}
 	}
 }
 
@@ -154,7 +188,12 @@ func TestScriptBuilderAddUint64(t *testing.T) {
 	t.Logf("Running %d tests", len(tests))
 	for i, test := range tests {
 		builder.Reset().AddUint64(test.val)
-		result := builder.Script()
+		result, err := builder.Script()
+		if err != nil {
+			t.Errorf("ScriptBuilder.AddUint64 #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
 		if !bytes.Equal(result, test.expected) {
 			t.Errorf("ScriptBuilder.AddUint64 #%d (%s) wrong result\n"+
 				"got: %x\nwant: %x", i, test.name, result,
@@ -171,6 +210,8 @@ func TestScriptBuilderAddData(t *testing.T) {
 		name     string
 		data     []byte
 		expected []byte
+		useFull  bool
+		err      error
 	}{
 		// Start off with the small ints to ensure canonical encoding.
 		{name: "push small int 0", data: []byte{0}, expected: []byte{btcscript.OP_0}},
@@ -222,24 +263,66 @@ func TestScriptBuilderAddData(t *testing.T) {
 			expected: append([]byte{btcscript.OP_PUSHDATA2, 0, 1}, bytes.Repeat([]byte{0x49}, 256)...),
 		},
 		{
-			name:     "push data len 32767",
+			name:     "push data len 520",
+			data:     bytes.Repeat([]byte{0x49}, 520),
+			expected: append([]byte{btcscript.OP_PUSHDATA2, 0x08, 0x02}, bytes.Repeat([]byte{0x49}, 520)...),
+		},
+
+		// Data over the max script element size is an error unless
+		// pushed with AddFullData.
+		{
+			name: "push data len 521",
+			data: bytes.Repeat([]byte{0x49}, 521),
+			err:  btcscript.ErrStackElementTooBig,
+		},
+		{
+			name: "push data len 65535",
+			data: bytes.Repeat([]byte{0x49}, 65535),
+			err:  btcscript.ErrStackElementTooBig,
+		},
+		{
+			name: "push data len 65536",
+			data: bytes.Repeat([]byte{0x49}, 65536),
+			err:  btcscript.ErrStackElementTooBig,
+		},
+		{
+			name:     "push full data len 32767",
 			data:     bytes.Repeat([]byte{0x49}, 32767),
 			expected: append([]byte{btcscript.OP_PUSHDATA2, 255, 127}, bytes.Repeat([]byte{0x49}, 32767)...),
+			useFull:  true,
+		},
+		{
+			name:     "push full data len 65535",
+			data:     bytes.Repeat([]byte{0x49}, 65535),
+			expected: append([]byte{btcscript.OP_PUSHDATA2, 255, 255}, bytes.Repeat([]byte{0x49}, 65535)...),
+			useFull:  true,
 		},
 
 		// 5-byte data push via OP_PUSHDATA_4.
 		{
-			name:     "push data len 65536",
+			name:     "push full data len 65536",
 			data:     bytes.Repeat([]byte{0x49}, 65536),
 			expected: append([]byte{btcscript.OP_PUSHDATA4, 0, 0, 1, 0}, bytes.Repeat([]byte{0x49}, 65536)...),
+			useFull:  true,
 		},
 	}
 
 	builder := btcscript.NewScriptBuilder()
 	t.Logf("Running %d tests", len(tests))
 	for i, test := range tests {
-		builder.Reset().AddData(test.data)
-		result := builder.Script()
+		builder.Reset()
+		if test.useFull {
+			builder.AddFullData(test.data)
+		} else {
+			builder.AddData(test.data)
+		}
+		result, err := builder.Script()
+		if err != test.err {
+			t.Errorf("ScriptBuilder.AddData #%d (%s) unexpected "+
+				"error: got %v, want %v", i, test.name, err,
+				test.err)
+			continue
+		}
 		if !bytes.Equal(result, test.expected) {
 			t.Errorf("ScriptBuilder.AddData #%d (%s) wrong result\n"+
 				"got: %x\nwant: %x", i, test.name, result,
@@ -248,3 +331,198 @@ func TestScriptBuilderAddData(t *testing.T) {
 		}
 	}
 }
+
+// TestScriptBuilderOverflow tests that data over MaxScriptElementSize is
+// handled according to the overflow policy of the ScriptBuilder and that an
+// error stops further additions to the script.
+func TestScriptBuilderOverflow(t *testing.T) {
+	max := btcscript.MaxScriptElementSize
+	chunk := append([]byte{btcscript.OP_PUSHDATA2, 0x08, 0x02},
+		bytes.Repeat([]byte{0x49}, max)...)
+
+	// The default policy records an error and ignores later additions.
+	builder := btcscript.NewScriptBuilder().AddOp(btcscript.OP_1)
+	builder.AddData(bytes.Repeat([]byte{0x49}, max+1))
+	builder.AddOp(btcscript.OP_2)
+	script, err := builder.Script()
+	if err != btcscript.ErrStackElementTooBig {
+		t.Errorf("ScriptBuilder default overflow unexpected error: "+
+			"got %v, want %v", err, btcscript.ErrStackElementTooBig)
+	}
+	if !bytes.Equal(script, []byte{btcscript.OP_1}) {
+		t.Errorf("ScriptBuilder default overflow wrong result: %x",
+			script)
+	}
+
+	// Reset clears the error.
+	script, err = builder.Reset().AddOp(btcscript.OP_2).Script()
+	if err != nil || !bytes.Equal(script, []byte{btcscript.OP_2}) {
+		t.Errorf("ScriptBuilder.Reset unexpected result: %x, %v",
+			script, err)
+	}
+
+	// The split policy pushes the data in MaxScriptElementSize chunks.
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []byte
+	}{
+		{
+			name:     "exact multiple",
+			data:     bytes.Repeat([]byte{0x49}, 2*max),
+			expected: append(append([]byte{}, chunk...), chunk...),
+		},
+		{
+			name: "with remainder",
+			data: bytes.Repeat([]byte{0x49}, max+2),
+			expected: append(append([]byte{}, chunk...),
+				btcscript.OP_DATA_2, 0x49, 0x49),
+		},
+	}
+
+	// Single byte remainders which AddData would push as small integers
+	// are pushed verbatim.
+	for b := 0; b <= 16; b++ {
+		data := append(bytes.Repeat([]byte{0x49}, max), byte(b))
+		tests = append(tests, struct {
+			name     string
+			data     []byte
+			expected []byte
+		}{
+			name: fmt.Sprintf("remainder 0x%02x", b),
+			data: data,
+			expected: append(append([]byte{}, chunk...),
+				btcscript.OP_DATA_1, byte(b)),
+		})
+	}
+
+	builder = btcscript.NewScriptBuilder().
+		SetOverflowPolicy(btcscript.PushOverflowSplit)
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		script, err := builder.Reset().AddData(test.data).Script()
+		if err != nil {
+			t.Errorf("ScriptBuilder split overflow #%d (%s) "+
+				"unexpected error: %v", i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(script, test.expected) {
+			t.Errorf("ScriptBuilder split overflow #%d (%s) wrong "+
+				"result\ngot: %x\nwant: %x", i, test.name,
+				script, test.expected)
+			continue
+		}
+
+		// Concatenating the pushes gives back the data.
+		pushes, err := btcscript.PushedData(script)
+		if err != nil {
+			t.Errorf("PushedData #%d (%s) unexpected error: %v", i,
+				test.name, err)
+			continue
+		}
+		if got := bytes.Join(pushes, nil); !bytes.Equal(got,
+			test.data) {
+			t.Errorf("ScriptBuilder split overflow #%d (%s) pushed "+
+				"wrong data\ngot: %x\nwant: %x", i, test.name,
+				got, test.data)
+		}
+	}
+}
+
+// TestScriptBuilderReset ensures a builder which is reset builds each script
+// from scratch while reusing the memory of the previous script.
+func TestScriptBuilderReset(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x11}, 20)
+	tests := []struct {
+		name     string
+		build    func(*btcscript.ScriptBuilder) *btcscript.ScriptBuilder
+		expected []byte
+	}{
+		{
+			name: "pay to pubkey hash",
+			build: func(b *btcscript.ScriptBuilder) *btcscript.ScriptBuilder {
+				return b.AddOp(btcscript.OP_DUP).
+					AddOp(btcscript.OP_HASH160).AddData(hash).
+					AddOp(btcscript.OP_EQUALVERIFY).
+					AddOp(btcscript.OP_CHECKSIG)
+			},
+			expected: append(append([]byte{btcscript.OP_DUP,
+				btcscript.OP_HASH160, btcscript.OP_DATA_20},
+				hash...), btcscript.OP_EQUALVERIFY,
+				btcscript.OP_CHECKSIG),
+		},
+		{
+			name: "oversized push",
+			build: func(b *btcscript.ScriptBuilder) *btcscript.ScriptBuilder {
+				return b.AddData(make([]byte,
+					btcscript.MaxScriptElementSize+1))
+			},
+			expected: nil,
+		},
+		{
+			name: "numbers",
+			build: func(b *btcscript.ScriptBuilder) *btcscript.ScriptBuilder {
+				return b.AddInt64(3).AddInt64(-1)
+			},
+			expected: []byte{btcscript.OP_3, btcscript.OP_1NEGATE},
+		},
+	}
+
+	builder := btcscript.NewScriptBuilder()
+	var first []byte
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		script, err := test.build(builder.Reset()).Script()
+		if (err != nil) != (test.expected == nil) {
+			t.Errorf("ScriptBuilder.Reset #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if err == nil && !bytes.Equal(script, test.expected) {
+			t.Errorf("ScriptBuilder.Reset #%d (%s) wrong result\n"+
+				"got: %x\nwant: %x", i, test.name, script,
+				test.expected)
+		}
+		if i == 0 {
+			first = script
+		}
+	}
+
+	// The first script shares its memory with the last one built.
+	if first[0] != btcscript.OP_3 {
+		t.Errorf("ScriptBuilder.Reset did not reuse the script memory")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		tests[0].build(builder.Reset()).Script()
+	})
+	if allocs != 0 {
+		t.Errorf("ScriptBuilder.Reset building a script made %v "+
+			"allocations, want 0", allocs)
+	}
+}
+
+// BenchmarkScriptBuilderReset benchmarks building pay-to-pubkey-hash scripts
+// by reusing a single builder.
+func BenchmarkScriptBuilderReset(b *testing.B) {
+	hash := bytes.Repeat([]byte{0x11}, 20)
+	builder := btcscript.NewScriptBuilder()
+	for i := 0; i < b.N; i++ {
+		builder.Reset().AddOp(btcscript.OP_DUP).
+			AddOp(btcscript.OP_HASH160).AddData(hash).
+			AddOp(btcscript.OP_EQUALVERIFY).
+			AddOp(btcscript.OP_CHECKSIG).Script()
+	}
+}
+
+// BenchmarkScriptBuilderNew benchmarks building pay-to-pubkey-hash scripts
+// with a new builder for each.
+func BenchmarkScriptBuilderNew(b *testing.B) {
+	hash := bytes.Repeat([]byte{0x11}, 20)
+	for i := 0; i < b.N; i++ {
+		btcscript.NewScriptBuilder().AddOp(btcscript.OP_DUP).
+			AddOp(btcscript.OP_HASH160).AddData(hash).
+			AddOp(btcscript.OP_EQUALVERIFY).
+			AddOp(btcscript.OP_CHECKSIG).Script()
+	}
+}
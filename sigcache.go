@@ -0,0 +1,101 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript
+
+import (
+	"sync"
+
+	"github.com/conformal/fastsha256"
+)
+
+// sigCacheKey identifies a signature hash, signature and public key triple in
+// a SigCache.  It is the SHA-256 hash of the three, each preceded by its
+// length, so that the cache does not hold on to the memory of the scripts.
+type sigCacheKey [fastsha256.Size]byte
+
+// SigCache is a cache of signatures which have been found to be valid.  The
+// same signature is often verified more than once, such as when a transaction
+// is accepted to the memory pool and again when it is included in a block.
+// Installing a cache on the script engines with SetSigCache lets OP_CHECKSIG
+// and OP_CHECKMULTISIG skip the verification of signatures found valid before.
+//
+// The cache holds at most the number of entries it was created with, evicting
+// a random entry to make room for a new one when it is full.  A SigCache is
+// safe for use by multiple goroutines, so one cache may be shared by all the
+// engines of a process.
+type SigCache struct {
+	mtx        sync.RWMutex
+	validSigs  map[sigCacheKey]struct{}
+	maxEntries uint
+}
+
+// NewSigCache returns a new, empty signature cache which holds at most
+// maxEntries entries.  A cache with no entries never stores anything.
+func NewSigCache(maxEntries uint) *SigCache {
+	return &SigCache{
+		validSigs:  make(map[sigCacheKey]struct{}, maxEntries),
+		maxEntries: maxEntries,
+	}
+}
+
+// newSigCacheKey returns the key of the passed signature hash, signature and
+// public key.
+func newSigCacheKey(sigHash, sig, pubKey []byte) sigCacheKey {
+	// ScriptLimits may allow stack items larger than MaxScriptElementSize,
+	// so the lengths are written in four bytes, which hold the length of
+	// any push.  Shorter lengths could be truncated, letting the same
+	// bytes be split into a different signature and public key.
+	b := make([]byte, 0, 12+len(sigHash)+len(sig)+len(pubKey))
+	for _, data := range [][]byte{sigHash, sig, pubKey} {
+		b = append(b, byte(len(data)>>24), byte(len(data)>>16),
+			byte(len(data)>>8), byte(len(data)))
+		b = append(b, data...)
+	}
+	return sigCacheKey(fastsha256.Sum256(b))
+}
+
+// Exists returns whether the signature sig of sigHash by the serialized public
+// key pubKey has been added to the cache.
+func (c *SigCache) Exists(sigHash, sig, pubKey []byte) bool {
+	key := newSigCacheKey(sigHash, sig, pubKey)
+
+	c.mtx.RLock()
+	_, ok := c.validSigs[key]
+	c.mtx.RUnlock()
+	return ok
+}
+
+// Add records that sig is a valid signature of sigHash by the serialized
+// public key pubKey.  Callers must only add signatures which have been
+// verified.  If the cache is full a random entry is evicted to make room.
+func (c *SigCache) Add(sigHash, sig, pubKey []byte) {
+	if c.maxEntries == 0 {
+		return
+	}
+	key := newSigCacheKey(sigHash, sig, pubKey)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.validSigs[key]; ok {
+		return
+	}
+	if uint(len(c.validSigs)) >= c.maxEntries {
+		// Iteration over a map starts at a random entry, so the first
+		// one is as good a choice as any.
+		for k := range c.validSigs {
+			delete(c.validSigs, k)
+			break
+		}
+	}
+	c.validSigs[key] = struct{}{}
+}
+
+// Len returns the number of entries in the cache.
+func (c *SigCache) Len() int {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return len(c.validSigs)
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/hlandauf/btcscript"
+)
+
+// sigCacheTestEntry returns a signature hash, signature and public key which
+// are distinct for each i.  They are not related in any way, which does not
+// matter to the cache.
+func sigCacheTestEntry(i int) ([]byte, []byte, []byte) {
+	return bytes.Repeat([]byte{byte(i)}, 32),
+		bytes.Repeat([]byte{byte(i >> 8), byte(i)}, 36),
+		bytes.Repeat([]byte{0x02}, 33)
+}
+
+// TestSigCache ensures entries added to a signature cache exist until they are
+// evicted and that the cache never holds more entries than it was created with.
+func TestSigCache(t *testing.T) {
+	cache := btcscript.NewSigCache(10)
+	for i := 0; i < 10; i++ {
+		cache.Add(sigCacheTestEntry(i))
+	}
+	for i := 0; i < 10; i++ {
+		if !cache.Exists(sigCacheTestEntry(i)) {
+			t.Errorf("Exists #%d entry not found", i)
+		}
+	}
+
+	// Each part of the triple is part of the key.
+	sigHash, sig, pubKey := sigCacheTestEntry(0)
+	if cache.Exists(sigHash[1:], sig, pubKey) ||
+		cache.Exists(sigHash, sig[1:], pubKey) ||
+		cache.Exists(sigHash, sig, pubKey[1:]) {
+		t.Errorf("Exists found an entry which was not added")
+	}
+	// The parts may not be moved from one to another either.
+	if cache.Exists(append(sigHash, sig[0]), sig[1:], pubKey) {
+		t.Errorf("Exists found an entry with data moved between parts")
+	}
+	// Lengths which do not fit in 16 bits are not truncated, which would
+	// let a signature of 0x10000 bytes followed by the public key give the
+	// same key as an empty signature followed by a public key made of
+	// both.
+	longCache := btcscript.NewSigCache(1)
+	longSig := append([]byte{0x00, byte(len(pubKey))},
+		bytes.Repeat([]byte{0x30}, 0xfffe)...)
+	longCache.Add(sigHash, longSig, pubKey)
+	joined := append([]byte(nil), longSig[2:]...)
+	joined = append(joined, 0x00, byte(len(pubKey)))
+	joined = append(joined, pubKey...)
+	if !longCache.Exists(sigHash, longSig, pubKey) {
+		t.Errorf("Exists entry with a long signature not found")
+	}
+	if longCache.Exists(sigHash, nil, joined) {
+		t.Errorf("Exists found an entry with a long signature moved " +
+			"into the public key")
+	}
+
+	// Adding an entry which exists does not evict anything.
+	cache.Add(sigCacheTestEntry(3))
+	if cache.Len() != 10 {
+		t.Errorf("Len got %d entries after adding an existing one, "+
+			"want 10", cache.Len())
+	}
+
+	// Adding to a full cache evicts another entry.
+	cache.Add(sigCacheTestEntry(10))
+	if !cache.Exists(sigCacheTestEntry(10)) {
+		t.Errorf("Exists new entry not found")
+	}
+	if cache.Len() != 10 {
+		t.Errorf("Len got %d entries after eviction, want 10",
+			cache.Len())
+	}
+
+	// A cache with no entries never stores anything.
+	cache = btcscript.NewSigCache(0)
+	cache.Add(sigCacheTestEntry(0))
+	if cache.Exists(sigCacheTestEntry(0)) || cache.Len() != 0 {
+		t.Errorf("Exists found an entry in a cache of no entries")
+	}
+}
+
+// TestSigCacheConcurrent ensures a signature cache may be used by many
+// goroutines at once.
+func TestSigCacheConcurrent(t *testing.T) {
+	const goroutines, entries = 8, 200
+	cache := btcscript.NewSigCache(goroutines * entries / 2)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * entries; i < (g+1)*entries; i++ {
+				cache.Add(sigCacheTestEntry(i))
+				cache.Exists(sigCacheTestEntry(i - entries))
+				cache.Len()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if cache.Len() != goroutines*entries/2 {
+		t.Errorf("Len got %d entries, want %d", cache.Len(),
+			goroutines*entries/2)
+	}
+}
+
+// TestSigCacheEngine ensures the script engine adds valid signatures to its
+// signature cache and does not verify signatures found in it again.
+func TestSigCacheEngine(t *testing.T) {
+	jobs, err := batchTestJobs(2)
+	if err != nil {
+		t.Fatalf("failed to make jobs: %v", err)
+	}
+	job := jobs[0]
+
+	cache := btcscript.NewSigCache(10)
+	for i := 0; i < 2; i++ {
+		engine, err := btcscript.NewScript(job.SigScript, job.PkScript,
+			job.TxIdx, job.Tx, job.Flags)
+		if err != nil {
+			t.Fatalf("NewScript unexpected error: %v", err)
+		}
+		engine.SetSigCache(cache)
+		if err := engine.Execute(); err != nil {
+			t.Fatalf("Execute #%d unexpected error: %v", i, err)
+		}
+		if cache.Len() != 1 {
+			t.Fatalf("Execute #%d got %d cache entries, want 1", i,
+				cache.Len())
+		}
+	}
+
+	// The signature of the first input is not valid for the second, but
+	// it is accepted once the cache says otherwise.
+	pushes, err := btcscript.PushedData(job.SigScript)
+	if err != nil {
+		t.Fatalf("PushedData unexpected error: %v", err)
+	}
+	sig, pubKey := btcscript.StripSignatureHashType(pushes[0]), pushes[1]
+	sigHash, err := btcscript.CalcSignatureHash(job.PkScript,
+		btcscript.SigHashAll, job.Tx, 1)
+	if err != nil {
+		t.Fatalf("CalcSignatureHash unexpected error: %v", err)
+	}
+	for i, want := range []error{btcscript.ErrStackScriptFailed, nil} {
+		engine, err := btcscript.NewScript(job.SigScript, job.PkScript,
+			1, job.Tx, job.Flags)
+		if err != nil {
+			t.Fatalf("NewScript unexpected error: %v", err)
+		}
+		engine.SetSigCache(cache)
+		if err := engine.Execute(); err != want {
+			t.Errorf("Execute #%d wrong error - got %v, want %v", i,
+				err, want)
+		}
+		cache.Add(sigHash, sig, pubKey)
+	}
+}
+
+// benchmarkSigCache benchmarks validating a pay-to-pubkey-hash input with or
+// without a signature cache holding its signature.
+func benchmarkSigCache(b *testing.B, cache *btcscript.SigCache) {
+	jobs, err := batchTestJobs(1)
+	if err != nil {
+		b.Fatalf("failed to make jobs: %v", err)
+	}
+	job := jobs[0]
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine, err := btcscript.NewScript(job.SigScript, job.PkScript,
+			job.TxIdx, job.Tx, job.Flags)
+		if err != nil {
+			b.Fatalf("NewScript unexpected error: %v", err)
+		}
+		if cache != nil {
+			engine.SetSigCache(cache)
+		}
+		if err := engine.Execute(); err != nil {
+			b.Fatalf("Execute unexpected error: %v", err)
+		}
+	}
+}
+
+// BenchmarkCheckSig benchmarks validating a pay-to-pubkey-hash input.
+func BenchmarkCheckSig(b *testing.B) {
+	benchmarkSigCache(b, nil)
+}
+
+// BenchmarkCheckSigCached benchmarks validating a pay-to-pubkey-hash input
+// whose signature is found in the signature cache.
+func BenchmarkCheckSigCached(b *testing.B) {
+	benchmarkSigCache(b, btcscript.NewSigCache(10))
+}
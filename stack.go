@@ -7,18 +7,34 @@ package btcscript
 import (
 	"encoding/hex"
 	"math/big"
+	"sync"
 )
 
 // asInt converts a byte array to a bignum by treating it as a little endian
 // number with sign bit.
 func asInt(v []byte) (*big.Int, error) {
 	// Only 32bit numbers allowed.
-	if len(v) > 4 {
+	return asIntN(v, 4, false)
+}
+
+// asIntN is the same as asInt but allows numbers of up to maxLen bytes.  This
+// is used by opcodes such as OP_CHECKSEQUENCEVERIFY whose operands may exceed
+// the usual 4 byte limit.  When requireMinimal is true, numbers which are not
+// encoded with the fewest bytes possible are rejected with ErrStackMinimalData,
+// as the reference implementation does when minimal data is enforced.
+func asIntN(v []byte, maxLen int, requireMinimal bool) (*big.Int, error) {
+	if len(v) > maxLen {
 		return nil, ErrStackNumberTooBig
 	}
 	if len(v) == 0 {
 		return big.NewInt(0), nil
 	}
+	// The most significant byte may only be zero, apart from the sign
+	// bit, when the sign bit would otherwise be set by the next byte.
+	if requireMinimal && v[len(v)-1]&0x7f == 0 &&
+		(len(v) == 1 || v[len(v)-2]&0x80 == 0) {
+		return nil, ErrStackMinimalData
+	}
 	negative := false
 	origlen := len(v)
 	msb := v[len(v)-1]
@@ -103,6 +119,26 @@ type Stack struct {
 	stk [][]byte
 }
 
+// stackPool holds the backing arrays of the data and alt stacks of script
+// engines which have been released, so that engines created later can reuse
+// them instead of growing new ones.
+var stackPool = sync.Pool{
+	New: func() interface{} {
+		return new([2][][]byte)
+	},
+}
+
+// clearStack removes all items from the passed backing array, including any
+// left beyond its length by earlier pops, and returns it truncated to zero
+// length so it may be reused without exposing the old items.
+func clearStack(stk [][]byte) [][]byte {
+	stk = stk[:cap(stk)]
+	for i := range stk {
+		stk[i] = nil
+	}
+	return stk[:0]
+}
+
 // PushByteArray adds the given back array to the top of the stack.
 func (s *Stack) PushByteArray(so []byte) {
 	s.stk = append(s.stk, so)
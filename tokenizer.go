@@ -0,0 +1,162 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript
+
+import (
+	"fmt"
+)
+
+// ScriptTokenizer provides a facility for iterating over the opcodes of a raw
+// script one at a time without parsing the entire script up front.  It is
+// intended for callers which only need to scan the opcodes, such as when
+// counting signature operations or matching templates, and so avoids the
+// allocations made by parsing.
+//
+// For example, the following would count the data pushes in a script:
+// 	tokenizer := btcscript.NewScriptTokenizer(script)
+// 	var pushes int
+// 	for tokenizer.Next() {
+// 		if tokenizer.Opcode() <= btcscript.OP_PUSHDATA4 {
+// 			pushes++
+// 		}
+// 	}
+// 	if err := tokenizer.Err(); err != nil {
+// 		// Handle the error.
+// 	}
+type ScriptTokenizer struct {
+	script    []byte
+	opcodemap map[byte]*opcode
+	offset    int
+	op        *opcode
+	data      []byte
+	err       error
+}
+
+// NewScriptTokenizer returns a new instance of a script tokenizer for the
+// passed script.  See ScriptTokenizer for details.
+func NewScriptTokenizer(script []byte) *ScriptTokenizer {
+	return newScriptTokenizer(script, opcodemap)
+}
+
+// newScriptTokenizer is the same as NewScriptTokenizer but allows the passing
+// of the template list for testing purposes.
+func newScriptTokenizer(script []byte, opcodemap map[byte]*opcode) *ScriptTokenizer {
+	return &ScriptTokenizer{script: script, opcodemap: opcodemap}
+}
+
+// Next attempts to parse the next opcode and returns whether it succeeded.  It
+// returns false once the end of the script is reached or if the script can not
+// be parsed, in which case Err returns the reason.
+func (t *ScriptTokenizer) Next() bool {
+	if t.Done() {
+		return false
+	}
+
+	op, ok := t.opcodemap[t.script[t.offset]]
+	if !ok {
+		t.fail(ErrStackInvalidOpcode)
+		return false
+	}
+
+	// parse data out of instruction.
+	switch {
+	case op.length == 1:
+		// no data, done here
+		t.op, t.data = op, nil
+		t.offset++
+	case op.length > 1:
+		if len(t.script[t.offset:]) < op.length {
+			t.fail(ErrStackShortScript)
+			return false
+		}
+		// slice out the data.
+		t.op, t.data = op, t.script[t.offset+1:t.offset+op.length]
+		t.offset += op.length
+	case op.length < 0:
+		var l uint
+		off := t.offset + 1
+
+		if len(t.script[off:]) < -op.length {
+			t.fail(ErrStackShortScript)
+			return false
+		}
+
+		// Next -length bytes are little endian length of data.
+		switch op.length {
+		case -1:
+			l = uint(t.script[off])
+		case -2:
+			l = ((uint(t.script[off+1]) << 8) |
+				uint(t.script[off]))
+		case -4:
+			l = ((uint(t.script[off+3]) << 24) |
+				(uint(t.script[off+2]) << 16) |
+				(uint(t.script[off+1]) << 8) |
+				uint(t.script[off]))
+		default:
+			t.fail(fmt.Errorf("invalid opcode length %d", op.length))
+			return false
+		}
+
+		off += -op.length // beginning of data
+		// Disallow entries that do not fit script or were
+		// sign extended.
+		if int(l) > len(t.script[off:]) || int(l) < 0 {
+			t.fail(ErrStackShortScript)
+			return false
+		}
+		t.op, t.data = op, t.script[off:off+int(l)]
+		t.offset = off + int(l)
+	}
+	return true
+}
+
+// fail records the passed error and moves the tokenizer to the end of the
+// script so that no further opcodes are parsed.
+func (t *ScriptTokenizer) fail(err error) {
+	t.err = err
+	t.op, t.data = nil, nil
+	t.offset = len(t.script)
+}
+
+// Done returns true when either all opcodes have been parsed or a parse error
+// has occurred.
+func (t *ScriptTokenizer) Done() bool {
+	return t.err != nil || t.offset >= len(t.script)
+}
+
+// Err returns the error which stopped the tokenizer, or nil if the script was
+// parsed successfully so far.
+func (t *ScriptTokenizer) Err() error {
+	return t.err
+}
+
+// Opcode returns the value of the opcode parsed by the most recent successful
+// call to Next.
+func (t *ScriptTokenizer) Opcode() byte {
+	if t.op == nil {
+		return 0
+	}
+	return t.op.value
+}
+
+// Data returns the data pushed by the opcode parsed by the most recent
+// successful call to Next, or nil if the opcode does not push data.  The
+// returned slice shares the memory of the script and must not be modified.
+func (t *ScriptTokenizer) Data() []byte {
+	return t.data
+}
+
+// ByteIndex returns the offset into the script of the opcode which will be
+// parsed by the next call to Next.
+func (t *ScriptTokenizer) ByteIndex() int {
+	return t.offset
+}
+
+// parsedOpcode returns the opcode parsed by the most recent successful call to
+// Next in the form used by the rest of the package.
+func (t *ScriptTokenizer) parsedOpcode() parsedOpcode {
+	return parsedOpcode{opcode: t.op, data: t.data}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hlandauf/btcscript"
+)
+
+// TestScriptTokenizer ensures the ScriptTokenizer produces the expected opcodes
+// and data, including for scripts which fail to parse part way through.
+func TestScriptTokenizer(t *testing.T) {
+	pkHash := decodeHex("433ec2ac1ffa1b7b7d027f564529c57197f9ae88")
+	p2pkhOps := []byte{btcscript.OP_DUP, btcscript.OP_HASH160,
+		btcscript.OP_DATA_20, btcscript.OP_EQUALVERIFY,
+		btcscript.OP_CHECKSIG}
+	p2pkhData := [][]byte{nil, nil, pkHash, nil, nil}
+
+	tests := []struct {
+		name   string
+		script []byte
+		ops    []byte
+		data   [][]byte
+		err    error
+	}{
+		{
+			name:   "empty script",
+			script: []byte{},
+		},
+		{
+			name:   "pay to pubkey hash",
+			script: nameTestP2PKH,
+			ops:    p2pkhOps,
+			data:   p2pkhData,
+		},
+		{
+			name: "name_update",
+			script: nameScript(btcscript.OP_NAME_UPDATE,
+				[][]byte{[]byte("d/example"), []byte("value")},
+				[]byte{btcscript.OP_2DROP, btcscript.OP_DROP},
+				nameTestP2PKH),
+			ops: append([]byte{btcscript.OP_NAME_UPDATE,
+				btcscript.OP_DATA_9, btcscript.OP_DATA_5,
+				btcscript.OP_2DROP, btcscript.OP_DROP}, p2pkhOps...),
+			data: append([][]byte{nil, []byte("d/example"),
+				[]byte("value"), nil, nil}, p2pkhData...),
+		},
+		{
+			name: "small integers",
+			script: []byte{btcscript.OP_0, btcscript.OP_1NEGATE,
+				btcscript.OP_16, btcscript.OP_DATA_1, 0x07,
+				btcscript.OP_CODESEPARATOR, 0xff},
+			ops: []byte{btcscript.OP_0, btcscript.OP_1NEGATE,
+				btcscript.OP_16, btcscript.OP_DATA_1,
+				btcscript.OP_CODESEPARATOR, 0xff},
+			data: [][]byte{nil, nil, nil, {0x07}, nil, nil},
+		},
+		{
+			name: "pushdata opcodes",
+			script: builderScript(btcscript.NewScriptBuilder().
+				AddFullData(bytes.Repeat([]byte{0x49}, 76)).
+				AddFullData(bytes.Repeat([]byte{0x49}, 256)).
+				AddFullData(bytes.Repeat([]byte{0x49}, 65536)).
+				AddOp(btcscript.OP_CHECKSIG)),
+			ops: []byte{btcscript.OP_PUSHDATA1,
+				btcscript.OP_PUSHDATA2, btcscript.OP_PUSHDATA4,
+				btcscript.OP_CHECKSIG},
+			data: [][]byte{bytes.Repeat([]byte{0x49}, 76),
+				bytes.Repeat([]byte{0x49}, 256),
+				bytes.Repeat([]byte{0x49}, 65536), nil},
+		},
+		{
+			name: "empty pushdata1",
+			script: []byte{btcscript.OP_PUSHDATA1, 0x00,
+				btcscript.OP_TRUE},
+			ops:  []byte{btcscript.OP_PUSHDATA1, btcscript.OP_TRUE},
+			data: [][]byte{{}, nil},
+		},
+		{
+			name: "short OP_DATA_2",
+			script: []byte{btcscript.OP_TRUE, btcscript.OP_DATA_2,
+				0x01},
+			ops:  []byte{btcscript.OP_TRUE},
+			data: [][]byte{nil},
+			err:  btcscript.ErrStackShortScript,
+		},
+		{
+			name: "short OP_PUSHDATA2 length",
+			script: []byte{btcscript.OP_TRUE,
+				btcscript.OP_PUSHDATA2, 0x01},
+			ops:  []byte{btcscript.OP_TRUE},
+			data: [][]byte{nil},
+			err:  btcscript.ErrStackShortScript,
+		},
+		{
+			name: "short OP_PUSHDATA4 data",
+			script: []byte{btcscript.OP_PUSHDATA4, 0x02, 0x00, 0x00,
+				0x00, 0x01},
+			err: btcscript.ErrStackShortScript,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var ops []byte
+		var data [][]byte
+		tokenizer := btcscript.NewScriptTokenizer(test.script)
+		for tokenizer.Next() {
+			ops = append(ops, tokenizer.Opcode())
+			data = append(data, tokenizer.Data())
+		}
+		if err := tokenizer.Err(); err != test.err {
+			t.Errorf("ScriptTokenizer #%d (%s) unexpected error: "+
+				"got %v, want %v", i, test.name, err, test.err)
+			continue
+		}
+		if !tokenizer.Done() {
+			t.Errorf("ScriptTokenizer #%d (%s) not done after "+
+				"Next returned false", i, test.name)
+			continue
+		}
+		if tokenizer.ByteIndex() != len(test.script) {
+			t.Errorf("ScriptTokenizer #%d (%s) wrong byte index: "+
+				"got %d, want %d", i, test.name,
+				tokenizer.ByteIndex(), len(test.script))
+			continue
+		}
+
+		if !bytes.Equal(ops, test.ops) {
+			t.Errorf("ScriptTokenizer #%d (%s) wrong opcodes\n"+
+				"got: %x\nwant: %x", i, test.name, ops, test.ops)
+			continue
+		}
+		if len(data) != len(test.data) {
+			t.Errorf("ScriptTokenizer #%d (%s) got data for %d "+
+				"opcodes, want %d", i, test.name, len(data),
+				len(test.data))
+			continue
+		}
+		for j := range data {
+			if !bytes.Equal(data[j], test.data[j]) {
+				t.Errorf("ScriptTokenizer #%d (%s) wrong data "+
+					"for opcode %d\ngot: %x\nwant: %x", i,
+					test.name, j, data[j], test.data[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/conformal/fastsha256"
+	"github.com/hlandauf/btcwire"
+)
+
+var (
+	// ErrWitnessUnexpected is returned when ScriptVerifyWitness is set and
+	// a witness is provided for an input which does not spend a witness
+	// program.
+	ErrWitnessUnexpected = scriptError(ErrCodeWitnessUnexpected,
+		"witness provided for non-witness script")
+
+	// ErrWitnessMalleated is returned when ScriptVerifyWitness is set and
+	// an input spending a native witness program has a non-empty signature
+	// script.
+	ErrWitnessMalleated = scriptError(ErrCodeWitnessMalleated,
+		"witness program spent with a non-empty signature script")
+
+	// ErrWitnessMalleatedP2SH is returned when ScriptVerifyWitness is set
+	// and an input spending a witness program nested in a
+	// pay-to-script-hash output has a signature script which does more
+	// than push the witness program.
+	ErrWitnessMalleatedP2SH = scriptError(ErrCodeWitnessMalleated,
+		"nested witness program spent with a signature script which "+
+			"is not a single push")
+
+	// ErrWitnessProgramWrongLength is returned when a version 0 witness
+	// program is neither 20 nor 32 bytes long.
+	ErrWitnessProgramWrongLength = scriptError(ErrCodeWitnessProgramWrongLength,
+		"witness program has the wrong length")
+
+	// ErrWitnessProgramEmpty is returned when a pay-to-witness-script-hash
+	// program is spent with an empty witness.
+	ErrWitnessProgramEmpty = scriptError(ErrCodeWitnessProgramEmpty,
+		"witness program spent with an empty witness")
+
+	// ErrWitnessProgramMismatch is returned when the witness does not
+	// match the witness program, either because a pay-to-witness-pubkey-
+	// hash witness does not have exactly two items or because the witness
+	// script does not hash to the witness program.
+	ErrWitnessProgramMismatch = scriptError(ErrCodeWitnessProgramMismatch,
+		"witness does not match the witness program")
+
+	// ErrWitnessCleanStack is returned when a witness script does not
+	// leave exactly one item on the stack.
+	ErrWitnessCleanStack = scriptError(ErrCodeWitnessCleanStack,
+		"witness script did not leave a single item on the stack")
+
+	// ErrWitnessUnsupportedVersion is returned when
+	// ScriptVerifyDiscourageUpgradableWitnessProgram is set and a witness
+	// program of a version other than 0 is spent.
+	ErrWitnessUnsupportedVersion = scriptError(
+		ErrCodeWitnessUnsupportedVersion, "unsupported witness version")
+)
+
+// These are the sizes of the version 0 witness programs.
+const (
+	// witnessV0PubKeyHashLen is the length of a pay-to-witness-pubkey-hash
+	// program, which is the hash160 of a public key.
+	witnessV0PubKeyHashLen = 20
+
+	// witnessV0ScriptHashLen is the length of a pay-to-witness-script-hash
+	// program, which is the sha256 of the witness script.
+	witnessV0ScriptHashLen = 32
+)
+
+// isWitnessProgram returns true if the passed script is a witness program as
+// defined by BIP0141: a small integer version followed by a single direct push
+// of 2 to 40 bytes.
+func isWitnessProgram(pops []parsedOpcode) bool {
+	return len(pops) == 2 &&
+		isSmallInt(pops[0].opcode) &&
+		pops[1].opcode.value >= OP_DATA_2 &&
+		pops[1].opcode.value <= OP_DATA_40
+}
+
+// IsWitnessProgram returns whether or not the passed script is a witness
+// program as defined by BIP0141.  If the script does not parse false will be
+// returned.
+func IsWitnessProgram(script []byte) bool {
+	pops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+	return isWitnessProgram(pops)
+}
+
+// startWitness is called once the script which pushes the witness program
+// being spent has run successfully.  It checks the witness against the
+// program and sets the script engine up to run the script the witness
+// satisfies with the remaining witness items as its stack.  Witness programs
+// of versions other than 0 are reserved for future upgrades and so succeed
+// without any checks, unless ScriptVerifyDiscourageUpgradableWitnessProgram is
+// set.
+func (s *Script) startWitness() error {
+	version := asSmallInt(s.witnessProgram[0].opcode)
+	program := s.witnessProgram[1].data
+	if version != 0 {
+		if s.strictWitness {
+			return ErrWitnessUnsupportedVersion
+		}
+		s.SetStack([][]byte{{1}})
+		return nil
+	}
+
+	var script []parsedOpcode
+	var stack [][]byte
+	switch len(program) {
+	case witnessV0PubKeyHashLen:
+		if len(s.witness) != 2 {
+			return ErrWitnessProgramMismatch
+		}
+		// payToPubKeyHashScript can't fail to build or parse with a
+		// hash of the right length.
+		pkScript, _ := payToPubKeyHashScript(program)
+		script, _ = parseScript(pkScript)
+		stack = s.witness
+	case witnessV0ScriptHashLen:
+		if len(s.witness) == 0 {
+			return ErrWitnessProgramEmpty
+		}
+		witnessScript := s.witness[len(s.witness)-1]
+		if len(witnessScript) > maxScriptSize {
+			return ErrStackLongScript
+		}
+		hash := calcHash(witnessScript, fastsha256.New())
+		if !bytes.Equal(hash, program) {
+			return ErrWitnessProgramMismatch
+		}
+		var err error
+		script, err = parseScript(witnessScript)
+		if err != nil {
+			return err
+		}
+		stack = s.witness[:len(s.witness)-1]
+	default:
+		return ErrWitnessProgramWrongLength
+	}
+
+	for _, item := range stack {
+		if len(item) > s.maxElementSize {
+			return ErrStackElementTooBig
+		}
+	}
+
+	s.scripts = append(s.scripts, script)
+	s.SetStack(stack)
+	s.witnessExec = true
+	return nil
+}
+
+// calcSignatureHash returns the hash signed by signatures checked by the
+// currently executing script, which is calculated as defined by BIP0143 when
+// running a witness script and as for all earlier transactions otherwise.
+// Hashes are cached, so signatures of the same hash type over the same script
+// only have the hash calculated once.
+func (s *Script) calcSignatureHash(script []parsedOpcode, hashType SigHashType) []byte {
+	if s.sigHashes != nil {
+		hash := s.sigHashes.lookup(script, hashType, s.witnessExec)
+		if hash != nil {
+			return hash
+		}
+	}
+
+	var hash []byte
+	if s.witnessExec {
+		hash = calcWitnessScriptHash(script, hashType, &s.tx, s.txidx,
+			s.amount)
+	} else {
+		hash = calcScriptHash(script, hashType, &s.tx, s.txidx)
+	}
+
+	if s.sigHashes != nil {
+		s.sigHashes.entries = append(s.sigHashes.entries, sigHashEntry{
+			script:   script,
+			hashType: hashType,
+			witness:  s.witnessExec,
+			hash:     hash,
+		})
+	}
+	return hash
+}
+
+// sigHashEntry is a signature hash calculated by a script engine along with the
+// script, hash type and kind of script it was calculated for.
+type sigHashEntry struct {
+	script   []parsedOpcode
+	hashType SigHashType
+	witness  bool
+	hash     []byte
+}
+
+// sigHashCache holds the signature hashes calculated by a script engine, such
+// as the hash signed by every signature of a multisig script.  The transaction,
+// input and amount are fixed for the engine, so an entry may be reused whenever
+// the script, which changes with OP_CODESEPARATOR and the removal of
+// signatures, the hash type and the kind of script executing are the same.
+type sigHashCache struct {
+	entries []sigHashEntry
+}
+
+// lookup returns the cached signature hash for the passed script, hash type
+// and kind of script, or nil if it has not been calculated yet.  Scripts are
+// compared by their opcodes and data since the subscript of a signature check
+// is usually a newly made slice.
+func (c *sigHashCache) lookup(script []parsedOpcode, hashType SigHashType,
+	witness bool) []byte {
+	for _, entry := range c.entries {
+		if entry.hashType == hashType && entry.witness == witness &&
+			equalParsedScripts(entry.script, script) {
+			return entry.hash
+		}
+	}
+	return nil
+}
+
+// equalParsedScripts returns whether the two parsed scripts have the same
+// opcodes pushing the same data.
+func equalParsedScripts(a, b []parsedOpcode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].opcode != b[i].opcode || !bytes.Equal(a[i].data, b[i].data) {
+			return false
+		}
+	}
+	return true
+}
+
+// CalcWitnessSignatureHash returns the hash of tx that a signature of the given
+// hashType must sign for input idx, which spends amount satoshi, to redeem the
+// witness script, as defined by BIP0143.  For pay-to-witness-pubkey-hash
+// inputs the script is the pay-to-pubkey-hash script for the program.  Unlike
+// CalcSignatureHash, OP_CODESEPARATOR opcodes are left in script.  An
+// ErrStackInvalidIndex is returned if idx is not an input of tx.
+func CalcWitnessSignatureHash(script []byte, hashType SigHashType,
+	tx *btcwire.MsgTx, idx int, amount int64) ([]byte, error) {
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return nil, ErrStackInvalidIndex
+	}
+	pops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+	return calcWitnessScriptHash(pops, hashType, tx, idx, amount), nil
+}
+
+// calcWitnessScriptHash will, given the script code, hash type and spent amount
+// for input idx of tx, calculate the doubleSha256 hash to be used for signature
+// signing and verification as defined by BIP0143.
+func calcWitnessScriptHash(script []parsedOpcode, hashType SigHashType,
+	tx *btcwire.MsgTx, idx int, amount int64) []byte {
+
+	anyoneCanPay := hashType&SigHashAnyOneCanPay != 0
+	baseType := hashType & 31
+
+	// The previous outpoints and sequence numbers of all inputs are
+	// committed to unless the signature only covers its own input, with
+	// sequence numbers also left out when not all outputs are signed.
+	var hashPrevOuts, hashSequence, hashOutputs [32]byte
+	if !anyoneCanPay {
+		var buf bytes.Buffer
+		for _, txIn := range tx.TxIn {
+			writeOutPoint(&buf, &txIn.PreviousOutPoint)
+		}
+		copy(hashPrevOuts[:], btcwire.DoubleSha256(buf.Bytes()))
+	}
+	if !anyoneCanPay && baseType != SigHashSingle &&
+		baseType != SigHashNone {
+		var buf bytes.Buffer
+		for _, txIn := range tx.TxIn {
+			binary.Write(&buf, binary.LittleEndian, txIn.Sequence)
+		}
+		copy(hashSequence[:], btcwire.DoubleSha256(buf.Bytes()))
+	}
+	if baseType != SigHashSingle && baseType != SigHashNone {
+		var buf bytes.Buffer
+		for _, txOut := range tx.TxOut {
+			writeTxOut(&buf, txOut)
+		}
+		copy(hashOutputs[:], btcwire.DoubleSha256(buf.Bytes()))
+	} else if baseType == SigHashSingle && idx < len(tx.TxOut) {
+		var buf bytes.Buffer
+		writeTxOut(&buf, tx.TxOut[idx])
+		copy(hashOutputs[:], btcwire.DoubleSha256(buf.Bytes()))
+	}
+
+	// unparseScript cannot fail here, because the script was parsed.
+	scriptCode, _ := unparseScript(script)
+	txIn := tx.TxIn[idx]
+
+	var wbuf bytes.Buffer
+	binary.Write(&wbuf, binary.LittleEndian, tx.Version)
+	wbuf.Write(hashPrevOuts[:])
+	wbuf.Write(hashSequence[:])
+	writeOutPoint(&wbuf, &txIn.PreviousOutPoint)
+	writeVarBytes(&wbuf, scriptCode)
+	binary.Write(&wbuf, binary.LittleEndian, amount)
+	binary.Write(&wbuf, binary.LittleEndian, txIn.Sequence)
+	wbuf.Write(hashOutputs[:])
+	binary.Write(&wbuf, binary.LittleEndian, tx.LockTime)
+	binary.Write(&wbuf, binary.LittleEndian, uint32(hashType))
+
+	return btcwire.DoubleSha256(wbuf.Bytes())
+}
+
+// writeOutPoint writes the passed outpoint to buf in its wire format.
+func writeOutPoint(buf *bytes.Buffer, op *btcwire.OutPoint) {
+	buf.Write(op.Hash[:])
+	binary.Write(buf, binary.LittleEndian, op.Index)
+}
+
+// writeTxOut writes the passed transaction output to buf in its wire format.
+func writeTxOut(buf *bytes.Buffer, txOut *btcwire.TxOut) {
+	binary.Write(buf, binary.LittleEndian, txOut.Value)
+	writeVarBytes(buf, txOut.PkScript)
+}
+
+// writeVarBytes writes b to buf preceded by its length as a variable length
+// integer in the wire format.
+func writeVarBytes(buf *bytes.Buffer, b []byte) {
+	n := uint64(len(b))
+	switch {
+	case n < 0xfd:
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xfd)
+		binary.Write(buf, binary.LittleEndian, uint16(n))
+	case n <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		binary.Write(buf, binary.LittleEndian, uint32(n))
+	default:
+		buf.WriteByte(0xff)
+		binary.Write(buf, binary.LittleEndian, n)
+	}
+	buf.Write(b)
+}
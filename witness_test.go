@@ -0,0 +1,608 @@
+// Copyright (c) 2013-2014 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcscript_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/conformal/btcec"
+	"github.com/hlandauf/btcscript"
+	"github.com/hlandauf/btcutil"
+	"github.com/hlandauf/btcwire"
+)
+
+// TestCalcWitnessSignatureHash ensures signature hashes for witness inputs
+// match the examples given in BIP0143.
+func TestCalcWitnessSignatureHash(t *testing.T) {
+	// Native P2WPKH example, signing the second input.
+	nativeTx := "0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3e" +
+		"df433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d2796" +
+		"55c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff0" +
+		"2202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7" +
+		"a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce" +
+		"2f0167faa815988ac11000000"
+	// P2SH-P2WPKH example.
+	nestedTx := "0100000001db6b1b20aa0fd7b23880be2ecbd4a98130974cf4748f" +
+		"b66092ac4d3ceb1a54770100000000feffffff02b8b4eb0b000000001976" +
+		"a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac0008af2f0000" +
+		"00001976a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac9204" +
+		"0000"
+	// P2SH-P2WSH 6-of-6 multisig example, signed with every hash
+	// type.
+	multiSigTx := "010000000136641869ca081e70f394c6948e8af409e18b619df2e" +
+		"d74aa106c1ca29787b96e0100000000ffffffff0200e9a435000000001976" +
+		"a914389ffce9cd9ae88dcc0631e88a821ffdbe9bfe2688acc0832f050000" +
+		"00001976a9147480a33f950689af511e6e84c138dbbd3c3ee41588ac0000" +
+		"0000"
+	multiSigScript := "56210307b8ae49ac90a048e9b53357a2354b3334e9c8bee8" +
+		"13ecb98e99a7e07e8c3ba32103b28f0c28bfab54554ae8c658ac5c3e0ce6" +
+		"e79ad336331f78c428dd43eea8449b21034b8113d703413d57761b8b9781" +
+		"957b8c0ac1dfe69f492580ca4195f50376ba4a21033400f6afecb833092a" +
+		"9a21cfdf1ed1376e58c5d1f47de74683123987e967a8f42103a6d48b1131" +
+		"e94ba04d9737d61acdaa1322008af9602b3b14862c07a1789aac162102d8" +
+		"b661b0b3302ee2f162b09e07a55ad5dfbe673a9f01d9f0c1961768102430" +
+		"6b56ae"
+
+	tests := []struct {
+		name     string
+		tx       string
+		idx      int
+		script   string
+		amount   int64
+		hashType btcscript.SigHashType
+		hash     string
+	}{
+		{
+			name:     "native p2wpkh",
+			tx:       nativeTx,
+			idx:      1,
+			script:   "76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac",
+			amount:   600000000,
+			hashType: btcscript.SigHashAll,
+			hash: "c37af31116d1b27caf68aae9e3ac82f1477929014d5b91" +
+				"7657d0eb49478cb670",
+		},
+		{
+			name:     "p2sh-p2wpkh",
+			tx:       nestedTx,
+			idx:      0,
+			script:   "76a91479091972186c449eb1ded22b78e40d009bdf008988ac",
+			amount:   1000000000,
+			hashType: btcscript.SigHashAll,
+			hash: "64f3b0f4dd2bb3aa1ce8566d220cc74dda9df97d8490cc" +
+				"81d89d735c92e59fb6",
+		},
+		{
+			name:     "p2sh-p2wsh all",
+			tx:       multiSigTx,
+			idx:      0,
+			script:   multiSigScript,
+			amount:   987654321,
+			hashType: btcscript.SigHashAll,
+			hash: "185c0be5263dce5b4bb50a047973c1b6272bfbd0103a89" +
+				"444597dc40b248ee7c",
+		},
+		{
+			name:     "p2sh-p2wsh none",
+			tx:       multiSigTx,
+			idx:      0,
+			script:   multiSigScript,
+			amount:   987654321,
+			hashType: btcscript.SigHashNone,
+			hash: "e9733bc60ea13c95c6527066bb975a2ff29a925e80aa14" +
+				"c213f686cbae5d2f36",
+		},
+		{
+			name:     "p2sh-p2wsh single",
+			tx:       multiSigTx,
+			idx:      0,
+			script:   multiSigScript,
+			amount:   987654321,
+			hashType: btcscript.SigHashSingle,
+			hash: "1e1f1c303dc025bd664acb72e583e933fae4cff9148bf7" +
+				"8c157d1e8f78530aea",
+		},
+		{
+			name:     "p2sh-p2wsh all anyonecanpay",
+			tx:       multiSigTx,
+			idx:      0,
+			script:   multiSigScript,
+			amount:   987654321,
+			hashType: btcscript.SigHashAll | btcscript.SigHashAnyOneCanPay,
+			hash: "2a67f03e63a6a422125878b40b82da593be8d4efaafe88" +
+				"ee528af6e5a9955c6e",
+		},
+		{
+			name:     "p2sh-p2wsh none anyonecanpay",
+			tx:       multiSigTx,
+			idx:      0,
+			script:   multiSigScript,
+			amount:   987654321,
+			hashType: btcscript.SigHashNone | btcscript.SigHashAnyOneCanPay,
+			hash: "781ba15f3779d5542ce8ecb5c18716733a5ee42a6f5148" +
+				"8ec96154934e2c890a",
+		},
+		{
+			name:     "p2sh-p2wsh single anyonecanpay",
+			tx:       multiSigTx,
+			idx:      0,
+			script:   multiSigScript,
+			amount:   987654321,
+			hashType: btcscript.SigHashSingle | btcscript.SigHashAnyOneCanPay,
+			hash: "511e8e52ed574121fc1b654970395502128263f62662e0" +
+				"76dc6baf05c2e6a99b",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		tx, err := btcutil.NewTxFromBytes(decodeHex(test.tx))
+		if err != nil {
+			t.Errorf("CalcWitnessSignatureHash #%d (%s) bad tx: %v",
+				i, test.name, err)
+			continue
+		}
+		hash, err := btcscript.CalcWitnessSignatureHash(
+			decodeHex(test.script), test.hashType, tx.MsgTx(),
+			test.idx, test.amount)
+		if err != nil {
+			t.Errorf("CalcWitnessSignatureHash #%d (%s) unexpected "+
+				"error: %v", i, test.name, err)
+			continue
+		}
+		if want := decodeHex(test.hash); !bytes.Equal(hash, want) {
+			t.Errorf("CalcWitnessSignatureHash #%d (%s) wrong hash - "+
+				"got %x, want %x", i, test.name, hash, want)
+		}
+	}
+}
+
+// TestWitnessScripts ensures inputs spending witness programs are validated
+// against their witness when ScriptVerifyWitness is set.
+func TestWitnessScripts(t *testing.T) {
+	const amount = 100000000
+
+	privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), decodeHex(
+		"22a47fa09a223f2aa079edf85a7c2d4f8720ee63e502ee2869afab7de234b80c"))
+	pk := pubKey.SerializeCompressed()
+
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{Index: 1}, nil))
+	tx.AddTxOut(btcwire.NewTxOut(amount-1000, []byte{btcscript.OP_TRUE}))
+
+	// sign returns a signature with a SigHashAll hash type of the input
+	// spending amount with the passed witness script.
+	sign := func(script []byte, amount int64) []byte {
+		hash, err := btcscript.CalcWitnessSignatureHash(script,
+			btcscript.SigHashAll, tx, 0, amount)
+		if err != nil {
+			t.Fatalf("CalcWitnessSignatureHash unexpected error: %v",
+				err)
+		}
+		sig, err := privKey.Sign(hash)
+		if err != nil {
+			t.Fatalf("Sign unexpected error: %v", err)
+		}
+		return append(sig.Serialize(), byte(btcscript.SigHashAll))
+	}
+
+	// witnessProgram returns a witness program script of the passed
+	// version and program.
+	witnessProgram := func(version byte, program []byte) []byte {
+		return builderScript(btcscript.NewScriptBuilder().
+			AddOp(version).AddData(program))
+	}
+
+	// p2sh returns a pay-to-script-hash script for the passed redeem
+	// script.
+	p2sh := func(redeemScript []byte) []byte {
+		return builderScript(btcscript.NewScriptBuilder().
+			AddOp(btcscript.OP_HASH160).
+			AddData(btcutil.Hash160(redeemScript)).
+			AddOp(btcscript.OP_EQUAL))
+	}
+
+	pkHash := btcutil.Hash160(pk)
+	p2pkhScript := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_DUP).AddOp(btcscript.OP_HASH160).
+		AddData(pkHash).AddOp(btcscript.OP_EQUALVERIFY).
+		AddOp(btcscript.OP_CHECKSIG))
+	p2wpkh := witnessProgram(btcscript.OP_0, pkHash)
+
+	multiSigScript := builderScript(btcscript.NewScriptBuilder().
+		AddOp(btcscript.OP_1).AddData(pk).AddOp(btcscript.OP_1).
+		AddOp(btcscript.OP_CHECKMULTISIG))
+	multiSigHash := sha256.Sum256(multiSigScript)
+	p2wsh := witnessProgram(btcscript.OP_0, multiSigHash[:])
+
+	// A witness script leaving two true values on the stack.
+	dirtyScript := []byte{btcscript.OP_1, btcscript.OP_1}
+	dirtyHash := sha256.Sum256(dirtyScript)
+
+	p2wpkhSig := sign(p2pkhScript, amount)
+	p2wshSig := sign(multiSigScript, amount)
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		witness   [][]byte
+		amount    int64
+		noFlag    bool
+		flags     btcscript.ScriptFlags
+		err       error
+	}{
+		{
+			name:     "p2wpkh",
+			pkScript: p2wpkh,
+			witness:  [][]byte{p2wpkhSig, pk},
+			amount:   amount,
+		},
+		{
+			name:     "p2wpkh wrong amount",
+			pkScript: p2wpkh,
+			witness:  [][]byte{p2wpkhSig, pk},
+			amount:   amount + 1,
+			err:      btcscript.ErrStackScriptFailed,
+		},
+		{
+			name:      "p2sh-p2wpkh",
+			sigScript: builderScript(btcscript.NewScriptBuilder().AddData(p2wpkh)),
+			pkScript:  p2sh(p2wpkh),
+			witness:   [][]byte{p2wpkhSig, pk},
+			amount:    amount,
+		},
+		{
+			name:     "p2wsh",
+			pkScript: p2wsh,
+			witness:  [][]byte{nil, p2wshSig, multiSigScript},
+			amount:   amount,
+		},
+		{
+			name: "p2sh-p2wsh",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddData(p2wsh)),
+			pkScript: p2sh(p2wsh),
+			witness:  [][]byte{nil, p2wshSig, multiSigScript},
+			amount:   amount,
+		},
+		{
+			name:      "native with signature script",
+			sigScript: []byte{btcscript.OP_TRUE},
+			pkScript:  p2wpkh,
+			witness:   [][]byte{p2wpkhSig, pk},
+			amount:    amount,
+			err:       btcscript.ErrWitnessMalleated,
+		},
+		{
+			name: "nested with extra push",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddOp(btcscript.OP_TRUE).AddData(p2wpkh)),
+			pkScript: p2sh(p2wpkh),
+			witness:  [][]byte{p2wpkhSig, pk},
+			amount:   amount,
+			err:      btcscript.ErrWitnessMalleatedP2SH,
+		},
+		{
+			name: "witness for non-witness script",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddData(p2wpkhSig).AddData(pk)),
+			pkScript: p2pkhScript,
+			witness:  [][]byte{{1}},
+			err:      btcscript.ErrWitnessUnexpected,
+		},
+		{
+			name:     "p2wpkh with extra witness item",
+			pkScript: p2wpkh,
+			witness:  [][]byte{nil, p2wpkhSig, pk},
+			amount:   amount,
+			err:      btcscript.ErrWitnessProgramMismatch,
+		},
+		{
+			name:     "p2wsh with wrong witness script",
+			pkScript: p2wsh,
+			witness:  [][]byte{nil, p2wshSig, p2pkhScript},
+			amount:   amount,
+			err:      btcscript.ErrWitnessProgramMismatch,
+		},
+		{
+			name:     "p2wsh with empty witness",
+			pkScript: p2wsh,
+			amount:   amount,
+			err:      btcscript.ErrWitnessProgramEmpty,
+		},
+		{
+			name:     "p2wsh with unclean stack",
+			pkScript: witnessProgram(btcscript.OP_0, dirtyHash[:]),
+			witness:  [][]byte{dirtyScript},
+			amount:   amount,
+			err:      btcscript.ErrWitnessCleanStack,
+		},
+		{
+			name: "version 0 program of wrong length",
+			pkScript: witnessProgram(btcscript.OP_0,
+				bytes.Repeat([]byte{1}, 25)),
+			witness: [][]byte{{1}},
+			amount:  amount,
+			err:     btcscript.ErrWitnessProgramWrongLength,
+		},
+		{
+			name: "future version program",
+			pkScript: witnessProgram(btcscript.OP_1,
+				bytes.Repeat([]byte{1}, 32)),
+			amount: amount,
+		},
+		{
+			name: "future version program discouraged",
+			pkScript: witnessProgram(btcscript.OP_1,
+				bytes.Repeat([]byte{1}, 32)),
+			amount: amount,
+			flags:  btcscript.ScriptVerifyDiscourageUpgradableWitnessProgram,
+			err:    btcscript.ErrWitnessUnsupportedVersion,
+		},
+		{
+			name: "version 16 program discouraged",
+			pkScript: witnessProgram(btcscript.OP_16,
+				bytes.Repeat([]byte{1}, 2)),
+			amount: amount,
+			flags:  btcscript.ScriptVerifyDiscourageUpgradableWitnessProgram,
+			err:    btcscript.ErrWitnessUnsupportedVersion,
+		},
+		{
+			name: "nested future version program discouraged",
+			sigScript: builderScript(btcscript.NewScriptBuilder().
+				AddData(witnessProgram(btcscript.OP_1,
+					bytes.Repeat([]byte{1}, 32)))),
+			pkScript: p2sh(witnessProgram(btcscript.OP_1,
+				bytes.Repeat([]byte{1}, 32))),
+			amount: amount,
+			flags:  btcscript.ScriptVerifyDiscourageUpgradableWitnessProgram,
+			err:    btcscript.ErrWitnessUnsupportedVersion,
+		},
+		{
+			name:     "version 0 program with discouraged versions",
+			pkScript: p2wpkh,
+			witness:  [][]byte{p2wpkhSig, pk},
+			amount:   amount,
+			flags:    btcscript.ScriptVerifyDiscourageUpgradableWitnessProgram,
+		},
+		{
+			name:     "p2wpkh without flag",
+			pkScript: p2wpkh,
+			witness:  [][]byte{{1}},
+			noFlag:   true,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		flags := btcscript.ScriptBip16 | btcscript.ScriptVerifyWitness
+		if test.noFlag {
+			flags = btcscript.ScriptBip16
+		}
+		flags |= test.flags
+		engine, err := btcscript.NewScriptWithWitness(test.sigScript,
+			test.pkScript, test.witness, test.amount, 0, tx, flags)
+		if err == nil {
+			err = engine.Execute()
+		}
+		if err != test.err {
+			t.Errorf("NewScriptWithWitness #%d (%s) wrong error - "+
+				"got %v, want %v", i, test.name, err, test.err)
+		}
+	}
+}
+
+// sigHashTestTx returns a transaction with a single input and output for the
+// signature hash cache tests.
+func sigHashTestTx() *btcwire.MsgTx {
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{Index: 1}, nil))
+	tx.AddTxOut(btcwire.NewTxOut(1, []byte{btcscript.OP_TRUE}))
+	return tx
+}
+
+// sigHashTestSign returns a signature of the passed hash type by key for the
+// input of tx redeeming script.
+func sigHashTestSign(tx *btcwire.MsgTx, script []byte,
+	hashType btcscript.SigHashType, key *btcec.PrivateKey) ([]byte, error) {
+	hash, err := btcscript.CalcSignatureHash(script, hashType, tx, 0)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := key.Sign(hash)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig.Serialize(), byte(hashType)), nil
+}
+
+// sigHashTestMultiSig returns an n-of-n multisig script and a signature script
+// redeeming it with signatures of the passed hash types, which are used in turn
+// for the keys.
+func sigHashTestMultiSig(tx *btcwire.MsgTx, n int,
+	hashTypes ...btcscript.SigHashType) ([]byte, []byte, error) {
+	keys := make([]*btcec.PrivateKey, n)
+	builder := btcscript.NewScriptBuilder().AddInt64(int64(n))
+	for i := range keys {
+		key, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[i] = key
+		pk := (*btcec.PublicKey)(&key.PublicKey)
+		builder.AddData(pk.SerializeCompressed())
+	}
+	pkScript, err := builder.AddInt64(int64(n)).
+		AddOp(btcscript.OP_CHECKMULTISIG).Script()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	builder = btcscript.NewScriptBuilder().AddOp(btcscript.OP_0)
+	for i, key := range keys {
+		sig, err := sigHashTestSign(tx, pkScript,
+			hashTypes[i%len(hashTypes)], key)
+		if err != nil {
+			return nil, nil, err
+		}
+		builder.AddData(sig)
+	}
+	sigScript, err := builder.Script()
+	if err != nil {
+		return nil, nil, err
+	}
+	return sigScript, pkScript, nil
+}
+
+// TestSigHashCache ensures scripts are validated the same whether or not the
+// signature hashes calculated by the script engine are reused, including for
+// signatures checked after an OP_CODESEPARATOR.
+func TestSigHashCache(t *testing.T) {
+	tx := sigHashTestTx()
+
+	allSigScript, allPkScript, err := sigHashTestMultiSig(tx, 15,
+		btcscript.SigHashAll)
+	if err != nil {
+		t.Fatalf("failed to make multisig script: %v", err)
+	}
+	mixedSigScript, mixedPkScript, err := sigHashTestMultiSig(tx, 15,
+		btcscript.SigHashAll, btcscript.SigHashNone,
+		btcscript.SigHashSingle,
+		btcscript.SigHashAll|btcscript.SigHashAnyOneCanPay)
+	if err != nil {
+		t.Fatalf("failed to make multisig script: %v", err)
+	}
+	// Swapping two signatures puts them out of the order of the keys.
+	pops, err := btcscript.PushedData(allSigScript)
+	if err != nil {
+		t.Fatalf("PushedData unexpected error: %v", err)
+	}
+	builder := btcscript.NewScriptBuilder().AddOp(btcscript.OP_0).
+		AddData(pops[2]).AddData(pops[1])
+	for _, sig := range pops[3:] {
+		builder.AddData(sig)
+	}
+	swappedSigScript := builderScript(builder)
+
+	key1, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("failed to make key: %v", err)
+	}
+	key2, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("failed to make key: %v", err)
+	}
+	pk1 := (*btcec.PublicKey)(&key1.PublicKey).SerializeCompressed()
+	pk2 := (*btcec.PublicKey)(&key2.PublicKey).SerializeCompressed()
+
+	// Two signatures over the whole script, so the second check can use
+	// the hash calculated by the first.
+	twoSigScript := builderScript(btcscript.NewScriptBuilder().
+		AddData(pk1).AddOp(btcscript.OP_CHECKSIGVERIFY).
+		AddData(pk2).AddOp(btcscript.OP_CHECKSIG))
+	// The second signature only signs the script after the separator.
+	codeSepTail := builderScript(btcscript.NewScriptBuilder().
+		AddData(pk2).AddOp(btcscript.OP_CHECKSIG))
+	codeSepScript := builderScript(btcscript.NewScriptBuilder().
+		AddData(pk1).AddOp(btcscript.OP_CHECKSIGVERIFY).
+		AddOp(btcscript.OP_CODESEPARATOR).AddData(pk2).
+		AddOp(btcscript.OP_CHECKSIG))
+
+	sigs := func(script2, script1 []byte) []byte {
+		sig2, err := sigHashTestSign(tx, script2, btcscript.SigHashAll,
+			key2)
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		sig1, err := sigHashTestSign(tx, script1, btcscript.SigHashAll,
+			key1)
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return builderScript(btcscript.NewScriptBuilder().AddData(sig2).
+			AddData(sig1))
+	}
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		pkScript  []byte
+		err       error
+	}{
+		{"15-of-15 multisig", allSigScript, allPkScript, nil},
+		{"15-of-15 multisig mixed hash types", mixedSigScript,
+			mixedPkScript, nil},
+		{"15-of-15 multisig wrong order", swappedSigScript, allPkScript,
+			btcscript.ErrStackScriptFailed},
+		{"two checksigs", sigs(twoSigScript, twoSigScript),
+			twoSigScript, nil},
+		{"code separator", sigs(codeSepTail, codeSepScript),
+			codeSepScript, nil},
+		{"code separator signing whole script",
+			sigs(codeSepScript, codeSepScript), codeSepScript,
+			btcscript.ErrStackScriptFailed},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		for _, cached := range []bool{true, false} {
+			engine, err := btcscript.NewScript(test.sigScript,
+				test.pkScript, 0, tx, 0)
+			if err != nil {
+				t.Errorf("NewScript #%d (%s) unexpected error: %v",
+					i, test.name, err)
+				break
+			}
+			if !cached {
+				engine.TstDisableSigHashCache()
+			}
+			err = engine.Execute()
+			if err != test.err {
+				t.Errorf("Execute #%d (%s) cached %v wrong error - "+
+					"got %v, want %v", i, test.name, cached, err,
+					test.err)
+			}
+		}
+	}
+}
+
+// benchmarkSigHashCache benchmarks validating a 15-of-15 multisig input with or
+// without reusing signature hashes.
+func benchmarkSigHashCache(b *testing.B, cached bool) {
+	tx := sigHashTestTx()
+	sigScript, pkScript, err := sigHashTestMultiSig(tx, 15,
+		btcscript.SigHashAll)
+	if err != nil {
+		b.Fatalf("failed to make multisig script: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine, err := btcscript.NewScript(sigScript, pkScript, 0, tx, 0)
+		if err != nil {
+			b.Fatalf("NewScript unexpected error: %v", err)
+		}
+		if !cached {
+			engine.TstDisableSigHashCache()
+		}
+		if err := engine.Execute(); err != nil {
+			b.Fatalf("Execute unexpected error: %v", err)
+		}
+	}
+}
+
+// BenchmarkMultiSig15of15 benchmarks validating a 15-of-15 multisig input.
+func BenchmarkMultiSig15of15(b *testing.B) {
+	benchmarkSigHashCache(b, true)
+}
+
+// BenchmarkMultiSig15of15NoCache benchmarks validating a 15-of-15 multisig
+// input calculating the signature hash for every signature.
+func BenchmarkMultiSig15of15NoCache(b *testing.B) {
+	benchmarkSigHashCache(b, false)
+}